@@ -0,0 +1,142 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+const (
+	// ImageRepositoryReadyCondition mirrors the Ready condition of the chart ImageRepository
+	ImageRepositoryReadyCondition string = "ImageRepositoryReady"
+
+	// ImagePolicyReadyCondition mirrors the Ready condition of the chart ImagePolicy
+	ImagePolicyReadyCondition string = "ImagePolicyReady"
+
+	// HelmRepositoryReadyCondition mirrors the Ready condition of the HelmRepository
+	HelmRepositoryReadyCondition string = "HelmRepositoryReady"
+
+	// GitRepositoryReadyCondition mirrors the Ready condition of the chart GitRepository
+	GitRepositoryReadyCondition string = "GitRepositoryReady"
+
+	// OCIRepositoryReadyCondition mirrors the Ready condition of the OCIRepository pulling the pinned chart digest
+	OCIRepositoryReadyCondition string = "OCIRepositoryReady"
+
+	// ForceUpgradeCondition warns that upgrades are forced which can cause resources to be recreated
+	ForceUpgradeCondition string = "ForceUpgrade"
+
+	// ChartDeprecatedCondition warns that the chart metadata marks the selected chart version as deprecated
+	ChartDeprecatedCondition string = "ChartDeprecated"
+
+	// VersionUpdatesPausedCondition warns that the chart version isn't updated to the latest matching version
+	VersionUpdatesPausedCondition string = "VersionUpdatesPaused"
+
+	// DefaultValuesMissingCondition warns that the app is deployed without the operator-level default values
+	DefaultValuesMissingCondition string = "DefaultValuesMissing"
+
+	// VersionOverriddenCondition warns that the chart version selected by the ImagePolicy is bypassed
+	VersionOverriddenCondition string = "VersionOverridden"
+
+	// PlatformMismatchCondition warns that the chart artifact isn't published for the cluster architecture
+	PlatformMismatchCondition string = "PlatformMismatch"
+
+	// InlineChartAuthCondition warns that the chart repository password is stored in plain text in the app
+	InlineChartAuthCondition string = "InlineChartAuth"
+
+	// QuarantinedCondition signals that the app keeps failing so it's only reconciled at the quarantine interval
+	QuarantinedCondition string = "Quarantined"
+)
+
+const (
+	// MissingTargetNamespaceReason signals that the target namespace doesn't exist
+	// and the HelmRelease isn't allowed to create it
+	MissingTargetNamespaceReason string = "MissingTargetNamespace"
+
+	// TemplateNotFoundReason signals that the referenced FluxAppTemplate doesn't exist
+	TemplateNotFoundReason string = "TemplateNotFound"
+
+	// HelmRepositoryNotFoundReason signals that the referenced HelmRepository doesn't exist
+	HelmRepositoryNotFoundReason string = "HelmRepositoryNotFound"
+
+	// WaitingForDependencyReason signals that a FluxApp the app depends on isn't ready
+	WaitingForDependencyReason string = "WaitingForDependency"
+
+	// NotAHelmChartReason signals that the artifact resolved by the ImagePolicy isn't a Helm chart
+	NotAHelmChartReason string = "NotAHelmChart"
+
+	// NoMatchingVersionReason signals that no chart versions match the version constraint
+	NoMatchingVersionReason string = "NoMatchingVersion"
+
+	// ForceUpgradeEnabledReason signals that the app has forceUpgrade enabled
+	ForceUpgradeEnabledReason string = "ForceUpgradeEnabled"
+
+	// DeprecatedChartReason signals that the selected chart version is deprecated
+	DeprecatedChartReason string = "DeprecatedChart"
+
+	// PausedVersionUpdatesReason signals that the app has pauseVersionUpdates set
+	PausedVersionUpdatesReason string = "PausedVersionUpdates"
+
+	// SuspendedImageAutomationReason signals that the app has suspendImageAutomation set
+	SuspendedImageAutomationReason string = "SuspendedImageAutomation"
+
+	// OverriddenVersionReason signals that the app has the override version annotation set
+	OverriddenVersionReason string = "OverriddenVersion"
+
+	// InvalidOverrideVersionReason signals that the override version annotation isn't a SemVer version
+	InvalidOverrideVersionReason string = "InvalidOverrideVersion"
+
+	// RolledBackReason signals that the app has the rollback annotation set
+	RolledBackReason string = "RolledBack"
+
+	// NoRollbackVersionReason signals that the rollback annotation is set but there's no previous version to roll back to
+	NoRollbackVersionReason string = "NoRollbackVersion"
+
+	// InvalidValuesReason signals that the inline values aren't an object
+	InvalidValuesReason string = "InvalidValues"
+
+	// InvalidValuesTemplateReason signals that the values template couldn't be rendered
+	InvalidValuesTemplateReason string = "InvalidValuesTemplate"
+
+	// InvalidValuesPatchesReason signals that the values patches couldn't be applied
+	InvalidValuesPatchesReason string = "InvalidValuesPatches"
+
+	// InvalidCustomResourceValuesReason signals that the values of a custom resource don't match the schema of its CRD
+	InvalidCustomResourceValuesReason string = "InvalidCustomResourceValues"
+
+	// AuthenticationFailedReason signals that the registry rejected the credentials used to scan the chart tags
+	AuthenticationFailedReason string = "AuthenticationFailed"
+
+	// ChartNotPullableReason signals that the selected chart version can't be pulled from the registry
+	ChartNotPullableReason string = "ChartNotPullable"
+
+	// NotPrivilegedReason signals that the app uses a capability only allowed in the privileged namespaces
+	NotPrivilegedReason string = "NotPrivileged"
+
+	// UnsupportedArchitectureReason signals that the chart artifact platforms don't include the cluster architecture
+	UnsupportedArchitectureReason string = "UnsupportedArchitecture"
+
+	// DefaultValuesNotFoundReason signals that the default values ConfigMap doesn't exist
+	DefaultValuesNotFoundReason string = "DefaultValuesNotFound"
+
+	// DevelopmentOnlyReason signals that the app uses a feature only meant for development
+	DevelopmentOnlyReason string = "DevelopmentOnly"
+
+	// HookFailedReason signals that a pre or post hook of the release didn't succeed
+	HookFailedReason string = "HookFailed"
+
+	// HookTimedOutReason signals that a pre or post hook of the release didn't respond in time
+	HookTimedOutReason string = "HookTimedOut"
+
+	// RepeatedFailuresReason signals that the app was quarantined after failing too many reconciles in a row
+	RepeatedFailuresReason string = "RepeatedFailures"
+)
@@ -17,6 +17,12 @@ limitations under the License.
 package v1
 
 import (
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/pkg/apis/acl"
+	"github.com/fluxcd/pkg/apis/kustomize"
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -24,25 +30,401 @@ import (
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
 // FluxAppSpec defines the desired state of FluxApp.
+// The releaseName rule only runs when the field is set before and after, so setting or unsetting it is rejected here
+// +kubebuilder:validation:XValidation:rule="has(self.releaseName) == has(oldSelf.releaseName)",message="releaseName is immutable"
 type FluxAppSpec struct {
+	// Description of the app for cataloging e.g. by inventory tooling
+	// It's added to the HelmRelease in the apps.kloudy.uk/description annotation
+	// +kubebuilder:validation:MaxLength=1024
+	// +optional
+	Description string `json:"description,omitempty"`
 	// Chart defines info about the chart to deploy
 	Chart Chart `json:"chart"`
+	// SourceKind is the kind of source the chart is pulled from
+	// HelmRepository pulls the chart from an OCI registry, GitRepository pulls it from a path in a Git repository
+	// Defaults to HelmRepository
+	// +kubebuilder:validation:Enum=HelmRepository;GitRepository
+	// +kubebuilder:default:=HelmRepository
+	// +optional
+	SourceKind string `json:"sourceKind,omitempty"`
+	// HelmRepositoryRef references an existing HelmRepository the chart is pulled from
+	// e.g. one provisioned centrally, rather than generating one. The registry is still scanned for the chart version
+	// Namespace defaults to the namespace of the FluxApp
+	// +optional
+	HelmRepositoryRef *meta.NamespacedObjectReference `json:"helmRepositoryRef,omitempty"`
+	// PauseVersionUpdates keeps the chart version last resolved from the version constraint
+	// The HelmRelease is still reconciled at its interval so drift is corrected, unlike suspending it
+	// Defaults to false
+	// +optional
+	PauseVersionUpdates bool `json:"pauseVersionUpdates,omitempty"`
+	// SuspendImageAutomation suspends the ImageRepository scanning the chart tags and keeps the chart version
+	// e.g. to freeze versions during an incident without the registry being scanned
+	// The HelmRelease is still reconciled at its interval so drift is corrected, unlike suspending it
+	// Defaults to false
+	// +optional
+	SuspendImageAutomation bool `json:"suspendImageAutomation,omitempty"`
+	// PreflightPull checks the chart version can be pulled from the registry before the HelmRelease uses it
+	// If it can't, the HelmRelease is left as it was rather than failing to pull the chart
+	// Defaults to false
+	// +optional
+	PreflightPull bool `json:"preflightPull,omitempty"`
+	// Hooks are webhooks called before & after the HelmRelease is created or upgraded to a new chart version
+	// e.g. to create a database or warm a CDN
+	// +optional
+	Hooks *Hooks `json:"hooks,omitempty"`
+	// TemplateRef references a FluxAppTemplate in the same namespace
+	// Fields not set on the FluxApp are inherited from the template
+	// +optional
+	TemplateRef *meta.LocalObjectReference `json:"templateRef,omitempty"`
+	// Interval at which the HelmRelease is reconciled
+	// Defaults to 1m
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:Pattern="^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$"
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// DriftDetection sets the drift detection mode of the HelmRelease
+	// Defaults to enabled
+	// +kubebuilder:validation:Enum=enabled;warn;disabled
+	// +optional
+	DriftDetection helmv2.DriftDetectionMode `json:"driftDetection,omitempty"`
+	// DriftIgnore holds the rules for changes to ignore during drift detection
+	// When set, these replace the default rule ignoring /spec/replicas
+	// +optional
+	DriftIgnore []helmv2.IgnoreRule `json:"driftIgnore,omitempty"`
+	// DriftDetectionResourceExclusions selects rendered resources to exclude from drift detection
+	// The matching resources are annotated with helm.toolkit.fluxcd.io/driftDetection: disabled
+	// +optional
+	DriftDetectionResourceExclusions []kustomize.Selector `json:"driftDetectionResourceExclusions,omitempty"`
 	// TargetNamespace is the namespace to use for the HelmRelease
 	// Defaults to the namespace of the FluxApp
 	// +optional
 	TargetNamespace string `json:"targetNamespace,omitempty"`
+	// TargetNamespaceMetadata holds labels & annotations for the target namespace
+	// e.g. to enable Istio injection or match network policies
+	// The controller creates the namespace with them if createNamespace is true, otherwise they're added to the existing namespace
+	// +optional
+	TargetNamespaceMetadata *NamespaceMetadata `json:"targetNamespaceMetadata,omitempty"`
+	// ReleaseName is the name of the HelmRelease and the Helm release
+	// Defaults to the name of the FluxApp
+	// +kubebuilder:validation:MaxLength=53
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="releaseName is immutable"
+	// +optional
+	ReleaseName string `json:"releaseName,omitempty"`
+	// CreateNamespace tells the HelmRelease to create the target namespace if it doesn't exist
+	// Defaults to true
+	// +kubebuilder:default:=true
+	// +optional
+	CreateNamespace *bool `json:"createNamespace,omitempty"`
+	// DependsOn holds references to FluxApps that must be ready before this FluxApp is deployed
+	// Namespace defaults to the namespace of the FluxApp
+	// +optional
+	DependsOn []meta.NamespacedObjectReference `json:"dependsOn,omitempty"`
+	// Values holds inline Helm values for the HelmRelease
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Values *apiextensionsv1.JSON `json:"values,omitempty"`
+	// SubstituteValues replaces ${name} tokens in the string values with the substitutions
+	// configured on the controller e.g. the cluster name or region
+	// +optional
+	SubstituteValues bool `json:"substituteValues,omitempty"`
+	// ValuesTemplate is a Go text/template rendering YAML values, with the sprig functions
+	// e.g. for conditional values. It's rendered with the app .Name, .Namespace, .Labels & .Annotations
+	// and the .Vars set on the controller. The rendered values are merged over the inline values
+	// +optional
+	ValuesTemplate string `json:"valuesTemplate,omitempty"`
+	// ValuesPatches is an RFC 6902 JSON patch applied to the inline values once the values template is merged
+	// e.g. to add or remove a single item of a list, which can't be done by merging values
+	// +optional
+	ValuesPatches []JSONPatchOp `json:"valuesPatches,omitempty"`
+	// ValuesFrom holds references to resources containing Helm values for the HelmRelease
+	// ValuesKey defaults to values.yaml unless TargetPath is set, in which case
+	// ValuesKey must reference a single value
+	// Encrypted values should be decrypted into a Secret and referenced with the Secret kind
+	// Optional references are skipped while the ConfigMap or Secret doesn't exist, defaults to required
+	// +optional
+	ValuesFrom []helmv2.ValuesReference `json:"valuesFrom,omitempty"`
+	// ValuesPrecedence is the order the inline values & valuesFrom are merged in, later values win
+	// fromFirst merges the valuesFrom first so the inline values override them
+	// inlineFirst merges the inline values first so the valuesFrom override them
+	// Defaults to fromFirst
+	// +kubebuilder:validation:Enum=fromFirst;inlineFirst
+	// +kubebuilder:default:=fromFirst
+	// +optional
+	ValuesPrecedence string `json:"valuesPrecedence,omitempty"`
+	// CustomResourceValues are the inline values rendered into the spec of custom resources the chart installs
+	// When the controller validates custom resource values and the CRD is already installed, the values are
+	// validated against the CRD schema before the HelmRelease is updated
+	// +optional
+	CustomResourceValues []CustomResourceValues `json:"customResourceValues,omitempty"`
+	// IgnoreMissingValuesFiles tolerates missing values rather than failing the install
+	// It's set on the HelmRelease chart and marks all valuesFrom references as optional
+	// Defaults to false
+	// +optional
+	IgnoreMissingValuesFiles bool `json:"ignoreMissingValuesFiles,omitempty"`
+	// DisableWait stops Helm waiting for resources to be ready after an install or upgrade
+	// Defaults to false
+	// +optional
+	DisableWait bool `json:"disableWait,omitempty"`
+	// ForceUpgrade forces resource updates through a replacement strategy on upgrade
+	// e.g. when a chart upgrade changes immutable fields. This can cause resources to be recreated
+	// Defaults to false
+	// +optional
+	ForceUpgrade bool `json:"forceUpgrade,omitempty"`
+	// CleanupOnFail removes the resources created by a failed install or upgrade
+	// so a failure doesn't leave a partial release behind
+	// Defaults to false
+	// +optional
+	CleanupOnFail bool `json:"cleanupOnFail,omitempty"`
+	// Timeout is how long Helm waits for an install, upgrade or rollback to complete
+	// Defaults to the controller --default-timeout, or the HelmRelease default of 5m if that isn't set
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:Pattern="^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$"
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// MaxHistory is how many Helm release revisions are kept, 0 keeps every revision
+	// Defaults to the controller --default-max-history, or the HelmRelease default of 5 if that isn't set
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxHistory *int `json:"maxHistory,omitempty"`
+	// Canary deploys a second release of the chart at a pinned version alongside the stable release
+	// +optional
+	Canary *Canary `json:"canary,omitempty"`
+	// RemoteCluster deploys the HelmRelease to a remote cluster using a service account token
+	// The generated kubeconfig is stored in the <name>-kubeconfig Secret
+	// +optional
+	RemoteCluster *RemoteCluster `json:"remoteCluster,omitempty"`
+	// ChildPatches holds a strategic merge patch for the spec of each kind of child, keyed by the kind
+	// e.g. HelmRepository. The patch is applied after the controller generates the spec so it takes precedence
+	// Only the ImageRepository, ImagePolicy, HelmRepository, GitRepository, OCIRepository & HelmRelease can be patched
+	// +optional
+	ChildPatches map[string]apiextensionsv1.JSON `json:"childPatches,omitempty"`
 }
 
 type Chart struct {
 	// Full repository URL of the chart including scheme e.g. oci://ghcr.io/stefanprodan/charts/podinfo
-	// +kubebuilder:validation:Pattern=`^oci://.*`
+	// For a GitRepository source this is the HTTP/S or SSH URL of the Git repository
+	// +kubebuilder:validation:Pattern=`^(oci|https?|ssh)://.*`
 	// +required
 	Repository string `json:"repository"`
 	// Version of the chart as a semver version or version constraint.
+	// A tag which isn't a version or constraint e.g. stable is tracked by its digest
 	// Defaults to latest when omitted.
 	// +kubebuilder:default:=*
 	// +optional
 	Version string `json:"version"`
+	// Name of the chart in the HelmRelease chart template, overriding the last path segment of the repository
+	// e.g. charts/podinfo when a referenced HelmRepository points at a parent path of the chart
+	// +kubebuilder:validation:Pattern=`^[a-z0-9][a-z0-9._/-]*$`
+	// +optional
+	Name string `json:"name,omitempty"`
+	// TagPrefix is a prefix on the chart tags before the SemVer version e.g. chart- for chart-1.2.3
+	// The version is extracted from the tags before the version constraint is applied
+	// +optional
+	TagPrefix string `json:"tagPrefix,omitempty"`
+	// ExclusionList is a list of regular expressions matching chart tags which are never scanned or selected
+	// e.g. to ignore quarantined tags. Tags ending .sig are always excluded
+	// +kubebuilder:validation:MaxItems:=24
+	// +optional
+	ExclusionList []string `json:"exclusionList,omitempty"`
+	// VersionSelection is whether the highest or lowest chart version matching the version constraint is selected
+	// Defaults to highest
+	// +kubebuilder:validation:Enum=highest;lowest
+	// +kubebuilder:default:=highest
+	// +optional
+	VersionSelection string `json:"versionSelection,omitempty"`
+	// Provider used to authenticate with the chart repository
+	// Defaults to detecting the provider from the repository host
+	// Set generic for a registry on a cloud provider host which doesn't use the provider's auth e.g. a proxy
+	// +kubebuilder:validation:Enum=aws;azure;gcp;generic
+	// +optional
+	Provider string `json:"provider,omitempty"`
+	// Auth is the username & password for the chart repository, copied into a Secret referenced by the sources
+	// This is only meant for development as the password is stored in plain text in the FluxApp
+	// +optional
+	Auth *ChartAuth `json:"auth,omitempty"`
+	// ReconcileStrategy determines what triggers a new chart artifact
+	// Revision can be used to upgrade when the chart digest changes without a version change
+	// +kubebuilder:validation:Enum=ChartVersion;Revision
+	// +kubebuilder:default:=ChartVersion
+	// +optional
+	ReconcileStrategy string `json:"reconcileStrategy,omitempty"`
+	// PinDigest deploys the selected chart version by the digest its tag pointed to when it was selected
+	// The chart is pulled by an OCIRepository so a tag moved mid-rollout can't change what's deployed
+	// +optional
+	PinDigest bool `json:"pinDigest,omitempty"`
+	// ValuesFiles are the values files from the source merged in order to replace the chart values.yaml
+	// e.g. values-production.yaml, relative to the chart root, or the repository root of a Git chart
+	// +optional
+	ValuesFiles []string `json:"valuesFiles,omitempty"`
+	// ScanInterval at which the chart repository is scanned for new versions
+	// This is independent of the HelmRelease interval so the registry can be scanned
+	// rarely while the HelmRelease is reconciled frequently to catch drift
+	// Defaults to 1m
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:Pattern="^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$"
+	// +optional
+	ScanInterval *metav1.Duration `json:"scanInterval,omitempty"`
+	// AccessFrom defines an ACL for allowing cross-namespace references to the
+	// generated ImageRepository and HelmRepository
+	// +optional
+	AccessFrom *acl.AccessFrom `json:"accessFrom,omitempty"`
+	// RepositoryLabels are added to the generated ImageRepository and HelmRepository
+	// e.g. to match network policy selectors
+	// +optional
+	RepositoryLabels map[string]string `json:"repositoryLabels,omitempty"`
+	// Git defines where the chart is in the Git repository when the source kind is GitRepository
+	// +optional
+	Git *GitChart `json:"git,omitempty"`
+}
+
+const (
+	// VersionSelectionHighest selects the highest chart version matching the version constraint
+	VersionSelectionHighest = "highest"
+	// VersionSelectionLowest selects the lowest chart version matching the version constraint
+	VersionSelectionLowest = "lowest"
+)
+
+const (
+	// ValuesPrecedenceFromFirst merges the valuesFrom before the inline values
+	ValuesPrecedenceFromFirst = "fromFirst"
+	// ValuesPrecedenceInlineFirst merges the inline values before the valuesFrom
+	ValuesPrecedenceInlineFirst = "inlineFirst"
+)
+
+// JSONPatchOp is an RFC 6902 JSON patch operation
+type JSONPatchOp struct {
+	// Op is the operation
+	// +kubebuilder:validation:Enum=add;remove;replace;move;copy;test
+	// +required
+	Op string `json:"op"`
+	// Path is the JSON pointer to the value the operation applies to e.g. /ingress/hosts/0
+	// +required
+	Path string `json:"path"`
+	// From is the JSON pointer to the value moved or copied
+	// +optional
+	From string `json:"from,omitempty"`
+	// Value is added, replaced with or tested against
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Value *apiextensionsv1.JSON `json:"value,omitempty"`
+}
+
+// ChartAuth is the basic auth for the chart repository
+type ChartAuth struct {
+	// Username for the chart repository
+	// +kubebuilder:validation:MinLength=1
+	// +required
+	Username string `json:"username"`
+	// Password for the chart repository
+	// +kubebuilder:validation:MinLength=1
+	// +required
+	Password string `json:"password"`
+}
+
+// Hooks defines the webhooks called around a release of a new chart version
+type Hooks struct {
+	// Pre hooks are called in order before the HelmRelease is created or upgraded
+	// The HelmRelease is left as it was until they all succeed
+	// +optional
+	Pre []Hook `json:"pre,omitempty"`
+	// Post hooks are called in order once the HelmRelease is created or upgraded
+	// +optional
+	Post []Hook `json:"post,omitempty"`
+}
+
+// Hook defines a webhook the release of a new chart version is posted to
+type Hook struct {
+	// Name of the hook, reported when it fails
+	// +kubebuilder:validation:MinLength=1
+	// +required
+	Name string `json:"name"`
+	// URL the hook is posted to
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// +required
+	URL string `json:"url"`
+	// Timeout is how long to wait for the hook to respond, up to 1m
+	// Defaults to 30s
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:MaxLength=32
+	// +kubebuilder:validation:Pattern="^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$"
+	// +kubebuilder:validation:XValidation:rule="duration(self) <= duration('1m')",message="timeout must be at most 1m"
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// CustomResourceValues maps the values of a custom resource the chart installs to its CRD
+type CustomResourceValues struct {
+	// ValuesPath is the JSON pointer to the values rendered into the custom resource spec e.g. /alertmanager/config
+	// +kubebuilder:validation:Pattern=`^/`
+	// +required
+	ValuesPath string `json:"valuesPath"`
+	// APIVersion of the custom resource e.g. monitoring.coreos.com/v1
+	// +required
+	APIVersion string `json:"apiVersion"`
+	// Kind of the custom resource e.g. Alertmanager
+	// +required
+	Kind string `json:"kind"`
+}
+
+// NamespaceMetadata defines labels & annotations for a namespace
+type NamespaceMetadata struct {
+	// Labels added to the namespace
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations added to the namespace
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// GitChart defines a chart in a Git repository
+type GitChart struct {
+	// Path of the chart directory relative to the root of the Git repository
+	// +required
+	Path string `json:"path"`
+	// Ref is the Git reference to check out, defaults to the master branch
+	// +optional
+	Ref *sourcev1.GitRepositoryRef `json:"ref,omitempty"`
+	// SecretRef references a Secret in the FluxApp namespace with the Git repository credentials
+	// +optional
+	SecretRef *meta.LocalObjectReference `json:"secretRef,omitempty"`
+}
+
+// Canary defines a canary release of the chart
+type Canary struct {
+	// Version is the exact chart version of the canary release
+	// +required
+	Version string `json:"version"`
+	// Weight is the percentage of traffic intended for the canary release
+	// The HelmRelease doesn't split traffic so the weight is recorded in the
+	// apps.kloudy.uk/canary-weight annotation for the ingress or service mesh
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +required
+	Weight int32 `json:"weight"`
+	// ValuesFrom holds references to resources containing Helm values for the canary release only
+	// These are merged after the FluxApp valuesFrom
+	// +optional
+	ValuesFrom []helmv2.ValuesReference `json:"valuesFrom,omitempty"`
+}
+
+// RemoteCluster defines how the HelmRelease connects to a remote cluster
+type RemoteCluster struct {
+	// Server is the URL of the remote cluster API server
+	// +kubebuilder:validation:Pattern=`^https://.*`
+	// +required
+	Server string `json:"server"`
+	// TokenFile is the path of the service account token used to authenticate with the remote cluster
+	// e.g. a projected token mounted in the helm-controller pod with the remote cluster as the audience
+	// The token is read by the helm-controller so is refreshed without updating the kubeconfig
+	// There's no default as the helm-controller's own token mustn't be sent to a remote cluster
+	// +kubebuilder:validation:MinLength=1
+	// +required
+	TokenFile string `json:"tokenFile"`
+	// CertificateAuthority is the PEM encoded CA certificate of the remote cluster API server
+	// Defaults to the system trust store
+	// +optional
+	CertificateAuthority string `json:"certificateAuthority,omitempty"`
 }
 
 // FluxAppStatus defines the observed state of FluxApp.
@@ -51,6 +433,51 @@ type FluxAppStatus struct {
 	// Conditions holds the conditions for the FluxApp.
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// LastError holds the most recent reconcile error, if any
+	// +optional
+	LastError *LastError `json:"lastError,omitempty"`
+	// ConsecutiveFailures is how many reconciles of the current generation have failed in a row
+	// +optional
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+	// Canary holds the state of the canary release, if any
+	// +optional
+	Canary *CanaryStatus `json:"canary,omitempty"`
+	// History holds the chart versions Helm deployed, most recent first
+	// +optional
+	History []VersionHistory `json:"history,omitempty"`
+	// FailingResources are the resources the HelmRelease reports as failing while it isn't ready
+	// as Kind/namespace/name, or Kind/name when the namespace isn't reported
+	// +optional
+	FailingResources []string `json:"failingResources,omitempty"`
+	// Plan lists what the last run of the handlers did to each child it manages
+	// Each child is diffed against the cluster before it's applied
+	// +optional
+	Plan []PlanEntry `json:"plan,omitempty"`
+	// LastHandledForceAt is the last reconcile.fluxcd.io/forceAt annotation token passed to the HelmRelease
+	// +optional
+	LastHandledForceAt string `json:"lastHandledForceAt,omitempty"`
+	// PendingPostHooksVersion is the chart version released without its post hooks succeeding yet
+	// +optional
+	PendingPostHooksVersion string `json:"pendingPostHooksVersion,omitempty"`
+	// ObservedGeneration is the last generation of the spec reconciled
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastReconcileDuration is how long the first reconcile of the observed generation took
+	// +optional
+	LastReconcileDuration *metav1.Duration `json:"lastReconcileDuration,omitempty"`
+	// LastQueueWaitDuration is the time between the spec changing and the first reconcile
+	// of the observed generation starting
+	// +optional
+	LastQueueWaitDuration *metav1.Duration `json:"lastQueueWaitDuration,omitempty"`
+}
+
+// CanaryStatus defines the observed state of the canary release
+type CanaryStatus struct {
+	Version string `json:"version"`
+	Weight  int32  `json:"weight"`
+	// AppliedVersion is the chart version of the canary release Helm last deployed
+	// +optional
+	AppliedVersion string `json:"appliedVersion,omitempty"`
 }
 
 // ChartStatus defines the observed state of the flux image resourfces for a chart
@@ -58,6 +485,101 @@ type ChartStatus struct {
 	Repository string `json:"repository"`
 	Name       string `json:"name"`
 	Version    string `json:"version,omitempty"`
+	// AvailableVersion is the latest chart version matching the version constraint
+	// It's updated by every scan, even while version updates are paused and the chart version is kept
+	// +optional
+	AvailableVersion string `json:"availableVersion,omitempty"`
+	// AppliedVersion is the chart version of the release Helm last deployed
+	// +optional
+	AppliedVersion string `json:"appliedVersion,omitempty"`
+	// RollbackVersion is the chart version pinned by the apps.kloudy.uk/rollback annotation
+	// +optional
+	RollbackVersion string `json:"rollbackVersion,omitempty"`
+	// OverrideVersion is the chart version set by the apps.kloudy.uk/override-version annotation
+	// It's deployed instead of the version selected by the ImagePolicy
+	// +optional
+	OverrideVersion string `json:"overrideVersion,omitempty"`
+	// SourceRevision is the latest chart revision observed in the chart repository
+	// regardless of whether it matches the chart version
+	// +optional
+	SourceRevision string `json:"sourceRevision,omitempty"`
+	// Digest is the digest of the chart artifact the floating tag pointed to when last resolved,
+	// or the digest the chart version is pinned to with pinDigest
+	// +optional
+	Digest string `json:"digest,omitempty"`
+	// ArtifactSize is the size in bytes of the config & layers of the chart artifact of the version
+	// It's only recorded when the controller inspects chart artifacts
+	// +optional
+	ArtifactSize int64 `json:"artifactSize,omitempty"`
+	// ArtifactLayers is the number of layers of the chart artifact of the version
+	// +optional
+	ArtifactLayers int32 `json:"artifactLayers,omitempty"`
+	// Update compares the applied & available versions
+	// It's ✔ when the applied version is the latest available, or new: <version> when a newer version isn't deployed yet
+	// +optional
+	Update string `json:"update,omitempty"`
+}
+
+// VersionHistory records a chart version Helm deployed
+type VersionHistory struct {
+	// Version of the chart
+	Version string `json:"version"`
+	// DeployedAt is when the version was first seen deployed
+	DeployedAt metav1.Time `json:"deployedAt"`
+}
+
+// PlanAction is what a reconcile does to a child
+// +kubebuilder:validation:Enum=Create;Update;Unchanged;Delete
+type PlanAction string
+
+const (
+	// PlanActionCreate is used for a child which doesn't exist yet
+	PlanActionCreate PlanAction = "Create"
+	// PlanActionUpdate is used for an existing child which differs from the spec
+	PlanActionUpdate PlanAction = "Update"
+	// PlanActionUnchanged is used for an existing child which already matches the spec
+	PlanActionUnchanged PlanAction = "Unchanged"
+	// PlanActionDelete is used for an existing child which is no longer needed
+	PlanActionDelete PlanAction = "Delete"
+)
+
+// PlanEntry records what a reconcile does to a child
+type PlanEntry struct {
+	// Kind of the child
+	Kind string `json:"kind"`
+	// Name of the child
+	Name string `json:"name"`
+	// Action taken on the child
+	Action PlanAction `json:"action"`
+}
+
+// ErrorType classifies a reconcile error
+// +kubebuilder:validation:Enum=Transient;Permanent
+type ErrorType string
+
+const (
+	// ErrorTypeTransient is used for errors which may resolve on retry e.g. registry timeouts
+	ErrorTypeTransient ErrorType = "Transient"
+	// ErrorTypePermanent is used for errors which require a change to the FluxApp e.g. an invalid URL
+	ErrorTypePermanent ErrorType = "Permanent"
+)
+
+// LastError defines the most recent error encountered during reconciliation
+type LastError struct {
+	// Type of the error
+	Type ErrorType `json:"type"`
+	// Message of the error
+	Message string `json:"message"`
+	// Time the error first occurred, kept while the same error repeats
+	Time metav1.Time `json:"time"`
+}
+
+// GetCreateNamespace returns whether the target namespace should be created, defaulting to true
+func (in FluxAppSpec) GetCreateNamespace() bool {
+	if in.CreateNamespace == nil {
+		return true
+	}
+	return *in.CreateNamespace
 }
 
 // GetConditions returns the status conditions of the object.
@@ -75,7 +597,10 @@ func (in *FluxApp) SetConditions(conditions []metav1.Condition) {
 // +kubebuilder:resource:shortName=fa
 // +kubebuilder:printcolumn:name="Chart",type=string,JSONPath=`.status.chart.name`
 // +kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.chart.version`
+// +kubebuilder:printcolumn:name="Available",type=string,JSONPath=`.status.chart.availableVersion`,priority=1
+// +kubebuilder:printcolumn:name="Update",type=string,JSONPath=`.status.chart.update`
 // +kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].message`
+// +kubebuilder:printcolumn:name="Description",type=string,JSONPath=`.spec.description`,priority=1
 
 // FluxApp is the Schema for the fluxapps API.
 type FluxApp struct {
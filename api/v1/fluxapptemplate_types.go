@@ -0,0 +1,69 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FluxAppTemplateSpec defines the common fields inherited by FluxApps referencing the template.
+// Fields set on a FluxApp take precedence over the template.
+type FluxAppTemplateSpec struct {
+	// Interval at which the HelmRelease is reconciled
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:Pattern="^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$"
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// ScanInterval at which the chart repository is scanned for new versions
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:Pattern="^([0-9]+(\\.[0-9]+)?(ms|s|m|h))+$"
+	// +optional
+	ScanInterval *metav1.Duration `json:"scanInterval,omitempty"`
+	// DriftDetection sets the drift detection mode of the HelmRelease
+	// +kubebuilder:validation:Enum=enabled;warn;disabled
+	// +optional
+	DriftDetection helmv2.DriftDetectionMode `json:"driftDetection,omitempty"`
+	// Provider used to authenticate with the chart repository
+	// +kubebuilder:validation:Enum=aws;azure;gcp;generic
+	// +optional
+	Provider string `json:"provider,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:shortName=fat
+
+// FluxAppTemplate is the Schema for the fluxapptemplates API.
+type FluxAppTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec FluxAppTemplateSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// FluxAppTemplateList contains a list of FluxAppTemplate.
+type FluxAppTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FluxAppTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FluxAppTemplate{}, &FluxAppTemplateList{})
+}
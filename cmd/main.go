@@ -17,20 +17,34 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -38,9 +52,11 @@ import (
 	helmv2 "github.com/fluxcd/helm-controller/api/v2"
 	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
 
 	appsv1 "github.com/kloudyuk/fluxer/api/v1"
 	"github.com/kloudyuk/fluxer/internal/controller"
+	webhookappsv1 "github.com/kloudyuk/fluxer/internal/webhook/v1"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -51,6 +67,7 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
 
 	utilruntime.Must(appsv1.AddToScheme(scheme))
 
@@ -58,6 +75,7 @@ func init() {
 	utilruntime.Must(helmv2.AddToScheme(scheme))
 	utilruntime.Must(imagev1.AddToScheme(scheme))
 	utilruntime.Must(sourcev1.AddToScheme(scheme))
+	utilruntime.Must(sourcev1beta2.AddToScheme(scheme))
 
 	// +kubebuilder:scaffold:scheme
 }
@@ -68,6 +86,34 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var scanRequeueInterval time.Duration
+	var queueDepthThreshold int
+	var queueDepthPeriod time.Duration
+	var maxConcurrentReconciles int
+	var jitterFactor float64
+	var shutdownGracePeriod time.Duration
+	var statusPatchAttempts int
+	var labelSelector string
+	substitutions := map[string]string{}
+	namespaceDefaultValues := map[string]types.NamespacedName{}
+	var adminAddr string
+	var adminToken string
+	var checkChartDeprecation bool
+	var inspectChartArtifacts bool
+	var validateCustomResourceValues bool
+	var clusterArchitecture string
+	var ownerReferenceMode string
+	var defaultValues string
+	var privilegedNamespaces string
+	var notificationURL string
+	var slowDeletionThreshold time.Duration
+	var reconcileBudget time.Duration
+	var disableFinalizer bool
+	var auditLogEntries int
+	var quarantineAfter int
+	var quarantineInterval time.Duration
+	var defaultTimeout time.Duration
+	var defaultMaxHistory int
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -79,6 +125,89 @@ func main() {
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.DurationVar(&scanRequeueInterval, "scan-requeue-interval", 10*time.Second,
+		"How often to check for the chart version while waiting for the chart repository scan.")
+	flag.IntVar(&queueDepthThreshold, "queue-depth-threshold", 0,
+		"The workqueue depth above which the controller reports not ready. Set to 0 to disable the check.")
+	flag.DurationVar(&queueDepthPeriod, "queue-depth-period", 5*time.Minute,
+		"How long the workqueue depth must exceed the threshold before the controller reports not ready.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 4,
+		"The maximum number of FluxApps which can be reconciled concurrently.")
+	flag.Float64Var(&jitterFactor, "jitter-factor", 0.1,
+		"The maximum fraction of the requeue & child intervals added as jitter, between 0 and 1. Set to 0 to disable jitter.")
+	flag.DurationVar(&shutdownGracePeriod, "shutdown-grace-period", 30*time.Second,
+		"How long in-flight reconciles have to finish and persist their status when the controller is stopped.")
+	flag.IntVar(&statusPatchAttempts, "status-patch-attempts", 5,
+		"How many times the FluxApp status patch is attempted if it conflicts with another update.")
+	flag.StringVar(&labelSelector, "label-selector", "",
+		"Only reconcile FluxApps matching the label selector e.g. shard=a. Used to shard apps between multiple controllers.")
+	flag.StringVar(&adminAddr, "admin-bind-address", "0",
+		"The address the admin endpoint binds to e.g. :8082, or leave as 0 to disable the admin endpoint.")
+	flag.StringVar(&adminToken, "admin-token", "",
+		"The bearer token required by the admin endpoint. Required when the admin endpoint is enabled.")
+	flag.BoolVar(&checkChartDeprecation, "check-chart-deprecation", false,
+		"If set, the metadata of the selected chart version is read from the registry to warn if the chart is deprecated.")
+	flag.BoolVar(&inspectChartArtifacts, "inspect-chart-artifacts", false,
+		"If set, the manifest of the selected chart version is read from the registry to record the chart size in the status.")
+	flag.BoolVar(&validateCustomResourceValues, "validate-custom-resource-values", false,
+		"If set, the customResourceValues of apps are validated against the schema of the installed CRDs.")
+	flag.StringVar(&clusterArchitecture, "cluster-architecture", "",
+		"The architecture of the cluster nodes e.g. amd64. If set, a warning is set on apps whose chart artifact "+
+			"lists platforms without the architecture.")
+	flag.StringVar(&ownerReferenceMode, "owner-reference-mode", string(controller.ControllerOwnerReferenceMode),
+		"How FluxApps are set as the owner of their children, either controller or non-controller. "+
+			"Use non-controller if other systems also need to own the children.")
+	flag.StringVar(&defaultValues, "default-values", "",
+		"The namespace/name of a ConfigMap whose values.yaml is merged under the values of every FluxApp "+
+			"e.g. to set org-wide resource limits.")
+	flag.StringVar(&privilegedNamespaces, "privileged-namespaces", "",
+		"A comma separated list of the namespaces whose FluxApps can deploy to another namespace e.g. flux-system. "+
+			"FluxApps in other namespaces can only deploy into their own namespace. If unset, every namespace is privileged.")
+	flag.StringVar(&notificationURL, "notification-url", "",
+		"The URL of a webhook which is posted a JSON notification when a FluxApp becomes Ready or Failed e.g. for ChatOps.")
+	flag.DurationVar(&slowDeletionThreshold, "slow-deletion-threshold", 5*time.Minute,
+		"How long a FluxApp deletion can take before it's counted in fluxer_slow_deletions_total. Set to 0 to disable.")
+	flag.DurationVar(&reconcileBudget, "reconcile-budget", 0,
+		"How long a reconcile can run the handlers before the rest are deferred to a requeue. Set to 0 to disable.")
+	flag.BoolVar(&disableFinalizer, "disable-finalizer", false,
+		"If set, the finalizer isn't added to FluxApps, and is removed from existing ones, so the children are only "+
+			"removed by the owner reference garbage collection.")
+	flag.IntVar(&auditLogEntries, "audit-log-entries", 0,
+		"How many entries are kept in the <name>-audit ConfigMap recording the version changes & Ready transitions "+
+			"of each FluxApp. Set to 0 to disable.")
+	flag.IntVar(&quarantineAfter, "quarantine-after", 0,
+		"How many reconciles of a FluxApp can fail in a row before it's quarantined and only reconciled every "+
+			"quarantine-interval until its spec changes. Set to 0 to disable.")
+	flag.DurationVar(&quarantineInterval, "quarantine-interval", time.Hour,
+		"How often a quarantined FluxApp is reconciled.")
+	flag.DurationVar(&defaultTimeout, "default-timeout", 0,
+		"The Helm timeout of FluxApps which don't set timeout. Set to 0 to use the HelmRelease default.")
+	flag.IntVar(&defaultMaxHistory, "default-max-history", 0,
+		"How many Helm release revisions are kept for FluxApps which don't set maxHistory. "+
+			"Set to 0 to use the HelmRelease default.")
+	flag.Func("substitute",
+		"A name=value substitution for the ${name} tokens in the values of FluxApps with substituteValues set "+
+			"e.g. clusterName=prod-eu. Can be repeated.",
+		func(s string) error {
+			name, value, ok := strings.Cut(s, "=")
+			if !ok || name == "" {
+				return fmt.Errorf("expected name=value but got %q", s)
+			}
+			substitutions[name] = value
+			return nil
+		})
+	flag.Func("namespace-default-values",
+		"A namespace=namespace/name mapping of a namespace to a ConfigMap whose values.yaml is merged under the values "+
+			"of the FluxApps in the namespace, over the default-values e.g. team-a=platform/team-a-defaults. Can be repeated.",
+		func(s string) error {
+			namespace, ref, _ := strings.Cut(s, "=")
+			cmNamespace, cmName, ok := strings.Cut(ref, "/")
+			if namespace == "" || !ok || cmNamespace == "" || cmName == "" {
+				return fmt.Errorf("expected namespace=namespace/name but got %q", s)
+			}
+			namespaceDefaultValues[namespace] = types.NamespacedName{Namespace: cmNamespace, Name: cmName}
+			return nil
+		})
 	opts := zap.Options{
 		Development: true,
 	}
@@ -87,6 +216,51 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if scanRequeueInterval <= 0 {
+		setupLog.Error(nil, "scan-requeue-interval must be greater than 0", "scan-requeue-interval", scanRequeueInterval)
+		os.Exit(1)
+	}
+	if jitterFactor < 0 || jitterFactor > 1 {
+		setupLog.Error(nil, "jitter-factor must be between 0 and 1", "jitter-factor", jitterFactor)
+		os.Exit(1)
+	}
+	if statusPatchAttempts < 1 {
+		setupLog.Error(nil, "status-patch-attempts must be at least 1", "status-patch-attempts", statusPatchAttempts)
+		os.Exit(1)
+	}
+	ownerRefMode, err := controller.ParseOwnerReferenceMode(ownerReferenceMode)
+	if err != nil {
+		setupLog.Error(err, "invalid owner-reference-mode")
+		os.Exit(1)
+	}
+	if notificationURL != "" {
+		if u, err := url.Parse(notificationURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			setupLog.Error(err, "notification-url must be an http or https URL", "notification-url", notificationURL)
+			os.Exit(1)
+		}
+	}
+	var defaultValuesKey types.NamespacedName
+	if defaultValues != "" {
+		namespace, name, ok := strings.Cut(defaultValues, "/")
+		if !ok || namespace == "" || name == "" {
+			setupLog.Error(nil, "default-values must be namespace/name", "default-values", defaultValues)
+			os.Exit(1)
+		}
+		defaultValuesKey = types.NamespacedName{Namespace: namespace, Name: name}
+	}
+	var selector labels.Selector
+	leaderElectionID := "b8cf36ef.kloudy.uk"
+	if labelSelector != "" {
+		if selector, err = labels.Parse(labelSelector); err != nil {
+			setupLog.Error(err, "unable to parse label-selector", "label-selector", labelSelector)
+			os.Exit(1)
+		}
+		// Each shard needs its own leader
+		h := fnv.New32a()
+		h.Write([]byte(selector.String()))
+		leaderElectionID = fmt.Sprintf("%x.%s", h.Sum32(), leaderElectionID)
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -134,7 +308,13 @@ func main() {
 		WebhookServer:          webhookServer,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "b8cf36ef.kloudy.uk",
+		LeaderElectionID:       leaderElectionID,
+		// Only the generated kubeconfig Secrets are read so don't cache every Secret in the cluster
+		Client: client.Options{
+			Cache: &client.CacheOptions{DisableFor: []client.Object{&corev1.Secret{}, &corev1.ConfigMap{}}},
+		},
+		// Wait for in-flight reconciles to persist their status before exiting
+		GracefulShutdownTimeout: &shutdownGracePeriod,
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -153,14 +333,102 @@ func main() {
 	}
 	c := mgr.GetClient()
 	scheme := mgr.GetScheme()
-	if err = (&controller.FluxAppReconciler{
-		Client:          c,
-		Scheme:          scheme,
-		ResourceManager: controller.NewResourceManager(c, scheme),
-	}).SetupWithManager(mgr); err != nil {
+	reconciler := &controller.FluxAppReconciler{
+		Client:                c,
+		Scheme:                scheme,
+		ResourceManager:       controller.NewResourceManager(c, scheme, ownerRefMode),
+		ScanRequeueInterval:   scanRequeueInterval,
+		JitterFactor:          jitterFactor,
+		ChartCache:            controller.NewChartCache(),
+		ShutdownGracePeriod:   shutdownGracePeriod,
+		StatusPatchAttempts:   statusPatchAttempts,
+		LabelSelector:         selector,
+		Recorder:              mgr.GetEventRecorderFor(controller.ControllerName),
+		Substitutions:         substitutions,
+		SlowDeletionThreshold: slowDeletionThreshold,
+		ReconcileBudget:       reconcileBudget,
+		DisableFinalizer:      disableFinalizer,
+		AuditLogEntries:       auditLogEntries,
+		QuarantineAfter:       quarantineAfter,
+		QuarantineInterval:    quarantineInterval,
+		DefaultTimeout:        defaultTimeout,
+		DefaultMaxHistory:     defaultMaxHistory,
+	}
+	if privilegedNamespaces != "" {
+		for _, namespace := range strings.Split(privilegedNamespaces, ",") {
+			if namespace = strings.TrimSpace(namespace); namespace != "" {
+				reconciler.PrivilegedNamespaces = append(reconciler.PrivilegedNamespaces, namespace)
+			}
+		}
+		// An empty list would make every namespace privileged
+		if len(reconciler.PrivilegedNamespaces) == 0 {
+			setupLog.Error(nil, "privileged-namespaces must list at least one namespace", "privileged-namespaces", privilegedNamespaces)
+			os.Exit(1)
+		}
+	}
+	if notificationURL != "" {
+		reconciler.Notifier = controller.NewNotifier(&http.Client{Timeout: 10 * time.Second}, notificationURL)
+	}
+	// The CRDs are read directly rather than caching every CRD in the cluster
+	if validateCustomResourceValues {
+		reconciler.CustomResourceDefinitions = mgr.GetAPIReader()
+	}
+	if defaultValues != "" {
+		reconciler.DefaultValues = controller.NewDefaultValues(mgr.GetAPIReader(), defaultValuesKey)
+	}
+	// Namespaces sharing a ConfigMap share its cache
+	if len(namespaceDefaultValues) > 0 {
+		reconciler.NamespaceDefaultValues = map[string]*controller.DefaultValues{}
+		shared := map[types.NamespacedName]*controller.DefaultValues{}
+		for namespace, key := range namespaceDefaultValues {
+			if shared[key] == nil {
+				shared[key] = controller.NewDefaultValues(mgr.GetAPIReader(), key)
+			}
+			reconciler.NamespaceDefaultValues[namespace] = shared[key]
+		}
+	}
+	// The registry client is shared so the chart checks reuse the same connections
+	registryClient := controller.NewRegistryClient(&http.Client{Timeout: 30 * time.Second})
+	reconciler.ChartProber = registryClient
+	reconciler.ChartTags = registryClient
+	reconciler.ChartDigests = registryClient
+	if checkChartDeprecation {
+		reconciler.ChartMetadata = registryClient
+	}
+	if inspectChartArtifacts {
+		reconciler.ChartManifests = registryClient
+	}
+	if clusterArchitecture != "" {
+		reconciler.ClusterArchitecture = clusterArchitecture
+		reconciler.ChartPlatforms = registryClient
+	}
+	if err = reconciler.SetupWithManager(mgr, crcontroller.Options{
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+	}); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "FluxApp")
 		os.Exit(1)
 	}
+	if adminAddr != "0" {
+		if adminToken == "" {
+			setupLog.Error(nil, "admin-token is required when the admin endpoint is enabled")
+			os.Exit(1)
+		}
+		// The admin endpoint enqueues reconciles so it's only served by the leader
+		handler := reconciler.AdminHandler(adminToken)
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			return controller.ServeAdmin(ctx, adminAddr, handler)
+		})); err != nil {
+			setupLog.Error(err, "unable to set up admin endpoint")
+			os.Exit(1)
+		}
+	}
+	// nolint:goconst
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = webhookappsv1.SetupFluxAppWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "FluxApp")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -171,6 +439,13 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if queueDepthThreshold > 0 {
+		check := controller.NewQueueDepthCheck(controller.ControllerName, queueDepthThreshold, queueDepthPeriod)
+		if err := mgr.AddReadyzCheck("queue-depth", check.Check); err != nil {
+			setupLog.Error(err, "unable to set up queue depth check")
+			os.Exit(1)
+		}
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -0,0 +1,139 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package childpatch applies the FluxApp child patches to the children generated by the controller
+// It's shared by the controller, which patches the children, and the webhook, which validates the patches
+package childpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+)
+
+// kinds returns an empty object for each kind of child which can be patched
+// The ConfigMaps & Secrets holding values and credentials are generated from other fields so can't be patched
+var kinds = map[string]func() client.Object{
+	imagev1.ImageRepositoryKind:     func() client.Object { return &imagev1.ImageRepository{} },
+	imagev1.ImagePolicyKind:         func() client.Object { return &imagev1.ImagePolicy{} },
+	sourcev1.HelmRepositoryKind:     func() client.Object { return &sourcev1.HelmRepository{} },
+	sourcev1.GitRepositoryKind:      func() client.Object { return &sourcev1.GitRepository{} },
+	sourcev1beta2.OCIRepositoryKind: func() client.Object { return &sourcev1beta2.OCIRepository{} },
+	helmv2.HelmReleaseKind:          func() client.Object { return &helmv2.HelmRelease{} },
+}
+
+// protectedField is a spec field of a child which a patch can't change
+type protectedField struct {
+	path  string
+	value func(client.Object) interface{}
+}
+
+// protected are the spec fields of each kind which a patch can't change
+// They decide where and as whom the release is deployed and which chart is deployed,
+// which the controller restricts outside the privileged namespaces
+// The fields are compared on the patched child rather than looked up in the patch
+// as the patched child is decoded case insensitively e.g. from spec.TargetNamespace
+var protected = map[string][]protectedField{
+	helmv2.HelmReleaseKind: {
+		{"targetNamespace", func(o client.Object) interface{} { return o.(*helmv2.HelmRelease).Spec.TargetNamespace }},
+		{"storageNamespace", func(o client.Object) interface{} { return o.(*helmv2.HelmRelease).Spec.StorageNamespace }},
+		{"serviceAccountName", func(o client.Object) interface{} { return o.(*helmv2.HelmRelease).Spec.ServiceAccountName }},
+		{"kubeConfig", func(o client.Object) interface{} { return o.(*helmv2.HelmRelease).Spec.KubeConfig }},
+		{"releaseName", func(o client.Object) interface{} { return o.(*helmv2.HelmRelease).Spec.ReleaseName }},
+		{"chartRef", func(o client.Object) interface{} { return o.(*helmv2.HelmRelease).Spec.ChartRef }},
+		{"chart.spec.sourceRef", func(o client.Object) interface{} {
+			if chart := o.(*helmv2.HelmRelease).Spec.Chart; chart != nil {
+				return chart.Spec.SourceRef
+			}
+			return helmv2.CrossNamespaceObjectReference{}
+		}},
+	},
+}
+
+// Kinds returns the kinds of child which can be patched, sorted for error messages
+func Kinds() []string {
+	names := make([]string, 0, len(kinds))
+	for kind := range kinds {
+		names = append(names, kind)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Supported returns true if children of the kind can be patched
+func Supported(kind string) bool {
+	_, ok := kinds[kind]
+	return ok
+}
+
+// Validate returns an error if the patch can't be applied to a child of the kind
+// The patch is applied to an empty child so a field the child doesn't have is rejected
+func Validate(kind string, patch []byte) error {
+	newObject, ok := kinds[kind]
+	if !ok {
+		return fmt.Errorf("unsupported kind %s", kind)
+	}
+	return Apply(kind, newObject(), patch)
+}
+
+// Apply applies the strategic merge patch to the spec of the child of the kind
+// The child is only changed if the patch applies cleanly, and the patch can only change the spec
+// so the name, namespace & owner references set by the controller are kept
+func Apply(kind string, obj client.Object, patch []byte) error {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		return fmt.Errorf("patch must be an object: %w", err)
+	}
+	for field := range fields {
+		if field != "spec" {
+			return fmt.Errorf("patch can only change the spec, not %s", field)
+		}
+	}
+	original, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	patched, err := strategicpatch.StrategicMergePatch(original, patch, obj)
+	if err != nil {
+		return err
+	}
+	// Decode into an empty child so fields removed by the patch are removed from the child
+	result := reflect.New(reflect.TypeOf(obj).Elem())
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(result.Interface()); err != nil {
+		return err
+	}
+	patchedObj := result.Interface().(client.Object)
+	for _, field := range protected[kind] {
+		if !equality.Semantic.DeepEqual(field.value(obj), field.value(patchedObj)) {
+			return fmt.Errorf("patch can't set spec.%s", field.path)
+		}
+	}
+	reflect.ValueOf(obj).Elem().Set(result.Elem())
+	return nil
+}
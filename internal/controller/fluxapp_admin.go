@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// adminShutdownTimeout is how long in-flight admin requests have to finish when the controller stops
+const adminShutdownTimeout = 5 * time.Second
+
+// AdminHandler returns the admin API handler
+// POST /reconcile/{namespace}/{name} enqueues the FluxApp for an immediate reconcile
+// GET /resolve?repo=...&range=... returns the chart version a FluxApp would select
+// Every request must have the token as a bearer token
+func (r *FluxAppReconciler) AdminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /reconcile/{namespace}/{name}", r.handleReconcileRequest)
+	mux.HandleFunc("GET /resolve", r.handleResolveRequest)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		bearer, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if token == "" || !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+// handleReconcileRequest enqueues the FluxApp named in the path
+func (r *FluxAppReconciler) handleReconcileRequest(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	key := types.NamespacedName{Namespace: req.PathValue("namespace"), Name: req.PathValue("name")}
+	app := &appsv1.FluxApp{}
+	if err := r.Get(ctx, key, app); err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			http.Error(w, "FluxApp not found", http.StatusNotFound)
+			return
+		}
+		log.FromContext(ctx).Error(err, "unable to fetch FluxApp", "fluxapp", key)
+		http.Error(w, "unable to fetch FluxApp", http.StatusInternalServerError)
+		return
+	}
+	// Apps in another shard are reconciled by another controller
+	if !r.inShard(app) {
+		http.Error(w, "FluxApp not found", http.StatusNotFound)
+		return
+	}
+	select {
+	case r.resync <- event.GenericEvent{Object: app}:
+		w.WriteHeader(http.StatusAccepted)
+	case <-ctx.Done():
+		http.Error(w, "timed out enqueuing FluxApp", http.StatusServiceUnavailable)
+	}
+}
+
+// Resolution is the chart version selected for the resolve request
+type Resolution struct {
+	Repository string `json:"repository"`
+	Name       string `json:"name"`
+	Provider   string `json:"provider"`
+	Range      string `json:"range"`
+	Selection  string `json:"selection"`
+	Version    string `json:"version"`
+}
+
+// handleResolveRequest returns the chart version selected by the version range without creating any resources
+// so a version range can be tried before it's applied
+// The repo & range query parameters are the chart.repository & chart.version of a FluxApp,
+// with the optional tagPrefix, selection & provider parameters for the other chart fields
+func (r *FluxAppReconciler) handleResolveRequest(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	query := req.URL.Query()
+	app := &appsv1.FluxApp{Spec: appsv1.FluxAppSpec{Chart: appsv1.Chart{
+		Repository:       query.Get("repo"),
+		Version:          query.Get("range"),
+		TagPrefix:        query.Get("tagPrefix"),
+		VersionSelection: query.Get("selection"),
+		Provider:         query.Get("provider"),
+	}}}
+	if app.Spec.Chart.Version == "" {
+		app.Spec.Chart.Version = "*"
+	}
+	if app.Spec.Chart.VersionSelection == "" {
+		app.Spec.Chart.VersionSelection = appsv1.VersionSelectionHighest
+	}
+	if !strings.HasPrefix(app.Spec.Chart.Repository, "oci://") {
+		http.Error(w, "repo must be an oci:// chart repository", http.StatusBadRequest)
+		return
+	}
+	if s := app.Spec.Chart.VersionSelection; s != appsv1.VersionSelectionHighest && s != appsv1.VersionSelectionLowest {
+		http.Error(w, "selection must be highest or lowest", http.StatusBadRequest)
+		return
+	}
+	switch app.Spec.Chart.Provider {
+	case "", "aws", "azure", "gcp", "generic":
+	default:
+		http.Error(w, "provider must be aws, azure, gcp or generic", http.StatusBadRequest)
+		return
+	}
+	if r.ChartTags == nil {
+		http.Error(w, "chart tags can't be listed", http.StatusServiceUnavailable)
+		return
+	}
+	chart, err := resolveChart(app)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resolution := Resolution{
+		Repository: "oci://" + path.Dir(chart.image),
+		Name:       path.Base(chart.image),
+		Provider:   chart.provider,
+		Range:      app.Spec.Chart.Version,
+		Selection:  app.Spec.Chart.VersionSelection,
+	}
+	tags, err := r.ChartTags.ChartTags(ctx, resolution.Repository, resolution.Name)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to list chart tags", "repository", app.Spec.Chart.Repository)
+		http.Error(w, fmt.Sprintf("unable to list chart tags: %s", err), http.StatusBadGateway)
+		return
+	}
+	resolution.Version, err = selectVersion(tags, app.Spec.Chart.Version, app.Spec.Chart.TagPrefix, app.Spec.Chart.VersionSelection)
+	switch {
+	case errors.Is(err, errInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, errNoMatchingTags):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resolution); err != nil {
+		log.FromContext(ctx).Error(err, "unable to write resolution")
+	}
+}
+
+// ServeAdmin serves the admin API on the address until the context is cancelled
+func ServeAdmin(ctx context.Context, addr string, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), adminShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
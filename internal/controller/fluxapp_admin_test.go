@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+var _ = Describe("Admin endpoint", func() {
+	const token = "s3cret"
+
+	// serve sends the request to the admin handler of a reconciler with the app
+	serve := func(r *FluxAppReconciler, method, path, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		r.AdminHandler(token).ServeHTTP(rec, req)
+		return rec
+	}
+
+	newAdminReconciler := func() *FluxAppReconciler {
+		r := newTestReconciler(newTestApp())
+		r.resync = make(chan event.GenericEvent, 1)
+		return r
+	}
+
+	It("should enqueue the FluxApp", func() {
+		r := newAdminReconciler()
+		rec := serve(r, http.MethodPost, "/reconcile/default/podinfo", "Bearer "+token)
+		Expect(rec.Code).To(Equal(http.StatusAccepted))
+		var e event.GenericEvent
+		Expect(r.resync).To(Receive(&e))
+		Expect(client.ObjectKeyFromObject(e.Object)).To(Equal(client.ObjectKeyFromObject(newTestApp())))
+	})
+
+	DescribeTable("should reject bad auth",
+		func(auth string) {
+			r := newAdminReconciler()
+			rec := serve(r, http.MethodPost, "/reconcile/default/podinfo", auth)
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			Expect(rec.Header().Get("WWW-Authenticate")).To(Equal("Bearer"))
+			Expect(r.resync).NotTo(Receive())
+		},
+		Entry("missing token", ""),
+		Entry("wrong token", "Bearer wrong"),
+		Entry("wrong scheme", "Basic "+token),
+	)
+
+	It("should reject every request when no token is configured", func() {
+		r := newAdminReconciler()
+		req := httptest.NewRequest(http.MethodPost, "/reconcile/default/podinfo", nil)
+		req.Header.Set("Authorization", "Bearer ")
+		rec := httptest.NewRecorder()
+		r.AdminHandler("").ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should report a missing FluxApp", func() {
+		r := newAdminReconciler()
+		rec := serve(r, http.MethodPost, "/reconcile/default/missing", "Bearer "+token)
+		Expect(rec.Code).To(Equal(http.StatusNotFound))
+		Expect(r.resync).NotTo(Receive())
+	})
+
+	It("should not enqueue a FluxApp in another shard", func() {
+		r := newAdminReconciler()
+		r.LabelSelector = labels.SelectorFromSet(labels.Set{"shard": "b"})
+		rec := serve(r, http.MethodPost, "/reconcile/default/podinfo", "Bearer "+token)
+		Expect(rec.Code).To(Equal(http.StatusNotFound))
+		Expect(r.resync).NotTo(Receive())
+	})
+
+	Context("resolve", func() {
+		tags := []string{"6.4.0", "6.5.0", "6.5.3", "6.6.0", "7.0.0", "chart-5.0.0", "latest"}
+
+		resolve := func(tags *fakeChartTags, query string) *httptest.ResponseRecorder {
+			r := newAdminReconciler()
+			r.ChartTags = tags
+			return serve(r, http.MethodGet, "/resolve?"+query, "Bearer "+token)
+		}
+
+		DescribeTable("should return the selected version",
+			func(query string, expected Resolution) {
+				fake := &fakeChartTags{tags: tags}
+				rec := resolve(fake, query)
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+				var resolution Resolution
+				Expect(json.Unmarshal(rec.Body.Bytes(), &resolution)).To(Succeed())
+				Expect(resolution).To(Equal(expected))
+				Expect(fake.repository).To(Equal(expected.Repository))
+				Expect(fake.name).To(Equal(expected.Name))
+			},
+			Entry("highest in range", "repo=oci://ghcr.io/stefanprodan/charts/podinfo&range=6.x", Resolution{
+				Repository: "oci://ghcr.io/stefanprodan/charts", Name: "podinfo", Provider: "generic",
+				Range: "6.x", Selection: "highest", Version: "6.6.0",
+			}),
+			Entry("any version by default", "repo=oci://ghcr.io/stefanprodan/charts/podinfo", Resolution{
+				Repository: "oci://ghcr.io/stefanprodan/charts", Name: "podinfo", Provider: "generic",
+				Range: "*", Selection: "highest", Version: "7.0.0",
+			}),
+			Entry("lowest in range", "repo=oci://ghcr.io/stefanprodan/charts/podinfo&range=%3E%3D6.5.1&selection=lowest", Resolution{
+				Repository: "oci://ghcr.io/stefanprodan/charts", Name: "podinfo", Provider: "generic",
+				Range: ">=6.5.1", Selection: "lowest", Version: "6.5.3",
+			}),
+			Entry("tag prefix", "repo=oci://ghcr.io/stefanprodan/charts/podinfo&range=5.x&tagPrefix=chart-", Resolution{
+				Repository: "oci://ghcr.io/stefanprodan/charts", Name: "podinfo", Provider: "generic",
+				Range: "5.x", Selection: "highest", Version: "5.0.0",
+			}),
+			Entry("detected provider", "repo=oci://123456789012.dkr.ecr.eu-west-1.amazonaws.com/charts/podinfo&range=6.5.x", Resolution{
+				Repository: "oci://123456789012.dkr.ecr.eu-west-1.amazonaws.com/charts", Name: "podinfo", Provider: "aws",
+				Range: "6.5.x", Selection: "highest", Version: "6.5.3",
+			}),
+			Entry("provider override", "repo=oci://europe-docker.pkg.dev.gcr.io/charts/podinfo&provider=generic", Resolution{
+				Repository: "oci://europe-docker.pkg.dev.gcr.io/charts", Name: "podinfo", Provider: "generic",
+				Range: "*", Selection: "highest", Version: "7.0.0",
+			}),
+		)
+
+		DescribeTable("should reject a request which can't be resolved",
+			func(fake *fakeChartTags, query string, code int) {
+				rec := resolve(fake, query)
+				Expect(rec.Code).To(Equal(code))
+			},
+			Entry("missing repo", &fakeChartTags{tags: tags}, "range=6.x", http.StatusBadRequest),
+			Entry("not an OCI repo", &fakeChartTags{tags: tags}, "repo=https://stefanprodan.github.io/podinfo", http.StatusBadRequest),
+			Entry("invalid range", &fakeChartTags{tags: tags}, "repo=oci://ghcr.io/stefanprodan/charts/podinfo&range=6.x.y.z", http.StatusBadRequest),
+			Entry("invalid selection", &fakeChartTags{tags: tags}, "repo=oci://ghcr.io/stefanprodan/charts/podinfo&selection=newest", http.StatusBadRequest),
+			Entry("invalid provider", &fakeChartTags{tags: tags}, "repo=oci://ghcr.io/stefanprodan/charts/podinfo&provider=oci", http.StatusBadRequest),
+			Entry("no matching version", &fakeChartTags{tags: tags}, "repo=oci://ghcr.io/stefanprodan/charts/podinfo&range=8.x", http.StatusNotFound),
+			Entry("registry error", &fakeChartTags{err: errors.New("connection refused")}, "repo=oci://ghcr.io/stefanprodan/charts/podinfo", http.StatusBadGateway),
+		)
+
+		It("should not create any resources", func() {
+			r := newAdminReconciler()
+			r.ChartTags = &fakeChartTags{tags: tags}
+			rec := serve(r, http.MethodGet, "/resolve?repo=oci://ghcr.io/stefanprodan/charts/podinfo", "Bearer "+token)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			repos := &imagev1.ImageRepositoryList{}
+			Expect(r.List(context.Background(), repos)).To(Succeed())
+			Expect(repos.Items).To(BeEmpty())
+		})
+
+		It("should require the token", func() {
+			rec := resolve(&fakeChartTags{tags: tags}, "repo=oci://ghcr.io/stefanprodan/charts/podinfo")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			r := newAdminReconciler()
+			r.ChartTags = &fakeChartTags{tags: tags}
+			rec = serve(r, http.MethodGet, "/resolve?repo=oci://ghcr.io/stefanprodan/charts/podinfo", "")
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	It("should only allow POST", func() {
+		r := newAdminReconciler()
+		rec := serve(r, http.MethodGet, "/reconcile/default/podinfo", "Bearer "+token)
+		Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(r.resync).NotTo(Receive())
+	})
+})
@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/yaml"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// valuesAnnotationPrefix prefixes the FluxApp annotations which set a top level value
+// e.g. values.kloudy.uk/replicaCount: "3"
+const valuesAnnotationPrefix = "values.kloudy.uk/"
+
+// annotationValues returns the values with the top level keys set by the app values annotations
+// Each annotation is parsed as YAML so numbers, booleans & objects keep their type
+// The annotations replace the whole top level key, taking precedence over the values, so they can be
+// used for quick tweaks with kubectl annotate
+func annotationValues(app *appsv1.FluxApp, values *apiextensionsv1.JSON) (*apiextensionsv1.JSON, error) {
+	var keys []string
+	for key := range app.GetAnnotations() {
+		if strings.HasPrefix(key, valuesAnnotationPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return values, nil
+	}
+	// Sort the annotations so the first invalid annotation is always reported
+	sort.Strings(keys)
+	merged := map[string]interface{}{}
+	if values != nil && len(values.Raw) > 0 {
+		if err := json.Unmarshal(values.Raw, &merged); err != nil {
+			return nil, fmt.Errorf("%w values: %w", errInvalid, err)
+		}
+	}
+	for _, key := range keys {
+		var v interface{}
+		if err := yaml.Unmarshal([]byte(app.GetAnnotations()[key]), &v); err != nil {
+			return nil, fmt.Errorf("%w annotation %s: %w", errInvalid, key, err)
+		}
+		merged[strings.TrimPrefix(key, valuesAnnotationPrefix)] = v
+	}
+	raw, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	return &apiextensionsv1.JSON{Raw: raw}, nil
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("Annotation values", func() {
+	newAnnotatedApp := func(annotations map[string]string) *appsv1.FluxApp {
+		app := newTestApp()
+		app.Annotations = annotations
+		return app
+	}
+
+	It("should set the top level values from the annotations", func() {
+		app := newAnnotatedApp(map[string]string{
+			"values.kloudy.uk/replicaCount": "3",
+			"values.kloudy.uk/debug":        "true",
+			"values.kloudy.uk/logLevel":     "info",
+			"values.kloudy.uk/resources":    `{"limits": {"memory": "256Mi"}}`,
+			"apps.kloudy.uk/features":       "",
+		})
+		values, err := annotationValues(app, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values.Raw).To(MatchJSON(`{"replicaCount":3,"debug":true,"logLevel":"info","resources":{"limits":{"memory":"256Mi"}}}`))
+	})
+
+	It("should take precedence over the values", func() {
+		app := newAnnotatedApp(map[string]string{
+			"values.kloudy.uk/replicaCount": "3",
+			"values.kloudy.uk/resources":    `{"limits": {"memory": "256Mi"}}`,
+		})
+		values := &apiextensionsv1.JSON{Raw: []byte(`{"replicaCount":1,"resources":{"limits":{"cpu":"100m"}},"image":{"tag":"6.5.3"}}`)}
+		merged, err := annotationValues(app, values)
+		Expect(err).NotTo(HaveOccurred())
+		// The annotation replaces the whole top level key rather than being merged into it
+		Expect(merged.Raw).To(MatchJSON(`{"replicaCount":3,"resources":{"limits":{"memory":"256Mi"}},"image":{"tag":"6.5.3"}}`))
+	})
+
+	It("should leave the values unchanged without any values annotations", func() {
+		values := &apiextensionsv1.JSON{Raw: []byte(`{"replicaCount":1}`)}
+		merged, err := annotationValues(newAnnotatedApp(map[string]string{"apps.kloudy.uk/features": ""}), values)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(merged).To(BeIdenticalTo(values))
+	})
+
+	It("should reject an annotation which isn't valid YAML", func() {
+		app := newAnnotatedApp(map[string]string{"values.kloudy.uk/resources": "{limits: "})
+		_, err := annotationValues(app, nil)
+		Expect(err).To(MatchError(errInvalid))
+		Expect(err).To(MatchError(ContainSubstring("values.kloudy.uk/resources")))
+	})
+
+	It("should merge the annotations over the rendered HelmRelease values", func() {
+		ctx := context.Background()
+		app := newAnnotatedApp(map[string]string{"values.kloudy.uk/replicaCount": "5"})
+		app.Spec.Values = &apiextensionsv1.JSON{Raw: []byte(`{"replicaCount":1,"cluster":"${clusterName}"}`)}
+		app.Spec.SubstituteValues = true
+		app.Spec.ValuesTemplate = `replicaCount: 3`
+		r := newTestReconciler()
+		r.Substitutions = map[string]string{"clusterName": "prod-eu"}
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Values.Raw).To(MatchJSON(`{"replicaCount":5,"cluster":"prod-eu"}`))
+	})
+})
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	corev1 "k8s.io/api/core/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// auditLogKey is the key of the audit log in the audit ConfigMap
+const auditLogKey = "audit.log"
+
+// auditEntries returns the audit log entries for what the reconcile changed
+// The chart version selected & deployed, and the Ready condition moving to another status or reason are recorded
+func auditEntries(before, app *appsv1.FluxApp, now time.Time) []string {
+	var entries []string
+	add := func(format string, args ...interface{}) {
+		message := strings.Join(strings.Fields(fmt.Sprintf(format, args...)), " ")
+		entries = append(entries, now.UTC().Format(time.RFC3339)+" "+message)
+	}
+	if from, to := before.Status.Chart.Version, app.Status.Chart.Version; to != "" && to != from {
+		add("version selected: %s", versionChange(from, to))
+	}
+	if from, to := before.Status.Chart.AppliedVersion, app.Status.Chart.AppliedVersion; to != "" && to != from {
+		add("version applied: %s", versionChange(from, to))
+	}
+	ready := conditions.Get(app, meta.ReadyCondition)
+	previous := conditions.Get(before, meta.ReadyCondition)
+	if ready != nil && (previous == nil || previous.Status != ready.Status || previous.Reason != ready.Reason) {
+		add("ready %s %s: %s", ready.Status, ready.Reason, ready.Message)
+	}
+	return entries
+}
+
+// versionChange describes a version change, which is just the new version if there wasn't one
+func versionChange(from, to string) string {
+	if from == "" {
+		return to
+	}
+	return from + " -> " + to
+}
+
+// appendAuditLog appends the entries to the log, keeping the most recent max entries
+func appendAuditLog(log string, entries []string, max int) string {
+	lines := append(strings.Split(strings.TrimSuffix(log, "\n"), "\n"), entries...)
+	if lines[0] == "" {
+		lines = lines[1:]
+	}
+	if len(lines) > max {
+		lines = lines[len(lines)-max:]
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// recordAudit appends what the reconcile changed to the audit log in the <name>-audit ConfigMap of the app
+// so the history outlives the events. The ConfigMap is only touched when there's something to record
+func (r *FluxAppReconciler) recordAudit(ctx context.Context, before, app *appsv1.FluxApp) error {
+	if r.AuditLogEntries <= 0 {
+		return nil
+	}
+	entries := auditEntries(before, app, time.Now())
+	if len(entries) == 0 {
+		return nil
+	}
+	mr, err := r.ResourceManager.Get(ctx, app, AuditLogKind)
+	if err != nil {
+		return err
+	}
+	cm := mr.Object.(*corev1.ConfigMap)
+	cm.Data = map[string]string{auditLogKey: appendAuditLog(cm.Data[auditLogKey], entries, r.AuditLogEntries)}
+	return r.ResourceManager.Update(ctx, mr)
+}
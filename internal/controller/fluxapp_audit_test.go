@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("Audit log", func() {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC)
+
+	getAuditLog := func(r *FluxAppReconciler, app *appsv1.FluxApp) (*corev1.ConfigMap, error) {
+		cm := &corev1.ConfigMap{}
+		err := r.Get(ctx, types.NamespacedName{Name: r.ResourceManager.AuditLogName(app), Namespace: app.Namespace}, cm)
+		return cm, err
+	}
+
+	It("should record the version changes & Ready transitions", func() {
+		before := newTestApp()
+		before.Status.Chart.AppliedVersion = "6.5.3"
+		conditions.MarkTrue(before, meta.ReadyCondition, "UpgradeSucceeded", "Helm upgrade succeeded")
+		app := before.DeepCopy()
+		app.Status.Chart.Version = "6.6.0"
+		conditions.MarkFalse(app, meta.ReadyCondition, "UpgradeFailed", "Helm upgrade failed:\nresource not ready")
+		Expect(auditEntries(before, app, now)).To(Equal([]string{
+			"2026-10-15T09:30:00Z version selected: 6.5.3 -> 6.6.0",
+			"2026-10-15T09:30:00Z ready False UpgradeFailed: Helm upgrade failed: resource not ready",
+		}))
+
+		before = app.DeepCopy()
+		app.Status.Chart.AppliedVersion = "6.6.0"
+		conditions.MarkTrue(app, meta.ReadyCondition, "UpgradeSucceeded", "Helm upgrade succeeded")
+		Expect(auditEntries(before, app, now)).To(Equal([]string{
+			"2026-10-15T09:30:00Z version applied: 6.5.3 -> 6.6.0",
+			"2026-10-15T09:30:00Z ready True UpgradeSucceeded: Helm upgrade succeeded",
+		}))
+	})
+
+	It("should record the first version without a previous version", func() {
+		before := newTestApp()
+		before.Status.Chart.Version = ""
+		app := newTestApp()
+		Expect(auditEntries(before, app, now)).To(Equal([]string{"2026-10-15T09:30:00Z version selected: 6.5.3"}))
+	})
+
+	It("should not record a reconcile which didn't change anything", func() {
+		before := newTestApp()
+		conditions.MarkFalse(before, meta.ReadyCondition, "UpgradeFailed", "Helm upgrade failed")
+		app := before.DeepCopy()
+		conditions.MarkFalse(app, meta.ReadyCondition, "UpgradeFailed", "Helm upgrade failed again")
+		Expect(auditEntries(before, app, now)).To(BeEmpty())
+	})
+
+	It("should keep the most recent entries", func() {
+		log := appendAuditLog("", []string{"one", "two"}, 3)
+		Expect(log).To(Equal("one\ntwo\n"))
+		log = appendAuditLog(log, []string{"three", "four"}, 3)
+		Expect(log).To(Equal("two\nthree\nfour\n"))
+	})
+
+	It("should append to the audit ConfigMap owned by the app", func() {
+		r := newTestReconciler()
+		r.AuditLogEntries = 5
+		app := newTestApp()
+		app.UID = "fluxapp-uid"
+		for i := 0; i < 4; i++ {
+			before := app.DeepCopy()
+			app.Status.Chart.Version = fmt.Sprintf("6.%d.0", i+6)
+			Expect(r.recordAudit(ctx, before, app)).To(Succeed())
+		}
+		cm, err := getAuditLog(r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(metav1.IsControlledBy(cm, app)).To(BeTrue())
+		lines := strings.Split(strings.TrimSuffix(cm.Data[auditLogKey], "\n"), "\n")
+		Expect(lines).To(HaveLen(4))
+		Expect(lines[0]).To(HaveSuffix("version selected: 6.5.3 -> 6.6.0"))
+		Expect(lines[3]).To(HaveSuffix("version selected: 6.8.0 -> 6.9.0"))
+
+		// Once full, the oldest entries are dropped
+		for i := 0; i < 3; i++ {
+			before := app.DeepCopy()
+			app.Status.Chart.Version = fmt.Sprintf("6.%d.0", i+10)
+			Expect(r.recordAudit(ctx, before, app)).To(Succeed())
+		}
+		cm, err = getAuditLog(r, app)
+		Expect(err).NotTo(HaveOccurred())
+		lines = strings.Split(strings.TrimSuffix(cm.Data[auditLogKey], "\n"), "\n")
+		Expect(lines).To(HaveLen(5))
+		Expect(lines[0]).To(HaveSuffix("version selected: 6.7.0 -> 6.8.0"))
+		Expect(lines[4]).To(HaveSuffix("version selected: 6.11.0 -> 6.12.0"))
+	})
+
+	It("should not keep an audit log unless enabled", func() {
+		r := newTestReconciler()
+		app := newTestApp()
+		before := app.DeepCopy()
+		app.Status.Chart.Version = "6.6.0"
+		Expect(r.recordAudit(ctx, before, app)).To(Succeed())
+		_, err := getAuditLog(r, app)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// reconcileStage is one step of the handler chain
+// A stage stops the chain by returning the result of the reconcile, otherwise the next stage is run
+type reconcileStage struct {
+	name string
+	run  func(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) (*ctrl.Result, error)
+}
+
+// stageProgress is the stage an app's next reconcile resumes from after it ran out of budget
+type stageProgress struct {
+	generation int64
+	next       int
+}
+
+// appProgress holds the stage each app resumes from so the handler chain can be split across reconciles
+// The zero value is ready to use
+type appProgress struct {
+	mu       sync.Mutex
+	progress map[types.NamespacedName]stageProgress
+}
+
+// Save records the stage the next reconcile of the app resumes from
+func (p *appProgress) Save(key types.NamespacedName, generation int64, next int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.progress == nil {
+		p.progress = map[types.NamespacedName]stageProgress{}
+	}
+	p.progress[key] = stageProgress{generation: generation, next: next}
+}
+
+// Resume returns the stage to resume the app from, or 0 to run every stage
+// The progress is removed so only the reconcile straight after the one which ran out of budget resumes,
+// and it's ignored if the spec has changed since
+func (p *appProgress) Resume(key types.NamespacedName, generation int64) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	progress, ok := p.progress[key]
+	delete(p.progress, key)
+	if !ok || progress.generation != generation {
+		return 0
+	}
+	return progress.next
+}
+
+// Forget removes the progress of the app e.g. once it's deleted
+func (p *appProgress) Forget(key types.NamespacedName) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.progress, key)
+}
+
+// Len returns the number of apps waiting to resume
+func (p *appProgress) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.progress)
+}
+
+// budgetExceeded returns true if the reconcile started at start has run for longer than the reconcile budget
+func (r *FluxAppReconciler) budgetExceeded(start time.Time) bool {
+	return r.ReconcileBudget > 0 && time.Since(start) > r.ReconcileBudget
+}
+
+// runStages runs the stages in order until one stops the chain
+// Once the reconcile budget is spent the remaining stages are deferred to a requeue so one slow app
+// doesn't hold a worker while other apps wait; the stages already run aren't repeated when it resumes
+// At least one stage runs in every reconcile so an app always makes progress
+func (r *FluxAppReconciler) runStages(ctx context.Context, app *appsv1.FluxApp, start time.Time, stages []reconcileStage) (ctrl.Result, error) {
+	key := client.ObjectKeyFromObject(app)
+	first := r.progress.Resume(key, app.Generation)
+	for i := first; i < len(stages); i++ {
+		if i > first && r.budgetExceeded(start) {
+			log.FromContext(ctx).Info("reconcile budget exceeded, deferring the remaining handlers",
+				"budget", r.ReconcileBudget, "next", stages[i].name)
+			r.progress.Save(key, app.Generation, i)
+			setReconcileReason(ctx, reconcileReasonBudgetExceeded)
+			return ctrl.Result{Requeue: true}, nil
+		}
+		if result, err := stages[i].run(ctx, r, app); result != nil || err != nil {
+			if result == nil {
+				return ctrl.Result{}, err
+			}
+			return *result, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
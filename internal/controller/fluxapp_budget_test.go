@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("Reconcile budget", func() {
+	var (
+		ran    []string
+		stages []reconcileStage
+	)
+
+	// newStage returns a stage which records it ran after taking the duration
+	newStage := func(name string, d time.Duration) reconcileStage {
+		return reconcileStage{name: name, run: func(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) (*ctrl.Result, error) {
+			time.Sleep(d)
+			ran = append(ran, name)
+			return nil, nil
+		}}
+	}
+
+	BeforeEach(func() {
+		ran = nil
+		stages = []reconcileStage{
+			newStage("sources", 0),
+			newStage("slow", 50*time.Millisecond),
+			newStage("chartArtifact", 0),
+			newStage("helmRelease", 0),
+		}
+	})
+
+	It("should yield & requeue once a long-running handler spends the budget", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		r.ReconcileBudget = 10 * time.Millisecond
+		ctx, reason := withReconcileReason(context.Background())
+		result, err := r.runStages(ctx, app, time.Now(), stages)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{Requeue: true}))
+		Expect(ran).To(Equal([]string{"sources", "slow"}))
+		Expect(*reason).To(Equal(reconcileReasonBudgetExceeded))
+		Expect(r.progress.Len()).To(Equal(1))
+
+		// The requeued reconcile resumes from the deferred handlers
+		result, err = r.runStages(context.Background(), app, time.Now(), stages)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IsZero()).To(BeTrue())
+		Expect(ran).To(Equal([]string{"sources", "slow", "chartArtifact", "helmRelease"}))
+		Expect(r.progress.Len()).To(BeZero())
+	})
+
+	It("should run at least one handler in every reconcile", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		r.ReconcileBudget = time.Millisecond
+		start := time.Now().Add(-time.Minute)
+		for i := 1; i <= len(stages); i++ {
+			_, err := r.runStages(context.Background(), app, start, stages)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ran).To(HaveLen(i))
+		}
+		Expect(ran).To(Equal([]string{"sources", "slow", "chartArtifact", "helmRelease"}))
+	})
+
+	It("should start again if the spec changed while yielding", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		r.ReconcileBudget = time.Millisecond
+		_, err := r.runStages(context.Background(), app, time.Now().Add(-time.Minute), stages)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ran).To(Equal([]string{"sources"}))
+
+		app.Generation++
+		r.ReconcileBudget = 0
+		_, err = r.runStages(context.Background(), app, time.Now(), stages)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ran).To(Equal([]string{"sources", "sources", "slow", "chartArtifact", "helmRelease"}))
+	})
+
+	It("should run every handler without a budget", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		result, err := r.runStages(context.Background(), app, time.Now().Add(-time.Hour), stages)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IsZero()).To(BeTrue())
+		Expect(ran).To(HaveLen(len(stages)))
+		Expect(r.progress.Len()).To(BeZero())
+	})
+
+	It("should stop at a handler which fails", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		failed := errors.New("boom")
+		stages[1].run = func(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) (*ctrl.Result, error) {
+			return nil, failed
+		}
+		_, err := r.runStages(context.Background(), app, time.Now(), stages)
+		Expect(err).To(MatchError(failed))
+		Expect(ran).To(Equal([]string{"sources"}))
+		Expect(r.progress.Len()).To(BeZero())
+	})
+
+	It("should forget the progress of a deleted app", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		r.progress.Save(client.ObjectKeyFromObject(app), app.Generation, 2)
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.progress.Len()).To(BeZero())
+	})
+})
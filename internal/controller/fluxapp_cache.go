@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// resolvedChart is the chart source info resolved from the app spec
+type resolvedChart struct {
+	// The spec fields the entry was resolved from, used to invalidate the entry when the spec changes
+	specRepository string
+	specProvider   string
+	// image is the chart repository without the URL scheme
+	image string
+	// provider is the provider used to authenticate with the chart repository
+	provider string
+}
+
+// ChartCache caches the chart source info resolved for each FluxApp
+// so it doesn't need resolving again on every reconcile
+type ChartCache struct {
+	mu      sync.RWMutex
+	entries map[types.NamespacedName]resolvedChart
+}
+
+// NewChartCache returns an empty ChartCache
+func NewChartCache() *ChartCache {
+	return &ChartCache{entries: map[types.NamespacedName]resolvedChart{}}
+}
+
+// Resolve returns the resolved chart for the app, resolving it if it isn't cached
+// or the spec has changed since it was cached
+// A nil ChartCache always resolves the chart
+func (c *ChartCache) Resolve(app *appsv1.FluxApp) (resolvedChart, error) {
+	if c == nil {
+		return resolveChart(app)
+	}
+	key := types.NamespacedName{Name: app.Name, Namespace: app.Namespace}
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+	if ok && entry.specRepository == app.Spec.Chart.Repository && entry.specProvider == app.Spec.Chart.Provider {
+		return entry, nil
+	}
+	entry, err := resolveChart(app)
+	if err != nil {
+		return entry, err
+	}
+	c.mu.Lock()
+	c.entries[key] = entry
+	c.mu.Unlock()
+	return entry, nil
+}
+
+// Delete removes the cached chart for the app
+func (c *ChartCache) Delete(key types.NamespacedName) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
+
+// Len returns the number of cached charts
+func (c *ChartCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// resolveChart resolves the chart source info from the app spec
+func resolveChart(app *appsv1.FluxApp) (resolvedChart, error) {
+	entry := resolvedChart{
+		specRepository: app.Spec.Chart.Repository,
+		specProvider:   app.Spec.Chart.Provider,
+	}
+	parts := strings.Split(app.Spec.Chart.Repository, "://")
+	if len(parts) != 2 {
+		return entry, fmt.Errorf("%w chart repository URL: %s", errInvalid, app.Spec.Chart.Repository)
+	}
+	provider, err := chartProvider(app, app.Spec.Chart.Repository)
+	if err != nil {
+		return entry, err
+	}
+	entry.image = parts[1]
+	entry.provider = provider
+	return entry, nil
+}
+
+// WarmChartCache resolves the charts of all existing FluxApps so the first reconcile
+// of each app after a restart doesn't need to
+// It's run by the manager once the caches have synced
+func (r *FluxAppReconciler) WarmChartCache(ctx context.Context) error {
+	log := log.FromContext(ctx)
+	apps := &appsv1.FluxAppList{}
+	if err := r.listApps(ctx, apps); err != nil {
+		return err
+	}
+	for i := range apps.Items {
+		app := &apps.Items[i]
+		// Resolve the chart from the spec the reconcile will see
+		if err := applyTemplate(ctx, r, app); err != nil {
+			if !errors.Is(err, errRequeue) {
+				log.Error(err, "unable to apply FluxAppTemplate", "fluxapp", client.ObjectKeyFromObject(app))
+			}
+			continue
+		}
+		if _, err := r.ChartCache.Resolve(app); err != nil {
+			log.Error(err, "unable to resolve chart", "fluxapp", client.ObjectKeyFromObject(app))
+		}
+	}
+	log.Info("warmed chart cache", "charts", r.ChartCache.Len())
+	return nil
+}
@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+)
+
+var _ = Describe("ChartCache", func() {
+	ctx := context.Background()
+	key := types.NamespacedName{Name: "podinfo", Namespace: "default"}
+
+	It("should resolve the chart without a cache", func() {
+		var cache *ChartCache
+		chart, err := cache.Resolve(newTestApp())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(chart.image).To(Equal("ghcr.io/stefanprodan/charts/podinfo"))
+		Expect(chart.provider).To(Equal("generic"))
+		Expect(cache.Len()).To(BeZero())
+	})
+
+	It("should cache the resolved chart", func() {
+		cache := NewChartCache()
+		app := newTestApp()
+		_, err := cache.Resolve(app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cache.Len()).To(Equal(1))
+		Expect(cache.entries[key].image).To(Equal("ghcr.io/stefanprodan/charts/podinfo"))
+	})
+
+	It("should not cache invalid charts", func() {
+		cache := NewChartCache()
+		app := newTestApp()
+		app.Spec.Chart.Repository = "ghcr.io/stefanprodan/charts/podinfo"
+		_, err := cache.Resolve(app)
+		Expect(err).To(MatchError(errInvalid))
+		Expect(cache.Len()).To(BeZero())
+	})
+
+	It("should invalidate the cached chart when the spec changes", func() {
+		cache := NewChartCache()
+		app := newTestApp()
+		_, err := cache.Resolve(app)
+		Expect(err).NotTo(HaveOccurred())
+
+		app.Spec.Chart.Repository = "oci://example.azurecr.io/charts/podinfo"
+		chart, err := cache.Resolve(app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(chart.image).To(Equal("example.azurecr.io/charts/podinfo"))
+		Expect(chart.provider).To(Equal("azure"))
+
+		app.Spec.Chart.Provider = "generic"
+		chart, err = cache.Resolve(app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(chart.provider).To(Equal("generic"))
+		Expect(cache.Len()).To(Equal(1))
+	})
+
+	It("should remove deleted apps", func() {
+		cache := NewChartCache()
+		_, err := cache.Resolve(newTestApp())
+		Expect(err).NotTo(HaveOccurred())
+		cache.Delete(key)
+		Expect(cache.Len()).To(BeZero())
+	})
+
+	It("should be populated on startup", func() {
+		tmpl := &appsv1.FluxAppTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "default"},
+			Spec:       appsv1.FluxAppTemplateSpec{Provider: "gcp"},
+		}
+		withTemplate := newTestApp()
+		withTemplate.Name = "templated"
+		withTemplate.Spec.TemplateRef = &meta.LocalObjectReference{Name: "shared"}
+		missingTemplate := newTestApp()
+		missingTemplate.Name = "missing"
+		missingTemplate.Spec.TemplateRef = &meta.LocalObjectReference{Name: "missing"}
+		r := newTestReconciler(newTestApp(), withTemplate, missingTemplate, tmpl)
+		r.ChartCache = NewChartCache()
+
+		Expect(r.WarmChartCache(ctx)).To(Succeed())
+		Expect(r.ChartCache.Len()).To(Equal(2))
+		Expect(r.ChartCache.entries[key].provider).To(Equal("generic"))
+		Expect(r.ChartCache.entries[types.NamespacedName{Name: "templated", Namespace: "default"}].provider).To(Equal("gcp"))
+	})
+
+	It("should use the cached chart during reconcile", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		r.ChartCache = NewChartCache()
+		// Seed an entry which differs from what would be resolved to prove it's used
+		r.ChartCache.entries[key] = resolvedChart{
+			specRepository: app.Spec.Chart.Repository,
+			image:          "ghcr.io/stefanprodan/charts/podinfo",
+			provider:       "gcp",
+		}
+		Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+		repo := &imagev1.ImageRepository{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: "podinfo-chart", Namespace: "default"}, repo)).To(Succeed())
+		Expect(repo.Spec.Provider).To(Equal("gcp"))
+	})
+})
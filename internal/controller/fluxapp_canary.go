@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/blang/semver/v4"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// canaryWeightAnnotation records the canary weight on the canary HelmRelease
+// The HelmRelease doesn't split traffic so this is left to the ingress or service mesh
+const canaryWeightAnnotation = "apps.kloudy.uk/canary-weight"
+
+// handleCanary creates or updates the canary HelmRelease from the stable HelmRelease spec,
+// or deletes it if the app no longer has a canary
+func handleCanary(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp, stable *helmv2.HelmReleaseSpec) error {
+	// Get the canary HelmRelease managed resource
+	mr, err := r.ResourceManager.Get(ctx, app, CanaryHelmReleaseKind)
+	if err != nil {
+		return err
+	}
+	canary := app.Spec.Canary
+	if canary == nil {
+		app.Status.Canary = nil
+		return r.ResourceManager.Delete(ctx, mr)
+	}
+	// The OCIRepository only pulls the digest of the stable version
+	if pinDigest(app) {
+		return fmt.Errorf("%w: canary can't be used with chart.pinDigest", errInvalid)
+	}
+	// The canary is pinned so must be an exact version rather than a constraint
+	if _, err := semver.Parse(canary.Version); err != nil {
+		return fmt.Errorf("%w canary version %q: %w", errInvalid, canary.Version, err)
+	}
+	canaryValues, err := validateValuesFrom("canary.valuesFrom", canary.ValuesFrom)
+	if err != nil {
+		return err
+	}
+	helmRelease := mr.Object.(*helmv2.HelmRelease)
+	// Update the spec
+	// The canary is the same as the stable release apart from the version & values
+	helmRelease.Spec = *stable.DeepCopy()
+	helmRelease.Spec.Chart.Spec.Version = app.Spec.Chart.TagPrefix + canary.Version
+	helmRelease.Spec.ReleaseName = r.ResourceManager.CanaryHelmReleaseName(app)
+	helmRelease.Spec.ValuesFrom = append(helmRelease.Spec.ValuesFrom, ignoreMissingValues(app, canaryValues)...)
+	annotations := helmRelease.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[canaryWeightAnnotation] = strconv.Itoa(int(canary.Weight))
+	helmRelease.SetAnnotations(annotations)
+	setDescription(app, helmRelease)
+	// Add the canary to the app status
+	app.Status.Canary = &appsv1.CanaryStatus{
+		Version:        canary.Version,
+		Weight:         canary.Weight,
+		AppliedVersion: appliedVersion(helmRelease),
+	}
+	// The app is only ready once both releases are ready
+	if conditions.IsReady(app) && !conditions.IsReady(helmRelease) {
+		reason := conditions.GetReason(helmRelease, meta.ReadyCondition)
+		if reason == "" {
+			reason = meta.ProgressingReason
+		}
+		message := conditions.GetMessage(helmRelease, meta.ReadyCondition)
+		if message == "" {
+			message = "HelmRelease is not ready"
+		}
+		conditions.MarkFalse(app, meta.ReadyCondition, reason, "canary: %s", message)
+	}
+	// Update the resource
+	return r.ResourceManager.Update(ctx, mr)
+}
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+var _ = Describe("Canary", func() {
+	ctx := context.Background()
+
+	getCanary := func(r *FluxAppReconciler, app *appsv1.FluxApp) (*helmv2.HelmRelease, error) {
+		hr := &helmv2.HelmRelease{}
+		key := types.NamespacedName{Name: r.ResourceManager.CanaryHelmReleaseName(app), Namespace: app.Namespace}
+		return hr, r.Get(ctx, key, hr)
+	}
+
+	appWithCanary := func() *appsv1.FluxApp {
+		app := newTestApp()
+		app.Spec.ValuesFrom = []helmv2.ValuesReference{{Kind: "ConfigMap", Name: "values"}}
+		app.Spec.Canary = &appsv1.Canary{
+			Version:    "6.5.0",
+			Weight:     10,
+			ValuesFrom: []helmv2.ValuesReference{{Kind: "ConfigMap", Name: "canary-values"}},
+		}
+		return app
+	}
+
+	It("should only generate the stable release without a canary", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		_, err := getCanary(r, app)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(app.Status.Canary).To(BeNil())
+	})
+
+	It("should generate the stable and canary releases", func() {
+		app := appWithCanary()
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+
+		stable, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stable.Spec.Chart.Spec.Version).To(Equal("6.5.3"))
+		Expect(stable.Spec.ReleaseName).To(Equal("podinfo"))
+		Expect(stable.Spec.ValuesFrom).To(HaveLen(1))
+
+		canary, err := getCanary(r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(canary.Name).To(Equal("podinfo-canary"))
+		Expect(canary.Spec.Chart.Spec.Version).To(Equal("6.5.0"))
+		Expect(canary.Spec.Chart.Spec.SourceRef).To(Equal(stable.Spec.Chart.Spec.SourceRef))
+		Expect(canary.Spec.ReleaseName).To(Equal("podinfo-canary"))
+		Expect(canary.Spec.TargetNamespace).To(Equal(stable.Spec.TargetNamespace))
+		Expect(canary.Spec.ValuesFrom).To(Equal([]helmv2.ValuesReference{
+			{Kind: "ConfigMap", Name: "values", ValuesKey: "values.yaml"},
+			{Kind: "ConfigMap", Name: "canary-values", ValuesKey: "values.yaml"},
+		}))
+		Expect(canary.Annotations).To(HaveKeyWithValue(canaryWeightAnnotation, "10"))
+		Expect(metav1.IsControlledBy(canary, app)).To(BeTrue())
+
+		Expect(app.Status.Canary).To(Equal(&appsv1.CanaryStatus{Version: "6.5.0", Weight: 10}))
+	})
+
+	It("should delete the canary release when the canary is removed", func() {
+		app := appWithCanary()
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		app.Spec.Canary = nil
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		_, err := getCanary(r, app)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(app.Status.Canary).To(BeNil())
+		_, err = getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should reject a canary version constraint", func() {
+		app := appWithCanary()
+		app.Spec.Canary.Version = "~> 6"
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(MatchError(errInvalid))
+	})
+
+	It("should only be ready once both releases are ready", func() {
+		app := appWithCanary()
+		stable := &helmv2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{Name: app.Name, Namespace: app.Namespace},
+		}
+		conditions.MarkTrue(stable, meta.ReadyCondition, meta.SucceededReason, "Helm install succeeded")
+		canary := &helmv2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo-canary", Namespace: app.Namespace},
+		}
+		conditions.MarkFalse(canary, meta.ReadyCondition, "InstallFailed", "Helm install failed")
+		r := newTestReconciler(stable, canary)
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal("InstallFailed"))
+		Expect(conditions.GetMessage(app, meta.ReadyCondition)).To(Equal("canary: Helm install failed"))
+	})
+})
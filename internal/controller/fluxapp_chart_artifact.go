@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"context"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// ChartManifest summarises the manifest of a chart artifact
+type ChartManifest struct {
+	// Size in bytes of the config & layers
+	Size int64
+	// Layers is the number of layers
+	Layers int
+}
+
+// ChartManifestGetter gets the manifest of the chart artifact with the tag
+type ChartManifestGetter interface {
+	ChartManifest(ctx context.Context, repository, name, tag string) (*ChartManifest, error)
+}
+
+// ChartManifest reads the manifest of the chart artifact with the tag without pulling the chart
+// The manifest of each chart version is cached as chart versions are immutable
+func (c *RegistryClient) ChartManifest(ctx context.Context, repository, name, tag string) (*ChartManifest, error) {
+	key := strings.TrimPrefix(repository, "oci://") + "/" + name + ":" + tag
+	c.mu.RLock()
+	summary, ok := c.manifests[key]
+	c.mu.RUnlock()
+	if ok {
+		return summary, nil
+	}
+	base, err := registryURL(repository, name)
+	if err != nil {
+		return nil, err
+	}
+	type descriptor struct {
+		Size int64 `json:"size"`
+	}
+	manifest := struct {
+		Config descriptor   `json:"config"`
+		Layers []descriptor `json:"layers"`
+	}{}
+	if err := c.get(ctx, base+"/manifests/"+tag, ociManifestMediaType, &manifest); err != nil {
+		return nil, err
+	}
+	summary = &ChartManifest{Size: manifest.Config.Size, Layers: len(manifest.Layers)}
+	for _, layer := range manifest.Layers {
+		summary.Size += layer.Size
+	}
+	c.mu.Lock()
+	c.manifests[key] = summary
+	c.mu.Unlock()
+	return summary, nil
+}
+
+// handleChartArtifact records the size & layer count of the selected chart version in the status for capacity planning
+// Reading the manifest is best effort so a failure doesn't block the release, the size is cleared
+// rather than reporting the size of another version
+func handleChartArtifact(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) {
+	// The manifests are cached by tag so a floating tag isn't inspected
+	if r.ChartManifests == nil || gitSource(app) || floatingTag(app) || app.Status.Chart.Version == "" {
+		app.Status.Chart.ArtifactSize, app.Status.Chart.ArtifactLayers = 0, 0
+		return
+	}
+	manifest, err := r.ChartManifests.ChartManifest(ctx, app.Status.Chart.Repository, app.Status.Chart.Name, chartTag(app))
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to read chart manifest", "chart", app.Status.Chart.Name, "version", app.Status.Chart.Version)
+		app.Status.Chart.ArtifactSize, app.Status.Chart.ArtifactLayers = 0, 0
+		return
+	}
+	app.Status.Chart.ArtifactSize = manifest.Size
+	app.Status.Chart.ArtifactLayers = int32(manifest.Layers)
+}
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("Chart artifact", func() {
+	var (
+		ctx      context.Context
+		server   *httptest.Server
+		requests []string
+		r        *FluxAppReconciler
+		app      *appsv1.FluxApp
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		requests = nil
+		// The stub registry only has podinfo 6.5.3 and chart-6.5.3
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			requests = append(requests, req.URL.Path)
+			switch req.URL.Path {
+			case "/v2/charts/podinfo/manifests/6.5.3", "/v2/charts/podinfo/manifests/chart-6.5.3":
+				Expect(req.Header.Get("Accept")).To(Equal(ociManifestMediaType))
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"mediaType": ociManifestMediaType,
+					"config":    map[string]interface{}{"mediaType": helmConfigMediaType, "size": 1024},
+					"layers": []map[string]interface{}{
+						{"mediaType": "application/vnd.cncf.helm.chart.content.v1.tar+gzip", "size": 14336},
+						{"mediaType": "application/vnd.cncf.helm.chart.provenance.v1.prov", "size": 512},
+					},
+				})
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		DeferCleanup(server.Close)
+		r = newTestReconciler()
+		r.ChartManifests = NewRegistryClient(server.Client())
+		app = newTestApp()
+		app.Status.Chart.Repository = "oci://" + strings.TrimPrefix(server.URL, "https://") + "/charts"
+	})
+
+	It("should record the size & layer count of the chart version", func() {
+		handleChartArtifact(ctx, r, app)
+		Expect(app.Status.Chart.ArtifactSize).To(Equal(int64(15872)))
+		Expect(app.Status.Chart.ArtifactLayers).To(Equal(int32(2)))
+	})
+
+	It("should cache the manifest of each chart version", func() {
+		handleChartArtifact(ctx, r, app)
+		handleChartArtifact(ctx, r, app)
+		Expect(requests).To(Equal([]string{"/v2/charts/podinfo/manifests/6.5.3"}))
+	})
+
+	It("should inspect the prefixed tag", func() {
+		app.Spec.Chart.TagPrefix = "chart-"
+		handleChartArtifact(ctx, r, app)
+		Expect(requests).To(Equal([]string{"/v2/charts/podinfo/manifests/chart-6.5.3"}))
+		Expect(app.Status.Chart.ArtifactSize).To(Equal(int64(15872)))
+	})
+
+	It("should clear the size if the manifest can't be read", func() {
+		handleChartArtifact(ctx, r, app)
+		app.Status.Chart.Version = "6.6.0"
+		handleChartArtifact(ctx, r, app)
+		Expect(app.Status.Chart.ArtifactSize).To(BeZero())
+		Expect(app.Status.Chart.ArtifactLayers).To(BeZero())
+	})
+
+	It("should not inspect the chart unless the controller is configured to", func() {
+		r.ChartManifests = nil
+		app.Status.Chart.ArtifactSize = 15872
+		handleChartArtifact(ctx, r, app)
+		Expect(requests).To(BeEmpty())
+		Expect(app.Status.Chart.ArtifactSize).To(BeZero())
+	})
+})
@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	corev1 "k8s.io/api/core/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// chartAuthConfig returns the Docker config JSON logging in to the chart repository host with the credentials
+// The ImageRepository only reads registry credentials from a Docker config
+func chartAuthConfig(repository string, auth *appsv1.ChartAuth) ([]byte, error) {
+	u, err := url.Parse(repository)
+	if err != nil {
+		return nil, err
+	}
+	type dockerAuth struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Auth     string `json:"auth"`
+	}
+	return json.Marshal(map[string]map[string]dockerAuth{
+		"auths": {u.Host: {
+			Username: auth.Username,
+			Password: auth.Password,
+			Auth:     base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password)),
+		}},
+	})
+}
+
+// handleChartAuth copies the chart.auth credentials into a Secret for the sources to reference
+// The Secret has the Docker config read by the ImageRepository & OCI HelmRepository,
+// and the username & password keys read by the GitRepository
+// If the app doesn't set chart.auth the Secret is deleted
+func handleChartAuth(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) error {
+	// Get the chart auth Secret managed resource
+	mr, err := r.ResourceManager.Get(ctx, app, ChartAuthKind)
+	if err != nil {
+		return err
+	}
+	auth := app.Spec.Chart.Auth
+	if auth == nil {
+		conditions.Delete(app, appsv1.InlineChartAuthCondition)
+		return r.ResourceManager.Delete(ctx, mr)
+	}
+	// Make it clear the credentials are only meant for development
+	conditions.MarkTrue(app, appsv1.InlineChartAuthCondition, appsv1.DevelopmentOnlyReason,
+		"chart.auth stores the chart repository password in plain text in the FluxApp and is only meant for development")
+	dockerConfig, err := chartAuthConfig(app.Spec.Chart.Repository, auth)
+	if err != nil {
+		return err
+	}
+	secret := mr.Object.(*corev1.Secret)
+	secret.Type = corev1.SecretTypeDockerConfigJson
+	secret.Data = map[string][]byte{
+		corev1.DockerConfigJsonKey: dockerConfig,
+		"username":                 []byte(auth.Username),
+		"password":                 []byte(auth.Password),
+	}
+	return r.ResourceManager.Update(ctx, mr)
+}
+
+// chartAuthRef returns the reference to the chart auth Secret for the sources, or nil if the app doesn't set chart.auth
+func chartAuthRef(r *FluxAppReconciler, app *appsv1.FluxApp) *meta.LocalObjectReference {
+	if app.Spec.Chart.Auth == nil {
+		return nil
+	}
+	return &meta.LocalObjectReference{Name: r.ResourceManager.ChartAuthName(app)}
+}
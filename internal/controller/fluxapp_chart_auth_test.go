@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+)
+
+var _ = Describe("Chart auth", func() {
+	ctx := context.Background()
+
+	newAuthApp := func() *appsv1.FluxApp {
+		app := newTestApp()
+		app.Spec.Chart.Auth = &appsv1.ChartAuth{Username: "dev", Password: "s3cret"}
+		return app
+	}
+
+	getSecret := func(r *FluxAppReconciler, app *appsv1.FluxApp) (*corev1.Secret, error) {
+		secret := &corev1.Secret{}
+		key := types.NamespacedName{Name: r.ResourceManager.ChartAuthName(app), Namespace: app.Namespace}
+		return secret, r.Get(ctx, key, secret)
+	}
+
+	It("should copy the credentials into a Secret owned by the app", func() {
+		app := newAuthApp()
+		r := newTestReconciler()
+		Expect(handleChartAuth(ctx, r, app)).To(Succeed())
+		secret, err := getSecret(r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret.Name).To(Equal("podinfo-chart-auth"))
+		Expect(metav1.IsControlledBy(secret, app)).To(BeTrue())
+		Expect(secret.Type).To(Equal(corev1.SecretTypeDockerConfigJson))
+		Expect(secret.Data).To(HaveKeyWithValue("username", []byte("dev")))
+		Expect(secret.Data).To(HaveKeyWithValue("password", []byte("s3cret")))
+		Expect(secret.Data[corev1.DockerConfigJsonKey]).To(MatchJSON(
+			`{"auths":{"ghcr.io":{"username":"dev","password":"s3cret","auth":"ZGV2OnMzY3JldA=="}}}`))
+	})
+
+	It("should warn the credentials are only meant for development", func() {
+		app := newAuthApp()
+		Expect(handleChartAuth(ctx, newTestReconciler(), app)).To(Succeed())
+		Expect(conditions.IsTrue(app, appsv1.InlineChartAuthCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, appsv1.InlineChartAuthCondition)).To(Equal(appsv1.DevelopmentOnlyReason))
+		Expect(conditions.GetMessage(app, appsv1.InlineChartAuthCondition)).To(ContainSubstring("only meant for development"))
+	})
+
+	It("should reference the Secret from the registry sources", func() {
+		app := newAuthApp()
+		r := newTestReconciler()
+		r.ChartCache = NewChartCache()
+		Expect(handleChartAuth(ctx, r, app)).To(Succeed())
+		Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRepository(ctx, r, app)).To(Succeed())
+
+		ref := &meta.LocalObjectReference{Name: "podinfo-chart-auth"}
+		repo := &imagev1.ImageRepository{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: r.ResourceManager.ImageRepositoryName(app), Namespace: app.Namespace}, repo)).To(Succeed())
+		Expect(repo.Spec.SecretRef).To(Equal(ref))
+		helmRepo := &sourcev1.HelmRepository{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: r.ResourceManager.HelmRepositoryName(app), Namespace: app.Namespace}, helmRepo)).To(Succeed())
+		Expect(helmRepo.Spec.SecretRef).To(Equal(ref))
+	})
+
+	It("should not share the HelmRepository of an app with credentials", func() {
+		app := newAuthApp()
+		other := newTestApp()
+		other.Name = "other"
+		other.UID = "other-uid"
+		r := newTestReconciler()
+		r.ChartCache = NewChartCache()
+		Expect(handleHelmRepository(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRepository(ctx, r, other)).To(Succeed())
+		Expect(r.ResourceManager.HelmRepositoryName(app)).To(Equal("podinfo-chart"))
+		Expect(r.ResourceManager.HelmRepositoryName(other)).To(Equal("ghcr-io-stefanprodan-charts"))
+
+		// Reconciling the other app doesn't change the credentials of the app
+		Expect(handleHelmRepository(ctx, r, other)).To(Succeed())
+		helmRepo := &sourcev1.HelmRepository{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: "podinfo-chart", Namespace: app.Namespace}, helmRepo)).To(Succeed())
+		Expect(helmRepo.Spec.SecretRef).To(Equal(&meta.LocalObjectReference{Name: "podinfo-chart-auth"}))
+		Expect(metav1.IsControlledBy(helmRepo, app)).To(BeTrue())
+		// And the other app doesn't pull with them
+		Expect(r.Get(ctx, types.NamespacedName{Name: "ghcr-io-stefanprodan-charts", Namespace: other.Namespace}, helmRepo)).To(Succeed())
+		Expect(helmRepo.Spec.SecretRef).To(BeNil())
+	})
+
+	It("should delete the HelmRepository of the app once it shares one again", func() {
+		app := newAuthApp()
+		r := newTestReconciler()
+		r.ChartCache = NewChartCache()
+		Expect(handleHelmRepository(ctx, r, app)).To(Succeed())
+		app.Spec.Chart.Auth = nil
+		Expect(handleHelmRepository(ctx, r, app)).To(Succeed())
+		helmRepo := &sourcev1.HelmRepository{}
+		err := r.Get(ctx, types.NamespacedName{Name: "podinfo-chart", Namespace: app.Namespace}, helmRepo)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(r.Get(ctx, types.NamespacedName{Name: "ghcr-io-stefanprodan-charts", Namespace: app.Namespace}, helmRepo)).To(Succeed())
+		Expect(helmRepo.Spec.SecretRef).To(BeNil())
+	})
+
+	It("should reference the Secret from the GitRepository", func() {
+		app := newAuthApp()
+		app.Spec.SourceKind = sourcev1.GitRepositoryKind
+		app.Spec.Chart.Repository = "https://github.com/stefanprodan/podinfo"
+		app.Spec.Chart.Git = &appsv1.GitChart{Path: "charts/podinfo"}
+		r := newTestReconciler()
+		Expect(handleChartAuth(ctx, r, app)).To(Succeed())
+		Expect(handleGitRepository(ctx, r, app)).To(Succeed())
+		gitRepo := &sourcev1.GitRepository{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: r.ResourceManager.GitRepositoryName(app), Namespace: app.Namespace}, gitRepo)).To(Succeed())
+		Expect(gitRepo.Spec.SecretRef).To(Equal(&meta.LocalObjectReference{Name: "podinfo-chart-auth"}))
+	})
+
+	It("should delete the Secret once the credentials are removed", func() {
+		app := newAuthApp()
+		r := newTestReconciler()
+		Expect(handleChartAuth(ctx, r, app)).To(Succeed())
+		app.Spec.Chart.Auth = nil
+		Expect(handleChartAuth(ctx, r, app)).To(Succeed())
+		_, err := getSecret(r, app)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(conditions.Has(app, appsv1.InlineChartAuthCondition)).To(BeFalse())
+		Expect(chartAuthRef(r, app)).To(BeNil())
+	})
+
+	It("should keep the Docker config valid JSON for any credentials", func() {
+		config, err := chartAuthConfig("oci://registry.example.com:5000/charts/podinfo",
+			&appsv1.ChartAuth{Username: `dev"user`, Password: `p@ss:word`})
+		Expect(err).NotTo(HaveOccurred())
+		parsed := map[string]map[string]map[string]string{}
+		Expect(json.Unmarshal(config, &parsed)).To(Succeed())
+		Expect(parsed["auths"]["registry.example.com:5000"]).To(HaveKeyWithValue("username", `dev"user`))
+	})
+})
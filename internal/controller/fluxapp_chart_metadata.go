@@ -0,0 +1,317 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/fluxcd/pkg/runtime/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// helmConfigMediaType is the media type of the config blob of a Helm chart OCI artifact
+// The config holds the Chart.yaml metadata as JSON so it can be read without pulling the chart
+const helmConfigMediaType = "application/vnd.cncf.helm.config.v1+json"
+
+// maxChartMetadataSize limits how much of a registry response is read
+const maxChartMetadataSize = 1 << 20
+
+// ChartMetadata is the chart metadata read from Chart.yaml
+type ChartMetadata struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Deprecated bool   `json:"deprecated,omitempty"`
+}
+
+// ChartMetadataGetter gets the metadata of the chart artifact with the tag
+type ChartMetadataGetter interface {
+	ChartMetadata(ctx context.Context, repository, name, tag string) (*ChartMetadata, error)
+}
+
+// ChartProber checks the chart artifact with the tag can be pulled
+type ChartProber interface {
+	ProbeChart(ctx context.Context, repository, name, tag string) error
+}
+
+// ChartDigestResolver resolves the digest of the chart artifact the tag currently points to
+type ChartDigestResolver interface {
+	ChartDigest(ctx context.Context, repository, name, tag string) (string, error)
+}
+
+// ChartTagLister lists the tags of the chart artifacts
+type ChartTagLister interface {
+	ChartTags(ctx context.Context, repository, name string) ([]string, error)
+}
+
+// ociManifestMediaType is the media type of the manifest of a Helm chart OCI artifact
+const ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+
+// RegistryClient reads chart metadata from public OCI registries
+// The metadata of each chart version is cached as chart versions are immutable
+type RegistryClient struct {
+	client    *http.Client
+	mu        sync.RWMutex
+	cache     map[string]*ChartMetadata
+	manifests map[string]*ChartManifest
+	platforms map[string][]string
+}
+
+// NewRegistryClient returns a RegistryClient using the HTTP client
+// If nil, the default HTTP client is used
+func NewRegistryClient(c *http.Client) *RegistryClient {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	return &RegistryClient{client: c, cache: map[string]*ChartMetadata{}, manifests: map[string]*ChartManifest{}, platforms: map[string][]string{}}
+}
+
+// ChartMetadata reads the metadata of the chart from the config blob of the chart artifact with the tag
+func (c *RegistryClient) ChartMetadata(ctx context.Context, repository, name, tag string) (*ChartMetadata, error) {
+	ref := strings.TrimPrefix(repository, "oci://") + "/" + name
+	key := ref + ":" + tag
+	c.mu.RLock()
+	metadata, ok := c.cache[key]
+	c.mu.RUnlock()
+	if ok {
+		return metadata, nil
+	}
+	base, err := registryURL(repository, name)
+	if err != nil {
+		return nil, err
+	}
+	manifest := struct {
+		Config struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+		} `json:"config"`
+	}{}
+	if err := c.get(ctx, base+"/manifests/"+tag, ociManifestMediaType, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Config.MediaType != helmConfigMediaType {
+		return nil, fmt.Errorf("%w: %s has config media type %s", errNotAHelmChart, key, manifest.Config.MediaType)
+	}
+	metadata = &ChartMetadata{}
+	if err := c.get(ctx, base+"/blobs/"+manifest.Config.Digest, helmConfigMediaType, metadata); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.cache[key] = metadata
+	c.mu.Unlock()
+	return metadata, nil
+}
+
+// ProbeChart checks the manifest of the chart artifact with the tag exists without pulling the chart
+// The result isn't cached so a chart pushed after a failed probe is found by the next probe
+func (c *RegistryClient) ProbeChart(ctx context.Context, repository, name, tag string) error {
+	base, err := registryURL(repository, name)
+	if err != nil {
+		return err
+	}
+	resp, err := c.request(ctx, http.MethodHead, base+"/manifests/"+tag, ociManifestMediaType)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to get %s:%s: %s", name, tag, resp.Status)
+	}
+	return nil
+}
+
+// ChartDigest returns the digest of the manifest of the chart artifact with the tag without pulling the chart
+// The result isn't cached as the tag can be moved to another artifact
+func (c *RegistryClient) ChartDigest(ctx context.Context, repository, name, tag string) (string, error) {
+	base, err := registryURL(repository, name)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.request(ctx, http.MethodHead, base+"/manifests/"+tag, ociManifestMediaType)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to get %s:%s: %s", name, tag, resp.Status)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry didn't return the digest of %s:%s", name, tag)
+	}
+	return digest, nil
+}
+
+// ChartTags lists the tags of the chart, following the registry's pagination
+func (c *RegistryClient) ChartTags(ctx context.Context, repository, name string) ([]string, error) {
+	base, err := registryURL(repository, name)
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for u := base + "/tags/list"; u != ""; {
+		resp, err := c.request(ctx, http.MethodGet, u, "application/json")
+		if err != nil {
+			return nil, err
+		}
+		page := struct {
+			Tags []string `json:"tags"`
+		}{}
+		err = func() error {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("unable to get %s: %s", u, resp.Status)
+			}
+			return json.NewDecoder(io.LimitReader(resp.Body, maxChartMetadataSize)).Decode(&page)
+		}()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page.Tags...)
+		if u, err = nextPage(resp); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// nextPage returns the URL of the next page of the response from the Link header, if there is one
+// e.g. Link: </v2/charts/podinfo/tags/list?n=100&last=6.5.3>; rel="next"
+func nextPage(resp *http.Response) (string, error) {
+	link := resp.Header.Get("Link")
+	if link == "" {
+		return "", nil
+	}
+	target, params, _ := strings.Cut(link, ";")
+	if !strings.Contains(params, `rel="next"`) {
+		return "", nil
+	}
+	next, err := resp.Request.URL.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+	if err != nil {
+		return "", fmt.Errorf("invalid registry Link header: %q", link)
+	}
+	return next.String(), nil
+}
+
+// registryURL returns the base URL of the registry API for the chart in the repository
+func registryURL(repository, name string) (string, error) {
+	host, path, ok := strings.Cut(strings.TrimPrefix(repository, "oci://")+"/"+name, "/")
+	if !ok {
+		return "", fmt.Errorf("%w chart repository: %s", errInvalid, repository)
+	}
+	return "https://" + host + "/v2/" + path, nil
+}
+
+// get decodes the JSON response of the registry
+func (c *RegistryClient) get(ctx context.Context, u, accept string, v interface{}) error {
+	resp, err := c.request(ctx, http.MethodGet, u, accept)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to get %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxChartMetadataSize)).Decode(v)
+}
+
+// request sends the request to the registry, requesting an anonymous token if the registry requires one
+func (c *RegistryClient) request(ctx context.Context, method, u, accept string) (*http.Response, error) {
+	resp, err := c.do(ctx, method, u, accept, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		token, err := c.token(ctx, challenge)
+		if err != nil {
+			return nil, err
+		}
+		return c.do(ctx, method, u, accept, token)
+	}
+	return resp, nil
+}
+
+func (c *RegistryClient) do(ctx context.Context, method, u, accept, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c.client.Do(req)
+}
+
+// token requests an anonymous pull token from the realm in the registry's bearer challenge
+func (c *RegistryClient) token(ctx context.Context, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("unsupported registry auth challenge: %q", challenge)
+	}
+	attrs := map[string]string{}
+	for _, p := range strings.Split(params, ",") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok {
+			attrs[k] = strings.Trim(v, `"`)
+		}
+	}
+	realm, err := url.Parse(attrs["realm"])
+	if err != nil || realm.Scheme != "https" {
+		return "", fmt.Errorf("invalid registry auth realm: %q", attrs["realm"])
+	}
+	q := realm.Query()
+	for _, k := range []string{"service", "scope"} {
+		if attrs[k] != "" {
+			q.Set(k, attrs[k])
+		}
+	}
+	realm.RawQuery = q.Encode()
+	resp, err := c.do(ctx, http.MethodGet, realm.String(), "application/json", "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to get registry token: %s", resp.Status)
+	}
+	body := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxChartMetadataSize)).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// handleChartDeprecation sets the ChartDeprecated condition if the selected chart version is deprecated
+// Reading the metadata is best effort so a failure doesn't block the release, the condition is left as it was
+// It's only checked when a ChartMetadata getter is configured and the chart is pulled from a registry
+func handleChartDeprecation(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) {
+	// The metadata is cached by tag so a floating tag isn't checked
+	if r.ChartMetadata == nil || gitSource(app) || floatingTag(app) || app.Status.Chart.Version == "" {
+		return
+	}
+	metadata, err := r.ChartMetadata.ChartMetadata(ctx, app.Status.Chart.Repository, app.Status.Chart.Name, chartTag(app))
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to read chart metadata", "chart", app.Status.Chart.Name, "version", app.Status.Chart.Version)
+		return
+	}
+	if metadata.Deprecated {
+		conditions.MarkTrue(app, appsv1.ChartDeprecatedCondition, appsv1.DeprecatedChartReason,
+			"chart %s %s is deprecated", app.Status.Chart.Name, app.Status.Chart.Version)
+		return
+	}
+	conditions.Delete(app, appsv1.ChartDeprecatedCondition)
+}
@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/fluxcd/pkg/runtime/conditions"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// fakeChartMetadata returns the metadata for each tag
+type fakeChartMetadata struct {
+	metadata map[string]*ChartMetadata
+	tags     []string
+}
+
+func (f *fakeChartMetadata) ChartMetadata(_ context.Context, _, _, tag string) (*ChartMetadata, error) {
+	f.tags = append(f.tags, tag)
+	metadata, ok := f.metadata[tag]
+	if !ok {
+		return nil, errors.New("manifest unknown")
+	}
+	return metadata, nil
+}
+
+var _ = Describe("Chart deprecation", func() {
+	var (
+		ctx    context.Context
+		getter *fakeChartMetadata
+		r      *FluxAppReconciler
+		app    *appsv1.FluxApp
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		getter = &fakeChartMetadata{metadata: map[string]*ChartMetadata{
+			"6.5.3": {Name: "podinfo", Version: "6.5.3", Deprecated: true},
+			"6.5.4": {Name: "podinfo", Version: "6.5.4"},
+		}}
+		r = newTestReconciler()
+		r.ChartMetadata = getter
+		app = newTestApp()
+	})
+
+	It("should mark a deprecated chart version", func() {
+		handleChartDeprecation(ctx, r, app)
+		c := conditions.Get(app, appsv1.ChartDeprecatedCondition)
+		Expect(c).NotTo(BeNil())
+		Expect(c.Status).To(Equal(metav1.ConditionTrue))
+		Expect(c.Reason).To(Equal(appsv1.DeprecatedChartReason))
+		Expect(c.Message).To(Equal("chart podinfo 6.5.3 is deprecated"))
+	})
+
+	It("should remove the condition once the chart version isn't deprecated", func() {
+		handleChartDeprecation(ctx, r, app)
+		app.Status.Chart.Version = "6.5.4"
+		handleChartDeprecation(ctx, r, app)
+		Expect(conditions.Has(app, appsv1.ChartDeprecatedCondition)).To(BeFalse())
+	})
+
+	It("should leave the condition as it was if the metadata can't be read", func() {
+		handleChartDeprecation(ctx, r, app)
+		app.Status.Chart.Version = "7.0.0"
+		handleChartDeprecation(ctx, r, app)
+		Expect(conditions.IsTrue(app, appsv1.ChartDeprecatedCondition)).To(BeTrue())
+	})
+
+	It("should read the metadata of the prefixed tag", func() {
+		app.Spec.Chart.TagPrefix = "podinfo-"
+		handleChartDeprecation(ctx, r, app)
+		Expect(getter.tags).To(Equal([]string{"podinfo-6.5.3"}))
+	})
+
+	It("should not check the chart unless a getter is configured", func() {
+		r.ChartMetadata = nil
+		handleChartDeprecation(ctx, r, app)
+		Expect(conditions.Has(app, appsv1.ChartDeprecatedCondition)).To(BeFalse())
+	})
+
+	It("should not check git charts", func() {
+		app.Spec.SourceKind = sourcev1.GitRepositoryKind
+		handleChartDeprecation(ctx, r, app)
+		Expect(getter.tags).To(BeEmpty())
+	})
+})
+
+var _ = Describe("RegistryClient", func() {
+	const (
+		token  = "anonymous-token"
+		digest = "sha256:0123456789abcdef"
+	)
+
+	var (
+		server   *httptest.Server
+		config   string
+		requests int
+	)
+
+	BeforeEach(func() {
+		config = `{"name":"podinfo","version":"6.5.3","deprecated":true}`
+		requests = 0
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/token" {
+				Expect(req.URL.Query().Get("scope")).To(Equal("repository:charts/podinfo:pull"))
+				_ = json.NewEncoder(w).Encode(map[string]string{"token": token})
+				return
+			}
+			requests++
+			if req.Header.Get("Authorization") != "Bearer "+token {
+				w.Header().Set("WWW-Authenticate",
+					`Bearer realm="https://`+req.Host+`/token",service="registry",scope="repository:charts/podinfo:pull"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			switch req.URL.Path {
+			case "/v2/charts/podinfo/manifests/6.5.3":
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"config": map[string]string{"mediaType": helmConfigMediaType, "digest": digest},
+				})
+			case "/v2/charts/podinfo/blobs/" + digest:
+				_, _ = w.Write([]byte(config))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		DeferCleanup(server.Close)
+	})
+
+	repository := func() string {
+		return "oci://" + strings.TrimPrefix(server.URL, "https://") + "/charts"
+	}
+
+	It("should read the chart metadata with an anonymous token", func() {
+		metadata, err := NewRegistryClient(server.Client()).ChartMetadata(context.Background(), repository(), "podinfo", "6.5.3")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(metadata).To(Equal(&ChartMetadata{Name: "podinfo", Version: "6.5.3", Deprecated: true}))
+	})
+
+	It("should read charts that aren't deprecated", func() {
+		config = `{"name":"podinfo","version":"6.5.3"}`
+		metadata, err := NewRegistryClient(server.Client()).ChartMetadata(context.Background(), repository(), "podinfo", "6.5.3")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(metadata.Deprecated).To(BeFalse())
+	})
+
+	It("should cache the metadata of each chart version", func() {
+		c := NewRegistryClient(server.Client())
+		_, err := c.ChartMetadata(context.Background(), repository(), "podinfo", "6.5.3")
+		Expect(err).NotTo(HaveOccurred())
+		n := requests
+		_, err = c.ChartMetadata(context.Background(), repository(), "podinfo", "6.5.3")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requests).To(Equal(n))
+	})
+
+	It("should return an error for a missing tag", func() {
+		_, err := NewRegistryClient(server.Client()).ChartMetadata(context.Background(), repository(), "podinfo", "0.0.1")
+		Expect(err).To(MatchError(ContainSubstring("404")))
+	})
+})
@@ -0,0 +1,24 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/kloudyuk/fluxer/internal/childpatch"
+)
+
+// patchChild applies the app's child patch for the kind of the resource to its generated spec
+// Applying the patch again to the patched spec doesn't change it so the resource can be checked more than once
+// A patch which doesn't apply can't succeed on retry so the app is invalid
+func patchChild(res *managedResource) error {
+	if res.app == nil || !childpatch.Supported(res.kind) {
+		return nil
+	}
+	patch, ok := res.app.Spec.ChildPatches[res.kind]
+	if !ok {
+		return nil
+	}
+	if err := childpatch.Apply(res.kind, res.Object, patch.Raw); err != nil {
+		return fmt.Errorf("%w: spec.childPatches.%s: %s", errInvalid, res.kind, err)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+)
+
+var _ = Describe("Child patches", func() {
+	ctx := context.Background()
+
+	getHelmRepository := func(r *FluxAppReconciler, app *appsv1.FluxApp) *sourcev1.HelmRepository {
+		repo := &sourcev1.HelmRepository{}
+		key := types.NamespacedName{Name: r.ResourceManager.HelmRepositoryName(app), Namespace: app.Namespace}
+		Expect(r.Get(ctx, key, repo)).To(Succeed())
+		return repo
+	}
+
+	It("should patch the generated HelmRepository spec", func() {
+		r := newTestReconciler()
+		app := newTestApp()
+		app.Spec.ChildPatches = map[string]apiextensionsv1.JSON{
+			sourcev1.HelmRepositoryKind: {Raw: []byte(`{"spec":{"interval":"30m","timeout":"2m","insecure":true}}`)},
+		}
+		policyErr, repoErr := handleSources(ctx, r, app)
+		Expect(policyErr).NotTo(HaveOccurred())
+		Expect(repoErr).NotTo(HaveOccurred())
+		repo := getHelmRepository(r, app)
+		// The patch takes precedence over the generated spec
+		Expect(repo.Spec.Interval).To(Equal(metav1.Duration{Duration: 30 * time.Minute}))
+		Expect(repo.Spec.Timeout).To(Equal(&metav1.Duration{Duration: 2 * time.Minute}))
+		Expect(repo.Spec.Insecure).To(BeTrue())
+		// The rest of the generated spec is kept
+		Expect(repo.Spec.URL).To(Equal("oci://ghcr.io/stefanprodan/charts"))
+		Expect(repo.Spec.Type).To(Equal(sourcev1.HelmRepositoryTypeOCI))
+		Expect(metav1.IsControlledBy(repo, app)).To(BeTrue())
+	})
+
+	It("should only patch the HelmRepository of the app", func() {
+		r := newTestReconciler()
+		app := newTestApp()
+		app.Spec.ChildPatches = map[string]apiextensionsv1.JSON{
+			sourcev1.HelmRepositoryKind: {Raw: []byte(`{"spec":{"interval":"30m"}}`)},
+		}
+		other := newTestApp()
+		other.Name = "other"
+		other.UID = "other-uid"
+		other.Spec.ChildPatches = map[string]apiextensionsv1.JSON{
+			sourcev1.HelmRepositoryKind: {Raw: []byte(`{"spec":{"interval":"5m"}}`)},
+		}
+		unpatched := newTestApp()
+		unpatched.Name = "unpatched"
+		unpatched.UID = "unpatched-uid"
+		for _, a := range []*appsv1.FluxApp{app, other, unpatched, app} {
+			_, repoErr := handleSources(ctx, r, a)
+			Expect(repoErr).NotTo(HaveOccurred())
+		}
+		Expect(getHelmRepository(r, app).Spec.Interval.Duration).To(Equal(30 * time.Minute))
+		Expect(getHelmRepository(r, other).Spec.Interval.Duration).To(Equal(5 * time.Minute))
+		Expect(getHelmRepository(r, unpatched).Spec.Interval.Duration).NotTo(BeElementOf(30*time.Minute, 5*time.Minute))
+		Expect(r.ResourceManager.HelmRepositoryName(unpatched)).To(Equal("ghcr-io-stefanprodan-charts"))
+	})
+
+	It("should leave a patched child unchanged by the next reconcile", func() {
+		r := newTestReconciler()
+		app := newTestApp()
+		app.Spec.ChildPatches = map[string]apiextensionsv1.JSON{
+			sourcev1.HelmRepositoryKind: {Raw: []byte(`{"spec":{"interval":"30m"}}`)},
+		}
+		_, repoErr := handleSources(ctx, r, app)
+		Expect(repoErr).NotTo(HaveOccurred())
+		app.Status.Plan = nil
+		_, repoErr = handleSources(ctx, r, app)
+		Expect(repoErr).NotTo(HaveOccurred())
+		Expect(app.Status.Plan).To(ContainElement(appsv1.PlanEntry{
+			Kind:   sourcev1.HelmRepositoryKind,
+			Name:   r.ResourceManager.HelmRepositoryName(app),
+			Action: appsv1.PlanActionUnchanged,
+		}))
+	})
+
+	It("should merge the patch into the generated HelmRelease spec", func() {
+		r := newTestReconciler()
+		app := newTestApp()
+		app.Spec.ChildPatches = map[string]apiextensionsv1.JSON{
+			helmv2.HelmReleaseKind: {Raw: []byte(`{"spec":{"install":{"disableHooks":true}}}`)},
+		}
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Install.DisableHooks).To(BeTrue())
+		Expect(hr.Spec.Install.Replace).To(BeTrue())
+		Expect(hr.Spec.Install.CRDs).To(Equal(helmv2.CreateReplace))
+	})
+
+	It("should reject a patch deploying the release into a privileged namespace", func() {
+		r := newTestReconciler()
+		r.PrivilegedNamespaces = []string{"flux-system"}
+		app := newTestApp()
+		app.Namespace = "team-a"
+		app.Spec.ChildPatches = map[string]apiextensionsv1.JSON{
+			helmv2.HelmReleaseKind: {Raw: []byte(`{"spec":{"targetNamespace":"kube-system"}}`)},
+		}
+		Expect(checkPrivileges(r, app)).To(Succeed())
+		err := handleHelmRelease(ctx, r, app)
+		Expect(err).To(MatchError(errInvalid))
+		Expect(err.Error()).To(ContainSubstring("can't set spec.targetNamespace"))
+		_, err = getHelmRelease(ctx, r, app)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should reject a patch changing the case of a protected key", func() {
+		r := newTestReconciler()
+		app := newTestApp()
+		app.Spec.ChildPatches = map[string]apiextensionsv1.JSON{
+			helmv2.HelmReleaseKind: {Raw: []byte(`{"spec":{"StorageNamespace":"kube-system"}}`)},
+		}
+		err := handleHelmRelease(ctx, r, app)
+		Expect(err).To(MatchError(errInvalid))
+		Expect(err.Error()).To(ContainSubstring("can't set spec.storageNamespace"))
+		_, err = getHelmRelease(ctx, r, app)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	DescribeTable("should reject a patch of the fields deciding where & what is deployed",
+		func(patch string) {
+			r := newTestReconciler()
+			app := newTestApp()
+			app.Spec.ChildPatches = map[string]apiextensionsv1.JSON{helmv2.HelmReleaseKind: {Raw: []byte(patch)}}
+			Expect(handleHelmRelease(ctx, r, app)).To(MatchError(errInvalid))
+		},
+		Entry("storageNamespace", `{"spec":{"storageNamespace":"kube-system"}}`),
+		Entry("serviceAccountName", `{"spec":{"serviceAccountName":"helm-controller"}}`),
+		Entry("kubeConfig", `{"spec":{"kubeConfig":{"secretRef":{"name":"other-cluster"}}}}`),
+		Entry("releaseName", `{"spec":{"releaseName":"other"}}`),
+		Entry("chartRef", `{"spec":{"chartRef":{"kind":"OCIRepository","name":"other"}}}`),
+		Entry("chart sourceRef", `{"spec":{"chart":{"spec":{"sourceRef":{"kind":"HelmRepository","name":"other"}}}}}`),
+		Entry("a key in another case", `{"spec":{"ServiceAccountName":"cluster-admin"}}`),
+		Entry("an object key in another case", `{"spec":{"KubeConfig":{"secretRef":{"name":"other-cluster"}}}}`),
+	)
+
+	DescribeTable("should reject a patch which doesn't apply",
+		func(patch string) {
+			r := newTestReconciler()
+			app := newTestApp()
+			app.Spec.ChildPatches = map[string]apiextensionsv1.JSON{sourcev1.HelmRepositoryKind: {Raw: []byte(patch)}}
+			_, repoErr := handleSources(ctx, r, app)
+			Expect(repoErr).To(MatchError(errInvalid))
+			Expect(repoErr.Error()).To(ContainSubstring("spec.childPatches.HelmRepository"))
+		},
+		Entry("unknown field", `{"spec":{"intervall":"30m"}}`),
+		Entry("metadata", `{"metadata":{"name":"other"}}`),
+		Entry("not an object", `["spec"]`),
+	)
+
+	It("should ignore the patches of other kinds", func() {
+		r := newTestReconciler()
+		app := newTestApp()
+		app.Spec.ChildPatches = map[string]apiextensionsv1.JSON{
+			sourcev1.GitRepositoryKind: {Raw: []byte(`{"spec":{"interval":"30m"}}`)},
+		}
+		_, repoErr := handleSources(ctx, r, app)
+		Expect(repoErr).NotTo(HaveOccurred())
+		Expect(getHelmRepository(r, app).Spec.Interval.Duration).NotTo(Equal(30 * time.Minute))
+	})
+})
@@ -20,41 +20,181 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"path"
+	"reflect"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/blang/semver/v4"
 	"github.com/fluxcd/pkg/apis/meta"
 	"github.com/fluxcd/pkg/runtime/conditions"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	appsv1 "github.com/kloudyuk/fluxer/api/v1"
 
 	helmv2 "github.com/fluxcd/helm-controller/api/v2"
 	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
 )
 
 const finalizer = "apps.kloudy.uk/finalizer"
 
+// ControllerName is the name of the FluxApp controller
+const ControllerName = "fluxapp"
+
 var errRequeue = errors.New("requeue")
 
+// releaseStatusDeployed is the Helm release status of a successfully deployed release
+const releaseStatusDeployed = "deployed"
+
+// driftDetectionAnnotation overrides the drift detection mode of the app while it's set to disabled
+const driftDetectionAnnotation = "apps.kloudy.uk/drift-detection"
+
+// defaultShutdownGracePeriod is the default time allowed to persist the status of a cancelled reconcile
+const defaultShutdownGracePeriod = 10 * time.Second
+
+// defaultStatusPatchAttempts is the default number of attempts to patch the status when it conflicts
+const defaultStatusPatchAttempts = 5
+
 // FluxAppReconciler reconciles a FluxApp object
 type FluxAppReconciler struct {
 	client.Client
 	Scheme          *runtime.Scheme
 	ResourceManager *ResourceManager
+	// ScanRequeueInterval is how long to wait before checking again for the chart info
+	// needed by the HelmRelease e.g. while waiting for the ImagePolicy to select a version
+	ScanRequeueInterval time.Duration
+	// JitterFactor is the maximum fraction of an interval added to it so apps created together
+	// don't all hit the registry at the same time
+	JitterFactor float64
+	// ChartCache caches the chart source info resolved for each app
+	// If nil, the chart is resolved on every reconcile
+	ChartCache *ChartCache
+	// ShutdownGracePeriod is how long the status patch at the end of a reconcile may take
+	// after the reconcile has been cancelled e.g. when the controller is shutting down
+	// If zero, defaultShutdownGracePeriod is used
+	ShutdownGracePeriod time.Duration
+	// StatusPatchAttempts is how many times the status patch at the end of a reconcile is attempted
+	// if it conflicts with another update, so the status isn't lost under contention
+	// If zero, defaultStatusPatchAttempts is used
+	StatusPatchAttempts int
+	// LabelSelector restricts the apps reconciled by the controller so apps can be sharded
+	// between multiple controllers. If nil, all apps are reconciled
+	LabelSelector labels.Selector
+	// Recorder emits events for the app e.g. when the HelmRelease values change
+	// If nil, no events are emitted
+	Recorder record.EventRecorder
+	// ChartMetadata reads the metadata of the selected chart version to warn if it's deprecated
+	// If nil, the chart metadata isn't read
+	ChartMetadata ChartMetadataGetter
+	// ChartManifests reads the manifest of the selected chart version to record the chart size in the status
+	// If nil, the chart artifact isn't inspected
+	ChartManifests ChartManifestGetter
+	// ChartPlatforms lists the platforms of the selected chart version to warn if the cluster architecture is missing
+	// If nil, the chart platforms aren't checked
+	ChartPlatforms ChartPlatformGetter
+	// ClusterArchitecture is the architecture of the cluster nodes e.g. amd64, checked against the chart platforms
+	ClusterArchitecture string
+	// ChartTags lists the chart versions for apps which select the lowest matching version
+	// If nil, apps can't select the lowest version
+	ChartTags ChartTagLister
+	// ChartDigests resolves the digest of the floating tag of apps which track one e.g. stable
+	// If nil, apps can't track a floating tag
+	ChartDigests ChartDigestResolver
+	// ChartProber checks the selected chart version can be pulled for apps with preflightPull set
+	// If nil, the chart isn't checked
+	ChartProber ChartProber
+	// CustomResourceDefinitions reads the CRDs the custom resource values of apps are validated against
+	// If nil, the custom resource values aren't validated
+	CustomResourceDefinitions client.Reader
+	// DefaultValues are the operator-level default values merged under the values of every app
+	// If nil, there are no default values
+	DefaultValues *DefaultValues
+	// NamespaceDefaultValues are the default values of each namespace, merged over the operator-level default values
+	// e.g. for a team's own defaults
+	NamespaceDefaultValues map[string]*DefaultValues
+	// Substitutions replace the ${name} tokens in the values of apps which opt in
+	// e.g. to inject the cluster name or region
+	Substitutions map[string]string
+	// PrivilegedNamespaces are the namespaces whose apps can deploy to another namespace
+	// If nil, the apps in every namespace are privileged
+	PrivilegedNamespaces []string
+	// HookClient posts the pre & post hooks of apps
+	// If nil, a client which times out after maxHookTimeout is used
+	HookClient *http.Client
+	// Notifier posts a notification when an app becomes ready or fails
+	// If nil, no notifications are posted
+	Notifier *Notifier
+	// SlowDeletionThreshold is how long a deletion can take before it's counted as slow
+	// If zero, no deletions are counted as slow
+	SlowDeletionThreshold time.Duration
+	// DisableFinalizer stops the finalizer being added to apps so their children are only removed
+	// by the owner reference garbage collection and a deletion can't hang on the controller
+	DisableFinalizer bool
+	// ReconcileBudget is how long a reconcile may run the handlers before the remaining handlers
+	// are deferred to a requeue, so one slow app doesn't starve the others
+	// If zero, the handlers aren't limited
+	ReconcileBudget time.Duration
+	// AuditLogEntries is how many entries are kept in the audit log ConfigMap of each app
+	// If zero, no audit log is kept
+	AuditLogEntries int
+	// QuarantineAfter is how many reconciles of an app can fail in a row before it's quarantined
+	// If zero, apps are never quarantined
+	QuarantineAfter int
+	// QuarantineInterval is how often a quarantined app is reconciled
+	// If zero, defaultQuarantineInterval is used
+	QuarantineInterval time.Duration
+	// DefaultTimeout is the Helm timeout of apps which don't set one
+	// If zero, the HelmRelease default is used
+	DefaultTimeout time.Duration
+	// DefaultMaxHistory is how many release revisions are kept for apps which don't set maxHistory
+	// If zero, the HelmRelease default is used
+	DefaultMaxHistory int
+
+	// resync receives apps whose children need re-applying
+	resync chan event.GenericEvent
+	// locks serialise the reconciles of each app
+	locks appLocks
+	// progress holds the handler each app resumes from after running out of budget
+	progress appProgress
 }
 
 // +kubebuilder:rbac:groups=apps.kloudy.uk,resources=fluxapps,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps.kloudy.uk,resources=fluxapps/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=apps.kloudy.uk,resources=fluxapps/finalizers,verbs=update
+// +kubebuilder:rbac:groups=apps.kloudy.uk,resources=fluxapptemplates,verbs=get;list;watch
+
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;create;patch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;create;patch;delete
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;create;patch;delete
+
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list
 
 // +kubebuilder:rbac:groups=image.toolkit.fluxcd.io,resources=imagerepositories;imagepolicies,verbs=get;list;watch;create;update;patch;delete;deletecollection
 // +kubebuilder:rbac:groups=image.toolkit.fluxcd.io,resources=imagerepositories/status;imagepolicies/status,verbs=get
@@ -62,8 +202,8 @@ type FluxAppReconciler struct {
 // +kubebuilder:rbac:groups=helm.toolkit.fluxcd.io,resources=helmreleases,verbs=get;list;watch;create;update;patch;delete;deletecollection
 // +kubebuilder:rbac:groups=helm.toolkit.fluxcd.io,resources=helmreleases/status,verbs=get
 
-// +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=helmrepositories,verbs=get;list;watch;create;update;patch;delete;deletecollection
-// +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=helmrepositories/status,verbs=get
+// +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=helmrepositories;gitrepositories;ocirepositories,verbs=get;list;watch;create;update;patch;delete;deletecollection
+// +kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=helmrepositories/status;gitrepositories/status;ocirepositories/status,verbs=get
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -74,7 +214,12 @@ type FluxAppReconciler struct {
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.19.1/pkg/reconcile
-func (r *FluxAppReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *FluxAppReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, retErr error) {
+	// Only one reconcile of the app mutates its children at a time
+	unlock := r.locks.Lock(req.NamespacedName)
+	defer unlock()
+
+	start := time.Now()
 
 	// Setup logger
 	log := log.FromContext(ctx)
@@ -83,6 +228,13 @@ func (r *FluxAppReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	app := &appsv1.FluxApp{}
 	if err := r.Get(ctx, req.NamespacedName, app); err != nil {
 		// Ignore NotFound errors
+		if apierrors.IsNotFound(err) {
+			r.ChartCache.Delete(req.NamespacedName)
+			r.progress.Forget(req.NamespacedName)
+			if r.Notifier != nil {
+				r.Notifier.Forget(req.NamespacedName)
+			}
+		}
 		err = client.IgnoreNotFound(err)
 		if err != nil {
 			log.Error(err, "unable to fetch FluxApp")
@@ -90,71 +242,206 @@ func (r *FluxAppReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, err
 	}
 
+	// Ignore apps in another shard which were enqueued by a change to their children
+	if !r.inShard(app) {
+		return ctrl.Result{}, nil
+	}
+
 	// Handle object deletion
 	if !app.ObjectMeta.DeletionTimestamp.IsZero() {
 		if controllerutil.ContainsFinalizer(app, finalizer) {
 			// Handle clean up logic
 			// TODO: Implement clean up logic here
+			r.ChartCache.Delete(req.NamespacedName)
 			// Remove finalizer and update the object
 			controllerutil.RemoveFinalizer(app, finalizer)
 			if err := r.Update(ctx, app); err != nil {
 				return ctrl.Result{}, err
 			}
+			r.observeDeletion(ctx, app)
 		}
 		// Stop reconciliation as the object is being deleted
 		return ctrl.Result{}, nil
 	}
 
+	// Measure the queue wait before adding the finalizer changes the managed fields
+	wait := queueWait(app, start)
+
 	// Add the finalizer if not present
-	if !controllerutil.ContainsFinalizer(app, finalizer) {
-		controllerutil.AddFinalizer(app, finalizer)
+	// If the finalizer is disabled, remove any added before it was so deletions aren't blocked
+	if r.DisableFinalizer {
+		if controllerutil.RemoveFinalizer(app, finalizer) {
+			if err := r.Update(ctx, app); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	} else if controllerutil.AddFinalizer(app, finalizer) {
 		if err := r.Update(ctx, app); err != nil {
 			return ctrl.Result{}, err
 		}
 	}
 
+	// Let the handlers record the reason for the outcome of the reconcile
+	ctx, reason := withReconcileReason(ctx)
+
 	// Always patch the status before returning
-	p := client.MergeFrom(app.DeepCopy())
+	before := app.DeepCopy()
 	defer func() {
-		if err := r.Status().Patch(ctx, app, p); err != nil {
+		reconcileTotal.WithLabelValues(reconcileReason(*reason, result, retErr)).Inc()
+		// A quarantined app which was skipped keeps its last error & failure count
+		if *reason != reconcileReasonQuarantined {
+			setLastError(app, retErr)
+			r.countFailures(before, app, retErr)
+		}
+		if retErr != nil && conditions.IsTrue(app, appsv1.QuarantinedCondition) {
+			log.Info("FluxApp quarantined after repeated failures", "error", retErr.Error())
+		}
+		result, retErr = r.quarantine(app, result, retErr)
+		setReconcileTiming(app, start, wait)
+		aggregateReady(app)
+		summarizeReady(app)
+		setChartUpdate(app)
+		// Detach from the reconcile context so the status is still persisted
+		// if the reconcile was cancelled part way through e.g. on SIGTERM
+		patchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.shutdownGracePeriod())
+		defer cancel()
+		if err := r.patchStatus(patchCtx, app, before); err != nil {
 			log.Error(err, "unable to update FluxApp status")
+			return
+		}
+		// Only notify once the status is persisted so the notification matches the app
+		if r.Notifier != nil {
+			if err := r.Notifier.Notify(patchCtx, before, app); err != nil {
+				log.Error(err, "unable to post FluxApp notification")
+			}
+		}
+		r.recordSuspension(before, app)
+		if err := r.recordAudit(patchCtx, before, app); err != nil {
+			log.Error(err, "unable to record FluxApp audit log")
 		}
 	}()
 
-	// Handle the chart ImageRepository object
-	if err := handleImageRepository(ctx, r, app); err != nil {
-		if errors.Is(err, errRequeue) {
-			return ctrl.Result{Requeue: true}, nil
-		}
+	// Remove conditions left over from a previous generation of the spec
+	clearStaleConditions(app)
+
+	// Leave a quarantined app alone until it's due, however often its children change
+	if remaining := r.quarantineRemaining(app); remaining > 0 {
+		setReconcileReason(ctx, reconcileReasonQuarantined)
+		return ctrl.Result{RequeueAfter: remaining}, nil
+	}
+
+	// Reject capabilities the app isn't allowed before checking or touching the children
+	// so a converged app is rejected once the controller restricts its namespace
+	if err := checkPrivileges(r, app); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	// Handle the chart ImagePolicy object
-	if err := handleImagePolicy(ctx, r, app); err != nil {
-		if errors.Is(err, errRequeue) {
-			return ctrl.Result{Requeue: true}, nil
-		}
+	// Skip the handlers if the children already reflect the current spec
+	// The children are still checked at the HelmRelease interval
+	if ok, err := converged(ctx, r, app); err != nil {
 		return ctrl.Result{}, err
+	} else if ok {
+		log.V(1).Info("children converged, skipping handlers")
+		setReconcileReason(ctx, reconcileReasonConverged)
+		settlePlan(app)
+		return ctrl.Result{RequeueAfter: r.childInterval(app, helmReleaseInterval(app).Duration)}, nil
 	}
 
-	// Handle the HelmRepository object
-	if err := handleHelmRepository(ctx, r, app); err != nil {
+	// Inherit any fields not set on the app from the referenced template
+	if err := applyTemplate(ctx, r, app); err != nil {
 		if errors.Is(err, errRequeue) {
 			return ctrl.Result{Requeue: true}, nil
 		}
 		return ctrl.Result{}, err
 	}
 
-	// Handle the HelmRelease object
+	// Run the handlers, deferring any left once the reconcile budget is spent
+	// The handlers record what they do to each child in a new plan
+	app.Status.Plan = nil
+	return r.runStages(ctx, app, start, handlerStages)
+}
+
+// handlerStages are the handlers run in order by each reconcile
+var handlerStages = []reconcileStage{
+	{name: "sources", run: reconcileSources},
+	// Warn if the selected chart version is deprecated
+	{name: "chartDeprecation", run: func(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) (*ctrl.Result, error) {
+		handleChartDeprecation(ctx, r, app)
+		return nil, nil
+	}},
+	// Record the size of the selected chart version
+	{name: "chartArtifact", run: func(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) (*ctrl.Result, error) {
+		handleChartArtifact(ctx, r, app)
+		return nil, nil
+	}},
+	// Warn if the selected chart version isn't published for the cluster architecture
+	{name: "chartPlatform", run: func(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) (*ctrl.Result, error) {
+		handleChartPlatform(ctx, r, app)
+		return nil, nil
+	}},
+	{name: "helmRelease", run: reconcileHelmRelease},
+}
+
+// reconcileSources handles the chart source objects
+func reconcileSources(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) (*ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	// The sources reference the chart auth Secret so it's created first
+	if err := handleChartAuth(ctx, r, app); err != nil {
+		return &ctrl.Result{}, err
+	}
+	if gitSource(app) {
+		// Handle the chart GitRepository object
+		if err := handleGitRepository(ctx, r, app); err != nil {
+			if errors.Is(err, errRequeue) {
+				return &ctrl.Result{Requeue: true}, nil
+			}
+			return &ctrl.Result{}, err
+		}
+	} else {
+		// Remove the GitRepository left over from when the chart was pulled from Git
+		if err := deleteGitRepository(ctx, r, app); err != nil {
+			return &ctrl.Result{}, err
+		}
+		// Handle the chart ImageRepository object
+		if err := handleImageRepository(ctx, r, app); err != nil {
+			if errors.Is(err, errRequeue) {
+				return &ctrl.Result{Requeue: true}, nil
+			}
+			return &ctrl.Result{}, err
+		}
+
+		// Handle the chart ImagePolicy & HelmRepository objects
+		policyErr, repoErr := handleSources(ctx, r, app)
+		// The override version is deployed even if the ImagePolicy fails
+		if policyErr != nil && versionOverridden(app) {
+			log.Info("ignoring ImagePolicy error while the chart version is overridden", "error", policyErr.Error())
+			policyErr = nil
+		}
+		if policyErr != nil {
+			if errors.Is(policyErr, errRequeue) {
+				return &ctrl.Result{RequeueAfter: r.requeueAfter()}, nil
+			}
+			return &ctrl.Result{}, policyErr
+		}
+		if repoErr != nil {
+			if errors.Is(repoErr, errRequeue) {
+				return &ctrl.Result{Requeue: true}, nil
+			}
+			return &ctrl.Result{}, repoErr
+		}
+	}
+	return nil, nil
+}
+
+// reconcileHelmRelease handles the HelmRelease object
+func reconcileHelmRelease(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) (*ctrl.Result, error) {
 	if err := handleHelmRelease(ctx, r, app); err != nil {
 		if errors.Is(err, errRequeue) {
-			return ctrl.Result{Requeue: true}, nil
+			return &ctrl.Result{RequeueAfter: r.requeueAfter()}, nil
 		}
-		return ctrl.Result{}, err
+		return &ctrl.Result{}, err
 	}
-
-	// Return success
-	return ctrl.Result{}, nil
+	return nil, nil
 }
 
 // Handle Flux ImageRepository object
@@ -165,29 +452,105 @@ func handleImageRepository(ctx context.Context, r *FluxAppReconciler, app *appsv
 		return err
 	}
 	imageRepo := mr.Object.(*imagev1.ImageRepository)
+	// Only registries are supported unless the chart is pulled from Git
+	if !strings.HasPrefix(app.Spec.Chart.Repository, "oci://") {
+		return fmt.Errorf("%w: %s is not an OCI repository URL, set sourceKind to %s to pull the chart from Git",
+			errInvalid, app.Spec.Chart.Repository, sourcev1.GitRepositoryKind)
+	}
 	// Update the ImageRepository spec
-	provider, err := providerFromURL(app.Spec.Chart.Repository)
+	chart, err := r.ChartCache.Resolve(app)
 	if err != nil {
 		return err
 	}
-	parts := strings.Split(app.Spec.Chart.Repository, "://")
-	if len(parts) != 2 {
-		return fmt.Errorf("invalid chart repository URL: %s", app.Spec.Chart.Repository)
+	if app.Spec.Chart.Provider == "" {
+		providerDetectedTotal.WithLabelValues(chart.provider).Inc()
 	}
 	imageRepo.Spec = imagev1.ImageRepositorySpec{
-		Image:    parts[1],
-		Interval: metav1.Duration{Duration: 1 * time.Minute},
-		Provider: provider,
+		Image:         chart.image,
+		Interval:      metav1.Duration{Duration: r.childInterval(app, scanInterval(app).Duration)},
+		Provider:      chart.provider,
+		AccessFrom:    app.Spec.Chart.AccessFrom.DeepCopy(),
+		Suspend:       app.Spec.SuspendImageAutomation,
+		ExclusionList: exclusionList(app),
+		SecretRef:     chartAuthRef(r, app),
 	}
+	mergeLabels(imageRepo, app.Spec.Chart.RepositoryLabels)
 	// Set the app chart status based on the ImageRepository object
 	if imageRepo.Spec.Image != "" {
 		app.Status.Chart.Repository = "oci://" + path.Dir(imageRepo.Spec.Image)
 		app.Status.Chart.Name = path.Base(imageRepo.Spec.Image)
 	}
+	if revision := latestScannedVersion(imageRepo, app.Spec.Chart.TagPrefix); revision != "" {
+		app.Status.Chart.SourceRevision = revision
+	}
+	mirrorChildReady(app, appsv1.ImageRepositoryReadyCondition, imagev1.ImageRepositoryKind, imageRepo)
+	classifyAuthFailure(app)
 	// Update the resource
 	return r.ResourceManager.Update(ctx, mr)
 }
 
+// handleSources handles the ImagePolicy and HelmRepository objects, returning the error from each
+// The HelmRepository doesn't depend on the ImagePolicy so with the parallelHandlers feature gate
+// they're handled concurrently, otherwise the HelmRepository is only handled once the ImagePolicy succeeds
+func handleSources(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) (policyErr, repoErr error) {
+	// A referenced HelmRepository is used instead of generating one
+	handleRepository := handleHelmRepository
+	if app.Spec.HelmRepositoryRef != nil {
+		handleRepository = handleHelmRepositoryRef
+	}
+	// A floating tag is tracked by its digest instead of an ImagePolicy
+	handlePolicy := handleImagePolicy
+	if floatingTag(app) {
+		handlePolicy = handleFloatingTag
+	}
+	if !featureEnabled(app, featureParallelHandlers) {
+		// The HelmRepository is still needed to deploy the override version if the ImagePolicy fails
+		if policyErr = handlePolicy(ctx, r, app); policyErr != nil && !versionOverridden(app) {
+			return policyErr, nil
+		}
+		return policyErr, handleRepository(ctx, r, app)
+	}
+	// Both handlers write the app status so the HelmRepository is handled on a copy of the app
+	// which is merged back once both have finished, as if it had been handled after the ImagePolicy
+	before := app.DeepCopy()
+	repoApp := app.DeepCopy()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		repoErr = handleRepository(ctx, r, repoApp)
+	}()
+	policyErr = handlePolicy(ctx, r, app)
+	wg.Wait()
+	mergeStatus(app, before, repoApp)
+	return policyErr, repoErr
+}
+
+// mergeStatus merges the status changes made to a copy of the app since before into the app
+// Only the conditions, source revision & plan are written by the HelmRepository handlers
+// Ready is aggregated again from the merged conditions rather than taken from either copy
+func mergeStatus(app, before, updated *appsv1.FluxApp) {
+	for i := range updated.Status.Conditions {
+		c := updated.Status.Conditions[i]
+		if c.Type == meta.ReadyCondition || reflect.DeepEqual(conditions.Get(before, c.Type), &c) {
+			continue
+		}
+		conditions.Set(app, &c)
+	}
+	for _, c := range before.Status.Conditions {
+		if c.Type != meta.ReadyCondition && !conditions.Has(updated, c.Type) {
+			conditions.Delete(app, c.Type)
+		}
+	}
+	if updated.Status.Chart.SourceRevision != before.Status.Chart.SourceRevision {
+		app.Status.Chart.SourceRevision = updated.Status.Chart.SourceRevision
+	}
+	for _, e := range updated.Status.Plan[len(before.Status.Plan):] {
+		addPlanEntry(app, e)
+	}
+	aggregateReady(app)
+}
+
 // Handle Flux ImagePolicy object
 func handleImagePolicy(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) error {
 	// Get the ImagePolicy managed resource
@@ -208,20 +571,97 @@ func handleImagePolicy(ctx context.Context, r *FluxAppReconciler, app *appsv1.Fl
 			},
 		},
 	}
-	// Add the latest image to the app status
-	if imagePolicy.Status.LatestImage != "" {
-		parts := strings.Split(imagePolicy.Status.LatestImage, ":")
-		if len(parts) != 2 {
-			return fmt.Errorf("invalid image reference: %s", imagePolicy.Status.LatestImage)
+	// Extract the version from prefixed tags so the SemVer range can be applied
+	if prefix := app.Spec.Chart.TagPrefix; prefix != "" {
+		imagePolicy.Spec.FilterTags = &imagev1.TagFilter{
+			Pattern: "^" + regexp.QuoteMeta(prefix) + "(?P<version>.*)$",
+			Extract: "$version",
 		}
-		app.Status.Chart.Version = parts[1]
+	}
+	mirrorChildReady(app, appsv1.ImagePolicyReadyCondition, imagev1.ImagePolicyKind, imagePolicy)
+	// If the version constraint doesn't match any chart versions, say so
+	// rather than waiting for a version that will never be selected
+	if noMatchingVersion(imagePolicy) {
+		conditions.MarkFalse(app, meta.ReadyCondition, appsv1.NoMatchingVersionReason, "no chart versions match %q", app.Spec.Chart.Version)
+		if err := r.ResourceManager.Update(ctx, mr); err != nil {
+			return err
+		}
+		setReconcileReason(ctx, reconcileReasonRequeueNoMatchingVersion)
+		return errRequeue
+	}
+	// Nothing is scanned while image automation is suspended so the chart version is kept
+	// The HelmRelease isn't suspended so it's still reconciled at its interval
+	if app.Spec.SuspendImageAutomation && app.Status.Chart.Version != "" {
+		markVersionUpdatesPaused(app, appsv1.SuspendedImageAutomationReason,
+			"image automation is suspended at %s", app.Status.Chart.Version)
+	} else if imagePolicy.Status.LatestImage != "" {
+		// Add the latest image to the app status
+		version, err := chartVersion(app, imagePolicy.Status.LatestImage)
+		if err != nil {
+			if errors.Is(err, errNotAHelmChart) {
+				conditions.MarkFalse(app, meta.ReadyCondition, appsv1.NotAHelmChartReason, "%s", err)
+			}
+			return err
+		}
+		// The ImagePolicy always selects the highest matching version
+		if app.Spec.Chart.VersionSelection == appsv1.VersionSelectionLowest {
+			if version, err = lowestVersion(ctx, r, app); err != nil {
+				return err
+			}
+		}
+		// The available version is reported even while the chart version is kept
+		// so gated upgrades can be watched for
+		app.Status.Chart.AvailableVersion = version
+		// Keep the last resolved version while version updates are paused
+		// The HelmRelease isn't suspended so it's still reconciled at its interval
+		if app.Spec.PauseVersionUpdates && app.Status.Chart.Version != "" {
+			markVersionUpdatesPaused(app, appsv1.PausedVersionUpdatesReason,
+				"version updates are paused at %s, the latest matching version is %s", app.Status.Chart.Version, version)
+		} else {
+			app.Status.Chart.Version = version
+		}
+	}
+	// A rollback pins the previous version over the resolved version until the rollback annotation is removed
+	rolledBack, err := rollback(app)
+	if err != nil {
+		return err
+	}
+	if !app.Spec.PauseVersionUpdates && !app.Spec.SuspendImageAutomation && !rolledBack {
+		conditions.Delete(app, appsv1.VersionUpdatesPausedCondition)
 	}
 	// Update the resource
 	return r.ResourceManager.Update(ctx, mr)
 }
 
+// ownHelmRepository returns true if the app needs a HelmRepository of its own rather than sharing one
+// The credentials & child patch would otherwise apply to every app pulling from the same registry
+func ownHelmRepository(app *appsv1.FluxApp) bool {
+	_, patched := app.Spec.ChildPatches[sourcev1.HelmRepositoryKind]
+	return app.Spec.Chart.Auth != nil || patched
+}
+
+// deleteOwnHelmRepository deletes the HelmRepository of the app left over from before it shared one
+func deleteOwnHelmRepository(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) error {
+	helmRepository := &sourcev1.HelmRepository{}
+	key := types.NamespacedName{Name: r.ResourceManager.OwnHelmRepositoryName(app), Namespace: app.Namespace}
+	if err := r.Get(ctx, key, helmRepository); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	// Only delete a HelmRepository the app created
+	if !slices.ContainsFunc(helmRepository.GetOwnerReferences(), func(ref metav1.OwnerReference) bool { return ref.UID == app.UID }) {
+		return nil
+	}
+	mr := &managedResource{Object: helmRepository, app: app, kind: sourcev1.HelmRepositoryKind, patch: client.MergeFrom(helmRepository.DeepCopy())}
+	return r.ResourceManager.Delete(ctx, mr)
+}
+
 // Handle Flux HelmRepository object
 func handleHelmRepository(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) error {
+	if !ownHelmRepository(app) {
+		if err := deleteOwnHelmRepository(ctx, r, app); err != nil {
+			return err
+		}
+	}
 	// Get the HelmRepository managed resource
 	mr, err := r.ResourceManager.Get(ctx, app, sourcev1.HelmRepositoryKind)
 	if err != nil {
@@ -229,70 +669,479 @@ func handleHelmRepository(ctx context.Context, r *FluxAppReconciler, app *appsv1
 	}
 	helmRepository := mr.Object.(*sourcev1.HelmRepository)
 	// Update the spec
-	provider, err := providerFromURL(app.Status.Chart.Repository)
+	// The HelmRepository is the parent of the chart repository so uses the same provider
+	chart, err := r.ChartCache.Resolve(app)
 	if err != nil {
 		return err
 	}
 	helmRepository.Spec = sourcev1.HelmRepositorySpec{
-		URL:      app.Status.Chart.Repository,
-		Type:     "oci",
-		Provider: provider,
+		URL:        app.Status.Chart.Repository,
+		Type:       sourcev1.HelmRepositoryTypeOCI,
+		Provider:   chart.provider,
+		AccessFrom: app.Spec.Chart.AccessFrom.DeepCopy(),
+		SecretRef:  chartAuthRef(r, app),
+	}
+	mergeLabels(helmRepository, app.Spec.Chart.RepositoryLabels)
+	// OCI HelmRepositories don't produce an artifact so this only applies to other repository types
+	if artifact := helmRepository.Status.Artifact; artifact != nil && artifact.Revision != "" {
+		app.Status.Chart.SourceRevision = artifact.Revision
 	}
+	mirrorHelmRepositoryReady(app, helmRepository)
 	// Update the resource
 	return r.ResourceManager.Update(ctx, mr)
 }
 
 // Handle Flux HelmRelease object
 func handleHelmRelease(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) error {
+	// The override version is deployed instead of the version selected by the ImagePolicy
+	if err := overrideVersion(app); err != nil {
+		return err
+	}
 	// If we don't have the info needed for the HelmRelease, requeue
 	if app.Status.Chart.Repository == "" || app.Status.Chart.Name == "" || app.Status.Chart.Version == "" {
+		setReconcileReason(ctx, reconcileReasonRequeueScan)
 		return errRequeue
 	}
-	// Get the HelmRelease managed resource
-	mr, err := r.ResourceManager.Get(ctx, app, helmv2.HelmReleaseKind)
+	// Validate the values & values references before touching the HelmRelease
+	valuesRefs, err := valuesFrom(app)
 	if err != nil {
 		return err
 	}
-	helmRelease := mr.Object.(*helmv2.HelmRelease)
-	// Update the spec
+	values, err := inlineValues(app, r.Substitutions)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidValues):
+			conditions.MarkFalse(app, meta.ReadyCondition, appsv1.InvalidValuesReason, "%s", err)
+		case errors.Is(err, errInvalidValuesTemplate):
+			conditions.MarkFalse(app, meta.ReadyCondition, appsv1.InvalidValuesTemplateReason, "%s", err)
+		case errors.Is(err, errInvalidValuesPatches):
+			conditions.MarkFalse(app, meta.ReadyCondition, appsv1.InvalidValuesPatchesReason, "%s", err)
+		}
+		return err
+	}
+	// Catch custom resource values the API server would reject before Helm installs them
+	if err := validateCustomResourceValues(ctx, r, app, values); err != nil {
+		if errors.Is(err, errInvalidCustomResourceValues) {
+			conditions.MarkFalse(app, meta.ReadyCondition, appsv1.InvalidCustomResourceValuesReason, "%s", err)
+		}
+		return err
+	}
 	targetNS := app.Spec.TargetNamespace
 	if targetNS == "" {
 		targetNS = app.Namespace
 	}
+	// Label & annotate the target namespace, creating it if needed
+	if err := handleTargetNamespace(ctx, r, app, targetNS); err != nil {
+		return err
+	}
+	// If the HelmRelease can't create the target namespace, make sure it exists
+	// rather than creating a HelmRelease that will repeatedly fail
+	// The namespace of a remote cluster can't be checked from here
+	if !app.Spec.GetCreateNamespace() && app.Spec.RemoteCluster == nil {
+		if err := r.Get(ctx, types.NamespacedName{Name: targetNS}, &corev1.Namespace{}); err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			conditions.MarkFalse(app, meta.ReadyCondition, appsv1.MissingTargetNamespaceReason, "target namespace %s does not exist", targetNS)
+			setReconcileReason(ctx, reconcileReasonRequeueNamespace)
+			return errRequeue
+		}
+	}
+	// Check the state of any dependencies
+	dependsOn, waiting, err := checkDependencies(ctx, r, app)
+	if err != nil {
+		return err
+	}
+	// Generate the kubeconfig if the release is deployed to a remote cluster
+	kubeConfig, err := handleRemoteCluster(ctx, r, app)
+	if err != nil {
+		return err
+	}
+	// Copy the default values into the app namespace so the HelmRelease can reference them
+	defaultValuesRefs, err := handleDefaultValues(ctx, r, app)
+	if err != nil {
+		return err
+	}
+	// Order the inline values & valuesFrom by the values precedence
+	values, inlineValuesRefs, err := handleInlineValues(ctx, r, app, values)
+	if err != nil {
+		return err
+	}
+	// Get the HelmRelease managed resource
+	mr, err := r.ResourceManager.Get(ctx, app, helmv2.HelmReleaseKind)
+	if err != nil {
+		return err
+	}
+	helmRelease := mr.Object.(*helmv2.HelmRelease)
+	// Check a new chart version can be pulled before the HelmRelease uses it
+	if err := preflightPull(ctx, r, app, helmRelease); err != nil {
+		return err
+	}
+	// Pin the chart version to the digest its tag points to
+	chartRef, err := handleOCIRepository(ctx, r, app)
+	if err != nil {
+		return err
+	}
+	// Call the pre hooks before the HelmRelease is created or upgraded to a new chart version
+	released := releasing(app, helmRelease)
+	if err := runPreHooks(ctx, r, app, helmRelease); err != nil {
+		return err
+	}
+	// Keep the current values to report any changes once the HelmRelease is updated
+	currentValues := helmRelease.Spec.Values
+	// Update the spec
 	helmRelease.Spec = helmv2.HelmReleaseSpec{
 		Chart: &helmv2.HelmChartTemplate{
 			Spec: helmv2.HelmChartTemplateSpec{
-				Chart:   app.Status.Chart.Name,
-				Version: app.Status.Chart.Version,
-				SourceRef: helmv2.CrossNamespaceObjectReference{
-					Kind:      "HelmRepository",
-					Name:      r.ResourceManager.HelmRepositoryName(app),
-					Namespace: app.Namespace,
-				},
+				Chart:                    helmChartName(app),
+				Version:                  chartTag(app),
+				ReconcileStrategy:        reconcileStrategy(app),
+				ValuesFiles:              app.Spec.Chart.ValuesFiles,
+				IgnoreMissingValuesFiles: app.Spec.IgnoreMissingValuesFiles,
+				SourceRef:                chartSourceRef(r, app),
 			},
 		},
-		Interval:        metav1.Duration{Duration: 1 * time.Minute},
-		ReleaseName:     app.Name,
+		Interval:        metav1.Duration{Duration: r.childInterval(app, helmReleaseInterval(app).Duration)},
+		KubeConfig:      kubeConfig,
+		ReleaseName:     r.ResourceManager.HelmReleaseName(app),
 		TargetNamespace: targetNS,
+		DependsOn:       dependsOn,
+		Timeout:         r.releaseTimeout(app),
+		MaxHistory:      r.releaseMaxHistory(app),
 		DriftDetection: &helmv2.DriftDetection{
-			Mode: helmv2.DriftDetectionEnabled,
-			Ignore: []helmv2.IgnoreRule{
-				{
-					Paths: []string{"/spec/replicas"},
-				},
-			},
+			Mode:   driftDetectionMode(app),
+			Ignore: driftIgnore(app),
 		},
+		PostRenderers: driftExclusionPostRenderers(app),
 		Install: &helmv2.Install{
 			Replace:         true,
 			CRDs:            helmv2.CreateReplace,
-			CreateNamespace: true,
+			CreateNamespace: app.Spec.GetCreateNamespace(),
+			DisableWait:     app.Spec.DisableWait,
+			Remediation:     installRemediation(app),
 		},
 		Upgrade: &helmv2.Upgrade{
-			CRDs: helmv2.CreateReplace,
+			CRDs:          helmv2.CreateReplace,
+			DisableWait:   app.Spec.DisableWait,
+			Force:         app.Spec.ForceUpgrade,
+			CleanupOnFail: app.Spec.CleanupOnFail,
 		},
+		Values:     values,
+		ValuesFrom: append(append(defaultValuesRefs, inlineValuesRefs...), valuesRefs...),
+	}
+	// Deploy a pinned chart from the OCIRepository
+	pinChart(app, helmRelease, chartRef)
+	// Pass a new force request on to the HelmRelease
+	forceAt := pendingForceRequest(app)
+	if forceAt != "" {
+		requestForcedUpgrade(helmRelease, forceAt)
+	}
+	// Upgrade the release when a floating tag is moved to a new chart artifact
+	trackChartDigest(r, app, helmRelease)
+	// Catalog the app on the HelmRelease
+	setDescription(app, helmRelease)
+	// Make it clear forced upgrades are enabled as they can recreate resources
+	if app.Spec.ForceUpgrade {
+		conditions.MarkTrue(app, appsv1.ForceUpgradeCondition, appsv1.ForceUpgradeEnabledReason,
+			"upgrades are forced which can cause resources to be recreated")
+	} else {
+		conditions.Delete(app, appsv1.ForceUpgradeCondition)
 	}
+	// Add the chart version Helm last deployed to the app status
+	app.Status.Chart.AppliedVersion = appliedVersion(helmRelease)
+	recordHistory(app)
+	// Surface the resources failing the release so they're visible without inspecting the HelmRelease
+	app.Status.FailingResources = failingResources(helmRelease)
 	conditions.SetMirror(app, meta.ReadyCondition, helmRelease, conditions.WithFallbackValue(false, meta.ProgressingReason, "HelmRelease is not ready"))
-	return r.ResourceManager.Update(ctx, mr)
+	// The app isn't ready while any of the sources aren't ready, even if the HelmRelease is
+	aggregateReady(app)
+	exists := mr.patch != nil
+	changed, err := r.ResourceManager.Changed(mr)
+	if err != nil {
+		return err
+	}
+	if err := r.ResourceManager.Update(ctx, mr); err != nil {
+		return err
+	}
+	// The force request is only handled once the HelmRelease has it
+	if forceAt != "" {
+		app.Status.LastHandledForceAt = forceAt
+	}
+	switch {
+	case !exists:
+		setReconcileReason(ctx, reconcileReasonCreated)
+	case changed:
+		setReconcileReason(ctx, reconcileReasonUpdated)
+	default:
+		setReconcileReason(ctx, reconcileReasonUnchanged)
+	}
+	// Call the post hooks once the HelmRelease is created or upgraded to a new chart version
+	if err := runPostHooks(ctx, r, app, released); err != nil {
+		return err
+	}
+	// Report what changed in the values of an existing HelmRelease
+	if exists && changed && r.Recorder != nil {
+		diff, err := valuesDiff(currentValues, helmRelease.Spec.Values, valuesRefs)
+		if err != nil {
+			return err
+		}
+		if diff != "" {
+			r.Recorder.Eventf(app, corev1.EventTypeNormal, valuesChangedReason, "HelmRelease values changed: %s", diff)
+		}
+	}
+	// Handle the canary HelmRelease alongside the stable release
+	if err := handleCanary(ctx, r, app, &helmRelease.Spec); err != nil {
+		return err
+	}
+	// The HelmRelease handles the ordering of dependencies
+	// but make it clear in the app status why it isn't ready yet
+	if waiting != "" {
+		conditions.MarkFalse(app, meta.ReadyCondition, appsv1.WaitingForDependencyReason, "%s", waiting)
+		setReconcileReason(ctx, reconcileReasonRequeueDependency)
+		return errRequeue
+	}
+	return nil
+}
+
+// appliedVersion returns the chart version of the latest deployed release in the HelmRelease history
+func appliedVersion(hr *helmv2.HelmRelease) string {
+	var latest *helmv2.Snapshot
+	for _, s := range hr.Status.History {
+		if s == nil || s.Status != releaseStatusDeployed {
+			continue
+		}
+		if latest == nil || s.Version > latest.Version {
+			latest = s
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return latest.ChartVersion
+}
+
+// latestScannedVersion returns the highest SemVer tag found by the last ImageRepository scan
+// The scan only reports the most recent tags so this is the newest chart pushed to the repository
+// Only tags with the prefix are considered and the version is compared without it
+func latestScannedVersion(repo *imagev1.ImageRepository, prefix string) string {
+	if repo.Status.LastScanResult == nil {
+		return ""
+	}
+	var latest *semver.Version
+	var tag string
+	for _, t := range repo.Status.LastScanResult.LatestTags {
+		if !strings.HasPrefix(t, prefix) {
+			continue
+		}
+		v, err := semver.Parse(strings.TrimPrefix(t, prefix))
+		if err != nil {
+			continue
+		}
+		if latest == nil || v.GT(*latest) {
+			latest, tag = &v, t
+		}
+	}
+	return tag
+}
+
+// noMatchingVersion returns true if the ImagePolicy reports that no tags match the policy
+// The image-reflector-controller doesn't use a dedicated reason for this so the message is checked,
+// only if the condition is for the current policy spec
+func noMatchingVersion(policy *imagev1.ImagePolicy) bool {
+	ready := conditions.Get(policy, meta.ReadyCondition)
+	if ready == nil || ready.Status != metav1.ConditionFalse || ready.ObservedGeneration != policy.Generation {
+		return false
+	}
+	return strings.Contains(ready.Message, "unable to determine latest version")
+}
+
+// chartVersion validates the image reference resolved by the ImagePolicy and returns the chart version
+// We can't inspect the artifact media type without pulling it, but a Helm chart must be in the chart
+// repository and must be tagged with a valid SemVer version
+func chartVersion(app *appsv1.FluxApp, ref string) (string, error) {
+	parts := strings.Split(ref, ":")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("%w image reference: %s", errInvalid, ref)
+	}
+	if repo := strings.TrimPrefix(app.Spec.Chart.Repository, "oci://"); parts[0] != repo {
+		return "", fmt.Errorf("%w: %s is not in the chart repository %s", errNotAHelmChart, ref, repo)
+	}
+	tag := parts[1]
+	if prefix := app.Spec.Chart.TagPrefix; prefix != "" {
+		if !strings.HasPrefix(tag, prefix) {
+			return "", fmt.Errorf("%w: %s is not tagged with the prefix %s", errNotAHelmChart, ref, prefix)
+		}
+		tag = strings.TrimPrefix(tag, prefix)
+	}
+	if _, err := semver.Parse(tag); err != nil {
+		return "", fmt.Errorf("%w: %s is not tagged with a SemVer version: %w", errNotAHelmChart, ref, err)
+	}
+	return tag, nil
+}
+
+// shutdownGracePeriod returns how long the status patch may take once the reconcile is cancelled
+func (r *FluxAppReconciler) shutdownGracePeriod() time.Duration {
+	if r.ShutdownGracePeriod > 0 {
+		return r.ShutdownGracePeriod
+	}
+	return defaultShutdownGracePeriod
+}
+
+// patchStatus patches the app status from before, failing if the app was updated since
+// On a conflict, the status is applied to the latest app & the patch retried with a short backoff
+func (r *FluxAppReconciler) patchStatus(ctx context.Context, app, before *appsv1.FluxApp) error {
+	backoff := retry.DefaultRetry
+	backoff.Steps = defaultStatusPatchAttempts
+	if r.StatusPatchAttempts > 0 {
+		backoff.Steps = r.StatusPatchAttempts
+	}
+	attempt := 0
+	return retry.RetryOnConflict(backoff, func() error {
+		if attempt++; attempt > 1 {
+			latest := &appsv1.FluxApp{}
+			if err := r.Get(ctx, client.ObjectKeyFromObject(app), latest); err != nil {
+				return err
+			}
+			before = latest.DeepCopy()
+			latest.Status = app.Status
+			*app = *latest
+		}
+		return r.Status().Patch(ctx, app, client.MergeFromWithOptions(before, client.MergeFromWithOptimisticLock{}))
+	})
+}
+
+// mergeLabels adds the labels to the object, keeping any existing labels
+func mergeLabels(obj client.Object, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	merged := obj.GetLabels()
+	if merged == nil {
+		merged = make(map[string]string, len(labels))
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	obj.SetLabels(merged)
+}
+
+// mergeAnnotations adds the annotations to the object, keeping any existing annotations
+func mergeAnnotations(obj client.Object, annotations map[string]string) {
+	if len(annotations) == 0 {
+		return
+	}
+	merged := obj.GetAnnotations()
+	if merged == nil {
+		merged = make(map[string]string, len(annotations))
+	}
+	for k, v := range annotations {
+		merged[k] = v
+	}
+	obj.SetAnnotations(merged)
+}
+
+// chartTag returns the tag of the chart version of the app, which is prefixed with the tag prefix if it's set
+func chartTag(app *appsv1.FluxApp) string {
+	return app.Spec.Chart.TagPrefix + app.Status.Chart.Version
+}
+
+// reconcileStrategy returns the chart reconcile strategy for the app, defaulting to ChartVersion
+func reconcileStrategy(app *appsv1.FluxApp) string {
+	if app.Spec.Chart.ReconcileStrategy != "" {
+		return app.Spec.Chart.ReconcileStrategy
+	}
+	return sourcev1.ReconcileStrategyChartVersion
+}
+
+// helmReleaseInterval returns the HelmRelease interval for the app, defaulting to 1m
+func helmReleaseInterval(app *appsv1.FluxApp) metav1.Duration {
+	if app.Spec.Interval != nil {
+		return *app.Spec.Interval
+	}
+	return metav1.Duration{Duration: 1 * time.Minute}
+}
+
+// scanInterval returns the ImageRepository interval for the app, defaulting to 1m
+// This is independent of the HelmRelease interval
+func scanInterval(app *appsv1.FluxApp) metav1.Duration {
+	if app.Spec.Chart.ScanInterval != nil {
+		return *app.Spec.Chart.ScanInterval
+	}
+	return metav1.Duration{Duration: 1 * time.Minute}
+}
+
+// releaseTimeout returns the Helm timeout for the app, defaulting to the controller default
+// or nil to leave the HelmRelease default if neither is set
+func (r *FluxAppReconciler) releaseTimeout(app *appsv1.FluxApp) *metav1.Duration {
+	if app.Spec.Timeout != nil {
+		return &metav1.Duration{Duration: app.Spec.Timeout.Duration}
+	}
+	if r.DefaultTimeout > 0 {
+		return &metav1.Duration{Duration: r.DefaultTimeout}
+	}
+	return nil
+}
+
+// releaseMaxHistory returns how many release revisions are kept for the app, defaulting to the controller default
+// or nil to leave the HelmRelease default if neither is set
+// An app can set 0 to keep every revision
+func (r *FluxAppReconciler) releaseMaxHistory(app *appsv1.FluxApp) *int {
+	if app.Spec.MaxHistory != nil {
+		maxHistory := *app.Spec.MaxHistory
+		return &maxHistory
+	}
+	if r.DefaultMaxHistory > 0 {
+		maxHistory := r.DefaultMaxHistory
+		return &maxHistory
+	}
+	return nil
+}
+
+// driftDetectionMode returns the HelmRelease drift detection mode for the app, defaulting to enabled
+// The drift detection annotation disables it without editing the spec e.g. during a manual hotfix,
+// and the spec applies again once the annotation is removed
+func driftDetectionMode(app *appsv1.FluxApp) helmv2.DriftDetectionMode {
+	if app.GetAnnotations()[driftDetectionAnnotation] == string(helmv2.DriftDetectionDisabled) {
+		return helmv2.DriftDetectionDisabled
+	}
+	if app.Spec.DriftDetection != "" {
+		return app.Spec.DriftDetection
+	}
+	return helmv2.DriftDetectionEnabled
+}
+
+// driftIgnore returns the drift detection ignore rules for the app
+// defaulting to ignoring replicas so autoscaled workloads aren't reported as drifted
+func driftIgnore(app *appsv1.FluxApp) []helmv2.IgnoreRule {
+	if len(app.Spec.DriftIgnore) > 0 {
+		rules := make([]helmv2.IgnoreRule, len(app.Spec.DriftIgnore))
+		for i := range app.Spec.DriftIgnore {
+			app.Spec.DriftIgnore[i].DeepCopyInto(&rules[i])
+		}
+		return rules
+	}
+	return []helmv2.IgnoreRule{
+		{
+			Paths: []string{"/spec/replicas"},
+		},
+	}
+}
+
+// installRemediation returns the HelmRelease install remediation for the app
+// With cleanupOnFail a failed install is uninstalled so it doesn't leave partial resources behind
+func installRemediation(app *appsv1.FluxApp) *helmv2.InstallRemediation {
+	if !app.Spec.CleanupOnFail {
+		return nil
+	}
+	return &helmv2.InstallRemediation{RemediateLastFailure: ptr.To(true)}
+}
+
+// chartProvider returns the provider set on the app chart, falling back to detecting it from the URL
+func chartProvider(app *appsv1.FluxApp, s string) (string, error) {
+	if app.Spec.Chart.Provider != "" {
+		return app.Spec.Chart.Provider, nil
+	}
+	return providerFromURL(s)
 }
 
 func providerFromURL(s string) (string, error) {
@@ -314,14 +1163,45 @@ func providerFromURL(s string) (string, error) {
 	return provider, nil
 }
 
+// appChanged filters the app events to those changing the spec, annotations or labels
+// The controller patches the status of the app every reconcile, so reconciling on status changes
+// would reconcile the app again straight away. The labels decide the shard of the app
+var appChanged = predicate.Or(
+	predicate.GenerationChangedPredicate{},
+	predicate.AnnotationChangedPredicate{},
+	predicate.LabelChangedPredicate{},
+)
+
 // SetupWithManager sets up the controller with the Manager.
-func (r *FluxAppReconciler) SetupWithManager(mgr ctrl.Manager) error {
+// The options are used to tune the controller e.g. MaxConcurrentReconciles
+func (r *FluxAppReconciler) SetupWithManager(mgr ctrl.Manager, opts controller.Options) error {
+	if r.ChartCache != nil {
+		if err := mgr.Add(manager.RunnableFunc(r.WarmChartCache)); err != nil {
+			return err
+		}
+	}
+	// The children applied with other controller defaults are resynced
+	r.ResourceManager.SetControllerDefaults(r.controllerDefaults())
+	r.resync = make(chan event.GenericEvent)
+	if err := mgr.Add(manager.RunnableFunc(r.ResyncChildren)); err != nil {
+		return err
+	}
+	// Children without a controller reference are only watched if every owner is matched
+	var ownsOpts []builder.OwnsOption
+	if r.ResourceManager.OwnerReferenceMode() == NonControllerOwnerReferenceMode {
+		ownsOpts = append(ownsOpts, builder.MatchEveryOwner)
+	}
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&appsv1.FluxApp{}).
-		Owns(&helmv2.HelmRelease{}).
-		Owns(&imagev1.ImagePolicy{}).
-		Owns(&imagev1.ImageRepository{}).
-		Owns(&sourcev1.HelmRepository{}).
-		Named("fluxapp").
+		For(&appsv1.FluxApp{}, builder.WithPredicates(predicate.NewPredicateFuncs(r.inShard), appChanged)).
+		Watches(&appsv1.FluxAppTemplate{}, handler.EnqueueRequestsFromMapFunc(r.appsForTemplate)).
+		WatchesRawSource(source.Channel(r.resync, &handler.EnqueueRequestForObject{})).
+		Owns(&helmv2.HelmRelease{}, ownsOpts...).
+		Owns(&imagev1.ImagePolicy{}, ownsOpts...).
+		Owns(&imagev1.ImageRepository{}, ownsOpts...).
+		Owns(&sourcev1.HelmRepository{}, ownsOpts...).
+		Owns(&sourcev1.GitRepository{}, ownsOpts...).
+		Owns(&sourcev1beta2.OCIRepository{}, ownsOpts...).
+		Named(ControllerName).
+		WithOptions(opts).
 		Complete(r)
 }
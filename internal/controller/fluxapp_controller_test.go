@@ -18,18 +18,106 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"time"
 
+	"github.com/fluxcd/pkg/apis/acl"
+	"github.com/fluxcd/pkg/apis/kustomize"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	"github.com/go-logr/logr"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/config"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
 )
 
+// newTestScheme returns a scheme with all the types managed by the controller
+func newTestScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(s)).To(Succeed())
+	Expect(apiextensionsv1.AddToScheme(s)).To(Succeed())
+	Expect(appsv1.AddToScheme(s)).To(Succeed())
+	Expect(helmv2.AddToScheme(s)).To(Succeed())
+	Expect(imagev1.AddToScheme(s)).To(Succeed())
+	Expect(sourcev1.AddToScheme(s)).To(Succeed())
+	Expect(sourcev1beta2.AddToScheme(s)).To(Succeed())
+	return s
+}
+
+// newTestReconciler returns a reconciler backed by a fake client
+// The envtest environment doesn't include the Flux CRDs so the handlers are tested against a fake client
+func newTestReconciler(objs ...client.Object) *FluxAppReconciler {
+	s := newTestScheme()
+	c := fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&appsv1.FluxApp{}).
+		Build()
+	return &FluxAppReconciler{
+		Client:          c,
+		Scheme:          s,
+		ResourceManager: NewResourceManager(c, s, ControllerOwnerReferenceMode),
+	}
+}
+
+// newTestApp returns a FluxApp with the chart status already resolved
+func newTestApp() *appsv1.FluxApp {
+	return &appsv1.FluxApp{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "podinfo",
+			Namespace: "default",
+			UID:       "test-uid",
+		},
+		Spec: appsv1.FluxAppSpec{
+			Chart: appsv1.Chart{
+				Repository: "oci://ghcr.io/stefanprodan/charts/podinfo",
+				Version:    "*",
+			},
+		},
+		Status: appsv1.FluxAppStatus{
+			Chart: appsv1.ChartStatus{
+				Repository: "oci://ghcr.io/stefanprodan/charts",
+				Name:       "podinfo",
+				Version:    "6.5.3",
+			},
+		},
+	}
+}
+
+// getHelmRelease fetches the HelmRelease generated for the app
+func getHelmRelease(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) (*helmv2.HelmRelease, error) {
+	hr := &helmv2.HelmRelease{}
+	key := types.NamespacedName{Name: r.ResourceManager.HelmReleaseName(app), Namespace: app.Namespace}
+	return hr, r.Get(ctx, key, hr)
+}
+
 var _ = Describe("FluxApp Controller", func() {
 	Context("When reconciling a resource", func() {
 		const resourceName = "test-resource"
@@ -82,3 +170,1035 @@ var _ = Describe("FluxApp Controller", func() {
 		})
 	})
 })
+
+var _ = Describe("HelmRelease", func() {
+	ctx := context.Background()
+
+	Context("when CreateNamespace is disabled", func() {
+		var app *appsv1.FluxApp
+
+		BeforeEach(func() {
+			app = newTestApp()
+			app.Spec.TargetNamespace = "podinfo"
+			app.Spec.CreateNamespace = new(bool)
+		})
+
+		It("should requeue without a HelmRelease when the target namespace is missing", func() {
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(MatchError(errRequeue))
+			Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+			Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.MissingTargetNamespaceReason))
+			_, err := getHelmRelease(ctx, r, app)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("should create the HelmRelease when the target namespace exists", func() {
+			r := newTestReconciler(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "podinfo"}})
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			Expect(conditions.GetReason(app, meta.ReadyCondition)).NotTo(Equal(appsv1.MissingTargetNamespaceReason))
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Install.CreateNamespace).To(BeFalse())
+		})
+	})
+
+	It("should propagate decrypted secret values references", func() {
+		app := newTestApp()
+		app.Spec.ValuesFrom = []helmv2.ValuesReference{
+			{Kind: "ConfigMap", Name: "podinfo-values"},
+			{Kind: "Secret", Name: "podinfo-secrets", ValuesKey: "password", TargetPath: "auth.password"},
+		}
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.ValuesFrom).To(Equal([]helmv2.ValuesReference{
+			{Kind: "ConfigMap", Name: "podinfo-values", ValuesKey: "values.yaml"},
+			{Kind: "Secret", Name: "podinfo-secrets", ValuesKey: "password", TargetPath: "auth.password"},
+		}))
+	})
+
+	It("should propagate optional & required values references", func() {
+		app := newTestApp()
+		app.Spec.ValuesFrom = []helmv2.ValuesReference{
+			{Kind: "ConfigMap", Name: "podinfo-values"},
+			{Kind: "ConfigMap", Name: "podinfo-overrides", Optional: true},
+			{Kind: "Secret", Name: "podinfo-secrets", ValuesKey: "password", TargetPath: "auth.password", Optional: true},
+		}
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.ValuesFrom).To(Equal([]helmv2.ValuesReference{
+			{Kind: "ConfigMap", Name: "podinfo-values", ValuesKey: "values.yaml"},
+			{Kind: "ConfigMap", Name: "podinfo-overrides", ValuesKey: "values.yaml", Optional: true},
+			{Kind: "Secret", Name: "podinfo-secrets", ValuesKey: "password", TargetPath: "auth.password", Optional: true},
+		}))
+	})
+
+	It("should report the applied version separately from the selected version during rollout", func() {
+		app := newTestApp()
+		app.Status.Chart.Version = "6.6.0"
+		hr := &helmv2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{Name: app.Name, Namespace: app.Namespace},
+			Status: helmv2.HelmReleaseStatus{
+				History: helmv2.Snapshots{
+					{Version: 2, ChartName: "podinfo", ChartVersion: "6.6.0", Status: "pending-upgrade"},
+					{Version: 1, ChartName: "podinfo", ChartVersion: "6.5.3", Status: "deployed"},
+				},
+			},
+		}
+		r := newTestReconciler(hr)
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(app.Status.Chart.Version).To(Equal("6.6.0"))
+		Expect(app.Status.Chart.AppliedVersion).To(Equal("6.5.3"))
+	})
+
+	It("should not report an applied version before the first install", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(app.Status.Chart.AppliedVersion).To(BeEmpty())
+	})
+
+	DescribeTable("reconcile strategy",
+		func(strategy string, expected string) {
+			app := newTestApp()
+			app.Spec.Chart.ReconcileStrategy = strategy
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Chart.Spec.ReconcileStrategy).To(Equal(expected))
+		},
+		Entry("defaults to ChartVersion", "", sourcev1.ReconcileStrategyChartVersion),
+		Entry("ChartVersion", sourcev1.ReconcileStrategyChartVersion, sourcev1.ReconcileStrategyChartVersion),
+		Entry("Revision", sourcev1.ReconcileStrategyRevision, sourcev1.ReconcileStrategyRevision),
+	)
+
+	Context("unchanged HelmRelease", func() {
+		// newPatchCountingReconciler returns a reconciler counting the HelmRelease patches
+		newPatchCountingReconciler := func() (*FluxAppReconciler, *int) {
+			patches := 0
+			c := fake.NewClientBuilder().
+				WithScheme(newTestScheme()).
+				WithInterceptorFuncs(interceptor.Funcs{
+					Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+						if _, ok := obj.(*helmv2.HelmRelease); ok {
+							patches++
+						}
+						return c.Patch(ctx, obj, patch, opts...)
+					},
+				}).
+				Build()
+			r := &FluxAppReconciler{Client: c, Scheme: c.Scheme(), ResourceManager: NewResourceManager(c, c.Scheme(), ControllerOwnerReferenceMode)}
+			return r, &patches
+		}
+
+		It("should not patch the HelmRelease when nothing changed", func() {
+			app := newTestApp()
+			app.Spec.Values = &apiextensionsv1.JSON{Raw: []byte(`{"replicaCount":2}`)}
+			r, patches := newPatchCountingReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			Expect(*patches).To(BeZero())
+
+			reasonCtx, reason := withReconcileReason(ctx)
+			Expect(handleHelmRelease(reasonCtx, r, app)).To(Succeed())
+			Expect(*patches).To(BeZero())
+			Expect(*reason).To(Equal(reconcileReasonUnchanged))
+		})
+
+		DescribeTable("should patch the HelmRelease when the version or values change",
+			func(change func(app *appsv1.FluxApp)) {
+				app := newTestApp()
+				r, patches := newPatchCountingReconciler()
+				Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+				change(app)
+				reasonCtx, reason := withReconcileReason(ctx)
+				Expect(handleHelmRelease(reasonCtx, r, app)).To(Succeed())
+				Expect(*patches).To(Equal(1))
+				Expect(*reason).To(Equal(reconcileReasonUpdated))
+			},
+			Entry("version", func(app *appsv1.FluxApp) { app.Status.Chart.Version = "6.6.0" }),
+			Entry("values", func(app *appsv1.FluxApp) {
+				app.Spec.Values = &apiextensionsv1.JSON{Raw: []byte(`{"replicaCount":3}`)}
+			}),
+		)
+	})
+
+	It("should set the values files on the HelmRelease chart", func() {
+		app := newTestApp()
+		app.Spec.Chart.ValuesFiles = []string{"values.yaml", "values-production.yaml"}
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Chart.Spec.ValuesFiles).To(Equal([]string{"values.yaml", "values-production.yaml"}))
+
+		// Removing the values files goes back to the chart values.yaml
+		app.Spec.Chart.ValuesFiles = nil
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err = getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Chart.Spec.ValuesFiles).To(BeEmpty())
+	})
+
+	It("should create the namespace by default", func() {
+		app := newTestApp()
+		app.Spec.TargetNamespace = "podinfo"
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Install.CreateNamespace).To(BeTrue())
+	})
+
+	Context("releaseName", func() {
+		It("should default to the app name", func() {
+			app := newTestApp()
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Name).To(Equal("podinfo"))
+			Expect(hr.Spec.ReleaseName).To(Equal("podinfo"))
+		})
+
+		It("should override the HelmRelease and Helm release names", func() {
+			app := newTestApp()
+			app.Spec.ReleaseName = "legacy-podinfo"
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr := &helmv2.HelmRelease{}
+			Expect(r.Get(ctx, types.NamespacedName{Name: "legacy-podinfo", Namespace: app.Namespace}, hr)).To(Succeed())
+			Expect(hr.Spec.ReleaseName).To(Equal("legacy-podinfo"))
+			_, err := getHelmRelease(ctx, r, &appsv1.FluxApp{ObjectMeta: app.ObjectMeta})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Context("drift detection annotation", func() {
+		It("should disable drift detection while the annotation is set", func() {
+			app := newTestApp()
+			app.Spec.DriftDetection = helmv2.DriftDetectionWarn
+			app.Annotations = map[string]string{driftDetectionAnnotation: "disabled"}
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.DriftDetection.Mode).To(Equal(helmv2.DriftDetectionDisabled))
+		})
+
+		It("should reset to the spec when the annotation is removed", func() {
+			app := newTestApp()
+			app.Spec.DriftDetection = helmv2.DriftDetectionWarn
+			app.Annotations = map[string]string{driftDetectionAnnotation: "disabled"}
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			delete(app.Annotations, driftDetectionAnnotation)
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.DriftDetection.Mode).To(Equal(helmv2.DriftDetectionWarn))
+		})
+
+		It("should ignore other values", func() {
+			app := newTestApp()
+			app.Annotations = map[string]string{driftDetectionAnnotation: "off"}
+			Expect(driftDetectionMode(app)).To(Equal(helmv2.DriftDetectionEnabled))
+		})
+	})
+
+	Context("driftIgnore", func() {
+		It("should ignore replicas by default", func() {
+			app := newTestApp()
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.DriftDetection.Ignore).To(Equal([]helmv2.IgnoreRule{{Paths: []string{"/spec/replicas"}}}))
+		})
+
+		It("should replace the default rule", func() {
+			app := newTestApp()
+			app.Spec.DriftIgnore = []helmv2.IgnoreRule{
+				{
+					Paths:  []string{"/spec/template/spec/containers/0/resources"},
+					Target: &kustomize.Selector{Kind: "Deployment", Name: "podinfo"},
+				},
+			}
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.DriftDetection.Ignore).To(Equal(app.Spec.DriftIgnore))
+		})
+	})
+
+	DescribeTable("disableWait",
+		func(disableWait bool) {
+			app := newTestApp()
+			app.Spec.DisableWait = disableWait
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Install.DisableWait).To(Equal(disableWait))
+			Expect(hr.Spec.Upgrade.DisableWait).To(Equal(disableWait))
+		},
+		Entry("defaults to waiting", false),
+		Entry("disables waiting", true),
+	)
+
+	Context("timeout & maxHistory", func() {
+		It("should leave the HelmRelease defaults unless set", func() {
+			app := newTestApp()
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Timeout).To(BeNil())
+			Expect(hr.Spec.MaxHistory).To(BeNil())
+		})
+
+		It("should apply the controller defaults", func() {
+			app := newTestApp()
+			r := newTestReconciler()
+			r.DefaultTimeout = 10 * time.Minute
+			r.DefaultMaxHistory = 3
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Timeout).To(Equal(&metav1.Duration{Duration: 10 * time.Minute}))
+			Expect(hr.Spec.MaxHistory).To(Equal(ptr.To(3)))
+		})
+
+		It("should prefer the app settings to the controller defaults", func() {
+			app := newTestApp()
+			app.Spec.Timeout = &metav1.Duration{Duration: 15 * time.Minute}
+			// Keep every revision
+			app.Spec.MaxHistory = ptr.To(0)
+			r := newTestReconciler()
+			r.DefaultTimeout = 10 * time.Minute
+			r.DefaultMaxHistory = 3
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Timeout).To(Equal(&metav1.Duration{Duration: 15 * time.Minute}))
+			Expect(hr.Spec.MaxHistory).To(Equal(ptr.To(0)))
+		})
+	})
+
+	Context("forceUpgrade", func() {
+		It("should not force upgrades by default", func() {
+			app := newTestApp()
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Upgrade.Force).To(BeFalse())
+			Expect(conditions.Has(app, appsv1.ForceUpgradeCondition)).To(BeFalse())
+		})
+
+		It("should force upgrades and warn in the conditions", func() {
+			app := newTestApp()
+			app.Spec.ForceUpgrade = true
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Upgrade.Force).To(BeTrue())
+			Expect(conditions.IsTrue(app, appsv1.ForceUpgradeCondition)).To(BeTrue())
+			Expect(conditions.GetReason(app, appsv1.ForceUpgradeCondition)).To(Equal(appsv1.ForceUpgradeEnabledReason))
+		})
+
+		It("should remove the warning when disabled", func() {
+			app := newTestApp()
+			app.Spec.ForceUpgrade = true
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			app.Spec.ForceUpgrade = false
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Upgrade.Force).To(BeFalse())
+			Expect(conditions.Has(app, appsv1.ForceUpgradeCondition)).To(BeFalse())
+		})
+	})
+
+	Context("cleanupOnFail", func() {
+		It("should not clean up failed releases by default", func() {
+			app := newTestApp()
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Install.Remediation).To(BeNil())
+			Expect(hr.Spec.Upgrade.CleanupOnFail).To(BeFalse())
+		})
+
+		It("should clean up failed installs & upgrades", func() {
+			app := newTestApp()
+			app.Spec.CleanupOnFail = true
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Install.Remediation).NotTo(BeNil())
+			Expect(hr.Spec.Install.Remediation.MustRemediateLastFailure()).To(BeTrue())
+			Expect(hr.Spec.Upgrade.CleanupOnFail).To(BeTrue())
+		})
+	})
+
+	DescribeTable("ignoreMissingValuesFiles",
+		func(ignore bool) {
+			app := newTestApp()
+			app.Spec.IgnoreMissingValuesFiles = ignore
+			app.Spec.ValuesFrom = []helmv2.ValuesReference{{Kind: "ConfigMap", Name: "podinfo-values"}}
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Chart.Spec.IgnoreMissingValuesFiles).To(Equal(ignore))
+			Expect(hr.Spec.ValuesFrom[0].Optional).To(Equal(ignore))
+		},
+		Entry("defaults to strict", false),
+		Entry("ignores missing values", true),
+	)
+})
+
+var _ = Describe("ImagePolicy", func() {
+	ctx := context.Background()
+
+	DescribeTable("chartVersion",
+		func(ref string, expected string, expectedErr error) {
+			version, err := chartVersion(newTestApp(), ref)
+			if expectedErr != nil {
+				Expect(err).To(MatchError(expectedErr))
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal(expected))
+		},
+		Entry("chart version", "ghcr.io/stefanprodan/charts/podinfo:6.5.3", "6.5.3", nil),
+		Entry("malformed reference", "ghcr.io/stefanprodan/charts/podinfo", "", errInvalid),
+		Entry("artifact outside the chart repository", "ghcr.io/stefanprodan/podinfo:6.5.3", "", errNotAHelmChart),
+		Entry("artifact without a SemVer tag", "ghcr.io/stefanprodan/charts/podinfo:6.5", "", errNotAHelmChart),
+	)
+
+	It("should report a version range that matches no chart versions", func() {
+		app := newTestApp()
+		app.Spec.Chart.Version = "~> 99"
+		app.Status.Chart.Version = ""
+		policy := &imagev1.ImagePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace},
+		}
+		conditions.MarkFalse(policy, meta.ReadyCondition, meta.ReconciliationFailedReason,
+			"cannot determine latest tag for policy: unable to determine latest version from provided list")
+		r := newTestReconciler(policy)
+		Expect(handleImagePolicy(ctx, r, app)).To(MatchError(errRequeue))
+		Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.NoMatchingVersionReason))
+		Expect(conditions.GetMessage(app, meta.ReadyCondition)).To(ContainSubstring(`"~> 99"`))
+		Expect(app.Status.Chart.Version).To(BeEmpty())
+	})
+
+	It("should ignore a failure reported for a previous policy spec", func() {
+		app := newTestApp()
+		policy := &imagev1.ImagePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace, Generation: 2},
+			Status:     imagev1.ImagePolicyStatus{LatestImage: "ghcr.io/stefanprodan/charts/podinfo:6.5.3"},
+		}
+		policy.Status.Conditions = []metav1.Condition{{
+			Type:               meta.ReadyCondition,
+			Status:             metav1.ConditionFalse,
+			Reason:             meta.ReconciliationFailedReason,
+			Message:            "unable to determine latest version from provided list",
+			ObservedGeneration: 1,
+		}}
+		r := newTestReconciler(policy)
+		Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+		Expect(app.Status.Chart.Version).To(Equal("6.5.3"))
+	})
+
+	It("should report a non chart artifact", func() {
+		app := newTestApp()
+		app.Status.Chart.Version = ""
+		policy := &imagev1.ImagePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace},
+			Status:     imagev1.ImagePolicyStatus{LatestImage: "ghcr.io/stefanprodan/charts/podinfo:v6"},
+		}
+		r := newTestReconciler(policy)
+		err := handleImagePolicy(ctx, r, app)
+		Expect(err).To(MatchError(errNotAHelmChart))
+		Expect(classifyError(err)).To(Equal(appsv1.ErrorTypePermanent))
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.NotAHelmChartReason))
+		Expect(app.Status.Chart.Version).To(BeEmpty())
+	})
+
+	Context("tagPrefix", func() {
+		getImagePolicy := func(r *FluxAppReconciler, app *appsv1.FluxApp) *imagev1.ImagePolicy {
+			policy := &imagev1.ImagePolicy{}
+			key := types.NamespacedName{Name: r.ResourceManager.ImagePolicyName(app), Namespace: app.Namespace}
+			Expect(r.Get(ctx, key, policy)).To(Succeed())
+			return policy
+		}
+
+		It("should exclude tags on the ImageRepository", func() {
+			app := newTestApp()
+			r := newTestReconciler()
+			Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+			repo := &imagev1.ImageRepository{}
+			key := types.NamespacedName{Name: r.ResourceManager.ImageRepositoryName(app), Namespace: app.Namespace}
+			Expect(r.Get(ctx, key, repo)).To(Succeed())
+			// The image-reflector-controller default applies
+			Expect(repo.Spec.ExclusionList).To(BeNil())
+
+			app.Spec.Chart.ExclusionList = []string{`^6\.4\.`, "-rc"}
+			Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+			Expect(r.Get(ctx, key, repo)).To(Succeed())
+			Expect(repo.Spec.ExclusionList).To(Equal([]string{`^.*\.sig$`, `^6\.4\.`, "-rc"}))
+		})
+
+		It("should not filter tags by default", func() {
+			app := newTestApp()
+			r := newTestReconciler()
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			Expect(getImagePolicy(r, app).Spec.FilterTags).To(BeNil())
+		})
+
+		It("should extract the version from prefixed tags", func() {
+			app := newTestApp()
+			app.Spec.Chart.TagPrefix = "chart-"
+			r := newTestReconciler()
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			filter := getImagePolicy(r, app).Spec.FilterTags
+			Expect(filter).NotTo(BeNil())
+			// Apply the filter the same way as the image-reflector-controller
+			pattern := regexp.MustCompile(filter.Pattern)
+			Expect(pattern.MatchString("chart-1.2.3")).To(BeTrue())
+			Expect(pattern.ReplaceAllString("chart-1.2.3", filter.Extract)).To(Equal("1.2.3"))
+			Expect(pattern.MatchString("1.2.3")).To(BeFalse())
+			Expect(pattern.MatchString("chartx1.2.3")).To(BeFalse())
+		})
+
+		It("should resolve the chart version from a prefixed tag", func() {
+			app := newTestApp()
+			app.Spec.Chart.TagPrefix = "chart-"
+			app.Status.Chart.Version = ""
+			policy := &imagev1.ImagePolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace},
+				Status:     imagev1.ImagePolicyStatus{LatestImage: "ghcr.io/stefanprodan/charts/podinfo:chart-6.5.3"},
+			}
+			r := newTestReconciler(policy)
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			Expect(app.Status.Chart.Version).To(Equal("6.5.3"))
+		})
+
+		It("should deploy the prefixed tag of the chart version", func() {
+			app := newTestApp()
+			app.Spec.Chart.TagPrefix = "chart-"
+			r := newTestReconciler()
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			// The HelmRepository pulls the chart by its tag
+			Expect(hr.Spec.Chart.Spec.Version).To(Equal("chart-6.5.3"))
+			// The deployed tag is the current chart version so the release isn't upgraded again
+			Expect(releasing(app, hr)).To(BeFalse())
+		})
+
+		It("should reject a tag without the prefix", func() {
+			app := newTestApp()
+			app.Spec.Chart.TagPrefix = "chart-"
+			_, err := chartVersion(app, "ghcr.io/stefanprodan/charts/podinfo:6.5.3")
+			Expect(err).To(MatchError(errNotAHelmChart))
+		})
+
+		It("should report the latest prefixed tag found by the scan", func() {
+			app := newTestApp()
+			app.Spec.Chart.TagPrefix = "chart-"
+			existing := &imagev1.ImageRepository{
+				ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace},
+				Status: imagev1.ImageRepositoryStatus{
+					LastScanResult: &imagev1.ScanResult{
+						LatestTags: []string{"7.0.0", "chart-6.9.0", "chart-6.10.0", "app-8.0.0"},
+					},
+				},
+			}
+			r := newTestReconciler(existing)
+			Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+			Expect(app.Status.Chart.SourceRevision).To(Equal("chart-6.10.0"))
+		})
+	})
+
+	Context("pauseVersionUpdates", func() {
+		newPolicy := func(app *appsv1.FluxApp, tag string) *imagev1.ImagePolicy {
+			return &imagev1.ImagePolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace},
+				Status:     imagev1.ImagePolicyStatus{LatestImage: "ghcr.io/stefanprodan/charts/podinfo:" + tag},
+			}
+		}
+
+		It("should keep the chart version while the HelmRelease still updates", func() {
+			app := newTestApp()
+			app.Spec.PauseVersionUpdates = true
+			r := newTestReconciler(newPolicy(app, "6.6.0"))
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			Expect(app.Status.Chart.Version).To(Equal("6.5.3"))
+			Expect(conditions.IsTrue(app, appsv1.VersionUpdatesPausedCondition)).To(BeTrue())
+			Expect(conditions.GetMessage(app, appsv1.VersionUpdatesPausedCondition)).To(ContainSubstring("6.6.0"))
+			// Other changes are still applied to the HelmRelease
+			app.Spec.Interval = &metav1.Duration{Duration: 5 * time.Minute}
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Chart.Spec.Version).To(Equal("6.5.3"))
+			Expect(hr.Spec.Interval.Duration).To(Equal(5 * time.Minute))
+			Expect(hr.Spec.Suspend).To(BeFalse())
+		})
+
+		It("should resolve the first version while paused", func() {
+			app := newTestApp()
+			app.Spec.PauseVersionUpdates = true
+			app.Status.Chart.Version = ""
+			r := newTestReconciler(newPolicy(app, "6.6.0"))
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			Expect(app.Status.Chart.Version).To(Equal("6.6.0"))
+		})
+
+		It("should update the version once unpaused", func() {
+			app := newTestApp()
+			app.Spec.PauseVersionUpdates = true
+			r := newTestReconciler(newPolicy(app, "6.6.0"))
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			app.Spec.PauseVersionUpdates = false
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			Expect(app.Status.Chart.Version).To(Equal("6.6.0"))
+			Expect(conditions.Has(app, appsv1.VersionUpdatesPausedCondition)).To(BeFalse())
+		})
+
+		It("should update the available version while the chart version is kept", func() {
+			app := newTestApp()
+			app.Spec.PauseVersionUpdates = true
+			policy := newPolicy(app, "6.6.0")
+			r := newTestReconciler(policy)
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			Expect(app.Status.Chart.AvailableVersion).To(Equal("6.6.0"))
+			Expect(app.Status.Chart.Version).To(Equal("6.5.3"))
+			// A later scan finds a newer version
+			Expect(r.Get(ctx, client.ObjectKeyFromObject(policy), policy)).To(Succeed())
+			policy.Status.LatestImage = "ghcr.io/stefanprodan/charts/podinfo:6.7.0"
+			Expect(r.Update(ctx, policy)).To(Succeed())
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			Expect(app.Status.Chart.AvailableVersion).To(Equal("6.7.0"))
+			Expect(app.Status.Chart.Version).To(Equal("6.5.3"))
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Chart.Spec.Version).To(Equal("6.5.3"))
+		})
+
+		It("should set the available version to the chart version when not paused", func() {
+			app := newTestApp()
+			r := newTestReconciler(newPolicy(app, "6.6.0"))
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			Expect(app.Status.Chart.AvailableVersion).To(Equal("6.6.0"))
+			Expect(app.Status.Chart.Version).To(Equal("6.6.0"))
+		})
+	})
+
+	Context("suspendImageAutomation", func() {
+		newPolicy := func(app *appsv1.FluxApp, tag string) *imagev1.ImagePolicy {
+			return &imagev1.ImagePolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace},
+				Status:     imagev1.ImagePolicyStatus{LatestImage: "ghcr.io/stefanprodan/charts/podinfo:" + tag},
+			}
+		}
+
+		getImageRepository := func(r *FluxAppReconciler, app *appsv1.FluxApp) *imagev1.ImageRepository {
+			repo := &imagev1.ImageRepository{}
+			Expect(r.Get(ctx, client.ObjectKey{Name: "podinfo-chart", Namespace: app.Namespace}, repo)).To(Succeed())
+			return repo
+		}
+
+		It("should suspend the scan & keep the chart version while the HelmRelease still updates", func() {
+			app := newTestApp()
+			app.Spec.SuspendImageAutomation = true
+			r := newTestReconciler(newPolicy(app, "6.6.0"))
+			Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+			Expect(getImageRepository(r, app).Spec.Suspend).To(BeTrue())
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			Expect(app.Status.Chart.Version).To(Equal("6.5.3"))
+			Expect(conditions.IsTrue(app, appsv1.VersionUpdatesPausedCondition)).To(BeTrue())
+			Expect(conditions.GetReason(app, appsv1.VersionUpdatesPausedCondition)).To(Equal(appsv1.SuspendedImageAutomationReason))
+			app.Spec.Interval = &metav1.Duration{Duration: 5 * time.Minute}
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Chart.Spec.Version).To(Equal("6.5.3"))
+			Expect(hr.Spec.Interval.Duration).To(Equal(5 * time.Minute))
+			Expect(hr.Spec.Suspend).To(BeFalse())
+		})
+
+		It("should not list the chart tags to select the lowest version", func() {
+			app := newTestApp()
+			app.Spec.SuspendImageAutomation = true
+			app.Spec.Chart.VersionSelection = appsv1.VersionSelectionLowest
+			r := newTestReconciler(newPolicy(app, "6.6.0"))
+			r.ChartTags = &fakeChartTags{err: fmt.Errorf("registry unavailable")}
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			Expect(app.Status.Chart.Version).To(Equal("6.5.3"))
+		})
+
+		It("should resolve the first version while suspended", func() {
+			app := newTestApp()
+			app.Spec.SuspendImageAutomation = true
+			app.Status.Chart.Version = ""
+			r := newTestReconciler(newPolicy(app, "6.6.0"))
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			Expect(app.Status.Chart.Version).To(Equal("6.6.0"))
+		})
+
+		It("should resume the scan & update the version once resumed", func() {
+			app := newTestApp()
+			app.Spec.SuspendImageAutomation = true
+			r := newTestReconciler(newPolicy(app, "6.6.0"))
+			Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			app.Spec.SuspendImageAutomation = false
+			Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+			Expect(getImageRepository(r, app).Spec.Suspend).To(BeFalse())
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			Expect(app.Status.Chart.Version).To(Equal("6.6.0"))
+			Expect(conditions.Has(app, appsv1.VersionUpdatesPausedCondition)).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("Reconcile", func() {
+	ctx := context.Background()
+
+	It("should requeue after the scan interval while waiting for the chart version", func() {
+		app := newTestApp()
+		app.Status = appsv1.FluxAppStatus{}
+		r := newTestReconciler(app)
+		r.ScanRequeueInterval = 42 * time.Second
+		result, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(42 * time.Second))
+	})
+
+	It("should persist the status when cancelled mid-reconcile", func() {
+		app := newTestApp()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		// Cancel the reconcile part way through as the manager does on shutdown
+		// The fake client ignores the context so fail any call made after the cancellation
+		c := fake.NewClientBuilder().
+			WithScheme(newTestScheme()).
+			WithObjects(app).
+			WithStatusSubresource(&appsv1.FluxApp{}).
+			WithInterceptorFuncs(interceptor.Funcs{
+				Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+					if _, ok := obj.(*imagev1.ImageRepository); ok {
+						cancel()
+					}
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+					return c.Get(ctx, key, obj, opts...)
+				},
+				SubResourcePatch: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+					return c.SubResource(subResourceName).Patch(ctx, obj, patch, opts...)
+				},
+			}).
+			Build()
+		r := &FluxAppReconciler{Client: c, Scheme: c.Scheme(), ResourceManager: NewResourceManager(c, c.Scheme(), ControllerOwnerReferenceMode)}
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).To(MatchError(context.Canceled))
+
+		updated := &appsv1.FluxApp{}
+		Expect(c.Get(context.Background(), client.ObjectKeyFromObject(app), updated)).To(Succeed())
+		Expect(updated.Status.LastError).NotTo(BeNil())
+		Expect(updated.Status.LastError.Message).To(ContainSubstring(context.Canceled.Error()))
+	})
+
+	Context("status patch", func() {
+		// newConflictingReconciler returns a reconciler whose status patches conflict the given number of times
+		newConflictingReconciler := func(app *appsv1.FluxApp, conflicts int) (*FluxAppReconciler, *int) {
+			attempts := 0
+			c := fake.NewClientBuilder().
+				WithScheme(newTestScheme()).
+				WithObjects(app).
+				WithStatusSubresource(&appsv1.FluxApp{}).
+				WithInterceptorFuncs(interceptor.Funcs{
+					SubResourcePatch: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+						attempts++
+						if attempts <= conflicts {
+							return errors.NewConflict(appsv1.GroupVersion.WithResource("fluxapps").GroupResource(), obj.GetName(),
+								fmt.Errorf("the object has been modified"))
+						}
+						return c.SubResource(subResourceName).Patch(ctx, obj, patch, opts...)
+					},
+				}).
+				Build()
+			r := &FluxAppReconciler{Client: c, Scheme: c.Scheme(), ResourceManager: NewResourceManager(c, c.Scheme(), ControllerOwnerReferenceMode)}
+			return r, &attempts
+		}
+
+		It("should retry the status patch when it conflicts", func() {
+			app := newTestApp()
+			app.Status = appsv1.FluxAppStatus{}
+			r, attempts := newConflictingReconciler(app, 1)
+			_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*attempts).To(Equal(2))
+
+			updated := &appsv1.FluxApp{}
+			Expect(r.Get(ctx, client.ObjectKeyFromObject(app), updated)).To(Succeed())
+			Expect(updated.Status.ObservedGeneration).To(Equal(updated.Generation))
+			Expect(updated.Status.Conditions).NotTo(BeEmpty())
+		})
+
+		It("should give up after the configured attempts", func() {
+			app := newTestApp()
+			r, attempts := newConflictingReconciler(app, 10)
+			r.StatusPatchAttempts = 3
+			Expect(r.patchStatus(ctx, app, app.DeepCopy())).To(Satisfy(errors.IsConflict))
+			Expect(*attempts).To(Equal(3))
+		})
+
+		It("should apply the status to the latest app when it was updated concurrently", func() {
+			app := newTestApp()
+			app.Status = appsv1.FluxAppStatus{}
+			r, attempts := newConflictingReconciler(app, 0)
+			Expect(r.Get(ctx, client.ObjectKeyFromObject(app), app)).To(Succeed())
+			before := app.DeepCopy()
+
+			concurrent := app.DeepCopy()
+			concurrent.Labels = map[string]string{"team": "platform"}
+			Expect(r.Update(ctx, concurrent)).To(Succeed())
+
+			app.Status.ObservedGeneration = app.Generation
+			Expect(r.patchStatus(ctx, app, before)).To(Succeed())
+			Expect(*attempts).To(Equal(2))
+			Expect(app.Labels).To(HaveKeyWithValue("team", "platform"))
+
+			updated := &appsv1.FluxApp{}
+			Expect(r.Get(ctx, client.ObjectKeyFromObject(app), updated)).To(Succeed())
+			Expect(updated.Labels).To(HaveKeyWithValue("team", "platform"))
+			Expect(updated.Status.ObservedGeneration).To(Equal(app.Generation))
+		})
+	})
+})
+
+var _ = Describe("Sources", func() {
+	ctx := context.Background()
+
+	getImageRepository := func(r *FluxAppReconciler, app *appsv1.FluxApp) *imagev1.ImageRepository {
+		repo := &imagev1.ImageRepository{}
+		key := types.NamespacedName{Name: r.ResourceManager.ImageRepositoryName(app), Namespace: app.Namespace}
+		Expect(r.Get(ctx, key, repo)).To(Succeed())
+		return repo
+	}
+
+	getHelmRepository := func(r *FluxAppReconciler, app *appsv1.FluxApp) *sourcev1.HelmRepository {
+		repo := &sourcev1.HelmRepository{}
+		key := types.NamespacedName{Name: r.ResourceManager.HelmRepositoryName(app), Namespace: app.Namespace}
+		Expect(r.Get(ctx, key, repo)).To(Succeed())
+		return repo
+	}
+
+	DescribeTable("should use the chart provider rather than detecting it from the host",
+		func(repository string) {
+			app := newTestApp()
+			app.Spec.Chart.Repository = repository
+			app.Spec.Chart.Provider = "generic"
+			r := newTestReconciler()
+			Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+			Expect(handleHelmRepository(ctx, r, app)).To(Succeed())
+			Expect(getImageRepository(r, app).Spec.Provider).To(Equal("generic"))
+			Expect(getHelmRepository(r, app).Spec.Provider).To(Equal("generic"))
+		},
+		Entry("gcp", "oci://europe-docker.gcr.io/charts/podinfo"),
+		Entry("aws", "oci://123456789012.dkr.ecr.eu-west-1.amazonaws.com/charts/podinfo"),
+		Entry("azure", "oci://example.azurecr.io/charts/podinfo"),
+	)
+
+	It("should prefer the app chart provider over the template provider", func() {
+		tmpl := &appsv1.FluxAppTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "default"},
+			Spec:       appsv1.FluxAppTemplateSpec{Provider: "gcp"},
+		}
+		app := newTestApp()
+		app.Spec.Chart.Repository = "oci://europe-docker.gcr.io/charts/podinfo"
+		app.Spec.Chart.Provider = "generic"
+		app.Spec.TemplateRef = &meta.LocalObjectReference{Name: "shared"}
+		r := newTestReconciler(tmpl)
+		Expect(applyTemplate(ctx, r, app)).To(Succeed())
+		Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+		Expect(getImageRepository(r, app).Spec.Provider).To(Equal("generic"))
+	})
+
+	It("should set the scan interval independently of the HelmRelease interval", func() {
+		app := newTestApp()
+		app.Spec.Interval = &metav1.Duration{Duration: 30 * time.Second}
+		app.Spec.Chart.ScanInterval = &metav1.Duration{Duration: 6 * time.Hour}
+		r := newTestReconciler()
+		Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(getImageRepository(r, app).Spec.Interval.Duration).To(Equal(6 * time.Hour))
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Interval.Duration).To(Equal(30 * time.Second))
+	})
+
+	It("should default both intervals to 1m", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(getImageRepository(r, app).Spec.Interval.Duration).To(Equal(time.Minute))
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Interval.Duration).To(Equal(time.Minute))
+	})
+
+	It("should propagate the accessFrom rules", func() {
+		app := newTestApp()
+		app.Spec.Chart.AccessFrom = &acl.AccessFrom{
+			NamespaceSelectors: []acl.NamespaceSelector{
+				{MatchLabels: map[string]string{"tenant": "team-a"}},
+			},
+		}
+		r := newTestReconciler()
+		Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRepository(ctx, r, app)).To(Succeed())
+		Expect(getImageRepository(r, app).Spec.AccessFrom).To(Equal(app.Spec.Chart.AccessFrom))
+		Expect(getHelmRepository(r, app).Spec.AccessFrom).To(Equal(app.Spec.Chart.AccessFrom))
+	})
+
+	It("should not set accessFrom by default", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRepository(ctx, r, app)).To(Succeed())
+		Expect(getImageRepository(r, app).Spec.AccessFrom).To(BeNil())
+		Expect(getHelmRepository(r, app).Spec.AccessFrom).To(BeNil())
+	})
+
+	It("should report the latest chart version found by the scan", func() {
+		app := newTestApp()
+		existing := &imagev1.ImageRepository{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace},
+			Status: imagev1.ImageRepositoryStatus{
+				LastScanResult: &imagev1.ScanResult{
+					TagCount:   4,
+					LatestTags: []string{"latest", "6.9.0", "6.10.0", "6.5.3"},
+				},
+			},
+		}
+		r := newTestReconciler(existing)
+		Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+		Expect(app.Status.Chart.SourceRevision).To(Equal("6.10.0"))
+		// The latest chart is reported separately from the selected version
+		Expect(app.Status.Chart.Version).To(Equal("6.5.3"))
+	})
+
+	It("should not report a source revision before the first scan", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRepository(ctx, r, app)).To(Succeed())
+		Expect(app.Status.Chart.SourceRevision).To(BeEmpty())
+	})
+
+	It("should report the HelmRepository artifact revision", func() {
+		app := newTestApp()
+		existing := &sourcev1.HelmRepository{
+			ObjectMeta: metav1.ObjectMeta{Name: "ghcr-io-stefanprodan-charts", Namespace: app.Namespace},
+			Status: sourcev1.HelmRepositoryStatus{
+				Artifact: &sourcev1.Artifact{Revision: "sha256:1234"},
+			},
+		}
+		r := newTestReconciler(existing)
+		Expect(handleHelmRepository(ctx, r, app)).To(Succeed())
+		Expect(app.Status.Chart.SourceRevision).To(Equal("sha256:1234"))
+	})
+
+	It("should add the repository labels to the sources only", func() {
+		app := newTestApp()
+		app.Spec.Chart.RepositoryLabels = map[string]string{"egress": "ghcr"}
+		r := newTestReconciler()
+		Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+		Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRepository(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(getImageRepository(r, app).Labels).To(HaveKeyWithValue("egress", "ghcr"))
+		Expect(getHelmRepository(r, app).Labels).To(HaveKeyWithValue("egress", "ghcr"))
+		policy := &imagev1.ImagePolicy{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: r.ResourceManager.ImagePolicyName(app), Namespace: app.Namespace}, policy)).To(Succeed())
+		Expect(policy.Labels).NotTo(HaveKey("egress"))
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Labels).NotTo(HaveKey("egress"))
+	})
+
+	It("should merge the repository labels with existing labels", func() {
+		app := newTestApp()
+		app.Spec.Chart.RepositoryLabels = map[string]string{"egress": "ghcr"}
+		existing := &sourcev1.HelmRepository{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "ghcr-io-stefanprodan-charts",
+				Namespace: app.Namespace,
+				Labels:    map[string]string{"team": "platform", "egress": "none"},
+			},
+		}
+		r := newTestReconciler(existing)
+		Expect(handleHelmRepository(ctx, r, app)).To(Succeed())
+		Expect(getHelmRepository(r, app).Labels).To(Equal(map[string]string{"team": "platform", "egress": "ghcr"}))
+	})
+})
+
+// setupTestManager captures the runnables added by SetupWithManager
+// Any manager methods not overridden here aren't expected to be used
+type setupTestManager struct {
+	ctrl.Manager
+	scheme    *runtime.Scheme
+	runnables []manager.Runnable
+}
+
+func (m *setupTestManager) GetScheme() *runtime.Scheme { return m.scheme }
+
+func (m *setupTestManager) GetLogger() logr.Logger { return logr.Discard() }
+
+func (m *setupTestManager) GetCache() cache.Cache { return nil }
+
+func (m *setupTestManager) GetRESTMapper() apimeta.RESTMapper { return nil }
+
+func (m *setupTestManager) GetControllerOptions() config.Controller {
+	// Controller names must be unique per process unless validation is skipped
+	return config.Controller{SkipNameValidation: ptr.To(true)}
+}
+
+func (m *setupTestManager) Add(r manager.Runnable) error {
+	m.runnables = append(m.runnables, r)
+	return nil
+}
+
+var _ = Describe("SetupWithManager", func() {
+	It("should apply the controller options", func() {
+		mgr := &setupTestManager{scheme: newTestScheme()}
+		r := newTestReconciler()
+		Expect(r.SetupWithManager(mgr, controller.Options{MaxConcurrentReconciles: 7})).To(Succeed())
+		// The controller is added after the startup runnables
+		// and its implementation is internal to controller-runtime
+		ctrlr := mgr.runnables[len(mgr.runnables)-1]
+		Expect(reflect.ValueOf(ctrlr).Elem().FieldByName("MaxConcurrentReconciles").Int()).To(BeEquivalentTo(7))
+	})
+})
@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+)
+
+// converged returns true if the children were applied from the current spec and are all ready
+// so there's nothing for the handlers to change
+// Apps using a template aren't checked as a template change doesn't change the app generation
+func converged(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) (bool, error) {
+	if app.Spec.TemplateRef != nil || app.Status.ObservedGeneration != app.Generation || app.Status.LastError != nil {
+		return false, nil
+	}
+	// The digest of a floating tag can change without any of the children changing
+	if floatingTag(app) {
+		return false, nil
+	}
+	// The rollback annotation pins the version without changing the generation
+	if rollbackRequested(app) != (app.Status.Chart.RollbackVersion != "") {
+		return false, nil
+	}
+	// So does the force annotation
+	if pendingForceRequest(app) != "" {
+		return false, nil
+	}
+	// So does the override version annotation
+	if requestedOverrideVersion(app) != app.Status.Chart.OverrideVersion {
+		return false, nil
+	}
+	// So does the suspend reason annotation
+	if !suspendReasonApplied(app) {
+		return false, nil
+	}
+	if ready := conditions.Get(app, meta.ReadyCondition); ready == nil ||
+		ready.Status != metav1.ConditionTrue || ready.ObservedGeneration != app.Generation {
+		return false, nil
+	}
+	// The default values can change without changing the app
+	defaults, found, err := r.defaultValues(ctx, app.Namespace)
+	if err != nil {
+		return false, err
+	}
+	for _, kind := range childKinds {
+		// A referenced HelmRepository is used instead of the generated one
+		if kind == sourcev1.HelmRepositoryKind && app.Spec.HelmRepositoryRef != nil {
+			continue
+		}
+		mr, err := r.ResourceManager.Get(ctx, app, kind)
+		if err != nil {
+			return false, err
+		}
+		if mr.patch == nil {
+			// The app can't be ready without a HelmRelease, the other children are optional
+			if kind == helmv2.HelmReleaseKind || (kind == DefaultValuesKind && found) ||
+				(kind == InlineValuesKind && inlineValuesFirst(app)) || (kind == ChartAuthKind && app.Spec.Chart.Auth != nil) ||
+				(kind == sourcev1beta2.OCIRepositoryKind && pinDigest(app)) {
+				return false, nil
+			}
+			continue
+		}
+		// A child edited since it was applied is applied again to revert the edit
+		if mr.GetAnnotations()[childrenVersionAnnotation] != r.ResourceManager.version || edited(mr) || !childReady(mr.Object) {
+			return false, nil
+		}
+		switch o := mr.Object.(type) {
+		case *corev1.ConfigMap:
+			if kind == DefaultValuesKind && (!found || o.Data[defaultValuesKey] != defaults) {
+				return false, nil
+			}
+			// The values annotations change the inline values without changing the generation
+			if kind == InlineValuesKind {
+				if ok, err := inlineValuesApplied(app, r.Substitutions, o); err != nil || !ok {
+					return false, nil
+				}
+			}
+		case *imagev1.ImageRepository:
+			// A new scan may have found a newer tag
+			if revision := latestScannedVersion(o, app.Spec.Chart.TagPrefix); revision != "" && revision != app.Status.Chart.SourceRevision {
+				return false, nil
+			}
+		case *imagev1.ImagePolicy:
+			// A new scan may have selected a newer chart version
+			// The lowest matching version isn't selected by the ImagePolicy so it's only checked by the handlers
+			// The available version is compared so an app with version updates paused can still converge
+			// Nothing is scanned while image automation is suspended
+			if o.Status.LatestImage != "" && app.Spec.Chart.VersionSelection != appsv1.VersionSelectionLowest &&
+				!app.Spec.SuspendImageAutomation {
+				version, err := chartVersion(app, o.Status.LatestImage)
+				if err != nil || version != app.Status.Chart.AvailableVersion {
+					return false, nil
+				}
+			}
+		case *helmv2.HelmRelease:
+			if kind != helmv2.HelmReleaseKind {
+				continue
+			}
+			// The drift detection annotation changes the HelmRelease without changing the generation
+			if helmReleaseChartVersion(o) != chartTag(app) ||
+				o.Spec.DriftDetection == nil || o.Spec.DriftDetection.Mode != driftDetectionMode(app) {
+				return false, nil
+			}
+			// So do the values annotations
+			if ok, err := valuesApplied(app, r.Substitutions, o); err != nil || !ok {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// childReady returns true unless the child reports it isn't ready or hasn't reconciled its current spec
+// Children without a Ready condition e.g. OCI HelmRepositories are ready
+func childReady(obj interface{}) bool {
+	getter, ok := obj.(conditions.Getter)
+	if !ok {
+		return true
+	}
+	ready := conditions.Get(getter, meta.ReadyCondition)
+	if ready == nil {
+		return true
+	}
+	return ready.Status == metav1.ConditionTrue && ready.ObservedGeneration == getter.GetGeneration()
+}
+
+// valuesApplied returns true if the HelmRelease has the current inline values of the app
+func valuesApplied(app *appsv1.FluxApp, vars map[string]string, hr *helmv2.HelmRelease) (bool, error) {
+	values, err := inlineValues(app, vars)
+	if err != nil {
+		return false, err
+	}
+	// The inline values are in the inline values ConfigMap instead
+	if inlineValuesFirst(app) {
+		values = nil
+	}
+	want, err := topLevelValues(values)
+	if err != nil {
+		return false, err
+	}
+	got, err := topLevelValues(hr.Spec.Values)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(want, got), nil
+}
+
+// inlineValuesApplied returns true if the inline values ConfigMap has the current inline values of the app
+// and is only kept while the inline values are merged first
+func inlineValuesApplied(app *appsv1.FluxApp, vars map[string]string, cm *corev1.ConfigMap) (bool, error) {
+	if !inlineValuesFirst(app) {
+		return false, nil
+	}
+	values, err := inlineValues(app, vars)
+	if err != nil {
+		return false, err
+	}
+	data, err := inlineValuesData(values)
+	if err != nil {
+		return false, err
+	}
+	return cm.Data[defaultValuesKey] == data, nil
+}
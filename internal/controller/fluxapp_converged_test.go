@@ -0,0 +1,305 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+)
+
+var _ = Describe("Converged", func() {
+	ctx := context.Background()
+
+	// newConvergedReconciler returns a reconciler with the app and ready children
+	// which have already been through a full reconcile
+	newConvergedReconciler := func(app *appsv1.FluxApp) *FluxAppReconciler {
+		repo := &imagev1.ImageRepository{ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace}}
+		conditions.MarkTrue(repo, meta.ReadyCondition, meta.SucceededReason, "successful scan")
+		policy := &imagev1.ImagePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace},
+			Status:     imagev1.ImagePolicyStatus{LatestImage: "ghcr.io/stefanprodan/charts/podinfo:6.5.3"},
+		}
+		conditions.MarkTrue(policy, meta.ReadyCondition, meta.SucceededReason, "Latest image tag resolved")
+		hr := &helmv2.HelmRelease{ObjectMeta: metav1.ObjectMeta{Name: app.Name, Namespace: app.Namespace}}
+		conditions.MarkTrue(hr, meta.ReadyCondition, meta.SucceededReason, "Helm install succeeded")
+		r := newTestReconciler(app, repo, policy, hr)
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).NotTo(HaveOccurred())
+		return r
+	}
+
+	getApp := func(r *FluxAppReconciler, app *appsv1.FluxApp) *appsv1.FluxApp {
+		current := &appsv1.FluxApp{}
+		Expect(r.Get(ctx, client.ObjectKeyFromObject(app), current)).To(Succeed())
+		return current
+	}
+
+	It("should skip the handlers once the children are converged", func() {
+		app := newTestApp()
+		r := newConvergedReconciler(app)
+		current := getApp(r, app)
+		Expect(conditions.IsTrue(current, meta.ReadyCondition)).To(BeTrue())
+		ok, err := converged(ctx, r, current)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+
+		result, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(time.Minute))
+	})
+
+	It("should run the handlers when a scan selects a new chart version", func() {
+		app := newTestApp()
+		r := newConvergedReconciler(app)
+		policy := &imagev1.ImagePolicy{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: "podinfo-chart", Namespace: app.Namespace}, policy)).To(Succeed())
+		policy.Status.LatestImage = "ghcr.io/stefanprodan/charts/podinfo:6.6.0"
+		Expect(r.Update(ctx, policy)).To(Succeed())
+
+		ok, err := converged(ctx, r, getApp(r, app))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeFalse())
+		_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getApp(r, app).Status.Chart.Version).To(Equal("6.6.0"))
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Chart.Spec.Version).To(Equal("6.6.0"))
+	})
+
+	It("should converge with a newer available version while version updates are paused", func() {
+		app := newTestApp()
+		app.Spec.PauseVersionUpdates = true
+		r := newConvergedReconciler(app)
+		policy := &imagev1.ImagePolicy{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: "podinfo-chart", Namespace: app.Namespace}, policy)).To(Succeed())
+		policy.Status.LatestImage = "ghcr.io/stefanprodan/charts/podinfo:6.6.0"
+		Expect(r.Update(ctx, policy)).To(Succeed())
+
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).NotTo(HaveOccurred())
+		current := getApp(r, app)
+		Expect(current.Status.Chart.AvailableVersion).To(Equal("6.6.0"))
+		Expect(current.Status.Chart.Version).To(Equal("6.5.3"))
+		ok, err := converged(ctx, r, current)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+	})
+
+	It("should stay converged while image automation is suspended", func() {
+		app := newTestApp()
+		app.Spec.SuspendImageAutomation = true
+		r := newConvergedReconciler(app)
+		policy := &imagev1.ImagePolicy{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: "podinfo-chart", Namespace: app.Namespace}, policy)).To(Succeed())
+		policy.Status.LatestImage = "ghcr.io/stefanprodan/charts/podinfo:6.6.0"
+		Expect(r.Update(ctx, policy)).To(Succeed())
+
+		ok, err := converged(ctx, r, getApp(r, app))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+	})
+
+	DescribeTable("should not be converged",
+		func(change func(r *FluxAppReconciler, app *appsv1.FluxApp)) {
+			app := newTestApp()
+			r := newConvergedReconciler(app)
+			current := getApp(r, app)
+			change(r, current)
+			ok, err := converged(ctx, r, current)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeFalse())
+		},
+		Entry("when the spec has changed", func(r *FluxAppReconciler, app *appsv1.FluxApp) {
+			app.Generation++
+		}),
+		Entry("when the app isn't ready", func(r *FluxAppReconciler, app *appsv1.FluxApp) {
+			conditions.MarkFalse(app, meta.ReadyCondition, meta.ProgressingReason, "HelmRelease is not ready")
+		}),
+		Entry("when the rollback annotation is added", func(r *FluxAppReconciler, app *appsv1.FluxApp) {
+			app.Annotations = map[string]string{rollbackAnnotation: "true"}
+		}),
+		Entry("when the rollback annotation is removed", func(r *FluxAppReconciler, app *appsv1.FluxApp) {
+			app.Status.Chart.RollbackVersion = "6.5.2"
+		}),
+		Entry("when the override version annotation is added", func(r *FluxAppReconciler, app *appsv1.FluxApp) {
+			app.Annotations = map[string]string{overrideVersionAnnotation: "6.4.0"}
+		}),
+		Entry("when the force annotation is set", func(r *FluxAppReconciler, app *appsv1.FluxApp) {
+			app.Annotations = map[string]string{helmv2.ForceRequestAnnotation: "1"}
+		}),
+		Entry("when the suspend reason annotation is changed", func(r *FluxAppReconciler, app *appsv1.FluxApp) {
+			markVersionUpdatesPaused(app, appsv1.PausedVersionUpdatesReason, "version updates are paused at 6.5.3")
+			app.Annotations = map[string]string{suspendReasonAnnotation: "change freeze"}
+		}),
+		Entry("when the app uses a template", func(r *FluxAppReconciler, app *appsv1.FluxApp) {
+			app.Spec.TemplateRef = &meta.LocalObjectReference{Name: "defaults"}
+		}),
+		Entry("when the HelmRelease isn't ready", func(r *FluxAppReconciler, app *appsv1.FluxApp) {
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			conditions.MarkFalse(hr, meta.ReadyCondition, "UpgradeFailed", "Helm upgrade failed")
+			Expect(r.Update(ctx, hr)).To(Succeed())
+		}),
+		Entry("when the HelmRelease has been deleted", func(r *FluxAppReconciler, app *appsv1.FluxApp) {
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Delete(ctx, hr)).To(Succeed())
+		}),
+		Entry("when the drift detection annotation is set", func(r *FluxAppReconciler, app *appsv1.FluxApp) {
+			app.Annotations = map[string]string{driftDetectionAnnotation: "disabled"}
+		}),
+		Entry("when a values annotation is set", func(r *FluxAppReconciler, app *appsv1.FluxApp) {
+			app.Annotations = map[string]string{valuesAnnotationPrefix + "replicaCount": "3"}
+		}),
+		Entry("when default values are configured", func(r *FluxAppReconciler, app *appsv1.FluxApp) {
+			defaults := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "fluxer-defaults", Namespace: "flux-system"},
+				Data:       map[string]string{defaultValuesKey: "replicaCount: 1\n"},
+			}
+			Expect(r.Create(ctx, defaults)).To(Succeed())
+			r.DefaultValues = NewDefaultValues(r.Client, client.ObjectKeyFromObject(defaults))
+		}),
+	)
+
+	It("should run the handlers when a values annotation is removed", func() {
+		app := newTestApp()
+		app.Annotations = map[string]string{valuesAnnotationPrefix + "replicaCount": "3"}
+		r := newConvergedReconciler(app)
+		current := getApp(r, app)
+		ok, err := converged(ctx, r, current)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+
+		current.Annotations = nil
+		ok, err = converged(ctx, r, current)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should run the handlers when a values annotation changes the inline values merged first", func() {
+		app := newTestApp()
+		app.Spec.ValuesPrecedence = appsv1.ValuesPrecedenceInlineFirst
+		r := newConvergedReconciler(app)
+		current := getApp(r, app)
+		ok, err := converged(ctx, r, current)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+
+		current.Annotations = map[string]string{valuesAnnotationPrefix + "replicaCount": "3"}
+		ok, err = converged(ctx, r, current)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should run the handlers when the default values change", func() {
+		app := newTestApp()
+		defaults := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "fluxer-defaults", Namespace: "flux-system"},
+			Data:       map[string]string{defaultValuesKey: "replicaCount: 1\n"},
+		}
+		r := newConvergedReconciler(app)
+		Expect(r.Create(ctx, defaults)).To(Succeed())
+		r.DefaultValues = NewDefaultValues(r.Client, client.ObjectKeyFromObject(defaults))
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).NotTo(HaveOccurred())
+		ok, err := converged(ctx, r, getApp(r, app))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+
+		defaults.Data[defaultValuesKey] = "replicaCount: 2\n"
+		Expect(r.Update(ctx, defaults)).To(Succeed())
+		r.DefaultValues = NewDefaultValues(r.Client, client.ObjectKeyFromObject(defaults))
+		ok, err = converged(ctx, r, getApp(r, app))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should run the handlers when the controller defaults change", func() {
+		app := newTestApp()
+		r := newConvergedReconciler(app)
+		ok, err := converged(ctx, r, getApp(r, app))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+
+		// The controller restarted with a default timeout
+		r.DefaultTimeout = 10 * time.Minute
+		r.ResourceManager.SetControllerDefaults(r.controllerDefaults())
+		ok, err = converged(ctx, r, getApp(r, app))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should revert a child edited since it was applied", func() {
+		app := newTestApp()
+		r := newConvergedReconciler(app)
+		// Record a generation as the API server would
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		hr.Generation = 1
+		conditions.MarkTrue(hr, meta.ReadyCondition, meta.SucceededReason, "Helm install succeeded")
+		Expect(r.Update(ctx, hr)).To(Succeed())
+		_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).NotTo(HaveOccurred())
+		ok, err := converged(ctx, r, getApp(r, app))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+
+		// The HelmRelease is deployed to another namespace by hand, which the helm-controller has reconciled
+		hr, err = getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		hr.Spec.TargetNamespace = "kube-system"
+		hr.Generation = 2
+		conditions.MarkTrue(hr, meta.ReadyCondition, meta.SucceededReason, "Helm upgrade succeeded")
+		Expect(r.Update(ctx, hr)).To(Succeed())
+		ok, err = converged(ctx, r, getApp(r, app))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeFalse())
+		_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).NotTo(HaveOccurred())
+		hr, err = getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.TargetNamespace).To(Equal(app.Namespace))
+	})
+
+	It("should run the handlers when a scan finds a new tag", func() {
+		app := newTestApp()
+		r := newConvergedReconciler(app)
+		repo := &imagev1.ImageRepository{}
+		Expect(r.Get(ctx, types.NamespacedName{Name: "podinfo-chart", Namespace: app.Namespace}, repo)).To(Succeed())
+		repo.Status.LastScanResult = &imagev1.ScanResult{LatestTags: []string{"6.6.0"}}
+		Expect(r.Update(ctx, repo)).To(Succeed())
+
+		ok, err := converged(ctx, r, getApp(r, app))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeFalse())
+		_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getApp(r, app).Status.Chart.SourceRevision).To(Equal("6.6.0"))
+	})
+
+	It("should plan to leave every child unchanged while converged", func() {
+		app := newTestApp()
+		r := newConvergedReconciler(app)
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).NotTo(HaveOccurred())
+		plan := getApp(r, app).Status.Plan
+		Expect(plan).To(HaveLen(4))
+		for _, e := range plan {
+			Expect(e.Action).To(Equal(appsv1.PlanActionUnchanged), e.Kind)
+		}
+	})
+})
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/validation"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// validateCustomResourceValues validates the values of each custom resource the chart installs
+// against the schema of its CRD, so values the API server would reject fail before Helm installs them
+// Custom resources whose CRD isn't installed yet e.g. because the chart installs it are skipped,
+// as are custom resources without values
+func validateCustomResourceValues(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp, values *apiextensionsv1.JSON) error {
+	if r.CustomResourceDefinitions == nil || len(app.Spec.CustomResourceValues) == 0 || values == nil {
+		return nil
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(values.Raw, &doc); err != nil {
+		return err
+	}
+	var invalid []string
+	for _, cr := range app.Spec.CustomResourceValues {
+		spec, ok := valuesAt(doc, cr.ValuesPath)
+		if !ok {
+			continue
+		}
+		props, err := customResourceSchema(ctx, r, cr)
+		if err != nil {
+			return err
+		}
+		if props == nil {
+			continue
+		}
+		validator, _, err := validation.NewSchemaValidator(props)
+		if err != nil {
+			return err
+		}
+		obj := map[string]interface{}{"apiVersion": cr.APIVersion, "kind": cr.Kind, "spec": spec}
+		for _, fieldErr := range validation.ValidateCustomResource(nil, obj, validator) {
+			invalid = append(invalid, fmt.Sprintf("%s values at %s: %s", cr.Kind, cr.ValuesPath, fieldErr.Error()))
+		}
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("%w: %s", errInvalidCustomResourceValues, strings.Join(invalid, "; "))
+	}
+	return nil
+}
+
+// customResourceSchema returns the schema of the custom resource version from its CRD
+// or nil if the CRD or version isn't installed
+// The CRDs are listed as the CRD name is made from the plural, which the app doesn't know
+func customResourceSchema(ctx context.Context, r *FluxAppReconciler, cr appsv1.CustomResourceValues) (*apiextensions.JSONSchemaProps, error) {
+	gv, err := schema.ParseGroupVersion(cr.APIVersion)
+	if err != nil {
+		return nil, fmt.Errorf("%w customResourceValues apiVersion %q: %w", errInvalid, cr.APIVersion, err)
+	}
+	crds := &apiextensionsv1.CustomResourceDefinitionList{}
+	if err := r.CustomResourceDefinitions.List(ctx, crds); err != nil {
+		return nil, err
+	}
+	for _, crd := range crds.Items {
+		if crd.Spec.Group != gv.Group || crd.Spec.Names.Kind != cr.Kind {
+			continue
+		}
+		for _, version := range crd.Spec.Versions {
+			if version.Name != gv.Version || version.Schema == nil || version.Schema.OpenAPIV3Schema == nil {
+				continue
+			}
+			props := &apiextensions.JSONSchemaProps{}
+			if err := apiextensionsv1.Convert_v1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(version.Schema.OpenAPIV3Schema, props, nil); err != nil {
+				return nil, err
+			}
+			return props, nil
+		}
+	}
+	return nil, nil
+}
+
+// valuesAt returns the value at the JSON pointer in the values & whether it exists
+func valuesAt(values map[string]interface{}, pointer string) (interface{}, bool) {
+	var current interface{} = values
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[token]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
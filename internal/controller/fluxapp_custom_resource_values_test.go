@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("Custom resource values", func() {
+	ctx := context.Background()
+
+	// alertmanagerCRD is a CRD whose spec requires a route receiver & limits the replicas
+	alertmanagerCRD := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "alertmanagers.monitoring.coreos.com"},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: "monitoring.coreos.com",
+			Names: apiextensionsv1.CustomResourceDefinitionNames{Kind: "Alertmanager", Plural: "alertmanagers"},
+			Scope: apiextensionsv1.NamespaceScoped,
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{{
+				Name:    "v1",
+				Served:  true,
+				Storage: true,
+				Schema: &apiextensionsv1.CustomResourceValidation{OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{
+					Type: "object",
+					Properties: map[string]apiextensionsv1.JSONSchemaProps{
+						"spec": {
+							Type: "object",
+							Properties: map[string]apiextensionsv1.JSONSchemaProps{
+								"replicas": {Type: "integer", Minimum: ptr.To(1.0)},
+								"route": {
+									Type:       "object",
+									Required:   []string{"receiver"},
+									Properties: map[string]apiextensionsv1.JSONSchemaProps{"receiver": {Type: "string"}},
+								},
+							},
+						},
+					},
+				}},
+			}},
+		},
+	}
+
+	newCustomResourceApp := func(values string) *appsv1.FluxApp {
+		app := newTestApp()
+		app.Spec.Values = &apiextensionsv1.JSON{Raw: []byte(values)}
+		app.Spec.CustomResourceValues = []appsv1.CustomResourceValues{{
+			ValuesPath: "/alertmanager/spec",
+			APIVersion: "monitoring.coreos.com/v1",
+			Kind:       "Alertmanager",
+		}}
+		return app
+	}
+
+	newCustomResourceReconciler := func(objs ...client.Object) *FluxAppReconciler {
+		r := newTestReconciler(objs...)
+		r.CustomResourceDefinitions = r.Client
+		return r
+	}
+
+	It("should deploy values matching the CRD schema", func() {
+		app := newCustomResourceApp(`{"alertmanager":{"spec":{"replicas":2,"route":{"receiver":"slack"}}}}`)
+		r := newCustomResourceReconciler(alertmanagerCRD.DeepCopy())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		_, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should reject values which don't match the CRD schema", func() {
+		app := newCustomResourceApp(`{"alertmanager":{"spec":{"replicas":0,"route":{}}}}`)
+		r := newCustomResourceReconciler(alertmanagerCRD.DeepCopy())
+		err := handleHelmRelease(ctx, r, app)
+		Expect(err).To(MatchError(errInvalidCustomResourceValues))
+		Expect(err).To(MatchError(ContainSubstring("spec.replicas")))
+		Expect(err).To(MatchError(ContainSubstring("spec.route.receiver")))
+		Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.InvalidCustomResourceValuesReason))
+		_, err = getHelmRelease(ctx, r, app)
+		Expect(err).To(HaveOccurred())
+	})
+
+	DescribeTable("should skip the validation",
+		func(validate bool, crds []client.Object, apiVersion string, values string) {
+			r := newTestReconciler(crds...)
+			if validate {
+				r.CustomResourceDefinitions = r.Client
+			}
+			app := newCustomResourceApp(values)
+			app.Spec.CustomResourceValues[0].APIVersion = apiVersion
+			Expect(validateCustomResourceValues(ctx, r, app, app.Spec.Values)).To(Succeed())
+		},
+		Entry("when the CRD isn't installed",
+			true, nil, "monitoring.coreos.com/v1", `{"alertmanager":{"spec":{"replicas":0}}}`),
+		Entry("when the CRD doesn't have the version",
+			true, []client.Object{alertmanagerCRD}, "monitoring.coreos.com/v2", `{"alertmanager":{"spec":{"replicas":0}}}`),
+		Entry("when there are no values at the path",
+			true, []client.Object{alertmanagerCRD}, "monitoring.coreos.com/v1", `{"replicaCount":1}`),
+		Entry("unless the controller validates custom resource values",
+			false, []client.Object{alertmanagerCRD}, "monitoring.coreos.com/v1", `{"alertmanager":{"spec":{"replicas":0}}}`),
+	)
+
+	It("should resolve escaped JSON pointers", func() {
+		values := map[string]interface{}{"a/b": map[string]interface{}{"c~d": "e"}}
+		value, ok := valuesAt(values, "/a~1b/c~0d")
+		Expect(ok).To(BeTrue())
+		Expect(value).To(Equal("e"))
+		_, ok = valuesAt(values, "/a~1b/c~0d/f")
+		Expect(ok).To(BeFalse())
+	})
+})
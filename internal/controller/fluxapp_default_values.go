@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluxcd/pkg/runtime/conditions"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// defaultValuesTTL is how long the default values are cached before the ConfigMap is read again
+const defaultValuesTTL = time.Minute
+
+// DefaultValues reads the operator-level default values from the values.yaml key of a ConfigMap
+// The values are cached so every reconcile doesn't read the ConfigMap
+type DefaultValues struct {
+	reader client.Reader
+	key    types.NamespacedName
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	values  string
+	found   bool
+	expires time.Time
+}
+
+// NewDefaultValues returns DefaultValues read from the ConfigMap using the reader
+func NewDefaultValues(reader client.Reader, key types.NamespacedName) *DefaultValues {
+	return &DefaultValues{reader: reader, key: key, ttl: defaultValuesTTL}
+}
+
+// Get returns the default values & whether the ConfigMap exists
+func (d *DefaultValues) Get(ctx context.Context) (string, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Now().Before(d.expires) {
+		return d.values, d.found, nil
+	}
+	cm := &corev1.ConfigMap{}
+	if err := d.reader.Get(ctx, d.key, cm); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return "", false, err
+		}
+		d.values, d.found = "", false
+	} else {
+		values := cm.Data[defaultValuesKey]
+		// Fail here rather than every HelmRelease failing to merge the values
+		if err := yaml.Unmarshal([]byte(values), &map[string]interface{}{}); err != nil {
+			return "", false, fmt.Errorf("%w default values ConfigMap %s: %w", errInvalid, d.key, err)
+		}
+		d.values, d.found = values, true
+	}
+	d.expires = time.Now().Add(d.ttl)
+	return d.values, d.found, nil
+}
+
+// defaultValuesSources returns the default values for apps in the namespace in the order they're merged,
+// the operator-level default values then the default values of the namespace
+func (r *FluxAppReconciler) defaultValuesSources(namespace string) []*DefaultValues {
+	var sources []*DefaultValues
+	if r.DefaultValues != nil {
+		sources = append(sources, r.DefaultValues)
+	}
+	if d, ok := r.NamespaceDefaultValues[namespace]; ok {
+		sources = append(sources, d)
+	}
+	return sources
+}
+
+// defaultValues returns the default values for apps in the namespace & whether any were found
+// The namespace default values are merged over the operator-level default values
+// Nothing is found if the controller isn't configured with default values
+func (r *FluxAppReconciler) defaultValues(ctx context.Context, namespace string) (string, bool, error) {
+	var found []string
+	for _, d := range r.defaultValuesSources(namespace) {
+		values, ok, err := d.Get(ctx)
+		if err != nil {
+			return "", false, err
+		}
+		if ok {
+			found = append(found, values)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", false, nil
+	case 1:
+		return found[0], true, nil
+	}
+	merged := map[string]interface{}{}
+	for _, values := range found {
+		v := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(values), &v); err != nil {
+			return "", false, err
+		}
+		merged = mergeValues(merged, v)
+	}
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return "", false, err
+	}
+	return string(data), true, nil
+}
+
+// handleDefaultValues copies the default values into a ConfigMap in the app namespace
+// and returns the reference for the HelmRelease
+// The reference is added before the app valuesFrom so the default values have the lowest precedence
+// If there are no default values the ConfigMap is deleted and no reference is returned
+func handleDefaultValues(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) ([]helmv2.ValuesReference, error) {
+	// Get the default values ConfigMap managed resource
+	mr, err := r.ResourceManager.Get(ctx, app, DefaultValuesKind)
+	if err != nil {
+		return nil, err
+	}
+	values, found, err := r.defaultValues(ctx, app.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	// The app is deployed without the missing default values rather than blocking every app on the ConfigMap
+	// The values are cached so they aren't read again
+	var missing []string
+	for _, d := range r.defaultValuesSources(app.Namespace) {
+		if _, ok, _ := d.Get(ctx); !ok {
+			missing = append(missing, d.key.String())
+		}
+	}
+	if len(missing) > 0 {
+		conditions.MarkTrue(app, appsv1.DefaultValuesMissingCondition, appsv1.DefaultValuesNotFoundReason,
+			"default values ConfigMap %s not found", strings.Join(missing, ", "))
+	} else {
+		conditions.Delete(app, appsv1.DefaultValuesMissingCondition)
+	}
+	if !found {
+		return nil, r.ResourceManager.Delete(ctx, mr)
+	}
+	cm := mr.Object.(*corev1.ConfigMap)
+	cm.Data = map[string]string{defaultValuesKey: values}
+	if err := r.ResourceManager.Update(ctx, mr); err != nil {
+		return nil, err
+	}
+	return []helmv2.ValuesReference{{
+		Kind:      "ConfigMap",
+		Name:      cm.Name,
+		ValuesKey: defaultValuesKey,
+	}}, nil
+}
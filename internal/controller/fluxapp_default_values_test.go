@@ -0,0 +1,234 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+var _ = Describe("Default values", func() {
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "flux-system", Name: "fluxer-defaults"}
+
+	newDefaults := func(values string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+			Data:       map[string]string{defaultValuesKey: values},
+		}
+	}
+
+	// newDefaultValuesReconciler returns a reconciler configured with the default values ConfigMap
+	newDefaultValuesReconciler := func(objs ...client.Object) *FluxAppReconciler {
+		r := newTestReconciler(objs...)
+		r.DefaultValues = NewDefaultValues(r.Client, key)
+		return r
+	}
+
+	getDefaultValues := func(r *FluxAppReconciler, app *appsv1.FluxApp) (*corev1.ConfigMap, error) {
+		cm := &corev1.ConfigMap{}
+		err := r.Get(ctx, types.NamespacedName{Name: r.ResourceManager.DefaultValuesName(app), Namespace: app.Namespace}, cm)
+		return cm, err
+	}
+
+	// helmValues merges the HelmRelease values in the same order as the helm-controller
+	// The valuesFrom are merged in order, then the inline values
+	helmValues := func(r *FluxAppReconciler, hr *helmv2.HelmRelease) map[string]interface{} {
+		values := map[string]interface{}{}
+		for _, ref := range hr.Spec.ValuesFrom {
+			cm := &corev1.ConfigMap{}
+			Expect(r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: hr.Namespace}, cm)).To(Succeed())
+			from := map[string]interface{}{}
+			Expect(yaml.Unmarshal([]byte(cm.Data[ref.ValuesKey]), &from)).To(Succeed())
+			values = mergeValues(values, from)
+		}
+		if hr.Spec.Values != nil {
+			inline := map[string]interface{}{}
+			Expect(json.Unmarshal(hr.Spec.Values.Raw, &inline)).To(Succeed())
+			values = mergeValues(values, inline)
+		}
+		return values
+	}
+
+	It("should reference the default values before the app valuesFrom", func() {
+		app := newTestApp()
+		app.Spec.ValuesFrom = []helmv2.ValuesReference{{Kind: "ConfigMap", Name: "podinfo-values"}}
+		r := newDefaultValuesReconciler(newDefaults("resources:\n  limits:\n    memory: 256Mi\n"))
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.ValuesFrom).To(Equal([]helmv2.ValuesReference{
+			{Kind: "ConfigMap", Name: "podinfo-default-values", ValuesKey: "values.yaml"},
+			{Kind: "ConfigMap", Name: "podinfo-values", ValuesKey: "values.yaml"},
+		}))
+		cm, err := getDefaultValues(r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cm.Data).To(Equal(map[string]string{defaultValuesKey: "resources:\n  limits:\n    memory: 256Mi\n"}))
+		Expect(metav1.IsControlledBy(cm, app)).To(BeTrue())
+		Expect(conditions.Has(app, appsv1.DefaultValuesMissingCondition)).To(BeFalse())
+	})
+
+	It("should give the default values the lowest precedence", func() {
+		app := newTestApp()
+		app.Spec.ValuesFrom = []helmv2.ValuesReference{{Kind: "ConfigMap", Name: "podinfo-values"}}
+		app.Spec.Values = &apiextensionsv1.JSON{Raw: []byte(`{"replicaCount":3}`)}
+		appValues := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo-values", Namespace: app.Namespace},
+			Data:       map[string]string{defaultValuesKey: "resources:\n  limits:\n    cpu: 500m\n"},
+		}
+		r := newDefaultValuesReconciler(appValues, newDefaults(`
+replicaCount: 1
+resources:
+  limits:
+    cpu: 100m
+    memory: 256Mi
+securityContext:
+  runAsNonRoot: true
+`))
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(helmValues(r, hr)).To(Equal(map[string]interface{}{
+			"replicaCount":    float64(3),
+			"resources":       map[string]interface{}{"limits": map[string]interface{}{"cpu": "500m", "memory": "256Mi"}},
+			"securityContext": map[string]interface{}{"runAsNonRoot": true},
+		}))
+	})
+
+	It("should deploy without the default values while the ConfigMap is missing", func() {
+		app := newTestApp()
+		r := newDefaultValuesReconciler(newDefaults("replicaCount: 1\n"))
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(r.Delete(ctx, newDefaults(""))).To(Succeed())
+		r.DefaultValues = NewDefaultValues(r.Client, key)
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.ValuesFrom).To(BeEmpty())
+		_, err = getDefaultValues(r, app)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(conditions.IsTrue(app, appsv1.DefaultValuesMissingCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, appsv1.DefaultValuesMissingCondition)).To(Equal(appsv1.DefaultValuesNotFoundReason))
+		Expect(conditions.GetMessage(app, appsv1.DefaultValuesMissingCondition)).To(ContainSubstring("flux-system/fluxer-defaults"))
+	})
+
+	It("should not reference default values unless configured", func() {
+		app := newTestApp()
+		r := newTestReconciler(newDefaults("replicaCount: 1\n"))
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.ValuesFrom).To(BeEmpty())
+		Expect(conditions.Has(app, appsv1.DefaultValuesMissingCondition)).To(BeFalse())
+	})
+
+	It("should cache the default values", func() {
+		defaults := newDefaults("replicaCount: 1\n")
+		r := newDefaultValuesReconciler(defaults)
+		values, found, err := r.DefaultValues.Get(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(values).To(Equal("replicaCount: 1\n"))
+
+		defaults.Data[defaultValuesKey] = "replicaCount: 2\n"
+		Expect(r.Update(ctx, defaults)).To(Succeed())
+		values, _, err = r.DefaultValues.Get(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values).To(Equal("replicaCount: 1\n"))
+
+		// The ConfigMap is read again once the cache expires
+		r.DefaultValues.expires = time.Time{}
+		values, _, err = r.DefaultValues.Get(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values).To(Equal("replicaCount: 2\n"))
+	})
+
+	It("should reject default values which aren't a YAML object", func() {
+		r := newDefaultValuesReconciler(newDefaults("- replicaCount\n"))
+		_, _, err := r.DefaultValues.Get(ctx)
+		Expect(err).To(MatchError(errInvalid))
+	})
+
+	Context("namespace default values", func() {
+		teamKey := types.NamespacedName{Namespace: "platform", Name: "team-a-defaults"}
+
+		newTeamDefaults := func(values string) *corev1.ConfigMap {
+			return &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: teamKey.Name, Namespace: teamKey.Namespace},
+				Data:       map[string]string{defaultValuesKey: values},
+			}
+		}
+
+		// newNamespaceDefaultValuesReconciler returns a reconciler with the team-a namespace mapped to the team defaults
+		newNamespaceDefaultValuesReconciler := func(objs ...client.Object) *FluxAppReconciler {
+			r := newDefaultValuesReconciler(objs...)
+			r.NamespaceDefaultValues = map[string]*DefaultValues{"team-a": NewDefaultValues(r.Client, teamKey)}
+			return r
+		}
+
+		It("should merge the namespace default values over the operator-level default values", func() {
+			app := newTestApp()
+			app.Namespace = "team-a"
+			r := newNamespaceDefaultValuesReconciler(
+				newDefaults("replicaCount: 1\nresources:\n  limits:\n    cpu: 100m\n    memory: 256Mi\n"),
+				newTeamDefaults("resources:\n  limits:\n    memory: 1Gi\nteam: a\n"),
+			)
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(helmValues(r, hr)).To(Equal(map[string]interface{}{
+				"replicaCount": float64(1),
+				"resources":    map[string]interface{}{"limits": map[string]interface{}{"cpu": "100m", "memory": "1Gi"}},
+				"team":         "a",
+			}))
+			Expect(conditions.Has(app, appsv1.DefaultValuesMissingCondition)).To(BeFalse())
+		})
+
+		It("should only use the default values of the app namespace", func() {
+			app := newTestApp()
+			r := newNamespaceDefaultValuesReconciler(newDefaults("replicaCount: 1\n"), newTeamDefaults("team: a\n"))
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			cm, err := getDefaultValues(r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cm.Data).To(Equal(map[string]string{defaultValuesKey: "replicaCount: 1\n"}))
+		})
+
+		It("should use the namespace default values without operator-level default values", func() {
+			app := newTestApp()
+			app.Namespace = "team-a"
+			r := newNamespaceDefaultValuesReconciler(newTeamDefaults("team: a\n"))
+			r.DefaultValues = nil
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			cm, err := getDefaultValues(r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cm.Data).To(Equal(map[string]string{defaultValuesKey: "team: a\n"}))
+			Expect(conditions.Has(app, appsv1.DefaultValuesMissingCondition)).To(BeFalse())
+		})
+
+		It("should deploy with the operator-level default values while the namespace ConfigMap is missing", func() {
+			app := newTestApp()
+			app.Namespace = "team-a"
+			r := newNamespaceDefaultValuesReconciler(newDefaults("replicaCount: 1\n"))
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			cm, err := getDefaultValues(r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cm.Data).To(Equal(map[string]string{defaultValuesKey: "replicaCount: 1\n"}))
+			Expect(conditions.IsTrue(app, appsv1.DefaultValuesMissingCondition)).To(BeTrue())
+			Expect(conditions.GetMessage(app, appsv1.DefaultValuesMissingCondition)).To(Equal("default values ConfigMap platform/team-a-defaults not found"))
+		})
+	})
+})
@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// checkDependencies fetches the FluxApps the app depends on
+// It returns the matching HelmRelease references along with a message describing
+// the first dependency which isn't ready, or an empty message if all are ready
+func checkDependencies(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) ([]meta.NamespacedObjectReference, string, error) {
+	var refs []meta.NamespacedObjectReference
+	var waiting string
+	for _, dep := range app.Spec.DependsOn {
+		key := types.NamespacedName{Name: dep.Name, Namespace: dep.Namespace}
+		if key.Namespace == "" {
+			key.Namespace = app.Namespace
+		}
+		// Default the HelmRelease name to the dependency name until we find the dependency
+		ref := meta.NamespacedObjectReference{Name: key.Name, Namespace: key.Namespace}
+		depApp := &appsv1.FluxApp{}
+		if err := r.Get(ctx, key, depApp); err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return nil, "", err
+			}
+			if waiting == "" {
+				waiting = fmt.Sprintf("dependency %s not found", key)
+			}
+		} else {
+			ref.Name = r.ResourceManager.HelmReleaseName(depApp)
+			if !conditions.IsReady(depApp) && waiting == "" {
+				waiting = fmt.Sprintf("dependency %s is not ready", key)
+			}
+		}
+		refs = append(refs, ref)
+	}
+	return refs, waiting, nil
+}
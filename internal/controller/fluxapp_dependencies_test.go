@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("Dependencies", func() {
+	ctx := context.Background()
+
+	var app, dep *appsv1.FluxApp
+
+	BeforeEach(func() {
+		app = newTestApp()
+		app.Spec.DependsOn = []meta.NamespacedObjectReference{{Name: "database"}}
+		dep = newTestApp()
+		dep.Name = "database"
+	})
+
+	It("should wait for a dependency that doesn't exist", func() {
+		r := newTestReconciler()
+		refs, waiting, err := checkDependencies(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(refs).To(Equal([]meta.NamespacedObjectReference{{Name: "database", Namespace: "default"}}))
+		Expect(waiting).To(Equal("dependency default/database not found"))
+	})
+
+	It("should wait for a dependency that isn't ready", func() {
+		conditions.MarkFalse(dep, meta.ReadyCondition, meta.ProgressingReason, "HelmRelease is not ready")
+		r := newTestReconciler(dep)
+		_, waiting, err := checkDependencies(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(waiting).To(Equal("dependency default/database is not ready"))
+	})
+
+	It("should not wait for a dependency that is ready", func() {
+		conditions.MarkTrue(dep, meta.ReadyCondition, meta.SucceededReason, "Helm install succeeded")
+		r := newTestReconciler(dep)
+		refs, waiting, err := checkDependencies(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(refs).To(Equal([]meta.NamespacedObjectReference{{Name: "database", Namespace: "default"}}))
+		Expect(waiting).To(BeEmpty())
+	})
+
+	It("should reference the HelmRelease of a dependency with a release name override", func() {
+		dep.Spec.ReleaseName = "postgres"
+		conditions.MarkTrue(dep, meta.ReadyCondition, meta.SucceededReason, "Helm install succeeded")
+		r := newTestReconciler(dep)
+		refs, _, err := checkDependencies(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(refs).To(Equal([]meta.NamespacedObjectReference{{Name: "postgres", Namespace: "default"}}))
+	})
+
+	It("should surface the dependency in the app status and the HelmRelease", func() {
+		r := newTestReconciler(dep)
+		Expect(handleHelmRelease(ctx, r, app)).To(MatchError(errRequeue))
+		Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.WaitingForDependencyReason))
+		Expect(conditions.GetMessage(app, meta.ReadyCondition)).To(ContainSubstring("default/database"))
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.DependsOn).To(Equal([]meta.NamespacedObjectReference{{Name: "database", Namespace: "default"}}))
+	})
+})
@@ -0,0 +1,24 @@
+package controller
+
+import (
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// descriptionAnnotation records the description of the app on its HelmReleases for inventory tooling
+const descriptionAnnotation = "apps.kloudy.uk/description"
+
+// setDescription sets the description annotation on the HelmRelease, removing it if the app has no description
+func setDescription(app *appsv1.FluxApp, hr *helmv2.HelmRelease) {
+	annotations := hr.GetAnnotations()
+	if app.Spec.Description == "" {
+		delete(annotations, descriptionAnnotation)
+		return
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[descriptionAnnotation] = app.Spec.Description
+	hr.SetAnnotations(annotations)
+}
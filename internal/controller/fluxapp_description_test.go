@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+var _ = Describe("Description", func() {
+	ctx := context.Background()
+
+	It("should add the description to the HelmRelease until it's removed", func() {
+		app := newTestApp()
+		app.Spec.Description = "Podinfo demo app owned by the platform team"
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Annotations).To(HaveKeyWithValue(descriptionAnnotation, "Podinfo demo app owned by the platform team"))
+
+		app.Spec.Description = ""
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err = getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Annotations).NotTo(HaveKey(descriptionAnnotation))
+		Expect(hr.Annotations).To(HaveKey(childrenVersionAnnotation))
+	})
+
+	It("should add the description to the canary HelmRelease", func() {
+		app := newTestApp()
+		app.Spec.Description = "Podinfo demo app"
+		app.Spec.Canary = &appsv1.Canary{Version: "6.6.0", Weight: 10}
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		canary := &helmv2.HelmRelease{}
+		key := types.NamespacedName{Name: r.ResourceManager.CanaryHelmReleaseName(app), Namespace: app.Namespace}
+		Expect(r.Get(ctx, key, canary)).To(Succeed())
+		Expect(canary.Annotations).To(HaveKeyWithValue(descriptionAnnotation, "Podinfo demo app"))
+	})
+})
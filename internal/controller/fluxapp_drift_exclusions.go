@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/fluxcd/pkg/apis/kustomize"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// driftExclusionPatch is the strategic merge patch annotating a rendered resource so helm-controller skips it
+// during drift detection
+// The kind & name are placeholders as kustomize applies a patch with a target to every resource it selects
+var driftExclusionPatch = fmt.Sprintf(`apiVersion: v1
+kind: DriftExclusion
+metadata:
+  name: drift-exclusion
+  annotations:
+    %s: %s
+`, helmv2.DriftDetectionMetadataKey, helmv2.DriftDetectionDisabledValue)
+
+// driftExclusionPostRenderers returns the HelmRelease post renderers excluding the resources selected by
+// spec.driftDetectionResourceExclusions from drift detection, or nil if none are selected
+// helm-controller only honours the annotation on the rendered manifests so it's added by a post renderer
+// rather than to the live resources
+func driftExclusionPostRenderers(app *appsv1.FluxApp) []helmv2.PostRenderer {
+	if len(app.Spec.DriftDetectionResourceExclusions) == 0 {
+		return nil
+	}
+	patches := make([]kustomize.Patch, len(app.Spec.DriftDetectionResourceExclusions))
+	for i := range app.Spec.DriftDetectionResourceExclusions {
+		target := app.Spec.DriftDetectionResourceExclusions[i]
+		patches[i] = kustomize.Patch{Patch: driftExclusionPatch, Target: &target}
+	}
+	return []helmv2.PostRenderer{{Kustomize: &helmv2.Kustomize{Patches: patches}}}
+}
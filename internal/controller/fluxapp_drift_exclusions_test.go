@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/kustomize"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+var _ = Describe("Drift detection resource exclusions", func() {
+	ctx := context.Background()
+
+	It("should annotate the selected resources to exclude them from drift detection", func() {
+		r := newTestReconciler()
+		app := newTestApp()
+		app.Spec.DriftDetectionResourceExclusions = []kustomize.Selector{
+			{Kind: "ConfigMap", Name: "podinfo-redis"},
+			{LabelSelector: "app.kubernetes.io/component=cache"},
+		}
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.PostRenderers).To(HaveLen(1))
+		patches := hr.Spec.PostRenderers[0].Kustomize.Patches
+		Expect(patches).To(HaveLen(2))
+		Expect(patches[0].Target).To(Equal(&kustomize.Selector{Kind: "ConfigMap", Name: "podinfo-redis"}))
+		Expect(patches[1].Target).To(Equal(&kustomize.Selector{LabelSelector: "app.kubernetes.io/component=cache"}))
+
+		// The patch only adds the annotation helm-controller honours
+		var patch metav1.PartialObjectMetadata
+		Expect(yaml.Unmarshal([]byte(patches[0].Patch), &patch)).To(Succeed())
+		Expect(patch.Annotations).To(Equal(map[string]string{
+			helmv2.DriftDetectionMetadataKey: helmv2.DriftDetectionDisabledValue,
+		}))
+		Expect(patch.Labels).To(BeEmpty())
+	})
+
+	It("should not add post renderers unless resources are excluded", func() {
+		r := newTestReconciler()
+		app := newTestApp()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.PostRenderers).To(BeEmpty())
+	})
+
+	It("should remove the post renderers once the exclusions are removed", func() {
+		r := newTestReconciler()
+		app := newTestApp()
+		app.Spec.DriftDetectionResourceExclusions = []kustomize.Selector{{Kind: "Secret"}}
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		app.Spec.DriftDetectionResourceExclusions = nil
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.PostRenderers).To(BeEmpty())
+	})
+
+	It("should not share the selectors with the app", func() {
+		app := newTestApp()
+		app.Spec.DriftDetectionResourceExclusions = []kustomize.Selector{{Kind: "Secret"}}
+		postRenderers := driftExclusionPostRenderers(app)
+		app.Spec.DriftDetectionResourceExclusions[0].Kind = "ConfigMap"
+		Expect(postRenderers[0].Kustomize.Patches[0].Target.Kind).To(Equal("Secret"))
+	})
+})
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// errInvalid is wrapped by errors caused by an invalid FluxApp spec
+// These won't resolve on retry so are classified as permanent
+var errInvalid = errors.New("invalid")
+
+// errNotAHelmChart is wrapped by errors caused by the chart repository containing artifacts which aren't Helm charts
+var errNotAHelmChart = fmt.Errorf("%w artifact, not a Helm chart", errInvalid)
+
+// errInvalidValues is wrapped by errors caused by inline values which aren't an object
+var errInvalidValues = fmt.Errorf("%w values", errInvalid)
+
+// errInvalidValuesTemplate is wrapped by errors caused by a values template which can't be rendered
+var errInvalidValuesTemplate = fmt.Errorf("%w valuesTemplate", errInvalid)
+
+// errInvalidValuesPatches is wrapped by errors caused by values patches which can't be applied
+var errInvalidValuesPatches = fmt.Errorf("%w valuesPatches", errInvalid)
+
+// errInvalidCustomResourceValues is wrapped by errors caused by custom resource values which don't match the CRD schema
+var errInvalidCustomResourceValues = fmt.Errorf("%w customResourceValues", errInvalid)
+
+// errNoMatchingTags is wrapped by errors caused by none of the chart tags matching the version constraint
+var errNoMatchingTags = errors.New("no chart tags match")
+
+// classifyError returns the ErrorType for a reconcile error
+func classifyError(err error) appsv1.ErrorType {
+	var urlErr *url.Error
+	switch {
+	case errors.Is(err, errInvalid):
+		return appsv1.ErrorTypePermanent
+	case errors.As(err, &urlErr):
+		// A URL which can't be parsed won't resolve on retry but a request which timed out may
+		if urlErr.Timeout() || urlErr.Temporary() {
+			return appsv1.ErrorTypeTransient
+		}
+		return appsv1.ErrorTypePermanent
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return appsv1.ErrorTypePermanent
+	default:
+		// Assume anything else (timeouts, conflicts, unavailable servers etc.) may resolve on retry
+		return appsv1.ErrorTypeTransient
+	}
+}
+
+// setLastError records the reconcile error in the app status
+// A nil error clears any previously recorded error, and an error repeating the recorded one keeps
+// the time it first occurred so the status doesn't change while the app keeps failing in the same way
+func setLastError(app *appsv1.FluxApp, err error) {
+	if err == nil {
+		app.Status.LastError = nil
+		return
+	}
+	lastError := &appsv1.LastError{
+		Type:    classifyError(err),
+		Message: err.Error(),
+		Time:    metav1.Now(),
+	}
+	if last := app.Status.LastError; last != nil && last.Type == lastError.Type && last.Message == lastError.Message {
+		lastError.Time = last.Time
+	}
+	app.Status.LastError = lastError
+}
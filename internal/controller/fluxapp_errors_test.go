@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("Error classification", func() {
+	gr := schema.GroupResource{Group: "helm.toolkit.fluxcd.io", Resource: "helmreleases"}
+
+	DescribeTable("classifyError",
+		func(err error, expected appsv1.ErrorType) {
+			Expect(classifyError(err)).To(Equal(expected))
+		},
+		Entry("invalid chart repository URL", fmt.Errorf("%w chart repository URL: %s", errInvalid, "ghcr.io/podinfo"), appsv1.ErrorTypePermanent),
+		Entry("unparseable URL", &url.Error{Op: "parse", URL: "oci://%", Err: errors.New("invalid URL escape")}, appsv1.ErrorTypePermanent),
+		Entry("request timeout", &url.Error{Op: "Get", URL: "https://ghcr.io/v2/", Err: context.DeadlineExceeded}, appsv1.ErrorTypeTransient),
+		Entry("temporary request failure", &url.Error{Op: "Get", URL: "https://ghcr.io/v2/", Err: temporaryError{}}, appsv1.ErrorTypeTransient),
+		Entry("invalid object", apierrors.NewInvalid(schema.GroupKind{Kind: "HelmRelease"}, "test", nil), appsv1.ErrorTypePermanent),
+		Entry("bad request", apierrors.NewBadRequest("bad"), appsv1.ErrorTypePermanent),
+		Entry("registry timeout", context.DeadlineExceeded, appsv1.ErrorTypeTransient),
+		Entry("server timeout", apierrors.NewServerTimeout(gr, "get", 1), appsv1.ErrorTypeTransient),
+		Entry("conflict", apierrors.NewConflict(gr, "test", errors.New("modified")), appsv1.ErrorTypeTransient),
+		Entry("unknown error", errors.New("boom"), appsv1.ErrorTypeTransient),
+	)
+
+	It("should record and clear the last error", func() {
+		app := &appsv1.FluxApp{}
+		setLastError(app, fmt.Errorf("%w image reference: %s", errInvalid, "podinfo"))
+		Expect(app.Status.LastError).NotTo(BeNil())
+		Expect(app.Status.LastError.Type).To(Equal(appsv1.ErrorTypePermanent))
+		Expect(app.Status.LastError.Message).To(Equal("invalid image reference: podinfo"))
+		Expect(app.Status.LastError.Time.IsZero()).To(BeFalse())
+
+		setLastError(app, nil)
+		Expect(app.Status.LastError).To(BeNil())
+	})
+
+	It("should keep the time of a repeated error", func() {
+		app := &appsv1.FluxApp{}
+		first := metav1.NewTime(time.Now().Add(-time.Hour))
+		app.Status.LastError = &appsv1.LastError{Type: appsv1.ErrorTypeTransient, Message: "boom", Time: first}
+		setLastError(app, errors.New("boom"))
+		Expect(app.Status.LastError.Time).To(Equal(first))
+
+		// A different error is recorded with the time it occurred
+		setLastError(app, errors.New("bang"))
+		Expect(app.Status.LastError.Message).To(Equal("bang"))
+		Expect(app.Status.LastError.Time.After(first.Time)).To(BeTrue())
+	})
+})
+
+// temporaryError is a network error which may resolve on retry
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "connection reset" }
+func (temporaryError) Temporary() bool { return true }
@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"regexp"
+	"slices"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// maxFailingResources limits the failing resources recorded so a broken chart can't bloat the app status
+const maxFailingResources = 20
+
+var (
+	// statusResourceRegexp matches the Kind/namespace/name of a resource reported by the status checks
+	// e.g. resource Deployment/default/podinfo not ready
+	statusResourceRegexp = regexp.MustCompile(`\b([A-Z][A-Za-z0-9]*)/([a-z0-9][a-z0-9.-]*)/([a-z0-9][a-z0-9.-]*)\b`)
+	// invalidResourceRegexp matches the kind & name of a resource rejected by the API server
+	// e.g. Deployment.apps "podinfo" is invalid
+	invalidResourceRegexp = regexp.MustCompile(`\b([A-Z][A-Za-z0-9]*)(?:\.[a-z0-9.-]+)? "([^"]+)" is (?:invalid|forbidden)`)
+)
+
+// failingResources returns the resources the HelmRelease reports as failing, in the order they're reported
+// The HelmRelease status doesn't list its resources so they're parsed from the messages of its failed conditions
+// Nothing is returned while the HelmRelease is ready
+func failingResources(hr *helmv2.HelmRelease) []string {
+	if conditions.IsReady(hr) {
+		return nil
+	}
+	var resources []string
+	add := func(resource string) {
+		if !slices.Contains(resources, resource) && len(resources) < maxFailingResources {
+			resources = append(resources, resource)
+		}
+	}
+	for _, t := range []string{meta.ReadyCondition, helmv2.ReleasedCondition, helmv2.RemediatedCondition, helmv2.TestSuccessCondition} {
+		c := conditions.Get(hr, t)
+		if c == nil || c.Status != metav1.ConditionFalse {
+			continue
+		}
+		for _, m := range statusResourceRegexp.FindAllStringSubmatch(c.Message, -1) {
+			add(m[1] + "/" + m[2] + "/" + m[3])
+		}
+		for _, m := range invalidResourceRegexp.FindAllStringSubmatch(c.Message, -1) {
+			add(m[1] + "/" + m[2])
+		}
+	}
+	return resources
+}
@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+var _ = Describe("Failing resources", func() {
+	ctx := context.Background()
+
+	// newHelmRelease returns a HelmRelease with the conditions
+	newHelmRelease := func(conds ...metav1.Condition) *helmv2.HelmRelease {
+		hr := &helmv2.HelmRelease{ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "default"}}
+		hr.Status.Conditions = conds
+		return hr
+	}
+
+	failed := func(t, reason, message string) metav1.Condition {
+		return metav1.Condition{Type: t, Status: metav1.ConditionFalse, Reason: reason, Message: message}
+	}
+
+	DescribeTable("should parse the failing resources from the HelmRelease conditions",
+		func(message string, expected []string) {
+			hr := newHelmRelease(failed(meta.ReadyCondition, "UpgradeFailed", message))
+			Expect(failingResources(hr)).To(Equal(expected))
+		},
+		Entry("resource not ready",
+			"Helm upgrade failed for release default/podinfo with chart podinfo@6.5.4: resource Deployment/default/podinfo not ready. status: InProgress, message: Available: 0/1",
+			[]string{"Deployment/default/podinfo"}),
+		Entry("several resources timing out",
+			"Helm install failed for release default/podinfo with chart podinfo@6.5.3: timeout waiting for: [Deployment/default/podinfo status: 'InProgress', Job/default/podinfo-migrate status: 'Failed']",
+			[]string{"Deployment/default/podinfo", "Job/default/podinfo-migrate"}),
+		Entry("resource rejected by the API server",
+			`Helm upgrade failed for release default/podinfo with chart podinfo@6.5.4: cannot patch "podinfo" with kind Deployment: Deployment.apps "podinfo" is invalid: spec.selector: Invalid value`,
+			[]string{"Deployment/podinfo"}),
+		Entry("no resources reported",
+			"Helm install failed for release default/podinfo with chart podinfo@6.5.3: context deadline exceeded",
+			nil),
+	)
+
+	It("should combine the failed conditions without duplicates", func() {
+		hr := newHelmRelease(
+			failed(meta.ReadyCondition, "UpgradeFailed", "resource Deployment/default/podinfo not ready"),
+			failed(helmv2.ReleasedCondition, "UpgradeFailed", "resource Deployment/default/podinfo not ready"),
+			failed(helmv2.TestSuccessCondition, "TestFailed", "test hook Pod/default/podinfo-grpc-test failed"),
+		)
+		Expect(failingResources(hr)).To(Equal([]string{"Deployment/default/podinfo", "Pod/default/podinfo-grpc-test"}))
+	})
+
+	It("should ignore conditions which haven't failed", func() {
+		hr := newHelmRelease(
+			failed(meta.ReadyCondition, "UpgradeFailed", "Helm upgrade failed"),
+			metav1.Condition{Type: helmv2.RemediatedCondition, Status: metav1.ConditionTrue, Reason: "RollbackSucceeded",
+				Message: "Helm rollback to previous release default/podinfo.v1 with chart podinfo@6.5.3 succeeded, Deployment/default/podinfo"},
+		)
+		Expect(failingResources(hr)).To(BeEmpty())
+	})
+
+	It("should report nothing while the HelmRelease is ready", func() {
+		hr := newHelmRelease(
+			metav1.Condition{Type: meta.ReadyCondition, Status: metav1.ConditionTrue, Reason: "UpgradeSucceeded"},
+			failed(helmv2.TestSuccessCondition, "TestFailed", "test hook Pod/default/podinfo-grpc-test failed"),
+		)
+		Expect(failingResources(hr)).To(BeEmpty())
+	})
+
+	It("should limit the failing resources", func() {
+		message := "timeout waiting for: ["
+		for i := 0; i < maxFailingResources+5; i++ {
+			message += fmt.Sprintf("Deployment/default/podinfo-%d status: 'InProgress', ", i)
+		}
+		hr := newHelmRelease(failed(meta.ReadyCondition, "InstallFailed", message+"]"))
+		Expect(failingResources(hr)).To(HaveLen(maxFailingResources))
+	})
+
+	It("should add the failing resources to the app status until the HelmRelease is ready", func() {
+		app := newTestApp()
+		hr := newHelmRelease(failed(meta.ReadyCondition, "UpgradeFailed", "resource Deployment/default/podinfo not ready"))
+		r := newTestReconciler(hr)
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(app.Status.FailingResources).To(Equal([]string{"Deployment/default/podinfo"}))
+
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		conditions.MarkTrue(hr, meta.ReadyCondition, "UpgradeSucceeded", "Helm upgrade succeeded")
+		Expect(r.Update(ctx, hr)).To(Succeed())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(app.Status.FailingResources).To(BeEmpty())
+	})
+})
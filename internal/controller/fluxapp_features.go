@@ -0,0 +1,25 @@
+package controller
+
+import (
+	"strings"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// featuresAnnotation enables experimental behaviour for a single FluxApp
+// The value is a comma separated list of feature gates e.g. "parallelHandlers,ssa"
+const featuresAnnotation = "apps.kloudy.uk/features"
+
+// featureParallelHandlers reconciles the ImagePolicy and HelmRepository concurrently
+const featureParallelHandlers = "parallelHandlers"
+
+// featureEnabled returns true if the feature gate is enabled on the app
+// Unknown gates are ignored so apps can be annotated ahead of a controller upgrade
+func featureEnabled(app *appsv1.FluxApp, feature string) bool {
+	for _, f := range strings.Split(app.GetAnnotations()[featuresAnnotation], ",") {
+		if strings.TrimSpace(f) == feature {
+			return true
+		}
+	}
+	return false
+}
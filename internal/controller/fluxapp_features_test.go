@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+)
+
+var _ = Describe("Features", func() {
+	ctx := context.Background()
+
+	DescribeTable("featureEnabled",
+		func(annotations map[string]string, expected bool) {
+			app := newTestApp()
+			app.Annotations = annotations
+			Expect(featureEnabled(app, featureParallelHandlers)).To(Equal(expected))
+		},
+		Entry("no annotations", nil, false),
+		Entry("empty annotation", map[string]string{featuresAnnotation: ""}, false),
+		Entry("single gate", map[string]string{featuresAnnotation: "parallelHandlers"}, true),
+		Entry("multiple gates", map[string]string{featuresAnnotation: "ssa,parallelHandlers"}, true),
+		Entry("gates with whitespace", map[string]string{featuresAnnotation: "ssa, parallelHandlers "}, true),
+		Entry("other gates only", map[string]string{featuresAnnotation: "ssa"}, false),
+		Entry("gates are case sensitive", map[string]string{featuresAnnotation: "parallelhandlers"}, false),
+	)
+
+	Context("parallelHandlers", func() {
+		// An ImagePolicy waiting for a matching chart version
+		waitingPolicy := func() *imagev1.ImagePolicy {
+			policy := &imagev1.ImagePolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: "default"},
+			}
+			conditions.MarkFalse(policy, meta.ReadyCondition, meta.ReconciliationFailedReason,
+				"unable to determine latest version from provided list")
+			return policy
+		}
+
+		helmRepositoryExists := func(r *FluxAppReconciler) bool {
+			repo := &sourcev1.HelmRepository{}
+			key := types.NamespacedName{Name: "ghcr-io-stefanprodan-charts", Namespace: "default"}
+			return r.Get(ctx, key, repo) == nil
+		}
+
+		It("should handle the HelmRepository after the ImagePolicy when disabled", func() {
+			app := newTestApp()
+			r := newTestReconciler(waitingPolicy())
+			policyErr, repoErr := handleSources(ctx, r, app)
+			Expect(policyErr).To(MatchError(errRequeue))
+			Expect(repoErr).NotTo(HaveOccurred())
+			Expect(helmRepositoryExists(r)).To(BeFalse())
+		})
+
+		It("should handle the HelmRepository alongside the ImagePolicy when enabled", func() {
+			app := newTestApp()
+			app.Annotations = map[string]string{featuresAnnotation: featureParallelHandlers}
+			r := newTestReconciler(waitingPolicy())
+			policyErr, repoErr := handleSources(ctx, r, app)
+			Expect(policyErr).To(MatchError(errRequeue))
+			Expect(repoErr).NotTo(HaveOccurred())
+			Expect(helmRepositoryExists(r)).To(BeTrue())
+		})
+
+		It("should keep the status written by both handlers when enabled", func() {
+			app := newTestApp()
+			app.Annotations = map[string]string{featuresAnnotation: featureParallelHandlers}
+			repo := &sourcev1.HelmRepository{
+				ObjectMeta: metav1.ObjectMeta{Name: "ghcr-io-stefanprodan-charts", Namespace: "default"},
+				Spec:       sourcev1.HelmRepositorySpec{Type: sourcev1.HelmRepositoryTypeOCI},
+			}
+			r := newTestReconciler(waitingPolicy(), repo)
+			policyErr, repoErr := handleSources(ctx, r, app)
+			Expect(policyErr).To(MatchError(errRequeue))
+			Expect(repoErr).NotTo(HaveOccurred())
+			Expect(conditions.IsFalse(app, appsv1.ImagePolicyReadyCondition)).To(BeTrue())
+			Expect(conditions.IsTrue(app, appsv1.HelmRepositoryReadyCondition)).To(BeTrue())
+			// Ready is aggregated from both so the failing ImagePolicy isn't hidden by the ready HelmRepository
+			Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+			Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(conditions.GetReason(app, appsv1.ImagePolicyReadyCondition)))
+		})
+	})
+})
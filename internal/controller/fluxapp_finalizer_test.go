@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("Finalizer", func() {
+	ctx := context.Background()
+
+	// reconcileApp reconciles the app & returns it as stored
+	reconcileApp := func(r *FluxAppReconciler, app *appsv1.FluxApp) *appsv1.FluxApp {
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).NotTo(HaveOccurred())
+		updated := &appsv1.FluxApp{}
+		Expect(r.Get(ctx, client.ObjectKeyFromObject(app), updated)).To(Succeed())
+		return updated
+	}
+
+	It("should add the finalizer by default", func() {
+		app := newTestApp()
+		r := newTestReconciler(app)
+		Expect(reconcileApp(r, app).Finalizers).To(ConsistOf(finalizer))
+	})
+
+	It("should not add the finalizer when disabled", func() {
+		app := newTestApp()
+		r := newTestReconciler(app)
+		r.DisableFinalizer = true
+		updated := reconcileApp(r, app)
+		Expect(updated.Finalizers).To(BeEmpty())
+		// The children are still owned by the app for the garbage collector
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.OwnerReferences).To(HaveLen(1))
+
+		// The app is deleted straight away
+		Expect(r.Delete(ctx, updated)).To(Succeed())
+		Expect(apierrors.IsNotFound(r.Get(ctx, client.ObjectKeyFromObject(app), &appsv1.FluxApp{}))).To(BeTrue())
+	})
+
+	It("should remove the existing finalizer once disabled", func() {
+		app := newTestApp()
+		app.Finalizers = []string{finalizer, "example.com/keep"}
+		r := newTestReconciler(app)
+		r.DisableFinalizer = true
+		Expect(reconcileApp(r, app).Finalizers).To(ConsistOf("example.com/keep"))
+	})
+})
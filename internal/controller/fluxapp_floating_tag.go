@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	mmsemver "github.com/Masterminds/semver/v3"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	corev1 "k8s.io/api/core/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+)
+
+// chartDigestAnnotation records the digest of the floating tag the HelmRelease last deployed
+const chartDigestAnnotation = "apps.kloudy.uk/chart-digest"
+
+// chartDigestChangedReason is the reason of the event emitted when the floating tag moves to a new digest
+const chartDigestChangedReason = "ChartDigestChanged"
+
+// tagPattern matches a valid OCI tag
+var tagPattern = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
+// floatingTag returns true if the chart version is a tag which isn't a version or constraint e.g. stable
+// The tag can be moved to another chart artifact so it's tracked by its digest rather than an ImagePolicy
+func floatingTag(app *appsv1.FluxApp) bool {
+	version := app.Spec.Chart.Version
+	if gitSource(app) || version == "" || !tagPattern.MatchString(version) {
+		return false
+	}
+	_, err := mmsemver.NewConstraint(version)
+	return err != nil
+}
+
+// handleFloatingTag resolves the digest of the floating tag, used instead of the ImagePolicy
+// The tag is deployed as the chart version and the HelmRelease is upgraded when the digest changes
+func handleFloatingTag(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) error {
+	// Remove the ImagePolicy left over from when the chart version was a constraint
+	mr, err := r.ResourceManager.Get(ctx, app, imagev1.ImagePolicyKind)
+	if err != nil {
+		return err
+	}
+	if err := r.ResourceManager.Delete(ctx, mr); err != nil {
+		return err
+	}
+	conditions.Delete(app, appsv1.ImagePolicyReadyCondition)
+	if r.ChartDigests == nil {
+		return fmt.Errorf("%w: chart version %q isn't a SemVer version or constraint", errInvalid, app.Spec.Chart.Version)
+	}
+	app.Status.Chart.Version = app.Spec.Chart.Version
+	// The tag isn't a version so it can't be compared with the applied version
+	app.Status.Chart.AvailableVersion = ""
+	// Keep the last resolved digest while version updates are paused or image automation is suspended
+	if (app.Spec.PauseVersionUpdates || app.Spec.SuspendImageAutomation) && app.Status.Chart.Digest != "" {
+		markVersionUpdatesPaused(app, appsv1.PausedVersionUpdatesReason,
+			"version updates are paused at %s@%s", app.Status.Chart.Version, app.Status.Chart.Digest)
+		return nil
+	}
+	conditions.Delete(app, appsv1.VersionUpdatesPausedCondition)
+	tag := chartTag(app)
+	digest, err := r.ChartDigests.ChartDigest(ctx, app.Status.Chart.Repository, app.Status.Chart.Name, tag)
+	if err != nil {
+		return fmt.Errorf("unable to resolve the digest of %s:%s: %w", app.Status.Chart.Name, tag, err)
+	}
+	app.Status.Chart.Digest = digest
+	return nil
+}
+
+// trackChartDigest records the digest of the floating tag on the HelmRelease
+// The chart version doesn't change when the tag is moved, so when the digest changes the HelmChart
+// is asked to pull the tag again and the HelmRelease is forced to upgrade
+func trackChartDigest(r *FluxAppReconciler, app *appsv1.FluxApp, hr *helmv2.HelmRelease) {
+	digest := app.Status.Chart.Digest
+	annotations := hr.GetAnnotations()
+	if !floatingTag(app) || digest == "" {
+		delete(annotations, chartDigestAnnotation)
+		hr.SetAnnotations(annotations)
+		return
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	previous := annotations[chartDigestAnnotation]
+	annotations[chartDigestAnnotation] = digest
+	hr.SetAnnotations(annotations)
+	if previous != "" && previous != digest {
+		requestForcedUpgrade(hr, digest)
+		if r.Recorder != nil {
+			r.Recorder.Eventf(app, corev1.EventTypeNormal, chartDigestChangedReason,
+				"chart %s:%s moved from %s to %s", app.Status.Chart.Name, app.Status.Chart.Version, previous, digest)
+		}
+	}
+	// The HelmChart only pulls the chart again when it's asked to reconcile
+	if requested := hr.GetAnnotations()[meta.ReconcileRequestAnnotation]; requested != "" && hr.Spec.Chart != nil {
+		hr.Spec.Chart.ObjectMeta = &helmv2.HelmChartTemplateObjectMeta{
+			Annotations: map[string]string{meta.ReconcileRequestAnnotation: requested},
+		}
+	}
+}
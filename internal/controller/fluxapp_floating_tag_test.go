@@ -0,0 +1,191 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+)
+
+// fakeChartDigests resolves every tag to the digest
+type fakeChartDigests struct {
+	digest string
+	err    error
+	tag    string
+}
+
+func (f *fakeChartDigests) ChartDigest(_ context.Context, _, _, tag string) (string, error) {
+	f.tag = tag
+	return f.digest, f.err
+}
+
+var _ = Describe("Floating tag", func() {
+	ctx := context.Background()
+	const (
+		digest    = "sha256:0b1e4d3f"
+		newDigest = "sha256:9c8f7a6e"
+	)
+
+	var (
+		r       *FluxAppReconciler
+		digests *fakeChartDigests
+	)
+
+	BeforeEach(func() {
+		r = newTestReconciler()
+		digests = &fakeChartDigests{digest: digest}
+		r.ChartDigests = digests
+	})
+
+	newFloatingApp := func() *appsv1.FluxApp {
+		app := newTestApp()
+		app.Spec.Chart.Version = "stable"
+		app.Status.Chart.Version = ""
+		return app
+	}
+
+	// deploy handles the sources & HelmRelease of the app, returning the HelmRelease
+	deploy := func(app *appsv1.FluxApp) *helmv2.HelmRelease {
+		policyErr, repoErr := handleSources(ctx, r, app)
+		Expect(policyErr).NotTo(HaveOccurred())
+		Expect(repoErr).NotTo(HaveOccurred())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		return hr
+	}
+
+	DescribeTable("should detect floating tags",
+		func(version string, sourceKind string, floating bool) {
+			app := newTestApp()
+			app.Spec.Chart.Version = version
+			app.Spec.SourceKind = sourceKind
+			Expect(floatingTag(app)).To(Equal(floating))
+		},
+		Entry("named tag", "stable", "", true),
+		Entry("latest", "latest", "", true),
+		Entry("exact version", "6.5.3", "", false),
+		Entry("constraint", ">=6.5.0", "", false),
+		Entry("wildcard", "6.x", "", false),
+		Entry("any version", "*", "", false),
+		Entry("invalid tag", "not a tag", "", false),
+		Entry("git source", "stable", sourcev1.GitRepositoryKind, false),
+	)
+
+	It("should deploy the tag & record its digest instead of creating an ImagePolicy", func() {
+		app := newFloatingApp()
+		hr := deploy(app)
+		Expect(digests.tag).To(Equal("stable"))
+		Expect(app.Status.Chart.Version).To(Equal("stable"))
+		Expect(app.Status.Chart.Digest).To(Equal(digest))
+		Expect(hr.Spec.Chart.Spec.Version).To(Equal("stable"))
+		Expect(hr.Annotations).To(HaveKeyWithValue(chartDigestAnnotation, digest))
+		// Nothing is forced on the first release
+		Expect(hr.Annotations).NotTo(HaveKey(helmv2.ForceRequestAnnotation))
+		Expect(hr.Spec.Chart.ObjectMeta).To(BeNil())
+		err := r.Get(ctx, types.NamespacedName{Name: r.ResourceManager.ImagePolicyName(app), Namespace: app.Namespace}, &imagev1.ImagePolicy{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should upgrade the release when the digest of the tag changes", func() {
+		recorder := record.NewFakeRecorder(10)
+		r.Recorder = recorder
+		app := newFloatingApp()
+		deploy(app)
+
+		digests.digest = newDigest
+		hr := deploy(app)
+		Expect(app.Status.Chart.Digest).To(Equal(newDigest))
+		Expect(hr.Spec.Chart.Spec.Version).To(Equal("stable"))
+		Expect(hr.Annotations).To(HaveKeyWithValue(chartDigestAnnotation, newDigest))
+		Expect(hr.Annotations).To(HaveKeyWithValue(helmv2.ForceRequestAnnotation, newDigest))
+		Expect(hr.Annotations).To(HaveKeyWithValue(meta.ReconcileRequestAnnotation, newDigest))
+		Expect(hr.Spec.Chart.ObjectMeta).NotTo(BeNil())
+		Expect(hr.Spec.Chart.ObjectMeta.Annotations).To(HaveKeyWithValue(meta.ReconcileRequestAnnotation, newDigest))
+		Expect(recorder.Events).To(Receive(ContainSubstring(chartDigestChangedReason)))
+
+		// The upgrade isn't forced again until the digest changes
+		hr = deploy(app)
+		Expect(hr.Annotations).To(HaveKeyWithValue(helmv2.ForceRequestAnnotation, newDigest))
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("should keep the digest while version updates are paused", func() {
+		app := newFloatingApp()
+		deploy(app)
+		app.Spec.PauseVersionUpdates = true
+		digests.digest = newDigest
+		hr := deploy(app)
+		Expect(app.Status.Chart.Digest).To(Equal(digest))
+		Expect(hr.Annotations).NotTo(HaveKey(helmv2.ForceRequestAnnotation))
+		Expect(conditions.IsTrue(app, appsv1.VersionUpdatesPausedCondition)).To(BeTrue())
+	})
+
+	It("should delete the ImagePolicy left over from a version constraint", func() {
+		app := newTestApp()
+		Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+		app.Spec.Chart.Version = "stable"
+		deploy(app)
+		err := r.Get(ctx, types.NamespacedName{Name: r.ResourceManager.ImagePolicyName(app), Namespace: app.Namespace}, &imagev1.ImagePolicy{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(conditions.Has(app, appsv1.ImagePolicyReadyCondition)).To(BeFalse())
+	})
+
+	It("should return the error if the digest can't be resolved", func() {
+		digests.err = errors.New("registry unavailable")
+		policyErr, _ := handleSources(ctx, r, newFloatingApp())
+		Expect(policyErr).To(MatchError(ContainSubstring("registry unavailable")))
+	})
+
+	It("should reject a floating tag unless a resolver is configured", func() {
+		r.ChartDigests = nil
+		policyErr, _ := handleSources(ctx, r, newFloatingApp())
+		Expect(policyErr).To(MatchError(errInvalid))
+	})
+
+	It("should never be converged", func() {
+		app := newFloatingApp()
+		app.Generation = 1
+		app.Status.ObservedGeneration = 1
+		conditions.MarkTrue(app, meta.ReadyCondition, meta.SucceededReason, "deployed")
+		ok, err := converged(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeFalse())
+	})
+
+	Context("RegistryClient", func() {
+		It("should resolve the digest of the tag", func() {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				Expect(req.Method).To(Equal(http.MethodHead))
+				if req.URL.Path != "/v2/charts/podinfo/manifests/stable" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Header().Set("Docker-Content-Digest", digest)
+			}))
+			DeferCleanup(server.Close)
+			repository := "oci://" + strings.TrimPrefix(server.URL, "https://") + "/charts"
+			c := NewRegistryClient(server.Client())
+			resolved, err := c.ChartDigest(ctx, repository, "podinfo", "stable")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resolved).To(Equal(digest))
+			_, err = c.ChartDigest(ctx, repository, "podinfo", "edge")
+			Expect(err).To(MatchError(ContainSubstring("404")))
+		})
+	})
+})
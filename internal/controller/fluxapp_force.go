@@ -0,0 +1,32 @@
+package controller
+
+import (
+	"github.com/fluxcd/pkg/apis/meta"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// pendingForceRequest returns the token of the reconcile.fluxcd.io/forceAt annotation on the app
+// if it hasn't been passed to the HelmRelease yet, so each request only forces one upgrade
+func pendingForceRequest(app *appsv1.FluxApp) string {
+	token := app.GetAnnotations()[helmv2.ForceRequestAnnotation]
+	if token == app.Status.LastHandledForceAt {
+		return ""
+	}
+	return token
+}
+
+// requestForcedUpgrade asks the helm-controller for a one-off forced upgrade of the HelmRelease
+// e.g. to re-run the chart hooks without changing the chart version or values
+// The helm-controller only forces the upgrade when the forceAt & requestedAt tokens match
+func requestForcedUpgrade(hr *helmv2.HelmRelease, token string) {
+	annotations := hr.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[meta.ReconcileRequestAnnotation] = token
+	annotations[helmv2.ForceRequestAnnotation] = token
+	hr.SetAnnotations(annotations)
+}
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+var _ = Describe("Force upgrade", func() {
+	ctx := context.Background()
+
+	It("should set the force annotation on the HelmRelease when present on the app", func() {
+		app := newTestApp()
+		app.Annotations = map[string]string{helmv2.ForceRequestAnnotation: "2024-06-01T10:00:00Z"}
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Annotations).To(HaveKeyWithValue(helmv2.ForceRequestAnnotation, "2024-06-01T10:00:00Z"))
+		// The helm-controller only forces the upgrade when the requestedAt token matches
+		Expect(hr.Annotations).To(HaveKeyWithValue(meta.ReconcileRequestAnnotation, "2024-06-01T10:00:00Z"))
+		Expect(app.Status.LastHandledForceAt).To(Equal("2024-06-01T10:00:00Z"))
+	})
+
+	It("should only pass each token on once", func() {
+		app := newTestApp()
+		app.Annotations = map[string]string{helmv2.ForceRequestAnnotation: "1"}
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(pendingForceRequest(app)).To(BeEmpty())
+
+		// e.g. flux reconcile requests another reconcile of the HelmRelease
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		hr.Annotations[meta.ReconcileRequestAnnotation] = "2"
+		Expect(r.Update(ctx, hr)).To(Succeed())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err = getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Annotations).To(HaveKeyWithValue(meta.ReconcileRequestAnnotation, "2"))
+
+		app.Annotations[helmv2.ForceRequestAnnotation] = "3"
+		Expect(pendingForceRequest(app)).To(Equal("3"))
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err = getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Annotations).To(HaveKeyWithValue(helmv2.ForceRequestAnnotation, "3"))
+		Expect(hr.Annotations).To(HaveKeyWithValue(meta.ReconcileRequestAnnotation, "3"))
+	})
+
+	It("should not force an upgrade without the annotation", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Annotations).NotTo(HaveKey(helmv2.ForceRequestAnnotation))
+		Expect(app.Status.LastHandledForceAt).To(BeEmpty())
+	})
+})
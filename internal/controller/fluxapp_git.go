@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/fluxcd/pkg/runtime/conditions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+)
+
+// gitChartVersion is the chart version used for charts in a Git repository
+// The helm-controller ignores the version for Git sources and uses the version in Chart.yaml
+const gitChartVersion = "*"
+
+// gitSource returns true if the chart is pulled from a Git repository
+func gitSource(app *appsv1.FluxApp) bool {
+	return app.Spec.SourceKind == sourcev1.GitRepositoryKind
+}
+
+// chartSourceKind returns the kind of the source the HelmRelease chart is pulled from
+func chartSourceKind(app *appsv1.FluxApp) string {
+	if gitSource(app) {
+		return sourcev1.GitRepositoryKind
+	}
+	return sourcev1.HelmRepositoryKind
+}
+
+// Handle Flux GitRepository object
+func handleGitRepository(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) error {
+	git := app.Spec.Chart.Git
+	if git == nil || git.Path == "" {
+		return fmt.Errorf("%w: chart.git.path is required when the source kind is %s", errInvalid, sourcev1.GitRepositoryKind)
+	}
+	if strings.HasPrefix(app.Spec.Chart.Repository, "oci://") {
+		return fmt.Errorf("%w: %s is not a Git repository URL", errInvalid, app.Spec.Chart.Repository)
+	}
+	// Remove the OCI sources left over from when the chart was pulled from a registry
+	if err := deleteOCISources(ctx, r, app); err != nil {
+		return err
+	}
+	// Get the GitRepository managed resource
+	mr, err := r.ResourceManager.Get(ctx, app, sourcev1.GitRepositoryKind)
+	if err != nil {
+		return err
+	}
+	gitRepository := mr.Object.(*sourcev1.GitRepository)
+	// Update the spec
+	gitRepository.Spec = sourcev1.GitRepositorySpec{
+		URL:       app.Spec.Chart.Repository,
+		Reference: git.Ref.DeepCopy(),
+		SecretRef: git.SecretRef.DeepCopy(),
+		Interval:  metav1.Duration{Duration: r.childInterval(app, scanInterval(app).Duration)},
+	}
+	if gitRepository.Spec.SecretRef == nil {
+		gitRepository.Spec.SecretRef = chartAuthRef(r, app)
+	}
+	mergeLabels(gitRepository, app.Spec.Chart.RepositoryLabels)
+	// Set the app chart status based on the GitRepository object
+	app.Status.Chart.Repository = gitRepository.Spec.URL
+	app.Status.Chart.Name = git.Path
+	app.Status.Chart.Version = gitChartVersion
+	if artifact := gitRepository.Status.Artifact; artifact != nil && artifact.Revision != "" {
+		app.Status.Chart.SourceRevision = artifact.Revision
+	}
+	mirrorChildReady(app, appsv1.GitRepositoryReadyCondition, sourcev1.GitRepositoryKind, gitRepository)
+	// Update the resource
+	return r.ResourceManager.Update(ctx, mr)
+}
+
+// deleteOCISources deletes the ImageRepository, ImagePolicy & HelmRepository used to pull the chart from a registry
+func deleteOCISources(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) error {
+	kinds := []string{imagev1.ImageRepositoryKind, imagev1.ImagePolicyKind}
+	// The HelmRepository name is derived from the registry URL in the status
+	// so only look for one if the status still refers to a registry
+	if strings.HasPrefix(app.Status.Chart.Repository, "oci://") {
+		kinds = append(kinds, sourcev1.HelmRepositoryKind)
+	}
+	for _, kind := range kinds {
+		mr, err := r.ResourceManager.Get(ctx, app, kind)
+		if err != nil {
+			return err
+		}
+		if err := r.ResourceManager.Delete(ctx, mr); err != nil {
+			return err
+		}
+	}
+	conditions.Delete(app, appsv1.ImageRepositoryReadyCondition)
+	conditions.Delete(app, appsv1.ImagePolicyReadyCondition)
+	conditions.Delete(app, appsv1.HelmRepositoryReadyCondition)
+	return nil
+}
+
+// deleteGitRepository deletes the GitRepository used to pull the chart from a Git repository
+func deleteGitRepository(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) error {
+	mr, err := r.ResourceManager.Get(ctx, app, sourcev1.GitRepositoryKind)
+	if err != nil {
+		return err
+	}
+	conditions.Delete(app, appsv1.GitRepositoryReadyCondition)
+	return r.ResourceManager.Delete(ctx, mr)
+}
@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+)
+
+var _ = Describe("Git source", func() {
+	ctx := context.Background()
+
+	newGitApp := func() *appsv1.FluxApp {
+		app := newTestApp()
+		app.Spec.SourceKind = sourcev1.GitRepositoryKind
+		app.Spec.Chart.Repository = "https://github.com/stefanprodan/podinfo"
+		app.Spec.Chart.Git = &appsv1.GitChart{
+			Path:      "charts/podinfo",
+			Ref:       &sourcev1.GitRepositoryRef{Tag: "6.5.3"},
+			SecretRef: &meta.LocalObjectReference{Name: "git-credentials"},
+		}
+		return app
+	}
+
+	getGitRepository := func(r *FluxAppReconciler, app *appsv1.FluxApp) (*sourcev1.GitRepository, error) {
+		repo := &sourcev1.GitRepository{}
+		key := types.NamespacedName{Name: r.ResourceManager.GitRepositoryName(app), Namespace: app.Namespace}
+		return repo, r.Get(ctx, key, repo)
+	}
+
+	It("should generate the GitRepository from the chart", func() {
+		app := newGitApp()
+		app.Spec.Chart.ScanInterval = &metav1.Duration{Duration: 5 * time.Minute}
+		r := newTestReconciler()
+		Expect(handleGitRepository(ctx, r, app)).To(Succeed())
+		repo, err := getGitRepository(r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(repo.Spec.URL).To(Equal("https://github.com/stefanprodan/podinfo"))
+		Expect(repo.Spec.Reference).To(Equal(&sourcev1.GitRepositoryRef{Tag: "6.5.3"}))
+		Expect(repo.Spec.SecretRef).To(Equal(&meta.LocalObjectReference{Name: "git-credentials"}))
+		Expect(repo.Spec.Interval.Duration).To(Equal(5 * time.Minute))
+		Expect(repo.OwnerReferences).To(HaveLen(1))
+		Expect(app.Status.Chart.Repository).To(Equal("https://github.com/stefanprodan/podinfo"))
+		Expect(app.Status.Chart.Name).To(Equal("charts/podinfo"))
+	})
+
+	It("should reference the chart path in the GitRepository from the HelmRelease", func() {
+		app := newGitApp()
+		r := newTestReconciler()
+		Expect(handleGitRepository(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Chart.Spec.Chart).To(Equal("charts/podinfo"))
+		Expect(hr.Spec.Chart.Spec.Version).To(Equal(gitChartVersion))
+		Expect(hr.Spec.Chart.Spec.SourceRef.Kind).To(Equal(sourcev1.GitRepositoryKind))
+		Expect(hr.Spec.Chart.Spec.SourceRef.Name).To(Equal("podinfo-chart"))
+	})
+
+	It("should mirror the GitRepository Ready condition", func() {
+		app := newGitApp()
+		repo := &sourcev1.GitRepository{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace},
+			Status: sourcev1.GitRepositoryStatus{
+				Artifact: &sourcev1.Artifact{Revision: "6.5.3@sha1:abc123"},
+			},
+		}
+		conditions.MarkFalse(repo, meta.ReadyCondition, "GitOperationFailed", "authentication required")
+		r := newTestReconciler(repo)
+		Expect(handleGitRepository(ctx, r, app)).To(Succeed())
+		Expect(app.Status.Chart.SourceRevision).To(Equal("6.5.3@sha1:abc123"))
+		Expect(conditions.IsFalse(app, appsv1.GitRepositoryReadyCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal("GitOperationFailed"))
+	})
+
+	It("should reject a chart without a path", func() {
+		app := newGitApp()
+		app.Spec.Chart.Git = nil
+		r := newTestReconciler()
+		Expect(handleGitRepository(ctx, r, app)).To(MatchError(errInvalid))
+	})
+
+	It("should reject an OCI repository", func() {
+		app := newGitApp()
+		app.Spec.Chart.Repository = "oci://ghcr.io/stefanprodan/charts/podinfo"
+		r := newTestReconciler()
+		Expect(handleGitRepository(ctx, r, app)).To(MatchError(errInvalid))
+	})
+
+	It("should reject a Git repository without the Git source kind", func() {
+		app := newGitApp()
+		app.Spec.SourceKind = ""
+		r := newTestReconciler()
+		Expect(handleImageRepository(ctx, r, app)).To(MatchError(errInvalid))
+	})
+
+	It("should delete the OCI sources when switching to Git", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+		Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRepository(ctx, r, app)).To(Succeed())
+		helmRepoKey := types.NamespacedName{Name: r.ResourceManager.HelmRepositoryName(app), Namespace: app.Namespace}
+
+		git := newGitApp()
+		git.Status = app.Status
+		Expect(handleGitRepository(ctx, r, git)).To(Succeed())
+		key := types.NamespacedName{Name: r.ResourceManager.ImageRepositoryName(app), Namespace: app.Namespace}
+		Expect(apierrors.IsNotFound(r.Get(ctx, key, &imagev1.ImageRepository{}))).To(BeTrue())
+		Expect(apierrors.IsNotFound(r.Get(ctx, key, &imagev1.ImagePolicy{}))).To(BeTrue())
+		Expect(apierrors.IsNotFound(r.Get(ctx, helmRepoKey, &sourcev1.HelmRepository{}))).To(BeTrue())
+		Expect(conditions.Has(git, appsv1.ImageRepositoryReadyCondition)).To(BeFalse())
+	})
+
+	It("should delete the GitRepository when switching back to a registry", func() {
+		app := newGitApp()
+		r := newTestReconciler()
+		Expect(handleGitRepository(ctx, r, app)).To(Succeed())
+		Expect(deleteGitRepository(ctx, r, app)).To(Succeed())
+		_, err := getGitRepository(r, app)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(conditions.Has(app, appsv1.GitRepositoryReadyCondition)).To(BeFalse())
+	})
+})
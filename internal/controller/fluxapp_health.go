@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// QueueDepthCheck is a readiness check which reports unhealthy when the controller workqueue
+// depth stays above a threshold for longer than a given period
+type QueueDepthCheck struct {
+	// Depth returns the current depth of the workqueue
+	Depth func() (int, error)
+	// Threshold is the queue depth above which the queue is considered backed up
+	Threshold int
+	// Period is how long the queue must be backed up for before reporting unhealthy
+	Period time.Duration
+
+	mu    sync.Mutex
+	since time.Time
+	now   func() time.Time
+}
+
+// NewQueueDepthCheck returns a QueueDepthCheck for the workqueue of the named controller
+func NewQueueDepthCheck(controllerName string, threshold int, period time.Duration) *QueueDepthCheck {
+	return &QueueDepthCheck{
+		Depth:     workqueueDepth(controllerName),
+		Threshold: threshold,
+		Period:    period,
+	}
+}
+
+// Check implements healthz.Checker
+func (c *QueueDepthCheck) Check(_ *http.Request) error {
+	depth, err := c.Depth()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.now != nil {
+		now = c.now()
+	}
+	// Reset as soon as the queue drains below the threshold
+	if depth <= c.Threshold {
+		c.since = time.Time{}
+		return nil
+	}
+	if c.since.IsZero() {
+		c.since = now
+	}
+	if backedUp := now.Sub(c.since); backedUp >= c.Period {
+		return fmt.Errorf("workqueue depth %d has exceeded %d for %s", depth, c.Threshold, backedUp.Round(time.Second))
+	}
+	return nil
+}
+
+// workqueueDepth returns a function reading the workqueue depth of the named controller
+// from the controller-runtime metrics registry
+func workqueueDepth(controllerName string) func() (int, error) {
+	return func() (int, error) {
+		families, err := metrics.Registry.Gather()
+		if err != nil {
+			return 0, err
+		}
+		for _, f := range families {
+			if f.GetName() != metrics.WorkQueueSubsystem+"_"+metrics.DepthKey {
+				continue
+			}
+			for _, m := range f.GetMetric() {
+				for _, l := range m.GetLabel() {
+					if l.GetName() == "controller" && l.GetValue() == controllerName {
+						return int(m.GetGauge().GetValue()), nil
+					}
+				}
+			}
+		}
+		// The metric isn't registered until the controller starts
+		return 0, nil
+	}
+}
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QueueDepthCheck", func() {
+	var (
+		depth int
+		now   time.Time
+		check *QueueDepthCheck
+	)
+
+	BeforeEach(func() {
+		depth = 0
+		now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		check = &QueueDepthCheck{
+			Depth:     func() (int, error) { return depth, nil },
+			Threshold: 10,
+			Period:    time.Minute,
+			now:       func() time.Time { return now },
+		}
+	})
+
+	It("should be healthy below the threshold", func() {
+		depth = 10
+		Expect(check.Check(nil)).To(Succeed())
+	})
+
+	It("should tolerate a short spike above the threshold", func() {
+		depth = 50
+		Expect(check.Check(nil)).To(Succeed())
+		now = now.Add(30 * time.Second)
+		Expect(check.Check(nil)).To(Succeed())
+	})
+
+	It("should be unhealthy when the backlog is sustained", func() {
+		depth = 50
+		Expect(check.Check(nil)).To(Succeed())
+		now = now.Add(time.Minute)
+		Expect(check.Check(nil)).To(MatchError(ContainSubstring("workqueue depth 50 has exceeded 10 for 1m0s")))
+	})
+
+	It("should reset once the backlog drains", func() {
+		depth = 50
+		Expect(check.Check(nil)).To(Succeed())
+		now = now.Add(45 * time.Second)
+		depth = 5
+		Expect(check.Check(nil)).To(Succeed())
+		depth = 50
+		now = now.Add(45 * time.Second)
+		Expect(check.Check(nil)).To(Succeed())
+	})
+
+	It("should return errors reading the depth", func() {
+		check.Depth = func() (int, error) { return 0, errors.New("boom") }
+		Expect(check.Check(nil)).To(MatchError("boom"))
+	})
+})
@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+)
+
+// helmRepositoryRefKey returns the key of the HelmRepository referenced by the app
+// The namespace defaults to the namespace of the app
+func helmRepositoryRefKey(app *appsv1.FluxApp) types.NamespacedName {
+	ref := app.Spec.HelmRepositoryRef
+	key := types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}
+	if key.Namespace == "" {
+		key.Namespace = app.Namespace
+	}
+	return key
+}
+
+// handleHelmRepositoryRef checks the HelmRepository referenced by the app exists and mirrors its Ready condition
+// The referenced HelmRepository isn't owned by the app so it's never changed
+func handleHelmRepositoryRef(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) error {
+	key := helmRepositoryRefKey(app)
+	helmRepository := &sourcev1.HelmRepository{}
+	if err := r.Get(ctx, key, helmRepository); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		// Wait for the HelmRepository to be created rather than the HelmRelease failing to find it
+		conditions.MarkFalse(app, appsv1.HelmRepositoryReadyCondition, appsv1.HelmRepositoryNotFoundReason,
+			"HelmRepository %s not found", key)
+		aggregateReady(app)
+		return errRequeue
+	}
+	if artifact := helmRepository.Status.Artifact; artifact != nil && artifact.Revision != "" {
+		app.Status.Chart.SourceRevision = artifact.Revision
+	}
+	mirrorHelmRepositoryReady(app, helmRepository)
+	return nil
+}
+
+// mirrorHelmRepositoryReady sets the HelmRepositoryReady condition from the HelmRepository
+// OCI HelmRepositories are static so source-controller doesn't set a Ready condition
+func mirrorHelmRepositoryReady(app *appsv1.FluxApp, helmRepository *sourcev1.HelmRepository) {
+	if helmRepository.Spec.Type == sourcev1.HelmRepositoryTypeOCI && conditions.Get(helmRepository, meta.ReadyCondition) == nil {
+		conditions.MarkTrue(app, appsv1.HelmRepositoryReadyCondition, meta.SucceededReason, "OCI HelmRepository is static")
+		return
+	}
+	mirrorChildReady(app, appsv1.HelmRepositoryReadyCondition, sourcev1.HelmRepositoryKind, helmRepository)
+}
+
+// chartSourceRef returns the reference to the source the HelmRelease chart is pulled from
+func chartSourceRef(r *FluxAppReconciler, app *appsv1.FluxApp) helmv2.CrossNamespaceObjectReference {
+	if app.Spec.HelmRepositoryRef != nil && !gitSource(app) {
+		key := helmRepositoryRefKey(app)
+		return helmv2.CrossNamespaceObjectReference{
+			Kind:      sourcev1.HelmRepositoryKind,
+			Name:      key.Name,
+			Namespace: key.Namespace,
+		}
+	}
+	return helmv2.CrossNamespaceObjectReference{
+		Kind:      chartSourceKind(app),
+		Name:      r.ResourceManager.ChartSourceName(app),
+		Namespace: app.Namespace,
+	}
+}
+
+// helmChartName returns the name of the chart in the HelmRelease chart template
+// It's the last path segment of the repository unless chart.name overrides it
+// e.g. when a referenced HelmRepository points at a parent path of the chart
+// The chart of a GitRepository is always at chart.git.path
+func helmChartName(app *appsv1.FluxApp) string {
+	if app.Spec.Chart.Name != "" && !gitSource(app) {
+		return app.Spec.Chart.Name
+	}
+	return app.Status.Chart.Name
+}
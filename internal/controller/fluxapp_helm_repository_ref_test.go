@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+)
+
+var _ = Describe("HelmRepositoryRef", func() {
+	ctx := context.Background()
+
+	newRefApp := func(namespace string) *appsv1.FluxApp {
+		app := newTestApp()
+		app.Spec.HelmRepositoryRef = &meta.NamespacedObjectReference{Name: "charts", Namespace: namespace}
+		return app
+	}
+
+	newHelmRepository := func(namespace string) *sourcev1.HelmRepository {
+		return &sourcev1.HelmRepository{
+			ObjectMeta: metav1.ObjectMeta{Name: "charts", Namespace: namespace},
+			Spec:       sourcev1.HelmRepositorySpec{URL: "oci://ghcr.io/stefanprodan/charts", Type: sourcev1.HelmRepositoryTypeOCI},
+		}
+	}
+
+	It("should point the HelmRelease at the referenced HelmRepository", func() {
+		app := newRefApp("flux-system")
+		repo := newHelmRepository("flux-system")
+		r := newTestReconciler(repo)
+		policyErr, repoErr := handleSources(ctx, r, app)
+		Expect(policyErr).NotTo(HaveOccurred())
+		Expect(repoErr).NotTo(HaveOccurred())
+		Expect(conditions.IsTrue(app, appsv1.HelmRepositoryReadyCondition)).To(BeTrue())
+		// The HelmRepository isn't generated
+		err := r.Get(ctx, types.NamespacedName{Name: r.ResourceManager.HelmRepositoryName(app), Namespace: app.Namespace}, &sourcev1.HelmRepository{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Chart.Spec.SourceRef).To(Equal(helmv2.CrossNamespaceObjectReference{
+			Kind:      sourcev1.HelmRepositoryKind,
+			Name:      "charts",
+			Namespace: "flux-system",
+		}))
+	})
+
+	It("should default the namespace to the namespace of the app", func() {
+		app := newRefApp("")
+		r := newTestReconciler(newHelmRepository(app.Namespace))
+		Expect(handleHelmRepositoryRef(ctx, r, app)).To(Succeed())
+		Expect(chartSourceRef(r, app).Namespace).To(Equal(app.Namespace))
+	})
+
+	It("should mirror the Ready condition of the referenced HelmRepository", func() {
+		app := newRefApp("flux-system")
+		repo := newHelmRepository("flux-system")
+		repo.Spec.Type = sourcev1.HelmRepositoryTypeDefault
+		conditions.MarkFalse(repo, meta.ReadyCondition, meta.FailedReason, "failed to fetch index")
+		r := newTestReconciler(repo)
+		Expect(handleHelmRepositoryRef(ctx, r, app)).To(Succeed())
+		Expect(conditions.IsFalse(app, appsv1.HelmRepositoryReadyCondition)).To(BeTrue())
+		Expect(conditions.GetMessage(app, meta.ReadyCondition)).To(ContainSubstring("failed to fetch index"))
+	})
+
+	It("should requeue while the referenced HelmRepository doesn't exist", func() {
+		app := newRefApp("flux-system")
+		r := newTestReconciler()
+		Expect(handleHelmRepositoryRef(ctx, r, app)).To(MatchError(errRequeue))
+		Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.HelmRepositoryNotFoundReason))
+		Expect(conditions.GetMessage(app, meta.ReadyCondition)).To(ContainSubstring("flux-system/charts"))
+	})
+
+	Context("chart name", func() {
+		It("should derive the chart name from the repository", func() {
+			app := newTestApp()
+			r := newTestReconciler()
+			Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+			Expect(app.Status.Chart.Name).To(Equal("podinfo"))
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Chart.Spec.Chart).To(Equal("podinfo"))
+		})
+
+		It("should use the overridden chart name in the HelmRelease", func() {
+			app := newRefApp("flux-system")
+			app.Spec.Chart.Name = "stefanprodan/charts/podinfo"
+			r := newTestReconciler()
+			Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+			// The registry is still scanned at the repository path
+			Expect(app.Status.Chart.Name).To(Equal("podinfo"))
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Chart.Spec.Chart).To(Equal("stefanprodan/charts/podinfo"))
+		})
+
+		It("should ignore the chart name of a Git chart", func() {
+			app := newTestApp()
+			app.Spec.SourceKind = sourcev1.GitRepositoryKind
+			app.Spec.Chart.Name = "podinfo"
+			app.Status.Chart.Name = "charts/podinfo"
+			Expect(helmChartName(app)).To(Equal("charts/podinfo"))
+		})
+	})
+})
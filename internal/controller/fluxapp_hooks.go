@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+const (
+	// defaultHookTimeout is how long a hook is waited for if it doesn't set a timeout
+	defaultHookTimeout = 30 * time.Second
+	// maxHookTimeout is the longest a hook is waited for so a slow hook can't hold up the reconcile
+	maxHookTimeout = time.Minute
+)
+
+// defaultHookClient posts the hooks when the reconciler doesn't set a client
+var defaultHookClient = &http.Client{Timeout: maxHookTimeout}
+
+const (
+	// hookPhasePre is posted to the hooks called before the HelmRelease is created or upgraded
+	hookPhasePre = "pre"
+	// hookPhasePost is posted to the hooks called once the HelmRelease is created or upgraded
+	hookPhasePost = "post"
+)
+
+// HookRequest is the JSON payload posted to a hook
+type HookRequest struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Hook      string `json:"hook"`
+	Phase     string `json:"phase"`
+	Version   string `json:"version"`
+}
+
+// releasing returns true if the HelmRelease is about to be created or upgraded to a new chart version
+func releasing(app *appsv1.FluxApp, hr *helmv2.HelmRelease) bool {
+	return helmReleaseChartVersion(hr) != chartTag(app)
+}
+
+// runPreHooks calls the pre hooks before the HelmRelease is created or upgraded to a new chart version
+// The HelmRelease is left as it was until they all succeed
+func runPreHooks(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp, hr *helmv2.HelmRelease) error {
+	if app.Spec.Hooks == nil || !releasing(app, hr) {
+		return nil
+	}
+	return runHooks(ctx, r, app, hookPhasePre, app.Spec.Hooks.Pre)
+}
+
+// runPostHooks calls the post hooks once the HelmRelease is created or upgraded to a new chart version
+// The version is kept in the status until they all succeed so a failed hook is retried by the next reconcile
+func runPostHooks(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp, released bool) error {
+	if app.Spec.Hooks == nil || len(app.Spec.Hooks.Post) == 0 {
+		app.Status.PendingPostHooksVersion = ""
+		return nil
+	}
+	if released {
+		app.Status.PendingPostHooksVersion = app.Status.Chart.Version
+	}
+	if app.Status.PendingPostHooksVersion == "" {
+		return nil
+	}
+	if err := runHooks(ctx, r, app, hookPhasePost, app.Spec.Hooks.Post); err != nil {
+		return err
+	}
+	app.Status.PendingPostHooksVersion = ""
+	return nil
+}
+
+// runHooks calls the hooks in order, stopping at the first which fails or times out
+// The failure is reported in the Ready condition and the app is requeued to call the hooks again
+func runHooks(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp, phase string, hooks []appsv1.Hook) error {
+	for _, hook := range hooks {
+		timeout := hookTimeout(hook)
+		err := r.callHook(ctx, hook.URL, timeout, HookRequest{
+			Name:      app.Name,
+			Namespace: app.Namespace,
+			Hook:      hook.Name,
+			Phase:     phase,
+			Version:   app.Status.Chart.Version,
+		})
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			conditions.MarkFalse(app, meta.ReadyCondition, appsv1.HookTimedOutReason,
+				"%s hook %s timed out after %s", phase, hook.Name, timeout)
+		} else {
+			conditions.MarkFalse(app, meta.ReadyCondition, appsv1.HookFailedReason,
+				"%s hook %s failed: %s", phase, hook.Name, err)
+		}
+		setReconcileReason(ctx, reconcileReasonRequeueHook)
+		return errRequeue
+	}
+	return nil
+}
+
+// hookTimeout returns how long to wait for the hook to respond, capped at maxHookTimeout
+func hookTimeout(hook appsv1.Hook) time.Duration {
+	if hook.Timeout == nil {
+		return defaultHookTimeout
+	}
+	return min(hook.Timeout.Duration, maxHookTimeout)
+}
+
+// callHook posts the request to the hook as JSON and waits for a 2xx response
+func (r *FluxAppReconciler) callHook(ctx context.Context, url string, timeout time.Duration, hookRequest HookRequest) error {
+	body, err := json.Marshal(hookRequest)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c := r.HookClient
+	if c == nil {
+		c = defaultHookClient
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// stubHook records the requests posted to it, responding with the status after the delay
+type stubHook struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []HookRequest
+	status   int
+	delay    time.Duration
+}
+
+func newStubHook() *stubHook {
+	s := &stubHook{status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer GinkgoRecover()
+		Expect(req.Method).To(Equal(http.MethodPost))
+		Expect(req.Header.Get("Content-Type")).To(Equal("application/json"))
+		var hr HookRequest
+		Expect(json.NewDecoder(req.Body).Decode(&hr)).To(Succeed())
+		s.mu.Lock()
+		s.requests = append(s.requests, hr)
+		status, delay := s.status, s.delay
+		s.mu.Unlock()
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+		}
+		w.WriteHeader(status)
+	}))
+	return s
+}
+
+func (s *stubHook) respond(status int, delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status, s.delay = status, delay
+}
+
+func (s *stubHook) received() []HookRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]HookRequest(nil), s.requests...)
+}
+
+var _ = Describe("Hooks", func() {
+	ctx := context.Background()
+
+	var (
+		pre  *stubHook
+		post *stubHook
+		r    *FluxAppReconciler
+		app  *appsv1.FluxApp
+	)
+
+	BeforeEach(func() {
+		pre = newStubHook()
+		DeferCleanup(pre.Close)
+		post = newStubHook()
+		DeferCleanup(post.Close)
+		r = newTestReconciler()
+		app = newTestApp()
+		app.Spec.Hooks = &appsv1.Hooks{
+			Pre:  []appsv1.Hook{{Name: "create-database", URL: pre.URL}},
+			Post: []appsv1.Hook{{Name: "warm-cdn", URL: post.URL}},
+		}
+	})
+
+	It("should call the pre hooks before & the post hooks after creating the HelmRelease", func() {
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(pre.received()).To(Equal([]HookRequest{
+			{Name: "podinfo", Namespace: "default", Hook: "create-database", Phase: hookPhasePre, Version: "6.5.3"},
+		}))
+		Expect(post.received()).To(Equal([]HookRequest{
+			{Name: "podinfo", Namespace: "default", Hook: "warm-cdn", Phase: hookPhasePost, Version: "6.5.3"},
+		}))
+		_, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(app.Status.PendingPostHooksVersion).To(BeEmpty())
+	})
+
+	It("should only call the hooks when the chart version changes", func() {
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(pre.received()).To(HaveLen(1))
+		Expect(post.received()).To(HaveLen(1))
+
+		app.Status.Chart.Version = "6.5.4"
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(pre.received()).To(HaveLen(2))
+		Expect(pre.received()[1].Version).To(Equal("6.5.4"))
+		Expect(post.received()).To(HaveLen(2))
+		Expect(post.received()[1].Version).To(Equal("6.5.4"))
+	})
+
+	It("should leave the HelmRelease as it was while a pre hook fails", func() {
+		pre.respond(http.StatusInternalServerError, 0)
+		err := handleHelmRelease(ctx, r, app)
+		Expect(err).To(MatchError(errRequeue))
+		_, err = getHelmRelease(ctx, r, app)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(post.received()).To(BeEmpty())
+		Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.HookFailedReason))
+		Expect(conditions.GetMessage(app, meta.ReadyCondition)).To(Equal("pre hook create-database failed: unexpected status: 500 Internal Server Error"))
+
+		pre.respond(http.StatusOK, 0)
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		_, err = getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pre.received()).To(HaveLen(2))
+		Expect(post.received()).To(HaveLen(1))
+	})
+
+	It("should retry a failed post hook without calling the pre hooks again", func() {
+		post.respond(http.StatusBadGateway, 0)
+		err := handleHelmRelease(ctx, r, app)
+		Expect(err).To(MatchError(errRequeue))
+		_, err = getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(app.Status.PendingPostHooksVersion).To(Equal("6.5.3"))
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.HookFailedReason))
+		Expect(conditions.GetMessage(app, meta.ReadyCondition)).To(ContainSubstring("post hook warm-cdn failed"))
+
+		post.respond(http.StatusOK, 0)
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(pre.received()).To(HaveLen(1))
+		Expect(post.received()).To(HaveLen(2))
+		Expect(app.Status.PendingPostHooksVersion).To(BeEmpty())
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).NotTo(Equal(appsv1.HookFailedReason))
+	})
+
+	It("should requeue a hook which times out", func() {
+		pre.respond(http.StatusOK, time.Second)
+		app.Spec.Hooks.Pre[0].Timeout = &metav1.Duration{Duration: 50 * time.Millisecond}
+		err := handleHelmRelease(ctx, r, app)
+		Expect(err).To(MatchError(errRequeue))
+		_, err = getHelmRelease(ctx, r, app)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.HookTimedOutReason))
+		Expect(conditions.GetMessage(app, meta.ReadyCondition)).To(Equal("pre hook create-database timed out after 50ms"))
+	})
+
+	It("should cap the hook timeout", func() {
+		Expect(hookTimeout(appsv1.Hook{})).To(Equal(defaultHookTimeout))
+		Expect(hookTimeout(appsv1.Hook{Timeout: &metav1.Duration{Duration: 10 * time.Second}})).To(Equal(10 * time.Second))
+		Expect(hookTimeout(appsv1.Hook{Timeout: &metav1.Duration{Duration: time.Hour}})).To(Equal(maxHookTimeout))
+	})
+
+	It("should call the hooks in order, stopping at the first failure", func() {
+		second := newStubHook()
+		DeferCleanup(second.Close)
+		pre.respond(http.StatusForbidden, 0)
+		app.Spec.Hooks.Pre = append(app.Spec.Hooks.Pre, appsv1.Hook{Name: "seed-database", URL: second.URL})
+		Expect(handleHelmRelease(ctx, r, app)).To(MatchError(errRequeue))
+		Expect(pre.received()).To(HaveLen(1))
+		Expect(second.received()).To(BeEmpty())
+	})
+
+	It("should forget a pending post hook once the post hooks are removed", func() {
+		post.respond(http.StatusInternalServerError, 0)
+		Expect(handleHelmRelease(ctx, r, app)).To(MatchError(errRequeue))
+		Expect(app.Status.PendingPostHooksVersion).To(Equal("6.5.3"))
+		app.Spec.Hooks.Post = nil
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(app.Status.PendingPostHooksVersion).To(BeEmpty())
+	})
+})
@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"hash/fnv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// jitterSteps is the number of distinct jitter fractions an interval is spread over
+const jitterSteps = 1000
+
+// requeueAfter returns the scan requeue interval with up to JitterFactor of the interval added at random
+// so apps created together don't all check for the chart version at the same time
+func (r *FluxAppReconciler) requeueAfter() time.Duration {
+	if r.JitterFactor <= 0 {
+		return r.ScanRequeueInterval
+	}
+	return wait.Jitter(r.ScanRequeueInterval, r.JitterFactor)
+}
+
+// childInterval returns the interval with up to JitterFactor of the interval added
+// The jitter is derived from the app name rather than chosen at random so it's the same
+// on every reconcile, otherwise every reconcile would change the child spec
+func (r *FluxAppReconciler) childInterval(app *appsv1.FluxApp, d time.Duration) time.Duration {
+	if r.JitterFactor <= 0 {
+		return d
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(app.Namespace + "/" + app.Name))
+	fraction := float64(h.Sum64()%jitterSteps) / jitterSteps
+	return d + time.Duration(fraction*r.JitterFactor*float64(d))
+}
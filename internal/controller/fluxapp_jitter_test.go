@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Jitter", func() {
+	ctx := context.Background()
+
+	It("should not jitter without a jitter factor", func() {
+		r := newTestReconciler()
+		r.ScanRequeueInterval = 10 * time.Second
+		Expect(r.requeueAfter()).To(Equal(10 * time.Second))
+		Expect(r.childInterval(newTestApp(), time.Minute)).To(Equal(time.Minute))
+	})
+
+	It("should keep the jittered requeue interval within bounds", func() {
+		r := newTestReconciler()
+		r.ScanRequeueInterval = 10 * time.Second
+		r.JitterFactor = 0.5
+		for range 100 {
+			Expect(r.requeueAfter()).To(And(
+				BeNumerically(">=", 10*time.Second),
+				BeNumerically("<=", 15*time.Second),
+			))
+		}
+	})
+
+	It("should keep the jittered child interval within bounds", func() {
+		r := newTestReconciler()
+		r.JitterFactor = 0.5
+		app := newTestApp()
+		intervals := map[time.Duration]bool{}
+		for i := range 100 {
+			app.Name = fmt.Sprintf("app-%d", i)
+			interval := r.childInterval(app, time.Minute)
+			Expect(interval).To(And(
+				BeNumerically(">=", time.Minute),
+				BeNumerically("<=", 90*time.Second),
+			))
+			intervals[interval] = true
+		}
+		// The apps should be spread across the interval
+		Expect(len(intervals)).To(BeNumerically(">", 1))
+	})
+
+	It("should use the same child interval on every reconcile", func() {
+		r := newTestReconciler()
+		r.JitterFactor = 0.5
+		app := newTestApp()
+		Expect(r.childInterval(app, time.Minute)).To(Equal(r.childInterval(app, time.Minute)))
+	})
+
+	It("should jitter the HelmRelease interval", func() {
+		r := newTestReconciler()
+		r.JitterFactor = 0.5
+		app := newTestApp()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Interval.Duration).To(Equal(r.childInterval(app, time.Minute)))
+		Expect(hr.Spec.Interval.Duration).To(And(
+			BeNumerically(">=", time.Minute),
+			BeNumerically("<=", 90*time.Second),
+		))
+	})
+})
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// appLocks holds a mutex per app so only one reconcile of an app mutates its children at a time
+// The workqueue doesn't hand out an app to more than one worker, but Reconcile can also be called
+// outside the workqueue, so the reconciles of an app are serialised here too
+// The zero value is ready to use
+type appLocks struct {
+	mu    sync.Mutex
+	locks map[types.NamespacedName]*appLock
+}
+
+// appLock is the mutex of an app and how many reconciles hold or are waiting for it
+type appLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// Lock waits for the lock of the app and returns the function to unlock it
+// The lock is removed once nothing holds or is waiting for it so deleted apps don't leak locks
+func (l *appLocks) Lock(key types.NamespacedName) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[types.NamespacedName]*appLock{}
+	}
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &appLock{}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if lock.refs--; lock.refs == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
+
+// Len returns the number of apps with a lock
+func (l *appLocks) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.locks)
+}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("App locks", func() {
+	podinfo := types.NamespacedName{Namespace: "default", Name: "podinfo"}
+
+	It("should serialise the holders of the same app", func() {
+		var locks appLocks
+		var holders, maxHolders atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				unlock := locks.Lock(podinfo)
+				defer unlock()
+				n := holders.Add(1)
+				for {
+					m := maxHolders.Load()
+					if n <= m || maxHolders.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				holders.Add(-1)
+			}()
+		}
+		wg.Wait()
+		Expect(maxHolders.Load()).To(Equal(int32(1)))
+		Expect(locks.Len()).To(BeZero())
+	})
+
+	It("should not block other apps", func() {
+		var locks appLocks
+		unlock := locks.Lock(podinfo)
+		defer unlock()
+		locked := make(chan struct{})
+		go func() {
+			locks.Lock(types.NamespacedName{Namespace: "default", Name: "redis"})()
+			close(locked)
+		}()
+		Eventually(locked).Should(BeClosed())
+		Expect(locks.Len()).To(Equal(1))
+	})
+
+	It("should serialise concurrent reconciles of an app", func() {
+		ctx := context.Background()
+		app := newTestApp()
+		// Count the reconciles touching the children at the same time
+		var inFlight, maxInFlight atomic.Int32
+		c := fake.NewClientBuilder().
+			WithScheme(newTestScheme()).
+			WithObjects(app).
+			WithStatusSubresource(&appsv1.FluxApp{}).
+			WithInterceptorFuncs(interceptor.Funcs{
+				Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+					if _, ok := obj.(*imagev1.ImageRepository); ok {
+						n := inFlight.Add(1)
+						defer inFlight.Add(-1)
+						for {
+							m := maxInFlight.Load()
+							if n <= m || maxInFlight.CompareAndSwap(m, n) {
+								break
+							}
+						}
+						time.Sleep(5 * time.Millisecond)
+					}
+					return c.Get(ctx, key, obj, opts...)
+				},
+			}).
+			Build()
+		r := newTestReconciler()
+		r.Client = c
+		r.ResourceManager = NewResourceManager(c, r.Scheme, ControllerOwnerReferenceMode)
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+				Expect(err).NotTo(HaveOccurred())
+			}()
+		}
+		wg.Wait()
+		Expect(maxInFlight.Load()).To(Equal(int32(1)))
+		Expect(r.locks.Len()).To(BeZero())
+	})
+})
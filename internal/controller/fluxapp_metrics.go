@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// The reasons a reconcile is counted under in reconcileTotal
+const (
+	// reconcileReasonCreated means the HelmRelease was created
+	reconcileReasonCreated = "created"
+	// reconcileReasonUpdated means the HelmRelease was updated
+	reconcileReasonUpdated = "updated"
+	// reconcileReasonUnchanged means the HelmRelease already matched the spec so it wasn't patched
+	reconcileReasonUnchanged = "unchanged"
+	// reconcileReasonConverged means the children already reflected the spec so the handlers were skipped
+	reconcileReasonConverged = "converged"
+	// reconcileReasonRequeueScan means the chart version hasn't been selected by a scan yet
+	reconcileReasonRequeueScan = "requeue_scan"
+	// reconcileReasonRequeueNoMatchingVersion means no chart version matches the version constraint
+	reconcileReasonRequeueNoMatchingVersion = "requeue_no_matching_version"
+	// reconcileReasonRequeueChartNotPullable means the selected chart version can't be pulled
+	reconcileReasonRequeueChartNotPullable = "requeue_chart_not_pullable"
+	// reconcileReasonRequeueNamespace means the target namespace doesn't exist
+	reconcileReasonRequeueNamespace = "requeue_namespace"
+	// reconcileReasonRequeueDependency means the app is waiting for a dependency to be ready
+	reconcileReasonRequeueDependency = "requeue_dependency"
+	// reconcileReasonRequeueHook means a pre or post hook failed or timed out
+	reconcileReasonRequeueHook = "requeue_hook"
+	// reconcileReasonQuarantined means the app was quarantined so the handlers were skipped
+	reconcileReasonQuarantined = "quarantined"
+	// reconcileReasonBudgetExceeded means the reconcile budget was spent so the remaining handlers were deferred
+	reconcileReasonBudgetExceeded = "budget_exceeded"
+	// reconcileReasonRequeue means the app is waiting for anything else e.g. a template or child
+	reconcileReasonRequeue = "requeue"
+	// reconcileReasonErrorInvalidURL means the chart repository isn't a valid URL
+	reconcileReasonErrorInvalidURL = "error_invalid_url"
+	// reconcileReasonErrorInvalid means the spec is invalid
+	reconcileReasonErrorInvalid = "error_invalid"
+	// reconcileReasonError means any other error which may resolve on retry
+	reconcileReasonError = "error"
+)
+
+// providerDetectedTotal counts the providers detected from the chart repository host
+// A high generic count may mean apps are missing an explicit provider
+var providerDetectedTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "fluxer_provider_detected_total",
+		Help: "Total number of chart repository providers detected from the repository host",
+	},
+	[]string{"provider"},
+)
+
+// reconcileTotal counts the reconciles by their outcome
+var reconcileTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "fluxer_reconcile_total",
+		Help: "Total number of FluxApp reconciles by the reason for the outcome",
+	},
+	[]string{"reason"},
+)
+
+// deletionDuration measures how long an app takes to delete, from its deletion timestamp to the finalizer being removed
+var deletionDuration = prometheus.NewHistogram(
+	prometheus.HistogramOpts{
+		Name:    "fluxer_deletion_duration_seconds",
+		Help:    "Time from a FluxApp being deleted to its finalizer being removed",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	},
+)
+
+// slowDeletionsTotal counts the deletions which took longer than the slow deletion threshold
+// An increase may mean uninstalls are getting stuck
+var slowDeletionsTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "fluxer_slow_deletions_total",
+		Help: "Total number of FluxApp deletions which took longer than the slow deletion threshold",
+	},
+)
+
+func init() {
+	metrics.Registry.MustRegister(providerDetectedTotal, reconcileTotal, deletionDuration, slowDeletionsTotal)
+}
+
+// observeDeletion records how long the app took to delete once its finalizer has been removed
+func (r *FluxAppReconciler) observeDeletion(ctx context.Context, app *appsv1.FluxApp) {
+	d := time.Since(app.DeletionTimestamp.Time)
+	deletionDuration.Observe(d.Seconds())
+	if r.SlowDeletionThreshold > 0 && d > r.SlowDeletionThreshold {
+		slowDeletionsTotal.Inc()
+		log.FromContext(ctx).Info("slow deletion", "duration", d.String(), "threshold", r.SlowDeletionThreshold.String())
+	}
+}
+
+// reconcileReasonKey is the context key of the reconcile reason recorded by the handlers
+type reconcileReasonKey struct{}
+
+// withReconcileReason returns a context the handlers record the reconcile reason in
+func withReconcileReason(ctx context.Context) (context.Context, *string) {
+	reason := new(string)
+	return context.WithValue(ctx, reconcileReasonKey{}, reason), reason
+}
+
+// setReconcileReason records the reconcile reason if the context has one
+// A later reason replaces an earlier one e.g. waiting for a dependency once the HelmRelease is updated
+func setReconcileReason(ctx context.Context, reason string) {
+	if p, ok := ctx.Value(reconcileReasonKey{}).(*string); ok {
+		*p = reason
+	}
+}
+
+// reconcileReason returns the reason a reconcile is counted under
+// An error takes precedence over the reason recorded by the handlers
+func reconcileReason(reason string, result ctrl.Result, err error) string {
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &urlErr):
+		return reconcileReasonErrorInvalidURL
+	case errors.Is(err, errInvalid):
+		return reconcileReasonErrorInvalid
+	case err != nil:
+		return reconcileReasonError
+	case reason != "":
+		return reason
+	case !result.IsZero():
+		return reconcileReasonRequeue
+	default:
+		return reconcileReasonUpdated
+	}
+}
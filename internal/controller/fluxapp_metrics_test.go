@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+)
+
+var _ = Describe("Metrics", func() {
+	ctx := context.Background()
+
+	DescribeTable("provider detection",
+		func(repository string, provider string) {
+			app := newTestApp()
+			app.Spec.Chart.Repository = repository
+			r := newTestReconciler()
+			before := testutil.ToFloat64(providerDetectedTotal.WithLabelValues(provider))
+			Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+			Expect(testutil.ToFloat64(providerDetectedTotal.WithLabelValues(provider))).To(Equal(before + 1))
+		},
+		Entry("aws", "oci://123456789012.dkr.ecr.eu-west-1.amazonaws.com/charts/podinfo", "aws"),
+		Entry("azure", "oci://example.azurecr.io/charts/podinfo", "azure"),
+		Entry("gcp", "oci://europe-docker.gcr.io/charts/podinfo", "gcp"),
+		Entry("generic", "oci://ghcr.io/stefanprodan/charts/podinfo", "generic"),
+	)
+
+	It("should not count an explicit provider", func() {
+		app := newTestApp()
+		app.Spec.Chart.Provider = "generic"
+		r := newTestReconciler()
+		before := testutil.ToFloat64(providerDetectedTotal.WithLabelValues("generic"))
+		Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+		Expect(testutil.ToFloat64(providerDetectedTotal.WithLabelValues("generic"))).To(Equal(before))
+	})
+
+	Context("deletion", func() {
+		// deleteApp reconciles the app deleted for the duration & returns the increase in the deletion & slow deletion counts
+		deleteApp := func(deleted time.Duration, threshold time.Duration) (uint64, float64) {
+			app := newTestApp()
+			app.Finalizers = []string{finalizer}
+			app.DeletionTimestamp = &metav1.Time{Time: time.Now().Add(-deleted)}
+			r := newTestReconciler(app)
+			r.SlowDeletionThreshold = threshold
+			count := func() uint64 {
+				m := &dto.Metric{}
+				Expect(deletionDuration.Write(m)).To(Succeed())
+				return m.GetHistogram().GetSampleCount()
+			}
+			before, slowBefore := count(), testutil.ToFloat64(slowDeletionsTotal)
+			_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+			Expect(err).NotTo(HaveOccurred())
+			// The app is gone once the finalizer is removed
+			Expect(apierrors.IsNotFound(r.Get(ctx, client.ObjectKeyFromObject(app), &appsv1.FluxApp{}))).To(BeTrue())
+			return count() - before, testutil.ToFloat64(slowDeletionsTotal) - slowBefore
+		}
+
+		It("should observe the deletion duration once the finalizer is removed", func() {
+			observed, slow := deleteApp(time.Minute, 5*time.Minute)
+			Expect(observed).To(Equal(uint64(1)))
+			Expect(slow).To(BeZero())
+		})
+
+		It("should count a deletion slower than the threshold", func() {
+			observed, slow := deleteApp(10*time.Minute, 5*time.Minute)
+			Expect(observed).To(Equal(uint64(1)))
+			Expect(slow).To(Equal(float64(1)))
+		})
+
+		It("should not count slow deletions without a threshold", func() {
+			_, slow := deleteApp(10*time.Minute, 0)
+			Expect(slow).To(BeZero())
+		})
+	})
+
+	Context("reconcile reasons", func() {
+		// countReconcile reconciles the app once & returns the increase in the reconcile count of the reason
+		countReconcile := func(r *FluxAppReconciler, app *appsv1.FluxApp, reason string) float64 {
+			before := testutil.ToFloat64(reconcileTotal.WithLabelValues(reason))
+			_, _ = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+			return testutil.ToFloat64(reconcileTotal.WithLabelValues(reason)) - before
+		}
+
+		DescribeTable("should count the reconcile under the reason of the outcome",
+			func(reason string, setup func(app *appsv1.FluxApp) []client.Object) {
+				app := newTestApp()
+				r := newTestReconciler(append(setup(app), app)...)
+				Expect(countReconcile(r, app, reason)).To(Equal(float64(1)))
+			},
+			Entry("created", reconcileReasonCreated, func(app *appsv1.FluxApp) []client.Object {
+				return nil
+			}),
+			Entry("updated", reconcileReasonUpdated, func(app *appsv1.FluxApp) []client.Object {
+				return []client.Object{&helmv2.HelmRelease{ObjectMeta: metav1.ObjectMeta{Name: app.Name, Namespace: app.Namespace}}}
+			}),
+			Entry("waiting for the chart scan", reconcileReasonRequeueScan, func(app *appsv1.FluxApp) []client.Object {
+				app.Status = appsv1.FluxAppStatus{}
+				return nil
+			}),
+			Entry("no matching version", reconcileReasonRequeueNoMatchingVersion, func(app *appsv1.FluxApp) []client.Object {
+				policy := &imagev1.ImagePolicy{ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace}}
+				conditions.MarkFalse(policy, meta.ReadyCondition, "ReconciliationFailed", "unable to determine latest version from provided list")
+				return []client.Object{policy}
+			}),
+			Entry("missing target namespace", reconcileReasonRequeueNamespace, func(app *appsv1.FluxApp) []client.Object {
+				app.Spec.TargetNamespace = "podinfo"
+				app.Spec.CreateNamespace = ptr.To(false)
+				return nil
+			}),
+			Entry("waiting for a dependency", reconcileReasonRequeueDependency, func(app *appsv1.FluxApp) []client.Object {
+				app.Spec.DependsOn = []meta.NamespacedObjectReference{{Name: "redis"}}
+				return nil
+			}),
+			Entry("invalid URL", reconcileReasonErrorInvalidURL, func(app *appsv1.FluxApp) []client.Object {
+				app.Spec.Chart.Repository = "oci://ghcr.io/%zz/podinfo"
+				return nil
+			}),
+			Entry("invalid spec", reconcileReasonErrorInvalid, func(app *appsv1.FluxApp) []client.Object {
+				app.Spec.ValuesTemplate = "cluster: {{ .Vars.clusterName }}"
+				return nil
+			}),
+		)
+
+		It("should count a reconcile which skips the handlers as converged", func() {
+			app := newTestApp()
+			repo := &imagev1.ImageRepository{ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace}}
+			conditions.MarkTrue(repo, meta.ReadyCondition, meta.SucceededReason, "successful scan")
+			policy := &imagev1.ImagePolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace},
+				Status:     imagev1.ImagePolicyStatus{LatestImage: "ghcr.io/stefanprodan/charts/podinfo:6.5.3"},
+			}
+			conditions.MarkTrue(policy, meta.ReadyCondition, meta.SucceededReason, "Latest image tag resolved")
+			hr := &helmv2.HelmRelease{ObjectMeta: metav1.ObjectMeta{Name: app.Name, Namespace: app.Namespace}}
+			conditions.MarkTrue(hr, meta.ReadyCondition, meta.SucceededReason, "Helm install succeeded")
+			r := newTestReconciler(app, repo, policy, hr)
+			Expect(countReconcile(r, app, reconcileReasonUpdated)).To(Equal(float64(1)))
+			Expect(countReconcile(r, app, reconcileReasonConverged)).To(Equal(float64(1)))
+		})
+
+		DescribeTable("should classify the reason",
+			func(reason string, result ctrl.Result, err error, expected string) {
+				Expect(reconcileReason(reason, result, err)).To(Equal(expected))
+			},
+			Entry("recorded reason", reconcileReasonCreated, ctrl.Result{}, nil, reconcileReasonCreated),
+			Entry("requeue without a reason", "", ctrl.Result{Requeue: true}, nil, reconcileReasonRequeue),
+			Entry("error over a recorded reason", reconcileReasonCreated, ctrl.Result{}, errors.New("timeout"), reconcileReasonError),
+			Entry("invalid", "", ctrl.Result{}, fmt.Errorf("%w values", errInvalid), reconcileReasonErrorInvalid),
+			Entry("invalid URL", "", ctrl.Result{}, &url.Error{Op: "parse", URL: "oci://%zz", Err: errors.New("invalid URL escape")}, reconcileReasonErrorInvalidURL),
+		)
+	})
+})
@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// handleTargetNamespace adds the target namespace metadata of the app to the target namespace
+// Helm doesn't label the namespaces it creates so if the HelmRelease creates the namespace
+// the controller creates it first with the metadata
+// If the namespace doesn't exist and can't be created, it's left for the namespace check
+// Namespaces aren't owned by the app so they're kept when the app is deleted
+func handleTargetNamespace(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp, name string) error {
+	metadata := app.Spec.TargetNamespaceMetadata
+	// The namespace of a remote cluster can't be managed from here
+	if metadata == nil || app.Spec.RemoteCluster != nil {
+		return nil
+	}
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name}, ns); err != nil {
+		if client.IgnoreNotFound(err) != nil || !app.Spec.GetCreateNamespace() {
+			return client.IgnoreNotFound(err)
+		}
+		ns = &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		mergeLabels(ns, metadata.Labels)
+		mergeAnnotations(ns, metadata.Annotations)
+		return r.Create(ctx, ns)
+	}
+	patch := client.MergeFrom(ns.DeepCopy())
+	mergeLabels(ns, metadata.Labels)
+	mergeAnnotations(ns, metadata.Annotations)
+	return r.Patch(ctx, ns, patch)
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("Target namespace", func() {
+	ctx := context.Background()
+
+	newNamespaceApp := func() *appsv1.FluxApp {
+		app := newTestApp()
+		app.Spec.TargetNamespace = "podinfo"
+		app.Spec.TargetNamespaceMetadata = &appsv1.NamespaceMetadata{
+			Labels:      map[string]string{"istio-injection": "enabled"},
+			Annotations: map[string]string{"owner": "team-a"},
+		}
+		return app
+	}
+
+	getNamespace := func(r *FluxAppReconciler) (*corev1.Namespace, error) {
+		ns := &corev1.Namespace{}
+		return ns, r.Get(ctx, types.NamespacedName{Name: "podinfo"}, ns)
+	}
+
+	It("should create the namespace with the metadata before the HelmRelease", func() {
+		app := newNamespaceApp()
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		ns, err := getNamespace(r)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ns.Labels).To(HaveKeyWithValue("istio-injection", "enabled"))
+		Expect(ns.Annotations).To(HaveKeyWithValue("owner", "team-a"))
+		Expect(ns.OwnerReferences).To(BeEmpty())
+	})
+
+	It("should add the metadata to an existing namespace keeping its labels", func() {
+		app := newNamespaceApp()
+		app.Spec.CreateNamespace = ptr.To(false)
+		existing := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   "podinfo",
+			Labels: map[string]string{"team": "a", "istio-injection": "disabled"},
+		}}
+		r := newTestReconciler(existing)
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		ns, err := getNamespace(r)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ns.Labels).To(Equal(map[string]string{"team": "a", "istio-injection": "enabled"}))
+	})
+
+	It("should not create the namespace if the HelmRelease can't create it", func() {
+		app := newNamespaceApp()
+		app.Spec.CreateNamespace = ptr.To(false)
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(MatchError(errRequeue))
+		_, err := getNamespace(r)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should not touch the namespace without metadata", func() {
+		app := newNamespaceApp()
+		app.Spec.TargetNamespaceMetadata = nil
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		_, err := getNamespace(r)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
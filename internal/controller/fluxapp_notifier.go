@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+const (
+	// notificationStatusReady is notified when the app becomes ready
+	notificationStatusReady = "Ready"
+	// notificationStatusFailed is notified when the app fails
+	notificationStatusFailed = "Failed"
+)
+
+// Notification is the JSON payload posted to the notification webhook
+type Notification struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Version   string `json:"version,omitempty"`
+	Status    string `json:"status"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Notifier posts a Notification to a webhook e.g. for ChatOps when an app becomes ready or fails
+// The last status notified for each app is kept so the same status isn't notified on every reconcile
+type Notifier struct {
+	client *http.Client
+	url    string
+
+	mu   sync.Mutex
+	last map[types.NamespacedName]string
+}
+
+// NewNotifier returns a Notifier posting to the URL with the HTTP client
+// If nil, the default HTTP client is used
+func NewNotifier(c *http.Client, url string) *Notifier {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	return &Notifier{client: c, url: url, last: map[types.NamespacedName]string{}}
+}
+
+// notificationStatus returns the status notified for the app
+// Nothing is notified while the app is progressing
+func notificationStatus(app *appsv1.FluxApp) string {
+	ready := conditions.Get(app, meta.ReadyCondition)
+	switch {
+	case ready == nil || progressing(ready):
+		return ""
+	case conditions.IsTrue(app, meta.ReadyCondition):
+		return notificationStatusReady
+	default:
+		return notificationStatusFailed
+	}
+}
+
+// Notify posts a notification if the status of the app has changed since the last notification
+// Before is the app before the reconcile so the status persisted by a previous controller isn't notified again
+// If the notification can't be posted it's tried again on the next reconcile
+func (n *Notifier) Notify(ctx context.Context, before, app *appsv1.FluxApp) error {
+	status := notificationStatus(app)
+	if status == "" {
+		return nil
+	}
+	key := client.ObjectKeyFromObject(app)
+	n.mu.Lock()
+	last, ok := n.last[key]
+	if !ok {
+		last = notificationStatus(before)
+	}
+	if status == last {
+		n.mu.Unlock()
+		return nil
+	}
+	n.last[key] = status
+	n.mu.Unlock()
+
+	if err := n.post(ctx, Notification{
+		Name:      app.Name,
+		Namespace: app.Namespace,
+		Version:   app.Status.Chart.Version,
+		Status:    status,
+		Message:   conditions.GetMessage(app, meta.ReadyCondition),
+	}); err != nil {
+		n.mu.Lock()
+		n.last[key] = last
+		n.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Forget removes the last status notified for a deleted app
+func (n *Notifier) Forget(key types.NamespacedName) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.last, key)
+}
+
+// post posts the notification as JSON
+func (n *Notifier) post(ctx context.Context, notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status posting notification: %s", resp.Status)
+	}
+	return nil
+}
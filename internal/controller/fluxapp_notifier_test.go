@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// stubReceiver records the notifications posted to it
+type stubReceiver struct {
+	*httptest.Server
+	mu            sync.Mutex
+	notifications []Notification
+	status        int
+}
+
+func newStubReceiver() *stubReceiver {
+	s := &stubReceiver{status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer GinkgoRecover()
+		Expect(req.Method).To(Equal(http.MethodPost))
+		Expect(req.Header.Get("Content-Type")).To(Equal("application/json"))
+		var n Notification
+		Expect(json.NewDecoder(req.Body).Decode(&n)).To(Succeed())
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.status == http.StatusOK {
+			s.notifications = append(s.notifications, n)
+		}
+		w.WriteHeader(s.status)
+	}))
+	return s
+}
+
+func (s *stubReceiver) received() []Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Notification(nil), s.notifications...)
+}
+
+var _ = Describe("Notifier", func() {
+	ctx := context.Background()
+
+	var (
+		receiver *stubReceiver
+		notifier *Notifier
+	)
+
+	BeforeEach(func() {
+		receiver = newStubReceiver()
+		DeferCleanup(receiver.Close)
+		notifier = NewNotifier(receiver.Client(), receiver.URL)
+	})
+
+	// withReady returns a copy of the app with the Ready condition
+	withReady := func(app *appsv1.FluxApp, status, reason, message string) *appsv1.FluxApp {
+		app = app.DeepCopy()
+		switch status {
+		case "True":
+			conditions.MarkTrue(app, meta.ReadyCondition, reason, "%s", message)
+		case "False":
+			conditions.MarkFalse(app, meta.ReadyCondition, reason, "%s", message)
+		default:
+			conditions.MarkUnknown(app, meta.ReadyCondition, reason, "%s", message)
+		}
+		return app
+	}
+
+	It("should notify the transitions only", func() {
+		app := newTestApp()
+		progressingApp := withReady(app, "Unknown", meta.ProgressingReason, "HelmRelease: installing")
+		readyApp := withReady(app, "True", meta.SucceededReason, "podinfo 6.5.3 deployed")
+		failedApp := withReady(app, "False", "UpgradeFailed", "Helm upgrade failed")
+
+		Expect(notifier.Notify(ctx, app, progressingApp)).To(Succeed())
+		Expect(receiver.received()).To(BeEmpty())
+		Expect(notifier.Notify(ctx, progressingApp, readyApp)).To(Succeed())
+		// The same status is debounced, even after progressing again
+		Expect(notifier.Notify(ctx, readyApp, readyApp)).To(Succeed())
+		Expect(notifier.Notify(ctx, readyApp, progressingApp)).To(Succeed())
+		Expect(notifier.Notify(ctx, progressingApp, readyApp)).To(Succeed())
+		Expect(notifier.Notify(ctx, readyApp, failedApp)).To(Succeed())
+		Expect(notifier.Notify(ctx, failedApp, failedApp)).To(Succeed())
+
+		Expect(receiver.received()).To(Equal([]Notification{
+			{Name: "podinfo", Namespace: "default", Version: "6.5.3", Status: "Ready", Message: "podinfo 6.5.3 deployed"},
+			{Name: "podinfo", Namespace: "default", Version: "6.5.3", Status: "Failed", Message: "Helm upgrade failed"},
+		}))
+	})
+
+	It("should not notify the status persisted before the controller started", func() {
+		readyApp := withReady(newTestApp(), "True", meta.SucceededReason, "podinfo 6.5.3 deployed")
+		Expect(notifier.Notify(ctx, readyApp, readyApp)).To(Succeed())
+		Expect(receiver.received()).To(BeEmpty())
+	})
+
+	It("should notify again once a failed post succeeds", func() {
+		app := newTestApp()
+		readyApp := withReady(app, "True", meta.SucceededReason, "podinfo 6.5.3 deployed")
+		receiver.status = http.StatusServiceUnavailable
+		Expect(notifier.Notify(ctx, app, readyApp)).To(MatchError(ContainSubstring("503")))
+		receiver.status = http.StatusOK
+		Expect(notifier.Notify(ctx, readyApp, readyApp)).To(Succeed())
+		Expect(receiver.received()).To(HaveLen(1))
+	})
+
+	It("should notify the same status again for a recreated app", func() {
+		app := newTestApp()
+		readyApp := withReady(app, "True", meta.SucceededReason, "podinfo 6.5.3 deployed")
+		Expect(notifier.Notify(ctx, app, readyApp)).To(Succeed())
+		notifier.Forget(client.ObjectKeyFromObject(app))
+		Expect(notifier.Notify(ctx, app, readyApp)).To(Succeed())
+		Expect(receiver.received()).To(HaveLen(2))
+	})
+
+	It("should notify when a reconcile fails", func() {
+		app := newTestApp()
+		app.Spec.TargetNamespace = "kube-system"
+		r := newTestReconciler(app)
+		r.PrivilegedNamespaces = []string{"flux-system"}
+		r.Notifier = notifier
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).To(HaveOccurred())
+		Expect(receiver.received()).To(HaveLen(1))
+		Expect(receiver.received()[0].Status).To(Equal("Failed"))
+		Expect(receiver.received()[0].Message).To(ContainSubstring("kube-system"))
+		// Reconciling the same failure again isn't notified
+		_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).To(HaveOccurred())
+		Expect(receiver.received()).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/blang/semver/v4"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// overrideVersionAnnotation deploys the chart version it's set to instead of the version selected by the ImagePolicy
+// It's a break-glass for when the ImagePolicy is stuck but the version to deploy is known
+const overrideVersionAnnotation = "apps.kloudy.uk/override-version"
+
+// requestedOverrideVersion returns the version of the override version annotation
+// Charts pulled from Git aren't versioned by the ImagePolicy so can't be overridden
+func requestedOverrideVersion(app *appsv1.FluxApp) string {
+	if gitSource(app) {
+		return ""
+	}
+	return app.GetAnnotations()[overrideVersionAnnotation]
+}
+
+// versionOverridden returns true if the app has the override version annotation set
+func versionOverridden(app *appsv1.FluxApp) bool {
+	return requestedOverrideVersion(app) != ""
+}
+
+// overrideVersion sets the chart version from the override version annotation and records the override in the status
+// Removing the annotation clears the override so the version selected by the ImagePolicy is deployed again
+func overrideVersion(app *appsv1.FluxApp) error {
+	if !versionOverridden(app) {
+		app.Status.Chart.OverrideVersion = ""
+		conditions.Delete(app, appsv1.VersionOverriddenCondition)
+		return nil
+	}
+	version := requestedOverrideVersion(app)
+	if _, err := semver.Parse(version); err != nil {
+		conditions.MarkFalse(app, meta.ReadyCondition, appsv1.InvalidOverrideVersionReason,
+			"%s annotation %q is not a SemVer version", overrideVersionAnnotation, version)
+		return fmt.Errorf("%w %s annotation %q: %w", errInvalid, overrideVersionAnnotation, version, err)
+	}
+	app.Status.Chart.Version = version
+	app.Status.Chart.OverrideVersion = version
+	conditions.MarkTrue(app, appsv1.VersionOverriddenCondition, appsv1.OverriddenVersionReason,
+		"chart version is overridden to %s, remove the %s annotation to deploy the version selected by the ImagePolicy",
+		version, overrideVersionAnnotation)
+	return nil
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+)
+
+var _ = Describe("Override version", func() {
+	ctx := context.Background()
+
+	newOverrideApp := func(version string) *appsv1.FluxApp {
+		app := newTestApp()
+		app.Annotations = map[string]string{overrideVersionAnnotation: version}
+		return app
+	}
+
+	It("should deploy the override version instead of the selected version", func() {
+		app := newOverrideApp("6.4.0")
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Chart.Spec.Version).To(Equal("6.4.0"))
+		Expect(app.Status.Chart.Version).To(Equal("6.4.0"))
+		Expect(app.Status.Chart.OverrideVersion).To(Equal("6.4.0"))
+		Expect(conditions.IsTrue(app, appsv1.VersionOverriddenCondition)).To(BeTrue())
+		Expect(conditions.GetMessage(app, appsv1.VersionOverriddenCondition)).To(ContainSubstring("6.4.0"))
+	})
+
+	It("should deploy the selected version once the annotation is removed", func() {
+		app := newOverrideApp("6.4.0")
+		policy := &imagev1.ImagePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace},
+			Status:     imagev1.ImagePolicyStatus{LatestImage: "ghcr.io/stefanprodan/charts/podinfo:6.5.3"},
+		}
+		r := newTestReconciler(policy)
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		delete(app.Annotations, overrideVersionAnnotation)
+		Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Chart.Spec.Version).To(Equal("6.5.3"))
+		Expect(app.Status.Chart.OverrideVersion).To(BeEmpty())
+		Expect(conditions.Has(app, appsv1.VersionOverriddenCondition)).To(BeFalse())
+	})
+
+	It("should deploy the override version while the ImagePolicy fails", func() {
+		app := newOverrideApp("6.4.0")
+		app.Spec.Chart.Version = "~> 99"
+		app.Status = appsv1.FluxAppStatus{}
+		policy := &imagev1.ImagePolicy{ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace}}
+		conditions.MarkFalse(policy, meta.ReadyCondition, meta.ReconciliationFailedReason,
+			"cannot determine latest tag for policy: unable to determine latest version from provided list")
+		r := newTestReconciler(app, policy)
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).NotTo(HaveOccurred())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Chart.Spec.Version).To(Equal("6.4.0"))
+		Expect(hr.Spec.Chart.Spec.SourceRef.Kind).To(Equal(sourcev1.HelmRepositoryKind))
+		current := &appsv1.FluxApp{}
+		Expect(r.Get(ctx, client.ObjectKeyFromObject(app), current)).To(Succeed())
+		Expect(current.Status.Chart.OverrideVersion).To(Equal("6.4.0"))
+		Expect(conditions.IsTrue(current, appsv1.VersionOverriddenCondition)).To(BeTrue())
+	})
+
+	It("should reject an override version which isn't a SemVer version", func() {
+		app := newOverrideApp("latest")
+		r := newTestReconciler()
+		err := handleHelmRelease(ctx, r, app)
+		Expect(err).To(MatchError(errInvalid))
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.InvalidOverrideVersionReason))
+		Expect(app.Status.Chart.Version).To(Equal("6.5.3"))
+	})
+
+	It("should ignore the override version for charts pulled from Git", func() {
+		app := newOverrideApp("6.4.0")
+		app.Spec.SourceKind = sourcev1.GitRepositoryKind
+		Expect(overrideVersion(app)).To(Succeed())
+		Expect(app.Status.Chart.Version).To(Equal("6.5.3"))
+		Expect(conditions.Has(app, appsv1.VersionOverriddenCondition)).To(BeFalse())
+	})
+})
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fluxcd/pkg/runtime/conditions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+)
+
+// chartVersionAnnotation records the chart version of a HelmRelease deploying the chart from an OCIRepository
+// as the HelmRelease only references the OCIRepository
+const chartVersionAnnotation = "apps.kloudy.uk/chart-version"
+
+// helmChartLayerMediaType is the media type of the layer holding the chart in a Helm chart OCI artifact
+const helmChartLayerMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+
+// pinDigest returns true if the chart is deployed by the digest of the selected version
+// A chart in a Git repository has no digest so can't be pinned
+func pinDigest(app *appsv1.FluxApp) bool {
+	return app.Spec.Chart.PinDigest && !gitSource(app)
+}
+
+// handleOCIRepository pins the selected chart version to the digest its tag points to in an OCIRepository
+// and returns the reference for the HelmRelease
+// The digest is only resolved when the chart version changes so moving the tag can't change what's deployed,
+// apart from a floating tag whose digest is resolved by every scan
+// If the chart isn't pinned the OCIRepository is deleted and no reference is returned
+func handleOCIRepository(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) (*helmv2.CrossNamespaceSourceReference, error) {
+	// Get the OCIRepository managed resource
+	mr, err := r.ResourceManager.Get(ctx, app, sourcev1beta2.OCIRepositoryKind)
+	if err != nil {
+		return nil, err
+	}
+	if !pinDigest(app) {
+		conditions.Delete(app, appsv1.OCIRepositoryReadyCondition)
+		// A floating tag keeps the digest it's tracked by
+		if !floatingTag(app) {
+			app.Status.Chart.Digest = ""
+		}
+		return nil, r.ResourceManager.Delete(ctx, mr)
+	}
+	if r.ChartDigests == nil {
+		return nil, fmt.Errorf("%w: chart.pinDigest can't be used as the controller can't resolve chart digests", errInvalid)
+	}
+	ociRepository := mr.Object.(*sourcev1beta2.OCIRepository)
+	tag := chartTag(app)
+	digest := app.Status.Chart.Digest
+	if ref := ociRepository.Spec.Reference; ref == nil || ref.Tag != tag || digest == "" {
+		digest, err = r.ChartDigests.ChartDigest(ctx, app.Status.Chart.Repository, app.Status.Chart.Name, tag)
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve the digest of %s:%s: %w", app.Status.Chart.Name, tag, err)
+		}
+	}
+	chart, err := r.ChartCache.Resolve(app)
+	if err != nil {
+		return nil, err
+	}
+	// Update the spec
+	// The tag is kept alongside the digest so it's clear which version is deployed
+	ociRepository.Spec = sourcev1beta2.OCIRepositorySpec{
+		URL:       app.Spec.Chart.Repository,
+		Reference: &sourcev1beta2.OCIRepositoryRef{Tag: tag, Digest: digest},
+		LayerSelector: &sourcev1beta2.OCILayerSelector{
+			MediaType: helmChartLayerMediaType,
+			Operation: sourcev1beta2.OCILayerCopy,
+		},
+		Provider:  chart.provider,
+		SecretRef: chartAuthRef(r, app),
+		Interval:  metav1.Duration{Duration: r.childInterval(app, scanInterval(app).Duration)},
+	}
+	mergeLabels(ociRepository, app.Spec.Chart.RepositoryLabels)
+	app.Status.Chart.Digest = digest
+	mirrorChildReady(app, appsv1.OCIRepositoryReadyCondition, sourcev1beta2.OCIRepositoryKind, ociRepository)
+	// Update the resource
+	if err := r.ResourceManager.Update(ctx, mr); err != nil {
+		return nil, err
+	}
+	return &helmv2.CrossNamespaceSourceReference{
+		Kind:      sourcev1beta2.OCIRepositoryKind,
+		Name:      ociRepository.Name,
+		Namespace: app.Namespace,
+	}, nil
+}
+
+// pinChart deploys the HelmRelease chart from the OCIRepository instead of the chart template
+// recording the chart version on the HelmRelease, or removes the record if the chart isn't pinned
+func pinChart(app *appsv1.FluxApp, hr *helmv2.HelmRelease, chartRef *helmv2.CrossNamespaceSourceReference) {
+	annotations := hr.GetAnnotations()
+	if chartRef == nil {
+		delete(annotations, chartVersionAnnotation)
+		hr.SetAnnotations(annotations)
+		return
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[chartVersionAnnotation] = chartTag(app)
+	hr.SetAnnotations(annotations)
+	hr.Spec.Chart = nil
+	hr.Spec.ChartRef = chartRef
+}
+
+// helmReleaseChartVersion returns the chart version the HelmRelease is set to deploy, tagged with any tag prefix
+// or an empty string if it isn't set e.g. for a new HelmRelease
+func helmReleaseChartVersion(hr *helmv2.HelmRelease) string {
+	if hr.Spec.Chart != nil {
+		return hr.Spec.Chart.Spec.Version
+	}
+	if hr.Spec.ChartRef != nil {
+		return hr.GetAnnotations()[chartVersionAnnotation]
+	}
+	return ""
+}
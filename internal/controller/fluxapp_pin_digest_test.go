@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+)
+
+var _ = Describe("Pin digest", func() {
+	ctx := context.Background()
+	const (
+		digest    = "sha256:0b1e4d3f"
+		newDigest = "sha256:9c8f7a6e"
+	)
+
+	var (
+		r       *FluxAppReconciler
+		digests *fakeChartDigests
+		app     *appsv1.FluxApp
+	)
+
+	BeforeEach(func() {
+		r = newTestReconciler()
+		digests = &fakeChartDigests{digest: digest}
+		r.ChartDigests = digests
+		app = newTestApp()
+		app.Spec.Chart.PinDigest = true
+	})
+
+	getOCIRepository := func() (*sourcev1beta2.OCIRepository, error) {
+		repo := &sourcev1beta2.OCIRepository{}
+		err := r.Get(ctx, types.NamespacedName{Name: r.ResourceManager.OCIRepositoryName(app), Namespace: app.Namespace}, repo)
+		return repo, err
+	}
+
+	It("should deploy the chart by the digest of the selected version", func() {
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(digests.tag).To(Equal("6.5.3"))
+		Expect(app.Status.Chart.Digest).To(Equal(digest))
+
+		repo, err := getOCIRepository()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(metav1.IsControlledBy(repo, app)).To(BeTrue())
+		Expect(repo.Spec.URL).To(Equal("oci://ghcr.io/stefanprodan/charts/podinfo"))
+		Expect(repo.Spec.Reference).To(Equal(&sourcev1beta2.OCIRepositoryRef{Tag: "6.5.3", Digest: digest}))
+		Expect(repo.Spec.LayerSelector).To(Equal(&sourcev1beta2.OCILayerSelector{
+			MediaType: helmChartLayerMediaType,
+			Operation: sourcev1beta2.OCILayerCopy,
+		}))
+
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Chart).To(BeNil())
+		Expect(hr.Spec.ChartRef).To(Equal(&helmv2.CrossNamespaceSourceReference{
+			Kind:      sourcev1beta2.OCIRepositoryKind,
+			Name:      "podinfo-chart",
+			Namespace: "default",
+		}))
+		Expect(hr.Annotations).To(HaveKeyWithValue(chartVersionAnnotation, "6.5.3"))
+		Expect(helmReleaseChartVersion(hr)).To(Equal("6.5.3"))
+	})
+
+	It("should keep the digest while the chart version is unchanged", func() {
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		// The tag is moved to another artifact
+		digests.digest = newDigest
+		digests.tag = ""
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(digests.tag).To(BeEmpty())
+		repo, err := getOCIRepository()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(repo.Spec.Reference.Digest).To(Equal(digest))
+		Expect(app.Status.Chart.Digest).To(Equal(digest))
+	})
+
+	It("should resolve the digest of a new chart version", func() {
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		digests.digest = newDigest
+		app.Status.Chart.Version = "6.6.0"
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(digests.tag).To(Equal("6.6.0"))
+		repo, err := getOCIRepository()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(repo.Spec.Reference).To(Equal(&sourcev1beta2.OCIRepositoryRef{Tag: "6.6.0", Digest: newDigest}))
+		Expect(app.Status.Chart.Digest).To(Equal(newDigest))
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Annotations).To(HaveKeyWithValue(chartVersionAnnotation, "6.6.0"))
+	})
+
+	It("should resolve the digest of the prefixed tag", func() {
+		app.Spec.Chart.TagPrefix = "chart-"
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(digests.tag).To(Equal("chart-6.5.3"))
+	})
+
+	It("should go back to the chart template once the chart is unpinned", func() {
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		app.Spec.Chart.PinDigest = false
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		_, err := getOCIRepository()
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(app.Status.Chart.Digest).To(BeEmpty())
+		Expect(conditions.Has(app, appsv1.OCIRepositoryReadyCondition)).To(BeFalse())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.ChartRef).To(BeNil())
+		Expect(hr.Spec.Chart).NotTo(BeNil())
+		Expect(hr.Spec.Chart.Spec.Version).To(Equal("6.5.3"))
+		Expect(hr.Annotations).NotTo(HaveKey(chartVersionAnnotation))
+	})
+
+	It("should mirror the OCIRepository Ready condition", func() {
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		repo, err := getOCIRepository()
+		Expect(err).NotTo(HaveOccurred())
+		conditions.MarkFalse(repo, meta.ReadyCondition, "OCIArtifactPullFailed", "failed to pull artifact")
+		Expect(r.Update(ctx, repo)).To(Succeed())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(conditions.GetReason(app, appsv1.OCIRepositoryReadyCondition)).To(Equal("OCIArtifactPullFailed"))
+		Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+		Expect(conditions.GetMessage(app, meta.ReadyCondition)).To(Equal("OCIRepository: failed to pull artifact"))
+	})
+
+	It("should fail if the digest can't be resolved", func() {
+		r.ChartDigests = nil
+		Expect(handleHelmRelease(ctx, r, app)).To(MatchError(errInvalid))
+		_, err := getHelmRelease(ctx, r, app)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should not pin a canary", func() {
+		app.Spec.Canary = &appsv1.Canary{Version: "6.6.0", Weight: 10}
+		Expect(handleHelmRelease(ctx, r, app)).To(MatchError(errInvalid))
+	})
+
+	It("should only call the hooks when the pinned chart version changes", func() {
+		post := newStubHook()
+		DeferCleanup(post.Close)
+		app.Spec.Hooks = &appsv1.Hooks{Post: []appsv1.Hook{{Name: "warm-cdn", URL: post.URL}}}
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(post.received()).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// plan records the action about to be taken on the resource in the plan of its app
+// Handlers running concurrently each record into their own copy of the app, see handleSources
+func (rm *ResourceManager) plan(res *managedResource, action appsv1.PlanAction) {
+	if res.app == nil {
+		return
+	}
+	kind := res.GetObjectKind().GroupVersionKind().Kind
+	if gvk, err := apiutil.GVKForObject(res.Object, rm.scheme); err == nil {
+		kind = gvk.Kind
+	}
+	addPlanEntry(res.app, appsv1.PlanEntry{Kind: kind, Name: res.GetName(), Action: action})
+}
+
+// addPlanEntry adds the entry to the plan of the app, replacing the entry of the same child
+// unless the child was changed earlier in the reconcile and is now found unchanged
+func addPlanEntry(app *appsv1.FluxApp, entry appsv1.PlanEntry) {
+	for i, e := range app.Status.Plan {
+		if e.Kind != entry.Kind || e.Name != entry.Name {
+			continue
+		}
+		if entry.Action != appsv1.PlanActionUnchanged {
+			app.Status.Plan[i] = entry
+		}
+		return
+	}
+	app.Status.Plan = append(app.Status.Plan, entry)
+}
+
+// settlePlan marks the plan of a converged app as unchanged as the handlers were skipped
+// because the children already match the spec, dropping the children which were deleted
+func settlePlan(app *appsv1.FluxApp) {
+	var plan []appsv1.PlanEntry
+	for _, e := range app.Status.Plan {
+		if e.Action == appsv1.PlanActionDelete {
+			continue
+		}
+		e.Action = appsv1.PlanActionUnchanged
+		plan = append(plan, e)
+	}
+	app.Status.Plan = plan
+}
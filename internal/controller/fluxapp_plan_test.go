@@ -0,0 +1,132 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+)
+
+var _ = Describe("Plan", func() {
+	ctx := context.Background()
+
+	// planOf returns the plan entries of each child in order
+	planOf := func(kinds ...string) func(actions ...appsv1.PlanAction) []appsv1.PlanEntry {
+		names := map[string]string{
+			"ImageRepository": "podinfo-chart",
+			"ImagePolicy":     "podinfo-chart",
+			"HelmRepository":  "ghcr-io-stefanprodan-charts",
+			"OCIRepository":   "podinfo-chart",
+			"HelmRelease":     "podinfo",
+		}
+		return func(actions ...appsv1.PlanAction) []appsv1.PlanEntry {
+			plan := make([]appsv1.PlanEntry, len(kinds))
+			for i, kind := range kinds {
+				plan[i] = appsv1.PlanEntry{Kind: kind, Name: names[kind], Action: actions[i]}
+			}
+			return plan
+		}
+	}
+	children := planOf("ImageRepository", "ImagePolicy", "HelmRepository", "HelmRelease")
+
+	Context("reconcile", func() {
+		var (
+			r   *FluxAppReconciler
+			app *appsv1.FluxApp
+			req reconcile.Request
+		)
+
+		getApp := func() *appsv1.FluxApp {
+			updated := &appsv1.FluxApp{}
+			Expect(r.Get(ctx, req.NamespacedName, updated)).To(Succeed())
+			return updated
+		}
+
+		BeforeEach(func() {
+			app = newTestApp()
+			r = newTestReconciler(app)
+			req = reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)}
+		})
+
+		It("should plan to create each child of a new app", func() {
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(getApp().Status.Plan).To(Equal(children(
+				appsv1.PlanActionCreate, appsv1.PlanActionCreate, appsv1.PlanActionCreate, appsv1.PlanActionCreate)))
+		})
+
+		It("should plan to update only the children the spec change affects", func() {
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			updated := getApp()
+			updated.Spec.Interval = &metav1.Duration{Duration: 5 * time.Minute}
+			Expect(r.Update(ctx, updated)).To(Succeed())
+			_, err = r.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(getApp().Status.Plan).To(Equal(children(
+				appsv1.PlanActionUnchanged, appsv1.PlanActionUnchanged, appsv1.PlanActionUnchanged, appsv1.PlanActionUpdate)))
+		})
+	})
+
+	It("should plan to delete a child which is no longer needed", func() {
+		r := newTestReconciler()
+		r.ChartDigests = &fakeChartDigests{digest: "sha256:0b1e4d3f"}
+		app := newTestApp()
+		app.Spec.Chart.PinDigest = true
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(app.Status.Plan).To(Equal(planOf("OCIRepository", "HelmRelease")(
+			appsv1.PlanActionCreate, appsv1.PlanActionCreate)))
+
+		app.Status.Plan = nil
+		app.Spec.Chart.PinDigest = false
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(app.Status.Plan).To(Equal(planOf("OCIRepository", "HelmRelease")(
+			appsv1.PlanActionDelete, appsv1.PlanActionUpdate)))
+	})
+
+	It("should not plan deleting a child which doesn't exist", func() {
+		r := newTestReconciler()
+		app := newTestApp()
+		mr, err := r.ResourceManager.Get(ctx, app, sourcev1beta2.OCIRepositoryKind)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.ResourceManager.Delete(ctx, mr)).To(Succeed())
+		Expect(app.Status.Plan).To(BeEmpty())
+	})
+
+	It("should keep a change when the child is found unchanged later in the reconcile", func() {
+		r := newTestReconciler()
+		app := newTestApp()
+		mr, err := r.ResourceManager.Get(ctx, app, helmv2.HelmReleaseKind)
+		Expect(err).NotTo(HaveOccurred())
+		r.ResourceManager.plan(mr, appsv1.PlanActionUpdate)
+		r.ResourceManager.plan(mr, appsv1.PlanActionUnchanged)
+		Expect(app.Status.Plan).To(Equal(planOf("HelmRelease")(appsv1.PlanActionUpdate)))
+	})
+
+	It("should plan the children of each handler when the handlers run in parallel", func() {
+		r := newTestReconciler()
+		app := newTestApp()
+		app.Annotations = map[string]string{featuresAnnotation: featureParallelHandlers}
+		policyErr, repoErr := handleSources(ctx, r, app)
+		Expect(policyErr).NotTo(HaveOccurred())
+		Expect(repoErr).NotTo(HaveOccurred())
+		Expect(app.Status.Plan).To(Equal(planOf("ImagePolicy", "HelmRepository")(
+			appsv1.PlanActionCreate, appsv1.PlanActionCreate)))
+	})
+
+	It("should drop deleted children from a converged plan", func() {
+		app := newTestApp()
+		app.Status.Plan = planOf("OCIRepository", "HelmRelease")(appsv1.PlanActionDelete, appsv1.PlanActionUpdate)
+		settlePlan(app)
+		Expect(app.Status.Plan).To(Equal(planOf("HelmRelease")(appsv1.PlanActionUnchanged)))
+	})
+})
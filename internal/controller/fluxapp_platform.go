@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/fluxcd/pkg/runtime/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// ociIndexMediaType is the media type of a multi-platform OCI artifact
+const ociIndexMediaType = "application/vnd.oci.image.index.v1+json"
+
+// ChartPlatformGetter lists the platforms the chart artifact with the tag is published for
+// e.g. linux/amd64. No platforms are returned for a platform independent artifact
+type ChartPlatformGetter interface {
+	ChartPlatforms(ctx context.Context, repository, name, tag string) ([]string, error)
+}
+
+// ChartPlatforms lists the platforms of the chart artifact with the tag from its OCI index
+// A single manifest isn't tied to a platform so no platforms are returned
+// The platforms of each chart version are cached as chart versions are immutable
+func (c *RegistryClient) ChartPlatforms(ctx context.Context, repository, name, tag string) ([]string, error) {
+	key := strings.TrimPrefix(repository, "oci://") + "/" + name + ":" + tag
+	c.mu.RLock()
+	platforms, ok := c.platforms[key]
+	c.mu.RUnlock()
+	if ok {
+		return platforms, nil
+	}
+	base, err := registryURL(repository, name)
+	if err != nil {
+		return nil, err
+	}
+	index := struct {
+		MediaType string `json:"mediaType"`
+		Manifests []struct {
+			Platform *struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+				Variant      string `json:"variant,omitempty"`
+			} `json:"platform,omitempty"`
+		} `json:"manifests"`
+	}{}
+	if err := c.get(ctx, base+"/manifests/"+tag, ociIndexMediaType+", "+ociManifestMediaType, &index); err != nil {
+		return nil, err
+	}
+	platforms = []string{}
+	if index.MediaType == ociIndexMediaType {
+		for _, m := range index.Manifests {
+			if m.Platform != nil && m.Platform.Architecture != "" {
+				platforms = append(platforms, path.Join(m.Platform.OS, m.Platform.Architecture, m.Platform.Variant))
+			}
+		}
+	}
+	c.mu.Lock()
+	c.platforms[key] = platforms
+	c.mu.Unlock()
+	return platforms, nil
+}
+
+// supportsArchitecture returns true if any of the os/arch[/variant] platforms has the architecture
+func supportsArchitecture(platforms []string, arch string) bool {
+	for _, p := range platforms {
+		parts := strings.Split(p, "/")
+		if len(parts) > 1 && parts[1] == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// handleChartPlatform sets the PlatformMismatch condition if the selected chart version lists platforms
+// without the cluster architecture, as the release is likely to fail to pull its images
+// It's a warning only and reading the platforms is best effort, so the condition is left as it was on a failure
+func handleChartPlatform(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) {
+	if r.ChartPlatforms == nil || r.ClusterArchitecture == "" || gitSource(app) || floatingTag(app) || app.Status.Chart.Version == "" {
+		conditions.Delete(app, appsv1.PlatformMismatchCondition)
+		return
+	}
+	platforms, err := r.ChartPlatforms.ChartPlatforms(ctx, app.Status.Chart.Repository, app.Status.Chart.Name, chartTag(app))
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to read chart platforms", "chart", app.Status.Chart.Name, "version", app.Status.Chart.Version)
+		return
+	}
+	if len(platforms) > 0 && !supportsArchitecture(platforms, r.ClusterArchitecture) {
+		conditions.MarkTrue(app, appsv1.PlatformMismatchCondition, appsv1.UnsupportedArchitectureReason,
+			"chart %s %s is published for %s but not the cluster architecture %s",
+			app.Status.Chart.Name, app.Status.Chart.Version, strings.Join(platforms, ", "), r.ClusterArchitecture)
+		return
+	}
+	conditions.Delete(app, appsv1.PlatformMismatchCondition)
+}
@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// fakeChartPlatforms returns the platforms for each tag
+type fakeChartPlatforms struct {
+	platforms map[string][]string
+}
+
+func (f *fakeChartPlatforms) ChartPlatforms(_ context.Context, _, _, tag string) ([]string, error) {
+	platforms, ok := f.platforms[tag]
+	if !ok {
+		return nil, errors.New("manifest unknown")
+	}
+	return platforms, nil
+}
+
+var _ = Describe("Chart platform", func() {
+	var (
+		ctx context.Context
+		r   *FluxAppReconciler
+		app *appsv1.FluxApp
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		r = newTestReconciler()
+		r.ClusterArchitecture = "amd64"
+		r.ChartPlatforms = &fakeChartPlatforms{platforms: map[string][]string{
+			"6.5.3": {"linux/arm64", "linux/arm/v7"},
+			"6.6.0": {"linux/amd64", "linux/arm64"},
+			"6.7.0": {},
+		}}
+		app = newTestApp()
+	})
+
+	It("should warn if the chart isn't published for the cluster architecture", func() {
+		handleChartPlatform(ctx, r, app)
+		Expect(conditions.IsTrue(app, appsv1.PlatformMismatchCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, appsv1.PlatformMismatchCondition)).To(Equal(appsv1.UnsupportedArchitectureReason))
+		Expect(conditions.GetMessage(app, appsv1.PlatformMismatchCondition)).To(Equal(
+			"chart podinfo 6.5.3 is published for linux/arm64, linux/arm/v7 but not the cluster architecture amd64"))
+		// It's a warning only
+		Expect(conditions.Has(app, "Ready")).To(BeFalse())
+	})
+
+	It("should remove the warning once the chart supports the cluster architecture", func() {
+		handleChartPlatform(ctx, r, app)
+		app.Status.Chart.Version = "6.6.0"
+		handleChartPlatform(ctx, r, app)
+		Expect(conditions.Has(app, appsv1.PlatformMismatchCondition)).To(BeFalse())
+	})
+
+	It("should not warn for a platform independent chart", func() {
+		app.Status.Chart.Version = "6.7.0"
+		handleChartPlatform(ctx, r, app)
+		Expect(conditions.Has(app, appsv1.PlatformMismatchCondition)).To(BeFalse())
+	})
+
+	It("should leave the condition as it was if the platforms can't be read", func() {
+		handleChartPlatform(ctx, r, app)
+		app.Status.Chart.Version = "6.8.0"
+		handleChartPlatform(ctx, r, app)
+		Expect(conditions.IsTrue(app, appsv1.PlatformMismatchCondition)).To(BeTrue())
+	})
+
+	It("should not check the platforms without the cluster architecture", func() {
+		r.ClusterArchitecture = ""
+		handleChartPlatform(ctx, r, app)
+		Expect(conditions.Has(app, appsv1.PlatformMismatchCondition)).To(BeFalse())
+	})
+
+	Context("RegistryClient", func() {
+		var repository string
+
+		BeforeEach(func() {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				Expect(req.Header.Get("Accept")).To(ContainSubstring(ociIndexMediaType))
+				switch req.URL.Path {
+				case "/v2/charts/podinfo/manifests/6.5.3":
+					_ = json.NewEncoder(w).Encode(map[string]interface{}{
+						"mediaType": ociIndexMediaType,
+						"manifests": []map[string]interface{}{
+							{"platform": map[string]string{"os": "linux", "architecture": "arm64"}},
+							{"platform": map[string]string{"os": "linux", "architecture": "arm", "variant": "v7"}},
+							{"mediaType": "application/vnd.oci.image.manifest.v1+json"},
+						},
+					})
+				case "/v2/charts/podinfo/manifests/6.6.0":
+					_ = json.NewEncoder(w).Encode(map[string]interface{}{
+						"mediaType": ociManifestMediaType,
+						"config":    map[string]string{"mediaType": helmConfigMediaType},
+					})
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			DeferCleanup(server.Close)
+			r.ChartPlatforms = NewRegistryClient(server.Client())
+			repository = "oci://" + strings.TrimPrefix(server.URL, "https://") + "/charts"
+		})
+
+		It("should list the platforms of an OCI index", func() {
+			platforms, err := r.ChartPlatforms.ChartPlatforms(ctx, repository, "podinfo", "6.5.3")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(platforms).To(Equal([]string{"linux/arm64", "linux/arm/v7"}))
+		})
+
+		It("should not list platforms for a single manifest", func() {
+			platforms, err := r.ChartPlatforms.ChartPlatforms(ctx, repository, "podinfo", "6.6.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(platforms).To(BeEmpty())
+		})
+
+		It("should match the cluster architecture against the manifest", func() {
+			app.Status.Chart.Repository = repository
+			handleChartPlatform(ctx, r, app)
+			Expect(conditions.IsTrue(app, appsv1.PlatformMismatchCondition)).To(BeTrue())
+			r.ClusterArchitecture = "arm64"
+			handleChartPlatform(ctx, r, app)
+			Expect(conditions.Has(app, appsv1.PlatformMismatchCondition)).To(BeFalse())
+		})
+	})
+})
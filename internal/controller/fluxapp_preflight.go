@@ -0,0 +1,31 @@
+package controller
+
+import (
+	"context"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// preflightPull checks the selected chart version can be pulled before the HelmRelease is changed to use it
+// so a release isn't created or upgraded with a chart that will fail to pull
+// It's only checked for apps with preflightPull set when the chart version of the HelmRelease changes
+// and the chart is pulled from a registry
+func preflightPull(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp, hr *helmv2.HelmRelease) error {
+	if !app.Spec.PreflightPull || r.ChartProber == nil || gitSource(app) {
+		return nil
+	}
+	if helmReleaseChartVersion(hr) == chartTag(app) {
+		return nil
+	}
+	if err := r.ChartProber.ProbeChart(ctx, app.Status.Chart.Repository, app.Status.Chart.Name, chartTag(app)); err != nil {
+		conditions.MarkFalse(app, meta.ReadyCondition, appsv1.ChartNotPullableReason,
+			"chart %s %s can't be pulled: %s", app.Status.Chart.Name, app.Status.Chart.Version, err)
+		setReconcileReason(ctx, reconcileReasonRequeueChartNotPullable)
+		return errRequeue
+	}
+	return nil
+}
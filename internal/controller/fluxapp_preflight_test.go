@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("Preflight pull", func() {
+	const token = "anonymous-token"
+
+	var (
+		ctx    context.Context
+		server *httptest.Server
+		probes []string
+		r      *FluxAppReconciler
+		app    *appsv1.FluxApp
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		probes = nil
+		// The stub registry only has podinfo 6.5.3 and requires an anonymous token
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/token" {
+				_, _ = w.Write([]byte(`{"token":"` + token + `"}`))
+				return
+			}
+			if req.Header.Get("Authorization") != "Bearer "+token {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="https://`+req.Host+`/token",service="registry"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			Expect(req.Method).To(Equal(http.MethodHead))
+			probes = append(probes, req.URL.Path)
+			if req.URL.Path != "/v2/charts/podinfo/manifests/6.5.3" {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		DeferCleanup(server.Close)
+		r = newTestReconciler()
+		r.ChartProber = NewRegistryClient(server.Client())
+		app = newTestApp()
+		app.Spec.PreflightPull = true
+		app.Status.Chart.Repository = "oci://" + strings.TrimPrefix(server.URL, "https://") + "/charts"
+	})
+
+	It("should create the HelmRelease once the chart can be pulled", func() {
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(probes).To(Equal([]string{"/v2/charts/podinfo/manifests/6.5.3"}))
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Chart.Spec.Version).To(Equal("6.5.3"))
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).NotTo(Equal(appsv1.ChartNotPullableReason))
+	})
+
+	It("should not create the HelmRelease while the chart can't be pulled", func() {
+		app.Status.Chart.Version = "6.6.0"
+		Expect(handleHelmRelease(ctx, r, app)).To(MatchError(errRequeue))
+		_, err := getHelmRelease(ctx, r, app)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.ChartNotPullableReason))
+		Expect(conditions.GetMessage(app, meta.ReadyCondition)).To(ContainSubstring("404"))
+	})
+
+	It("should not upgrade the HelmRelease while the new chart version can't be pulled", func() {
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		app.Status.Chart.Version = "6.6.0"
+		Expect(handleHelmRelease(ctx, r, app)).To(MatchError(errRequeue))
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Chart.Spec.Version).To(Equal("6.5.3"))
+	})
+
+	It("should treat an unreachable registry as not pullable", func() {
+		server.Close()
+		Expect(handleHelmRelease(ctx, r, app)).To(MatchError(errRequeue))
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.ChartNotPullableReason))
+	})
+
+	It("should only probe when the chart version changes", func() {
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(probes).To(HaveLen(1))
+	})
+
+	It("should probe the prefixed tag", func() {
+		app.Spec.Chart.TagPrefix = "podinfo-"
+		Expect(handleHelmRelease(ctx, r, app)).To(MatchError(errRequeue))
+		Expect(probes).To(Equal([]string{"/v2/charts/podinfo/manifests/podinfo-6.5.3"}))
+	})
+
+	It("should not probe unless the app opts in", func() {
+		app.Spec.PreflightPull = false
+		app.Status.Chart.Version = "6.6.0"
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(probes).To(BeEmpty())
+	})
+})
@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// privileged returns true if the app is in a privileged namespace
+// Every app is privileged unless the controller is configured with privileged namespaces
+func (r *FluxAppReconciler) privileged(app *appsv1.FluxApp) bool {
+	return r.PrivilegedNamespaces == nil || slices.Contains(r.PrivilegedNamespaces, app.Namespace)
+}
+
+// checkPrivileges returns an error if the app uses a capability only allowed in the privileged namespaces
+// Apps in other namespaces can only deploy into their own namespace, so a tenant can't deploy into
+// e.g. kube-system or the controller namespace, or to a remote cluster, or relabel their namespace
+func checkPrivileges(r *FluxAppReconciler, app *appsv1.FluxApp) error {
+	if r.privileged(app) {
+		return nil
+	}
+	if ns := app.Spec.TargetNamespace; ns != "" && ns != app.Namespace {
+		conditions.MarkFalse(app, meta.ReadyCondition, appsv1.NotPrivilegedReason,
+			"only FluxApps in the privileged namespaces can deploy to namespace %s", ns)
+		return fmt.Errorf("%w targetNamespace %s: only FluxApps in the privileged namespaces can deploy to another namespace", errInvalid, ns)
+	}
+	// The kubeconfig of a remote cluster reads a token mounted in the helm-controller
+	if remote := app.Spec.RemoteCluster; remote != nil {
+		conditions.MarkFalse(app, meta.ReadyCondition, appsv1.NotPrivilegedReason,
+			"only FluxApps in the privileged namespaces can deploy to remote cluster %s", remote.Server)
+		return fmt.Errorf("%w remoteCluster %s: only FluxApps in the privileged namespaces can deploy to a remote cluster", errInvalid, remote.Server)
+	}
+	// Namespace labels decide e.g. the pod security level, which a tenant can't normally change
+	if metadata := app.Spec.TargetNamespaceMetadata; metadata != nil && (len(metadata.Labels) > 0 || len(metadata.Annotations) > 0) {
+		conditions.MarkFalse(app, meta.ReadyCondition, appsv1.NotPrivilegedReason,
+			"only FluxApps in the privileged namespaces can set the target namespace metadata")
+		return fmt.Errorf("%w targetNamespaceMetadata: only FluxApps in the privileged namespaces can label or annotate the target namespace", errInvalid)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+)
+
+var _ = Describe("Privileged namespaces", func() {
+	ctx := context.Background()
+
+	newPrivilegedApp := func(namespace, targetNamespace string) *appsv1.FluxApp {
+		app := newTestApp()
+		app.Namespace = namespace
+		app.Spec.TargetNamespace = targetNamespace
+		return app
+	}
+
+	DescribeTable("should allow the target namespace",
+		func(privileged []string, app *appsv1.FluxApp) {
+			r := newTestReconciler()
+			r.PrivilegedNamespaces = privileged
+			Expect(checkPrivileges(r, app)).To(Succeed())
+			Expect(conditions.Has(app, meta.ReadyCondition)).To(BeFalse())
+		},
+		Entry("in a privileged namespace", []string{"flux-system"}, newPrivilegedApp("flux-system", "kube-system")),
+		Entry("in its own namespace", []string{"flux-system"}, newPrivilegedApp("team-a", "team-a")),
+		Entry("defaulted to its own namespace", []string{"flux-system"}, newPrivilegedApp("team-a", "")),
+		Entry("without privileged namespaces", nil, newPrivilegedApp("team-a", "kube-system")),
+	)
+
+	It("should reject another target namespace outside the privileged namespaces", func() {
+		r := newTestReconciler()
+		r.PrivilegedNamespaces = []string{"flux-system"}
+		app := newPrivilegedApp("team-a", "kube-system")
+		err := checkPrivileges(r, app)
+		Expect(err).To(MatchError(errInvalid))
+		Expect(err).To(MatchError(ContainSubstring("targetNamespace kube-system")))
+		Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.NotPrivilegedReason))
+	})
+
+	It("should reject a remote cluster outside the privileged namespaces", func() {
+		r := newTestReconciler()
+		r.PrivilegedNamespaces = []string{"flux-system"}
+		app := newPrivilegedApp("team-a", "")
+		app.Spec.RemoteCluster = &appsv1.RemoteCluster{
+			Server:    "https://attacker.example.com",
+			TokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+		}
+		err := checkPrivileges(r, app)
+		Expect(err).To(MatchError(errInvalid))
+		Expect(err).To(MatchError(ContainSubstring("remoteCluster https://attacker.example.com")))
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.NotPrivilegedReason))
+
+		app.Namespace = "flux-system"
+		Expect(checkPrivileges(r, app)).To(Succeed())
+	})
+
+	It("should reject the target namespace metadata outside the privileged namespaces", func() {
+		r := newTestReconciler()
+		r.PrivilegedNamespaces = []string{"flux-system"}
+		app := newPrivilegedApp("team-a", "")
+		app.Spec.TargetNamespaceMetadata = &appsv1.NamespaceMetadata{
+			Labels: map[string]string{"pod-security.kubernetes.io/enforce": "privileged"},
+		}
+		err := checkPrivileges(r, app)
+		Expect(err).To(MatchError(errInvalid))
+		Expect(err).To(MatchError(ContainSubstring("targetNamespaceMetadata")))
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.NotPrivilegedReason))
+
+		app.Namespace = "flux-system"
+		Expect(checkPrivileges(r, app)).To(Succeed())
+	})
+
+	It("should reject the app before creating any children", func() {
+		app := newPrivilegedApp("team-a", "flux-system")
+		r := newTestReconciler(app)
+		r.PrivilegedNamespaces = []string{"flux-system"}
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).To(MatchError(errInvalid))
+
+		current := &appsv1.FluxApp{}
+		Expect(r.Get(ctx, client.ObjectKeyFromObject(app), current)).To(Succeed())
+		Expect(conditions.GetReason(current, meta.ReadyCondition)).To(Equal(appsv1.NotPrivilegedReason))
+		Expect(current.Status.LastError).NotTo(BeNil())
+		Expect(current.Status.LastError.Type).To(Equal(appsv1.ErrorTypePermanent))
+		repo := &imagev1.ImageRepository{}
+		err = r.Get(ctx, client.ObjectKey{Name: "podinfo-chart", Namespace: app.Namespace}, repo)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should reconcile the same app in a privileged namespace", func() {
+		app := newPrivilegedApp("flux-system", "kube-system")
+		r := newTestReconciler(app)
+		r.PrivilegedNamespaces = []string{"flux-system"}
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).NotTo(HaveOccurred())
+		repo := &imagev1.ImageRepository{}
+		Expect(r.Get(ctx, client.ObjectKey{Name: "podinfo-chart", Namespace: app.Namespace}, repo)).To(Succeed())
+	})
+})
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/fluxcd/pkg/runtime/conditions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// defaultQuarantineInterval is how often a quarantined app is reconciled by default
+const defaultQuarantineInterval = time.Hour
+
+// quarantineInterval returns how often a quarantined app is reconciled
+func (r *FluxAppReconciler) quarantineInterval() time.Duration {
+	if r.QuarantineInterval > 0 {
+		return r.QuarantineInterval
+	}
+	return defaultQuarantineInterval
+}
+
+// quarantineRemaining returns how long is left before a quarantined app is reconciled again
+// The app is reconciled once the quarantine interval has passed since its last failure, which moves
+// the Quarantined condition on as its message counts the failures. The last error keeps the time it first occurred
+// A spec change clears the Quarantined condition as a stale condition so the app is reconciled straight away
+func (r *FluxAppReconciler) quarantineRemaining(app *appsv1.FluxApp) time.Duration {
+	quarantined := conditions.Get(app, appsv1.QuarantinedCondition)
+	if quarantined == nil || quarantined.Status != metav1.ConditionTrue {
+		return 0
+	}
+	return time.Until(quarantined.LastTransitionTime.Add(r.quarantineInterval()))
+}
+
+// countFailures tracks the consecutive failed reconciles of the current generation of the app
+// The app is quarantined once QuarantineAfter reconciles have failed in a row and released once one succeeds
+// Failures aren't counted unless quarantine is enabled so a failing app doesn't change its status every reconcile
+func (r *FluxAppReconciler) countFailures(before, app *appsv1.FluxApp, err error) {
+	if r.QuarantineAfter <= 0 {
+		app.Status.ConsecutiveFailures = 0
+		conditions.Delete(app, appsv1.QuarantinedCondition)
+		return
+	}
+	// The failures of the previous spec don't count against the new one
+	if before.Status.ObservedGeneration != app.Generation {
+		app.Status.ConsecutiveFailures = 0
+	}
+	if err == nil {
+		app.Status.ConsecutiveFailures = 0
+	} else {
+		app.Status.ConsecutiveFailures++
+	}
+	if app.Status.ConsecutiveFailures < int32(r.QuarantineAfter) {
+		conditions.Delete(app, appsv1.QuarantinedCondition)
+		return
+	}
+	conditions.MarkTrue(app, appsv1.QuarantinedCondition, appsv1.RepeatedFailuresReason,
+		"quarantined after %d consecutive failed reconciles, reconciling every %s until the spec changes",
+		app.Status.ConsecutiveFailures, r.quarantineInterval())
+}
+
+// quarantine replaces the error of a quarantined app with a requeue at the quarantine interval
+// so the error backoff doesn't keep retrying it
+func (r *FluxAppReconciler) quarantine(app *appsv1.FluxApp, result ctrl.Result, err error) (ctrl.Result, error) {
+	if err == nil || !conditions.IsTrue(app, appsv1.QuarantinedCondition) {
+		return result, err
+	}
+	return ctrl.Result{RequeueAfter: r.quarantineInterval()}, nil
+}
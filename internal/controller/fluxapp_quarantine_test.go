@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("Quarantine", func() {
+	ctx := context.Background()
+	errFailed := errors.New("failed")
+
+	It("should quarantine an app once enough reconciles fail in a row", func() {
+		r := newTestReconciler()
+		r.QuarantineAfter = 3
+		app := newTestApp()
+		app.Generation = 1
+		app.Status.ObservedGeneration = 1
+		for i := 0; i < 2; i++ {
+			r.countFailures(app.DeepCopy(), app, errFailed)
+			Expect(conditions.Has(app, appsv1.QuarantinedCondition)).To(BeFalse())
+		}
+		r.countFailures(app.DeepCopy(), app, errFailed)
+		Expect(app.Status.ConsecutiveFailures).To(Equal(int32(3)))
+		Expect(conditions.IsTrue(app, appsv1.QuarantinedCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, appsv1.QuarantinedCondition)).To(Equal(appsv1.RepeatedFailuresReason))
+		Expect(conditions.GetMessage(app, appsv1.QuarantinedCondition)).To(Equal(
+			"quarantined after 3 consecutive failed reconciles, reconciling every 1h0m0s until the spec changes"))
+
+		// A successful reconcile releases the app
+		r.countFailures(app.DeepCopy(), app, nil)
+		Expect(app.Status.ConsecutiveFailures).To(BeZero())
+		Expect(conditions.Has(app, appsv1.QuarantinedCondition)).To(BeFalse())
+	})
+
+	It("should only count the failures of the current generation", func() {
+		r := newTestReconciler()
+		r.QuarantineAfter = 3
+		app := newTestApp()
+		app.Generation = 2
+		app.Status.ObservedGeneration = 1
+		app.Status.ConsecutiveFailures = 4
+		r.countFailures(app.DeepCopy(), app, errFailed)
+		Expect(app.Status.ConsecutiveFailures).To(Equal(int32(1)))
+	})
+
+	It("should not count failures unless quarantine is enabled", func() {
+		r := newTestReconciler()
+		app := newTestApp()
+		for i := 0; i < 10; i++ {
+			r.countFailures(app.DeepCopy(), app, errFailed)
+		}
+		// The status of an app failing in the same way doesn't change between reconciles
+		Expect(app.Status.ConsecutiveFailures).To(BeZero())
+		Expect(conditions.Has(app, appsv1.QuarantinedCondition)).To(BeFalse())
+	})
+
+	It("should not reconcile an app again for its own status patch", func() {
+		app := newTestApp()
+		app.Generation = 1
+		updated := app.DeepCopy()
+		updated.Status.LastError = &appsv1.LastError{Message: "failed"}
+		Expect(appChanged.Update(event.UpdateEvent{ObjectOld: app, ObjectNew: updated})).To(BeFalse())
+
+		updated.Generation = 2
+		Expect(appChanged.Update(event.UpdateEvent{ObjectOld: app, ObjectNew: updated})).To(BeTrue())
+		updated = app.DeepCopy()
+		updated.Annotations = map[string]string{"reconcile.fluxcd.io/requestedAt": "now"}
+		Expect(appChanged.Update(event.UpdateEvent{ObjectOld: app, ObjectNew: updated})).To(BeTrue())
+	})
+
+	Context("reconcile", func() {
+		var (
+			r   *FluxAppReconciler
+			app *appsv1.FluxApp
+			req reconcile.Request
+		)
+
+		// getApp fetches the persisted app
+		getApp := func() *appsv1.FluxApp {
+			updated := &appsv1.FluxApp{}
+			Expect(r.Get(ctx, req.NamespacedName, updated)).To(Succeed())
+			return updated
+		}
+
+		BeforeEach(func() {
+			// The app isn't allowed to deploy to another namespace so every reconcile fails
+			app = newTestApp()
+			app.Namespace = "team-a"
+			app.Generation = 1
+			app.Spec.TargetNamespace = "kube-system"
+			r = newTestReconciler(app)
+			r.PrivilegedNamespaces = []string{"flux-system"}
+			r.DisableFinalizer = true
+			r.QuarantineAfter = 2
+			r.QuarantineInterval = 30 * time.Minute
+			req = reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)}
+		})
+
+		It("should requeue a quarantined app at the quarantine interval instead of returning the error", func() {
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).To(MatchError(errInvalid))
+			Expect(getApp().Status.ConsecutiveFailures).To(Equal(int32(1)))
+
+			result, err := r.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(30 * time.Minute))
+			updated := getApp()
+			Expect(updated.Status.ConsecutiveFailures).To(Equal(int32(2)))
+			Expect(conditions.IsTrue(updated, appsv1.QuarantinedCondition)).To(BeTrue())
+			Expect(updated.Status.LastError).NotTo(BeNil())
+		})
+
+		It("should skip a quarantined app until it's due", func() {
+			for i := 0; i < 2; i++ {
+				_, _ = r.Reconcile(ctx, req)
+			}
+			skipped := testutil.ToFloat64(reconcileTotal.WithLabelValues(reconcileReasonQuarantined))
+			result, err := r.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(testutil.ToFloat64(reconcileTotal.WithLabelValues(reconcileReasonQuarantined))).To(Equal(skipped + 1))
+			Expect(result.RequeueAfter).To(BeNumerically(">", 29*time.Minute))
+			Expect(result.RequeueAfter).To(BeNumerically("<=", 30*time.Minute))
+			updated := getApp()
+			Expect(updated.Status.ConsecutiveFailures).To(Equal(int32(2)))
+			Expect(updated.Status.LastError).NotTo(BeNil())
+
+			// Once due, the app is reconciled again
+			for i := range updated.Status.Conditions {
+				if updated.Status.Conditions[i].Type == appsv1.QuarantinedCondition {
+					updated.Status.Conditions[i].LastTransitionTime = metav1.NewTime(time.Now().Add(-time.Hour))
+				}
+			}
+			Expect(r.Status().Update(ctx, updated)).To(Succeed())
+			result, err = r.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(30 * time.Minute))
+			Expect(getApp().Status.ConsecutiveFailures).To(Equal(int32(3)))
+		})
+
+		It("should release the app when the spec changes", func() {
+			for i := 0; i < 2; i++ {
+				_, _ = r.Reconcile(ctx, req)
+			}
+			Expect(conditions.IsTrue(getApp(), appsv1.QuarantinedCondition)).To(BeTrue())
+
+			updated := getApp()
+			updated.Spec.TargetNamespace = ""
+			updated.Generation = 2
+			Expect(r.Update(ctx, updated)).To(Succeed())
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			updated = getApp()
+			Expect(updated.Status.ConsecutiveFailures).To(BeZero())
+			Expect(conditions.Has(updated, appsv1.QuarantinedCondition)).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,29 @@
+package controller
+
+import (
+	"regexp"
+
+	"github.com/fluxcd/pkg/runtime/conditions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// authFailurePattern matches the ImageRepository messages of scans rejected by the registry
+// The image-reflector-controller reports these as a generic read failure with the registry response in the message
+var authFailurePattern = regexp.MustCompile(`(?i)\b(401|403)\b|unauthori[sz]ed|forbidden|denied|authentication required`)
+
+// classifyAuthFailure marks the ImageRepositoryReady condition as an authentication failure
+// if the ImageRepository isn't ready because the registry rejected the scan
+// so the Ready condition points at the credentials rather than a scan which will never finish
+func classifyAuthFailure(app *appsv1.FluxApp) {
+	c := conditions.Get(app, appsv1.ImageRepositoryReadyCondition)
+	if c == nil || c.Status == metav1.ConditionTrue || c.Reason == appsv1.AuthenticationFailedReason {
+		return
+	}
+	if !authFailurePattern.MatchString(c.Message) {
+		return
+	}
+	conditions.MarkFalse(app, appsv1.ImageRepositoryReadyCondition, appsv1.AuthenticationFailedReason, "%s", c.Message)
+	aggregateReady(app)
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+)
+
+var _ = Describe("Registry authentication failures", func() {
+	ctx := context.Background()
+
+	// scannedApp handles the ImageRepository of an app with the child Ready condition
+	scannedApp := func(status metav1.ConditionStatus, reason, message string) *appsv1.FluxApp {
+		app := newTestApp()
+		repo := &imagev1.ImageRepository{ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace}}
+		conditions.Set(repo, &metav1.Condition{Type: meta.ReadyCondition, Status: status, Reason: reason, Message: message})
+		r := newTestReconciler(repo)
+		Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+		return app
+	}
+
+	DescribeTable("should report scans rejected by the registry as an authentication failure",
+		func(status metav1.ConditionStatus, reason, message string) {
+			app := scannedApp(status, reason, message)
+			Expect(conditions.IsFalse(app, appsv1.ImageRepositoryReadyCondition)).To(BeTrue())
+			Expect(conditions.GetReason(app, appsv1.ImageRepositoryReadyCondition)).To(Equal(appsv1.AuthenticationFailedReason))
+			Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+			Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.AuthenticationFailedReason))
+			Expect(conditions.GetMessage(app, meta.ReadyCondition)).To(Equal("ImageRepository: " + message))
+		},
+		Entry("401", metav1.ConditionFalse, imagev1.ReadOperationFailedReason,
+			"GET https://ghcr.io/token: unexpected status code 401 Unauthorized"),
+		Entry("403", metav1.ConditionFalse, imagev1.ReadOperationFailedReason,
+			"GET https://ghcr.io/v2/stefanprodan/charts/podinfo/tags/list: 403 Forbidden"),
+		Entry("denied", metav1.ConditionFalse, imagev1.ReadOperationFailedReason,
+			"DENIED: requested access to the resource is denied"),
+		Entry("while the scan is retried", metav1.ConditionUnknown, meta.ProgressingReason,
+			"UNAUTHORIZED: authentication required"),
+	)
+
+	It("should not classify other failures", func() {
+		app := scannedApp(metav1.ConditionFalse, imagev1.ReadOperationFailedReason, "dial tcp: lookup ghcr.io: no such host")
+		Expect(conditions.GetReason(app, appsv1.ImageRepositoryReadyCondition)).To(Equal(imagev1.ReadOperationFailedReason))
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(imagev1.ReadOperationFailedReason))
+	})
+
+	It("should not classify a scan in progress", func() {
+		app := scannedApp(metav1.ConditionUnknown, meta.ProgressingReason, "reconciliation in progress")
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(meta.ProgressingReason))
+	})
+})
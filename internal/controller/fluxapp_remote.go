@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// remoteKubeConfigKey is the key of the kubeconfig in the generated Secret
+// This is the default key used by the HelmRelease KubeConfig reference
+const remoteKubeConfigKey = "value"
+
+// remoteClusterName is the name of the cluster, context & user in the generated kubeconfig
+const remoteClusterName = "remote"
+
+// remoteKubeConfig returns a kubeconfig for the remote cluster
+// The token isn't embedded, the helm-controller reads it from the token file on each use
+func remoteKubeConfig(remote *appsv1.RemoteCluster) ([]byte, error) {
+	cfg := clientcmdapi.NewConfig()
+	cfg.Clusters[remoteClusterName] = &clientcmdapi.Cluster{
+		Server:                   remote.Server,
+		CertificateAuthorityData: []byte(remote.CertificateAuthority),
+	}
+	cfg.AuthInfos[remoteClusterName] = &clientcmdapi.AuthInfo{
+		TokenFile: remote.TokenFile,
+	}
+	cfg.Contexts[remoteClusterName] = &clientcmdapi.Context{
+		Cluster:  remoteClusterName,
+		AuthInfo: remoteClusterName,
+	}
+	cfg.CurrentContext = remoteClusterName
+	return clientcmd.Write(*cfg)
+}
+
+// handleRemoteCluster creates or updates the Secret holding the remote cluster kubeconfig
+// and returns the reference for the HelmRelease
+// If the app isn't deployed to a remote cluster the Secret is deleted and no reference is returned
+func handleRemoteCluster(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) (*meta.KubeConfigReference, error) {
+	// Get the kubeconfig Secret managed resource
+	mr, err := r.ResourceManager.Get(ctx, app, RemoteKubeConfigKind)
+	if err != nil {
+		return nil, err
+	}
+	if app.Spec.RemoteCluster == nil {
+		return nil, r.ResourceManager.Delete(ctx, mr)
+	}
+	kubeConfig, err := remoteKubeConfig(app.Spec.RemoteCluster)
+	if err != nil {
+		return nil, err
+	}
+	secret := mr.Object.(*corev1.Secret)
+	secret.Type = corev1.SecretTypeOpaque
+	secret.Data = map[string][]byte{remoteKubeConfigKey: kubeConfig}
+	if err := r.ResourceManager.Update(ctx, mr); err != nil {
+		return nil, err
+	}
+	return &meta.KubeConfigReference{
+		SecretRef: meta.SecretKeyReference{
+			Name: secret.Name,
+			Key:  remoteKubeConfigKey,
+		},
+	}, nil
+}
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/utils/ptr"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("Remote cluster", func() {
+	ctx := context.Background()
+
+	remote := func() *appsv1.RemoteCluster {
+		return &appsv1.RemoteCluster{
+			Server:               "https://spoke.example.com:6443",
+			TokenFile:            "/var/run/secrets/tokens/spoke",
+			CertificateAuthority: "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n",
+		}
+	}
+
+	getSecret := func(r *FluxAppReconciler, app *appsv1.FluxApp) (*corev1.Secret, error) {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Name: "podinfo-kubeconfig", Namespace: app.Namespace}, secret)
+		return secret, err
+	}
+
+	It("should build a kubeconfig which reads the token file", func() {
+		b, err := remoteKubeConfig(remote())
+		Expect(err).NotTo(HaveOccurred())
+		cfg, err := clientcmd.Load(b)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.CurrentContext).To(Equal(remoteClusterName))
+		Expect(cfg.Clusters[remoteClusterName].Server).To(Equal("https://spoke.example.com:6443"))
+		Expect(string(cfg.Clusters[remoteClusterName].CertificateAuthorityData)).To(Equal(remote().CertificateAuthority))
+		Expect(cfg.AuthInfos[remoteClusterName].TokenFile).To(Equal("/var/run/secrets/tokens/spoke"))
+		Expect(cfg.AuthInfos[remoteClusterName].Token).To(BeEmpty())
+	})
+
+	It("should reference the generated kubeconfig from the HelmRelease", func() {
+		app := newTestApp()
+		app.Spec.RemoteCluster = remote()
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.KubeConfig).To(Equal(&meta.KubeConfigReference{
+			SecretRef: meta.SecretKeyReference{Name: "podinfo-kubeconfig", Key: "value"},
+		}))
+		secret, err := getSecret(r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret.OwnerReferences).To(HaveLen(1))
+		cfg, err := clientcmd.Load(secret.Data["value"])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Clusters[remoteClusterName].Server).To(Equal("https://spoke.example.com:6443"))
+	})
+
+	It("should not check the target namespace exists on a remote cluster", func() {
+		app := newTestApp()
+		app.Spec.CreateNamespace = ptr.To(false)
+		app.Spec.TargetNamespace = "remote-only"
+		app.Spec.RemoteCluster = remote()
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+	})
+
+	It("should remove the kubeconfig when the app is no longer remote", func() {
+		app := newTestApp()
+		app.Spec.RemoteCluster = remote()
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+
+		app.Spec.RemoteCluster = nil
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.KubeConfig).To(BeNil())
+		_, err = getSecret(r, app)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -2,43 +2,173 @@ package controller
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"strconv"
 	"strings"
 
 	helmv2 "github.com/fluxcd/helm-controller/api/v2"
 	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
 	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
+// CanaryHelmReleaseKind is used to get the canary HelmRelease from the ResourceManager
+// The canary is a HelmRelease but is named differently to the stable release
+const CanaryHelmReleaseKind = "CanaryHelmRelease"
+
+// RemoteKubeConfigKind is used to get the Secret holding the remote cluster kubeconfig from the ResourceManager
+const RemoteKubeConfigKind = "RemoteKubeConfig"
+
+// DefaultValuesKind is used to get the ConfigMap holding the app's copy of the default values from the ResourceManager
+const DefaultValuesKind = "DefaultValues"
+
+// InlineValuesKind is used to get the ConfigMap holding the inline values merged before the valuesFrom from the ResourceManager
+const InlineValuesKind = "InlineValues"
+
+// ChartAuthKind is used to get the Secret holding the chart repository credentials from the ResourceManager
+const ChartAuthKind = "ChartAuth"
+
+// AuditLogKind is used to get the ConfigMap holding the audit log of the app from the ResourceManager
+const AuditLogKind = "AuditLog"
+
+// OwnerReferenceMode is how the FluxApp is set as the owner of the children
+type OwnerReferenceMode string
+
+const (
+	// ControllerOwnerReferenceMode sets the FluxApp as the controller of the children
+	// Deleting a child in the foreground waits for the FluxApp and no other controller can own the child
+	ControllerOwnerReferenceMode OwnerReferenceMode = "controller"
+	// NonControllerOwnerReferenceMode sets the FluxApp as an owner of the children
+	// so other systems can also own them, the children are still garbage collected once every owner is deleted
+	NonControllerOwnerReferenceMode OwnerReferenceMode = "non-controller"
+)
+
+// ParseOwnerReferenceMode returns the OwnerReferenceMode, or an error if it isn't supported
+func ParseOwnerReferenceMode(s string) (OwnerReferenceMode, error) {
+	switch mode := OwnerReferenceMode(s); mode {
+	case ControllerOwnerReferenceMode, NonControllerOwnerReferenceMode:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported owner reference mode: %s", s)
+	}
+}
+
+// appliedGenerationAnnotation records the generation of a child once the controller has applied it
+// A child whose generation has moved on since has had its spec changed by something else e.g. edited by hand
+const appliedGenerationAnnotation = "apps.kloudy.uk/applied-generation"
+
 type ResourceManager struct {
-	c      client.Client
-	scheme *runtime.Scheme
+	c                  client.Client
+	scheme             *runtime.Scheme
+	ownerReferenceMode OwnerReferenceMode
+	// version is recorded on the children it applies, see SetControllerDefaults
+	version string
 }
 
 type managedResource struct {
 	client.Object
 	patch client.Patch
+	// app is the owner whose plan records what's done to the resource
+	app *appsv1.FluxApp
+	// kind the resource was got as, which selects the app's child patch
+	kind string
 }
 
-func NewResourceManager(c client.Client, scheme *runtime.Scheme) *ResourceManager {
-	return &ResourceManager{c, scheme}
+// NewResourceManager returns a ResourceManager which sets the owner references of new children using the mode
+func NewResourceManager(c client.Client, scheme *runtime.Scheme, mode OwnerReferenceMode) *ResourceManager {
+	return &ResourceManager{c, scheme, mode, childrenVersion}
 }
 
+// SetControllerDefaults includes the controller defaults applied to the children in the version recorded on them
+// so the children applied with other defaults, e.g. before the controller was restarted, are stale
+// Without any defaults the version is the childrenVersion
+func (rm *ResourceManager) SetControllerDefaults(defaults string) {
+	rm.version = childrenVersion
+	if defaults != "" {
+		sum := sha256.Sum256([]byte(defaults))
+		rm.version += "-" + hex.EncodeToString(sum[:4])
+	}
+}
+
+// OwnerReferenceMode returns how the FluxApp is set as the owner of the children
+func (rm *ResourceManager) OwnerReferenceMode() OwnerReferenceMode {
+	return rm.ownerReferenceMode
+}
+
+// Update creates the resource if it doesn't exist, otherwise patches it
+// An existing resource which hasn't changed isn't patched so the children aren't written on every reconcile
 func (rm *ResourceManager) Update(ctx context.Context, res *managedResource) error {
+	changed, err := rm.Changed(res)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !changed:
+		rm.plan(res, appsv1.PlanActionUnchanged)
+	case res.patch == nil:
+		rm.plan(res, appsv1.PlanActionCreate)
+		if err := rm.c.Create(ctx, res.Object); err != nil {
+			return err
+		}
+	default:
+		rm.plan(res, appsv1.PlanActionUpdate)
+		if err := rm.c.Patch(ctx, res.Object, res.patch); err != nil {
+			return err
+		}
+	}
+	return rm.recordGeneration(ctx, res)
+}
+
+// recordGeneration records the generation of the applied resource
+// The generation is only known once the resource is written, so it's recorded by a second patch
+// which doesn't change the generation as it only changes the metadata
+// Resources without a generation e.g. ConfigMaps & Secrets aren't recorded
+func (rm *ResourceManager) recordGeneration(ctx context.Context, res *managedResource) error {
+	if !edited(res) {
+		return nil
+	}
+	patch := client.MergeFrom(res.DeepCopyObject().(client.Object))
+	mergeAnnotations(res, map[string]string{appliedGenerationAnnotation: strconv.FormatInt(res.GetGeneration(), 10)})
+	return rm.c.Patch(ctx, res.Object, patch)
+}
+
+// Changed returns true if Update would create the resource or change the existing resource
+// The app's child patch is applied first so the patched spec is what's compared and applied
+func (rm *ResourceManager) Changed(res *managedResource) (bool, error) {
+	if err := patchChild(res); err != nil {
+		return false, err
+	}
+	setChildrenVersion(res, rm.version)
+	if res.patch == nil {
+		return true, nil
+	}
+	data, err := res.patch.Data(res.Object)
+	if err != nil {
+		return false, err
+	}
+	return string(data) != "{}", nil
+}
+
+// Delete deletes the resource if it exists
+func (rm *ResourceManager) Delete(ctx context.Context, res *managedResource) error {
 	if res.patch == nil {
-		return rm.c.Create(ctx, res.Object)
+		return nil
 	}
-	return rm.c.Patch(ctx, res.Object, res.patch)
+	rm.plan(res, appsv1.PlanActionDelete)
+	return client.IgnoreNotFound(rm.c.Delete(ctx, res.Object))
 }
 
 func (rm *ResourceManager) Get(ctx context.Context, app *appsv1.FluxApp, kind string) (*managedResource, error) {
 	// Initialise a ManagedResource object based on the kind
-	mr := &managedResource{}
+	mr := &managedResource{app: app, kind: kind}
 	key := types.NamespacedName{}
 	switch kind {
 	case imagev1.ImageRepositoryKind:
@@ -50,9 +180,33 @@ func (rm *ResourceManager) Get(ctx context.Context, app *appsv1.FluxApp, kind st
 	case sourcev1.HelmRepositoryKind:
 		mr.Object = &sourcev1.HelmRepository{}
 		key.Name = rm.HelmRepositoryName(app)
+	case sourcev1.GitRepositoryKind:
+		mr.Object = &sourcev1.GitRepository{}
+		key.Name = rm.GitRepositoryName(app)
+	case sourcev1beta2.OCIRepositoryKind:
+		mr.Object = &sourcev1beta2.OCIRepository{}
+		key.Name = rm.OCIRepositoryName(app)
 	case helmv2.HelmReleaseKind:
 		mr.Object = &helmv2.HelmRelease{}
 		key.Name = rm.HelmReleaseName(app)
+	case CanaryHelmReleaseKind:
+		mr.Object = &helmv2.HelmRelease{}
+		key.Name = rm.CanaryHelmReleaseName(app)
+	case RemoteKubeConfigKind:
+		mr.Object = &corev1.Secret{}
+		key.Name = rm.RemoteKubeConfigName(app)
+	case DefaultValuesKind:
+		mr.Object = &corev1.ConfigMap{}
+		key.Name = rm.DefaultValuesName(app)
+	case InlineValuesKind:
+		mr.Object = &corev1.ConfigMap{}
+		key.Name = rm.InlineValuesName(app)
+	case ChartAuthKind:
+		mr.Object = &corev1.Secret{}
+		key.Name = rm.ChartAuthName(app)
+	case AuditLogKind:
+		mr.Object = &corev1.ConfigMap{}
+		key.Name = rm.AuditLogName(app)
 	default:
 		return nil, fmt.Errorf("unsupported kind: %s", kind)
 	}
@@ -64,10 +218,10 @@ func (rm *ResourceManager) Get(ctx context.Context, app *appsv1.FluxApp, kind st
 			return nil, err
 		}
 		// Object not found
-		// so set the name, namespace & controller ref on our empty object
+		// so set the name, namespace & owner ref on our empty object
 		mr.SetName(key.Name)
 		mr.SetNamespace(key.Namespace)
-		if err := controllerutil.SetControllerReference(app, mr, rm.scheme); err != nil {
+		if err := rm.setOwnerReference(app, mr); err != nil {
 			return nil, err
 		}
 	} else {
@@ -80,8 +234,16 @@ func (rm *ResourceManager) Get(ctx context.Context, app *appsv1.FluxApp, kind st
 			mr.patch = client.MergeFrom(o.DeepCopy())
 		case *sourcev1.HelmRepository:
 			mr.patch = client.MergeFrom(o.DeepCopy())
+		case *sourcev1.GitRepository:
+			mr.patch = client.MergeFrom(o.DeepCopy())
+		case *sourcev1beta2.OCIRepository:
+			mr.patch = client.MergeFrom(o.DeepCopy())
 		case *helmv2.HelmRelease:
 			mr.patch = client.MergeFrom(o.DeepCopy())
+		case *corev1.Secret:
+			mr.patch = client.MergeFrom(o.DeepCopy())
+		case *corev1.ConfigMap:
+			mr.patch = client.MergeFrom(o.DeepCopy())
 		default:
 			return nil, fmt.Errorf("unsupported kind: %s", o.GetObjectKind().GroupVersionKind().Kind)
 		}
@@ -90,6 +252,14 @@ func (rm *ResourceManager) Get(ctx context.Context, app *appsv1.FluxApp, kind st
 	return mr, nil
 }
 
+// setOwnerReference sets the FluxApp as the owner of the object using the owner reference mode
+func (rm *ResourceManager) setOwnerReference(app *appsv1.FluxApp, obj client.Object) error {
+	if rm.ownerReferenceMode == NonControllerOwnerReferenceMode {
+		return controllerutil.SetOwnerReference(app, obj, rm.scheme)
+	}
+	return controllerutil.SetControllerReference(app, obj, rm.scheme)
+}
+
 func (rm *ResourceManager) ImageRepositoryName(app *appsv1.FluxApp) string {
 	return strings.Join([]string{app.Name, "chart"}, "-")
 }
@@ -98,10 +268,63 @@ func (rm *ResourceManager) ImagePolicyName(app *appsv1.FluxApp) string {
 	return rm.ImageRepositoryName(app)
 }
 
+// HelmRepositoryName returns the name of the HelmRepository the chart is pulled from
+// The apps pulling from the same registry share a HelmRepository unless the app has its own, see ownHelmRepository
 func (rm *ResourceManager) HelmRepositoryName(app *appsv1.FluxApp) string {
+	if ownHelmRepository(app) {
+		return rm.OwnHelmRepositoryName(app)
+	}
 	return strings.NewReplacer(".", "-", "/", "-").Replace(strings.TrimPrefix(app.Status.Chart.Repository, "oci://"))
 }
 
+// OwnHelmRepositoryName returns the name of the HelmRepository of an app which doesn't share one
+func (rm *ResourceManager) OwnHelmRepositoryName(app *appsv1.FluxApp) string {
+	return strings.Join([]string{app.Name, "chart"}, "-")
+}
+
+func (rm *ResourceManager) GitRepositoryName(app *appsv1.FluxApp) string {
+	return strings.Join([]string{app.Name, "chart"}, "-")
+}
+
+func (rm *ResourceManager) OCIRepositoryName(app *appsv1.FluxApp) string {
+	return strings.Join([]string{app.Name, "chart"}, "-")
+}
+
+// ChartSourceName returns the name of the source the HelmRelease chart is pulled from
+func (rm *ResourceManager) ChartSourceName(app *appsv1.FluxApp) string {
+	if gitSource(app) {
+		return rm.GitRepositoryName(app)
+	}
+	return rm.HelmRepositoryName(app)
+}
+
 func (rm *ResourceManager) HelmReleaseName(app *appsv1.FluxApp) string {
+	if app.Spec.ReleaseName != "" {
+		return app.Spec.ReleaseName
+	}
 	return app.Name
 }
+
+func (rm *ResourceManager) CanaryHelmReleaseName(app *appsv1.FluxApp) string {
+	return strings.Join([]string{rm.HelmReleaseName(app), "canary"}, "-")
+}
+
+func (rm *ResourceManager) RemoteKubeConfigName(app *appsv1.FluxApp) string {
+	return strings.Join([]string{app.Name, "kubeconfig"}, "-")
+}
+
+func (rm *ResourceManager) DefaultValuesName(app *appsv1.FluxApp) string {
+	return strings.Join([]string{app.Name, "default-values"}, "-")
+}
+
+func (rm *ResourceManager) InlineValuesName(app *appsv1.FluxApp) string {
+	return strings.Join([]string{app.Name, "inline-values"}, "-")
+}
+
+func (rm *ResourceManager) ChartAuthName(app *appsv1.FluxApp) string {
+	return strings.Join([]string{app.Name, "chart-auth"}, "-")
+}
+
+func (rm *ResourceManager) AuditLogName(app *appsv1.FluxApp) string {
+	return strings.Join([]string{app.Name, "audit"}, "-")
+}
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"context"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("ResourceManager", func() {
+	var (
+		ctx context.Context
+		r   *FluxAppReconciler
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		r = newTestReconciler()
+	})
+
+	// createChild creates the ImageRepository of the app using the owner reference mode
+	createChild := func(mode OwnerReferenceMode) *imagev1.ImageRepository {
+		app := newTestApp()
+		app.UID = "fluxapp-uid"
+		rm := NewResourceManager(r.Client, r.Scheme, mode)
+		mr, err := rm.Get(ctx, app, imagev1.ImageRepositoryKind)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rm.Update(ctx, mr)).To(Succeed())
+		repo := &imagev1.ImageRepository{}
+		Expect(r.Get(ctx, client.ObjectKeyFromObject(mr.Object), repo)).To(Succeed())
+		return repo
+	}
+
+	It("should set the FluxApp as the controller of new children", func() {
+		repo := createChild(ControllerOwnerReferenceMode)
+		Expect(repo.OwnerReferences).To(HaveLen(1))
+		Expect(metav1.GetControllerOf(repo)).NotTo(BeNil())
+		Expect(repo.OwnerReferences[0].UID).To(BeEquivalentTo("fluxapp-uid"))
+		Expect(repo.OwnerReferences[0].BlockOwnerDeletion).To(Equal(ptr.To(true)))
+	})
+
+	It("should set the FluxApp as a non-controller owner of new children", func() {
+		repo := createChild(NonControllerOwnerReferenceMode)
+		Expect(repo.OwnerReferences).To(HaveLen(1))
+		Expect(metav1.GetControllerOf(repo)).To(BeNil())
+		Expect(repo.OwnerReferences[0].UID).To(BeEquivalentTo("fluxapp-uid"))
+		Expect(repo.OwnerReferences[0].Kind).To(Equal("FluxApp"))
+		Expect(repo.OwnerReferences[0].BlockOwnerDeletion).To(BeNil())
+	})
+
+	DescribeTable("should parse the owner reference mode",
+		func(s string, expected OwnerReferenceMode, valid bool) {
+			mode, err := ParseOwnerReferenceMode(s)
+			if !valid {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mode).To(Equal(expected))
+		},
+		Entry("controller", "controller", ControllerOwnerReferenceMode, true),
+		Entry("non-controller", "non-controller", NonControllerOwnerReferenceMode, true),
+		Entry("unsupported", "owner", OwnerReferenceMode(""), false),
+	)
+})
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+)
+
+// childrenVersion is the version of the children generated by the controller
+// Bump this whenever a change to the controller changes the generated specs so existing
+// children are re-applied when the controller is upgraded
+const childrenVersion = "1"
+
+// childrenVersionAnnotation records the childrenVersion which last applied a child
+const childrenVersionAnnotation = "apps.kloudy.uk/children-version"
+
+// childKinds are the kinds of the children generated for each app
+var childKinds = []string{
+	imagev1.ImageRepositoryKind,
+	imagev1.ImagePolicyKind,
+	sourcev1.HelmRepositoryKind,
+	sourcev1.GitRepositoryKind,
+	sourcev1beta2.OCIRepositoryKind,
+	helmv2.HelmReleaseKind,
+	CanaryHelmReleaseKind,
+	RemoteKubeConfigKind,
+	DefaultValuesKind,
+	InlineValuesKind,
+	ChartAuthKind,
+	AuditLogKind,
+}
+
+// setChildrenVersion records the version of the children on the child
+func setChildrenVersion(obj client.Object, version string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[childrenVersionAnnotation] = version
+	obj.SetAnnotations(annotations)
+}
+
+// edited returns true if the spec of the child has changed since the controller applied it
+// Children without a generation can't be checked so are never edited
+func edited(obj client.Object) bool {
+	generation := obj.GetGeneration()
+	return generation != 0 && obj.GetAnnotations()[appliedGenerationAnnotation] != strconv.FormatInt(generation, 10)
+}
+
+// controllerDefaults returns the controller defaults applied to the generated children
+// or an empty string if none are set
+func (r *FluxAppReconciler) controllerDefaults() string {
+	if r.DefaultTimeout <= 0 && r.DefaultMaxHistory <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("timeout=%s,maxHistory=%d", r.DefaultTimeout, r.DefaultMaxHistory)
+}
+
+// childrenStale returns true if any existing children of the app were applied by a different version
+// Children which don't exist yet aren't stale as the next reconcile will create them
+func childrenStale(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) (bool, error) {
+	for _, kind := range childKinds {
+		mr, err := r.ResourceManager.Get(ctx, app, kind)
+		if err != nil {
+			return false, err
+		}
+		if mr.patch == nil {
+			continue
+		}
+		if mr.GetAnnotations()[childrenVersionAnnotation] != r.ResourceManager.version {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ResyncChildren enqueues every app with children applied by a different childrenVersion
+// so they're re-applied even though the app spec hasn't changed
+// It's run by the manager once the caches have synced
+func (r *FluxAppReconciler) ResyncChildren(ctx context.Context) error {
+	log := log.FromContext(ctx)
+	apps := &appsv1.FluxAppList{}
+	if err := r.listApps(ctx, apps); err != nil {
+		return err
+	}
+	var resynced int
+	for i := range apps.Items {
+		app := &apps.Items[i]
+		stale, err := childrenStale(ctx, r, app)
+		if err != nil {
+			log.Error(err, "unable to check children version", "fluxapp", client.ObjectKeyFromObject(app))
+			continue
+		}
+		if !stale {
+			continue
+		}
+		select {
+		case r.resync <- event.GenericEvent{Object: app}:
+			resynced++
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	log.Info("resynced children", "fluxapps", resynced, "version", r.ResourceManager.version)
+	return nil
+}
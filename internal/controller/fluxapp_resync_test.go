@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+var _ = Describe("Resync", func() {
+	ctx := context.Background()
+
+	// reconcileChildren applies all the children of the app
+	reconcileChildren := func(r *FluxAppReconciler, app *appsv1.FluxApp) {
+		Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+		Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRepository(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+	}
+
+	// staleHelmRelease returns a HelmRelease applied by an older version of the controller
+	staleHelmRelease := func(app *appsv1.FluxApp) *helmv2.HelmRelease {
+		return &helmv2.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        app.Name,
+				Namespace:   app.Namespace,
+				Annotations: map[string]string{childrenVersionAnnotation: "0"},
+			},
+		}
+	}
+
+	It("should record the children version on the children", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		reconcileChildren(r, app)
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Annotations).To(HaveKeyWithValue(childrenVersionAnnotation, childrenVersion))
+	})
+
+	It("should not consider missing children stale", func() {
+		r := newTestReconciler()
+		Expect(childrenStale(ctx, r, newTestApp())).To(BeFalse())
+	})
+
+	It("should not consider current children stale", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		reconcileChildren(r, app)
+		Expect(childrenStale(ctx, r, app)).To(BeFalse())
+	})
+
+	It("should consider children applied by another version stale", func() {
+		app := newTestApp()
+		r := newTestReconciler(staleHelmRelease(app))
+		Expect(childrenStale(ctx, r, app)).To(BeTrue())
+	})
+
+	It("should re-apply stale children when the version is bumped", func() {
+		app := newTestApp()
+		r := newTestReconciler(staleHelmRelease(app))
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(childrenStale(ctx, r, app)).To(BeFalse())
+	})
+
+	It("should consider children applied with other controller defaults stale", func() {
+		app := newTestApp()
+		r := newTestReconciler()
+		reconcileChildren(r, app)
+		// The controller restarted with a default timeout
+		r.DefaultTimeout = 10 * time.Minute
+		r.ResourceManager.SetControllerDefaults(r.controllerDefaults())
+		Expect(childrenStale(ctx, r, app)).To(BeTrue())
+		reconcileChildren(r, app)
+		Expect(childrenStale(ctx, r, app)).To(BeFalse())
+
+		// Restarting without any defaults goes back to the plain version
+		r.DefaultTimeout = 0
+		r.ResourceManager.SetControllerDefaults(r.controllerDefaults())
+		Expect(r.ResourceManager.version).To(Equal(childrenVersion))
+	})
+
+	It("should record the generation of the applied children", func() {
+		app := newTestApp()
+		existing := staleHelmRelease(app)
+		existing.Generation = 3
+		r := newTestReconciler(existing)
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Annotations).To(HaveKeyWithValue(appliedGenerationAnnotation, "3"))
+		Expect(edited(hr)).To(BeFalse())
+
+		// A child edited by something else has moved on from the recorded generation
+		hr.Generation = 4
+		Expect(edited(hr)).To(BeTrue())
+	})
+
+	It("should only enqueue apps with stale children", func() {
+		current := newTestApp()
+		stale := newTestApp()
+		stale.Name = "stale"
+		r := newTestReconciler(current, stale, staleHelmRelease(stale))
+		reconcileChildren(r, current)
+		r.resync = make(chan event.GenericEvent, 2)
+		Expect(r.ResyncChildren(ctx)).To(Succeed())
+		close(r.resync)
+		var enqueued []string
+		for e := range r.resync {
+			enqueued = append(enqueued, e.Object.GetName())
+		}
+		Expect(enqueued).To(ConsistOf("stale"))
+	})
+})
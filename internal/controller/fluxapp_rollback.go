@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// rollbackAnnotation pins the app to the chart version deployed before the current version while it's set to true
+const rollbackAnnotation = "apps.kloudy.uk/rollback"
+
+// maxHistory is how many deployed chart versions are kept in the app status
+const maxHistory = 10
+
+// recordHistory adds the chart version Helm last deployed to the history if it's changed
+func recordHistory(app *appsv1.FluxApp) {
+	version := app.Status.Chart.AppliedVersion
+	if version == "" || (len(app.Status.History) > 0 && app.Status.History[0].Version == version) {
+		return
+	}
+	history := append([]appsv1.VersionHistory{{Version: version, DeployedAt: metav1.Now()}}, app.Status.History...)
+	if len(history) > maxHistory {
+		history = history[:maxHistory]
+	}
+	app.Status.History = history
+}
+
+// rollbackRequested returns true if the app has the rollback annotation set
+func rollbackRequested(app *appsv1.FluxApp) bool {
+	return app.GetAnnotations()[rollbackAnnotation] == "true"
+}
+
+// rollback pins the chart version to the previous version in the history while the rollback annotation is set
+// and returns true if the version is pinned
+// The version is looked up once and kept in the status so the rollback doesn't move once the previous version
+// is deployed. Removing the annotation clears the pin so version updates resume
+func rollback(app *appsv1.FluxApp) (bool, error) {
+	if !rollbackRequested(app) {
+		app.Status.Chart.RollbackVersion = ""
+		return false, nil
+	}
+	if app.Status.Chart.RollbackVersion == "" {
+		previous := previousVersion(app)
+		if previous == "" {
+			conditions.MarkFalse(app, meta.ReadyCondition, appsv1.NoRollbackVersionReason,
+				"no previous chart version in the history to roll back to")
+			return false, fmt.Errorf("%w %s annotation: no previous chart version in the history", errInvalid, rollbackAnnotation)
+		}
+		app.Status.Chart.RollbackVersion = previous
+	}
+	app.Status.Chart.Version = app.Status.Chart.RollbackVersion
+	markVersionUpdatesPaused(app, appsv1.RolledBackReason,
+		"rolled back to %s, remove the %s annotation to resume version updates", app.Status.Chart.Version, rollbackAnnotation)
+	return true, nil
+}
+
+// previousVersion returns the most recent version in the history before the version deployed now
+func previousVersion(app *appsv1.FluxApp) string {
+	current := app.Status.Chart.AppliedVersion
+	if current == "" && len(app.Status.History) > 0 {
+		current = app.Status.History[0].Version
+	}
+	for _, h := range app.Status.History {
+		if h.Version != current {
+			return h.Version
+		}
+	}
+	return ""
+}
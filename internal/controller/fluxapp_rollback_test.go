@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+)
+
+var _ = Describe("Rollback", func() {
+	ctx := context.Background()
+
+	newPolicy := func(app *appsv1.FluxApp, tag string) *imagev1.ImagePolicy {
+		return &imagev1.ImagePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace},
+			Status:     imagev1.ImagePolicyStatus{LatestImage: "ghcr.io/stefanprodan/charts/podinfo:" + tag},
+		}
+	}
+
+	// deploy records the version as deployed by the helm-controller
+	deploy := func(r *FluxAppReconciler, app *appsv1.FluxApp, version string) {
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		hr.Status.History = append(helmv2.Snapshots{{
+			Version:      len(hr.Status.History) + 1,
+			ChartName:    "podinfo",
+			ChartVersion: version,
+			Status:       releaseStatusDeployed,
+		}}, hr.Status.History...)
+		Expect(r.Update(ctx, hr)).To(Succeed())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+	}
+
+	It("should record the deployed versions in the history", func() {
+		app := newTestApp()
+		r := newTestReconciler(newPolicy(app, "6.5.3"))
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(app.Status.History).To(BeEmpty())
+		deploy(r, app, "6.5.3")
+		deploy(r, app, "6.6.0")
+		// Reconciling without a new deployment doesn't add to the history
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		Expect(app.Status.History).To(HaveLen(2))
+		Expect(app.Status.History[0].Version).To(Equal("6.6.0"))
+		Expect(app.Status.History[1].Version).To(Equal("6.5.3"))
+	})
+
+	It("should keep a limited history", func() {
+		app := newTestApp()
+		for i := 0; i < maxHistory+5; i++ {
+			app.Status.Chart.AppliedVersion = fmt.Sprintf("6.%d.0", i)
+			recordHistory(app)
+		}
+		Expect(app.Status.History).To(HaveLen(maxHistory))
+		Expect(app.Status.History[0].Version).To(Equal(fmt.Sprintf("6.%d.0", maxHistory+4)))
+	})
+
+	It("should pin the previous version until the annotation is removed", func() {
+		app := newTestApp()
+		app.Status.Chart.Version = "6.6.0"
+		app.Status.Chart.AppliedVersion = "6.6.0"
+		app.Status.History = []appsv1.VersionHistory{{Version: "6.6.0"}, {Version: "6.5.3"}}
+		app.Annotations = map[string]string{rollbackAnnotation: "true"}
+		r := newTestReconciler(newPolicy(app, "6.6.0"))
+		Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+		Expect(app.Status.Chart.Version).To(Equal("6.5.3"))
+		Expect(app.Status.Chart.RollbackVersion).To(Equal("6.5.3"))
+		Expect(app.Status.Chart.AvailableVersion).To(Equal("6.6.0"))
+		Expect(conditions.IsTrue(app, appsv1.VersionUpdatesPausedCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, appsv1.VersionUpdatesPausedCondition)).To(Equal(appsv1.RolledBackReason))
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Chart.Spec.Version).To(Equal("6.5.3"))
+
+		// The rollback stays on the same version once it's deployed
+		deploy(r, app, "6.5.3")
+		Expect(app.Status.History[0].Version).To(Equal("6.5.3"))
+		Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+		Expect(app.Status.Chart.Version).To(Equal("6.5.3"))
+
+		// Removing the annotation resumes version updates
+		delete(app.Annotations, rollbackAnnotation)
+		Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+		Expect(app.Status.Chart.Version).To(Equal("6.6.0"))
+		Expect(app.Status.Chart.RollbackVersion).To(BeEmpty())
+		Expect(conditions.Has(app, appsv1.VersionUpdatesPausedCondition)).To(BeFalse())
+	})
+
+	It("should fail without a previous version in the history", func() {
+		app := newTestApp()
+		app.Status.Chart.AppliedVersion = "6.5.3"
+		app.Status.History = []appsv1.VersionHistory{{Version: "6.5.3"}}
+		app.Annotations = map[string]string{rollbackAnnotation: "true"}
+		r := newTestReconciler(newPolicy(app, "6.5.3"))
+		err := handleImagePolicy(ctx, r, app)
+		Expect(err).To(MatchError(errInvalid))
+		Expect(classifyError(err)).To(Equal(appsv1.ErrorTypePermanent))
+		Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.NoRollbackVersionReason))
+		Expect(app.Status.Chart.RollbackVersion).To(BeEmpty())
+	})
+})
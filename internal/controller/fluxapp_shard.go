@@ -0,0 +1,24 @@
+package controller
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// inShard returns true if the object matches the LabelSelector of the controller
+// All objects are in the shard when no selector is set
+func (r *FluxAppReconciler) inShard(obj client.Object) bool {
+	return r.LabelSelector == nil || r.LabelSelector.Matches(labels.Set(obj.GetLabels()))
+}
+
+// listApps lists the apps in the shard of the controller
+func (r *FluxAppReconciler) listApps(ctx context.Context, apps *appsv1.FluxAppList, opts ...client.ListOption) error {
+	if r.LabelSelector != nil {
+		opts = append(opts, client.MatchingLabelsSelector{Selector: r.LabelSelector})
+	}
+	return r.List(ctx, apps, opts...)
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+var _ = Describe("Sharding", func() {
+	ctx := context.Background()
+
+	// shardedApp returns an app in the named shard
+	shardedApp := func(name, shard string) *appsv1.FluxApp {
+		app := newTestApp()
+		app.Name = name
+		app.Labels = map[string]string{"shard": shard}
+		return app
+	}
+
+	It("should include every app without a selector", func() {
+		r := newTestReconciler()
+		Expect(r.inShard(shardedApp("podinfo", "b"))).To(BeTrue())
+		Expect(r.inShard(newTestApp())).To(BeTrue())
+	})
+
+	It("should only include apps matching the selector", func() {
+		r := newTestReconciler()
+		r.LabelSelector = labels.SelectorFromSet(labels.Set{"shard": "a"})
+		Expect(r.inShard(shardedApp("podinfo", "a"))).To(BeTrue())
+		Expect(r.inShard(shardedApp("podinfo", "b"))).To(BeFalse())
+		Expect(r.inShard(newTestApp())).To(BeFalse())
+	})
+
+	It("should ignore a non matching app", func() {
+		app := shardedApp("podinfo", "b")
+		r := newTestReconciler(app)
+		r.LabelSelector = labels.SelectorFromSet(labels.Set{"shard": "a"})
+		_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		Expect(err).NotTo(HaveOccurred())
+
+		updated := &appsv1.FluxApp{}
+		Expect(r.Get(ctx, client.ObjectKeyFromObject(app), updated)).To(Succeed())
+		Expect(updated.Finalizers).To(BeEmpty())
+		Expect(updated.Status).To(Equal(app.Status))
+		_, err = getHelmRelease(ctx, r, app)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should only list apps in the shard", func() {
+		r := newTestReconciler(shardedApp("a", "a"), shardedApp("b", "b"))
+		r.LabelSelector = labels.SelectorFromSet(labels.Set{"shard": "a"})
+		apps := &appsv1.FluxAppList{}
+		Expect(r.listApps(ctx, apps)).To(Succeed())
+		Expect(apps.Items).To(HaveLen(1))
+		Expect(apps.Items[0].Name).To(Equal("a"))
+	})
+
+	It("should not resync apps in another shard", func() {
+		stale := shardedApp("podinfo", "b")
+		hr := &helmv2.HelmRelease{}
+		hr.Name = stale.Name
+		hr.Namespace = stale.Namespace
+		hr.Annotations = map[string]string{childrenVersionAnnotation: "0"}
+		r := newTestReconciler(stale, hr)
+		r.LabelSelector = labels.SelectorFromSet(labels.Set{"shard": "a"})
+		r.resync = make(chan event.GenericEvent, 1)
+		Expect(r.ResyncChildren(ctx)).To(Succeed())
+		Expect(r.resync).To(BeEmpty())
+	})
+})
@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/blang/semver/v4"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	sourcev1beta2 "github.com/fluxcd/source-controller/api/v1beta2"
+)
+
+// childConditions maps the conditions mirrored from the children to the child kind
+// in the order the children are reconciled
+var childConditions = []struct {
+	condition string
+	kind      string
+}{
+	{appsv1.ImageRepositoryReadyCondition, imagev1.ImageRepositoryKind},
+	{appsv1.ImagePolicyReadyCondition, imagev1.ImagePolicyKind},
+	{appsv1.HelmRepositoryReadyCondition, sourcev1.HelmRepositoryKind},
+	{appsv1.GitRepositoryReadyCondition, sourcev1.GitRepositoryKind},
+	{appsv1.OCIRepositoryReadyCondition, sourcev1beta2.OCIRepositoryKind},
+}
+
+// clearStaleConditions removes any conditions set for an older generation of the app
+// so the status only reflects the current spec
+func clearStaleConditions(app *appsv1.FluxApp) {
+	current := make([]metav1.Condition, 0, len(app.Status.Conditions))
+	for _, c := range app.Status.Conditions {
+		if c.ObservedGeneration < app.Generation {
+			continue
+		}
+		current = append(current, c)
+	}
+	app.SetConditions(current)
+}
+
+// mirrorChildReady sets the condition from the Ready condition of the child
+// and marks the app not ready if the child isn't ready
+func mirrorChildReady(app *appsv1.FluxApp, condition string, kind string, child conditions.Getter) {
+	conditions.SetMirror(app, condition, child, conditions.WithFallbackValue(false, meta.ProgressingReason, kind+" is not ready"))
+	aggregateReady(app)
+}
+
+// aggregateReady sets the Ready condition from the child conditions so it's the same whichever handler ran last
+// Ready is False if any child has failed, using the condition of the first failed child so it points to the
+// stage which is broken. Otherwise a failure set by a handler e.g. a missing target namespace is kept, and
+// Ready is Unknown while any child or the HelmRelease is progressing
+// Ready is only True if it was mirrored as True from the HelmRelease and every child is ready
+func aggregateReady(app *appsv1.FluxApp) {
+	for _, c := range childConditions {
+		if conditions.IsFalse(app, c.condition) && !progressing(conditions.Get(app, c.condition)) {
+			conditions.MarkFalse(app, meta.ReadyCondition, conditions.GetReason(app, c.condition),
+				"%s: %s", c.kind, conditions.GetMessage(app, c.condition))
+			return
+		}
+	}
+	ready := conditions.Get(app, meta.ReadyCondition)
+	if ready != nil && ready.Status == metav1.ConditionFalse && !progressing(ready) {
+		return
+	}
+	for _, c := range childConditions {
+		if progressing(conditions.Get(app, c.condition)) {
+			conditions.MarkUnknown(app, meta.ReadyCondition, meta.ProgressingReason,
+				"%s: %s", c.kind, conditions.GetMessage(app, c.condition))
+			return
+		}
+	}
+	if progressing(ready) {
+		conditions.MarkUnknown(app, meta.ReadyCondition, meta.ProgressingReason, "%s", ready.Message)
+	}
+}
+
+// progressing returns true if the condition is Unknown, or False only because the object is still progressing
+// e.g. a child which hasn't been reconciled yet
+func progressing(c *metav1.Condition) bool {
+	if c == nil {
+		return false
+	}
+	return c.Status == metav1.ConditionUnknown || (c.Status == metav1.ConditionFalse && c.Reason == meta.ProgressingReason)
+}
+
+// summarizeReady replaces the Ready condition message with a concise summary of the status
+// e.g. podinfo 6.5.3 deployed, so the Status printer column is readable
+// Failures keep the message from the child as it explains what's wrong
+func summarizeReady(app *appsv1.FluxApp) {
+	ready := conditions.Get(app, meta.ReadyCondition)
+	if ready == nil {
+		return
+	}
+	var message string
+	switch {
+	case ready.Status == metav1.ConditionTrue:
+		message = chartSummary(app, app.Status.Chart.AppliedVersion) + " deployed"
+	case ready.Status == metav1.ConditionUnknown || ready.Reason == meta.ProgressingReason:
+		if app.Status.Chart.Version == "" {
+			message = "waiting for chart scan"
+		} else {
+			message = "deploying " + chartSummary(app, app.Status.Chart.Version)
+		}
+	default:
+		return
+	}
+	for i := range app.Status.Conditions {
+		if app.Status.Conditions[i].Type == meta.ReadyCondition {
+			app.Status.Conditions[i].Message = message
+		}
+	}
+}
+
+// upToDate is the chart update status when the latest available version is deployed
+const upToDate = "✔"
+
+// setChartUpdate compares the applied & available chart versions for the Update printer column
+// so apps with gated upgrades e.g. paused version updates show when a newer version is waiting
+// It's cleared until both versions are known, and an applied version ahead of the available version
+// e.g. from the override version annotation is up to date
+func setChartUpdate(app *appsv1.FluxApp) {
+	applied, available := app.Status.Chart.AppliedVersion, app.Status.Chart.AvailableVersion
+	switch {
+	case applied == "" || available == "":
+		app.Status.Chart.Update = ""
+	case newerVersion(available, applied):
+		app.Status.Chart.Update = "new: " + available
+	default:
+		app.Status.Chart.Update = upToDate
+	}
+}
+
+// newerVersion returns true if the version is newer than the current version
+// Versions which aren't SemVer are only compared for equality
+func newerVersion(version, current string) bool {
+	v, err := semver.Parse(version)
+	if err != nil {
+		return version != current
+	}
+	c, err := semver.Parse(current)
+	if err != nil {
+		return version != current
+	}
+	return v.GT(c)
+}
+
+// chartSummary returns the chart name & version, falling back to the selected version
+// The version is omitted for Git charts until Helm reports the version it deployed
+func chartSummary(app *appsv1.FluxApp, version string) string {
+	if version == "" {
+		version = app.Status.Chart.Version
+	}
+	name := path.Base(app.Status.Chart.Name)
+	if version == "" || version == gitChartVersion {
+		return name
+	}
+	return fmt.Sprintf("%s %s", name, version)
+}
+
+// specChangeTime returns when the app was last changed by something other than a status update
+// The API server doesn't record when the spec changed so the latest managed fields entry is used,
+// falling back to the creation time
+func specChangeTime(app *appsv1.FluxApp) time.Time {
+	changed := app.CreationTimestamp.Time
+	for _, f := range app.ManagedFields {
+		if f.Subresource == "" && f.Time != nil && f.Time.After(changed) {
+			changed = f.Time.Time
+		}
+	}
+	return changed
+}
+
+// queueWait returns how long the reconcile started after the app was last changed
+// Managed fields times only have second precision so it's never negative
+func queueWait(app *appsv1.FluxApp, start time.Time) time.Duration {
+	if changed := specChangeTime(app); !changed.IsZero() && start.After(changed) {
+		return start.Sub(changed)
+	}
+	return 0
+}
+
+// setReconcileTiming records how long the first reconcile of each generation waited & took
+// Later reconciles of the same generation aren't recorded so the status doesn't change on every reconcile
+func setReconcileTiming(app *appsv1.FluxApp, start time.Time, wait time.Duration) {
+	if app.Status.ObservedGeneration == app.Generation && app.Status.LastReconcileDuration != nil {
+		return
+	}
+	app.Status.ObservedGeneration = app.Generation
+	app.Status.LastReconcileDuration = &metav1.Duration{Duration: time.Since(start)}
+	app.Status.LastQueueWaitDuration = &metav1.Duration{Duration: wait}
+}
@@ -0,0 +1,376 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+)
+
+var _ = Describe("Status", func() {
+	Context("clearStaleConditions", func() {
+		It("should only keep conditions for the current generation", func() {
+			app := newTestApp()
+			app.Generation = 2
+			app.Status.Conditions = []metav1.Condition{
+				{Type: meta.ReadyCondition, Status: metav1.ConditionFalse, Reason: appsv1.MissingTargetNamespaceReason, ObservedGeneration: 1},
+				{Type: meta.ReconcilingCondition, Status: metav1.ConditionTrue, Reason: meta.ProgressingReason, ObservedGeneration: 2},
+			}
+			clearStaleConditions(app)
+			Expect(conditions.Has(app, meta.ReadyCondition)).To(BeFalse())
+			Expect(conditions.Has(app, meta.ReconcilingCondition)).To(BeTrue())
+		})
+
+		It("should clear outdated conditions when the spec changes", func() {
+			ctx := context.Background()
+			app := newTestApp()
+			app.Generation = 2
+			app.Status = appsv1.FluxAppStatus{
+				Conditions: []metav1.Condition{
+					{
+						Type:               meta.ReadyCondition,
+						Status:             metav1.ConditionFalse,
+						Reason:             appsv1.MissingTargetNamespaceReason,
+						ObservedGeneration: 1,
+						LastTransitionTime: metav1.Now(),
+					},
+				},
+			}
+			r := newTestReconciler(app)
+			_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+			Expect(err).NotTo(HaveOccurred())
+
+			updated := &appsv1.FluxApp{}
+			Expect(r.Get(ctx, types.NamespacedName{Name: app.Name, Namespace: app.Namespace}, updated)).To(Succeed())
+			Expect(conditions.GetReason(updated, meta.ReadyCondition)).NotTo(Equal(appsv1.MissingTargetNamespaceReason))
+			for _, c := range updated.Status.Conditions {
+				Expect(c.ObservedGeneration).To(Equal(updated.Generation))
+			}
+		})
+	})
+
+	Context("child conditions", func() {
+		ctx := context.Background()
+
+		// readyHelmRelease returns a HelmRelease which has been successfully installed
+		readyHelmRelease := func(app *appsv1.FluxApp) *helmv2.HelmRelease {
+			hr := &helmv2.HelmRelease{ObjectMeta: metav1.ObjectMeta{Name: app.Name, Namespace: app.Namespace}}
+			conditions.MarkTrue(hr, meta.ReadyCondition, meta.SucceededReason, "Helm install succeeded")
+			return hr
+		}
+
+		readyImagePolicy := func(app *appsv1.FluxApp) *imagev1.ImagePolicy {
+			policy := &imagev1.ImagePolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace},
+				Status:     imagev1.ImagePolicyStatus{LatestImage: "ghcr.io/stefanprodan/charts/podinfo:6.5.3"},
+			}
+			conditions.MarkTrue(policy, meta.ReadyCondition, meta.SucceededReason, "Latest image tag resolved")
+			return policy
+		}
+
+		reconcileChildren := func(r *FluxAppReconciler, app *appsv1.FluxApp) {
+			Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			Expect(handleHelmRepository(ctx, r, app)).To(Succeed())
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		}
+
+		It("should be ready when all the children are ready", func() {
+			app := newTestApp()
+			repo := &imagev1.ImageRepository{ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace}}
+			conditions.MarkTrue(repo, meta.ReadyCondition, meta.SucceededReason, "successful scan: found 10 tags")
+			r := newTestReconciler(repo, readyImagePolicy(app), readyHelmRelease(app))
+			reconcileChildren(r, app)
+			Expect(conditions.IsTrue(app, appsv1.ImageRepositoryReadyCondition)).To(BeTrue())
+			Expect(conditions.IsTrue(app, appsv1.ImagePolicyReadyCondition)).To(BeTrue())
+			// The OCI HelmRepository is static so never reports a Ready condition
+			Expect(conditions.IsTrue(app, appsv1.HelmRepositoryReadyCondition)).To(BeTrue())
+			Expect(conditions.IsReady(app)).To(BeTrue())
+		})
+
+		It("should report a failing ImageRepository", func() {
+			app := newTestApp()
+			repo := &imagev1.ImageRepository{ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace}}
+			conditions.MarkFalse(repo, meta.ReadyCondition, "AuthenticationFailed", "401 Unauthorized")
+			r := newTestReconciler(repo, readyImagePolicy(app), readyHelmRelease(app))
+			reconcileChildren(r, app)
+			Expect(conditions.IsFalse(app, appsv1.ImageRepositoryReadyCondition)).To(BeTrue())
+			Expect(conditions.GetReason(app, appsv1.ImageRepositoryReadyCondition)).To(Equal("AuthenticationFailed"))
+			Expect(conditions.IsTrue(app, appsv1.ImagePolicyReadyCondition)).To(BeTrue())
+			// The HelmRelease is ready but the app isn't while the ImageRepository is failing
+			Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+			Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal("AuthenticationFailed"))
+			Expect(conditions.GetMessage(app, meta.ReadyCondition)).To(Equal("ImageRepository: 401 Unauthorized"))
+		})
+
+		It("should report children which haven't been reconciled yet as progressing", func() {
+			app := newTestApp()
+			r := newTestReconciler()
+			Expect(handleImageRepository(ctx, r, app)).To(Succeed())
+			Expect(conditions.IsFalse(app, appsv1.ImageRepositoryReadyCondition)).To(BeTrue())
+			Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(meta.ProgressingReason))
+		})
+	})
+
+	Context("aggregateReady", func() {
+		condition := func(t string, status metav1.ConditionStatus, reason, message string) metav1.Condition {
+			return metav1.Condition{Type: t, Status: status, Reason: reason, Message: message}
+		}
+		ready := func(status metav1.ConditionStatus, reason, message string) metav1.Condition {
+			return condition(meta.ReadyCondition, status, reason, message)
+		}
+		repo := func(status metav1.ConditionStatus, reason, message string) metav1.Condition {
+			return condition(appsv1.ImageRepositoryReadyCondition, status, reason, message)
+		}
+		policy := func(status metav1.ConditionStatus, reason, message string) metav1.Condition {
+			return condition(appsv1.ImagePolicyReadyCondition, status, reason, message)
+		}
+		helmRepo := func(status metav1.ConditionStatus, reason, message string) metav1.Condition {
+			return condition(appsv1.HelmRepositoryReadyCondition, status, reason, message)
+		}
+		helmReleaseReady := ready(metav1.ConditionTrue, helmv2.InstallSucceededReason, "Helm install succeeded")
+
+		DescribeTable("should aggregate the child conditions",
+			func(conds []metav1.Condition, status metav1.ConditionStatus, reason, message string) {
+				app := newTestApp()
+				app.Status.Conditions = conds
+				aggregateReady(app)
+				c := conditions.Get(app, meta.ReadyCondition)
+				Expect(c).NotTo(BeNil())
+				Expect(c.Status).To(Equal(status))
+				Expect(c.Reason).To(Equal(reason))
+				Expect(c.Message).To(Equal(message))
+			},
+			Entry("all ready",
+				[]metav1.Condition{
+					repo(metav1.ConditionTrue, meta.SucceededReason, "successful scan"),
+					policy(metav1.ConditionTrue, meta.SucceededReason, "Latest image tag resolved"),
+					helmRepo(metav1.ConditionTrue, meta.SucceededReason, ""),
+					helmReleaseReady,
+				},
+				metav1.ConditionTrue, helmv2.InstallSucceededReason, "Helm install succeeded"),
+			Entry("a child progressing while the HelmRelease is ready",
+				[]metav1.Condition{
+					repo(metav1.ConditionTrue, meta.SucceededReason, "successful scan"),
+					policy(metav1.ConditionUnknown, meta.ProgressingReason, "reconciliation in progress"),
+					helmReleaseReady,
+				},
+				metav1.ConditionUnknown, meta.ProgressingReason, "ImagePolicy: reconciliation in progress"),
+			Entry("a child which hasn't been reconciled yet",
+				[]metav1.Condition{
+					repo(metav1.ConditionFalse, meta.ProgressingReason, "ImageRepository is not ready"),
+				},
+				metav1.ConditionUnknown, meta.ProgressingReason, "ImageRepository: ImageRepository is not ready"),
+			Entry("the HelmRelease progressing",
+				[]metav1.Condition{
+					repo(metav1.ConditionTrue, meta.SucceededReason, "successful scan"),
+					ready(metav1.ConditionFalse, meta.ProgressingReason, "HelmRelease is not ready"),
+				},
+				metav1.ConditionUnknown, meta.ProgressingReason, "HelmRelease is not ready"),
+			Entry("a failed child over a progressing child",
+				[]metav1.Condition{
+					repo(metav1.ConditionUnknown, meta.ProgressingReason, "scanning"),
+					policy(metav1.ConditionFalse, "ReconciliationFailed", "invalid range"),
+					helmReleaseReady,
+				},
+				metav1.ConditionFalse, "ReconciliationFailed", "ImagePolicy: invalid range"),
+			Entry("the first of several failed children",
+				[]metav1.Condition{
+					repo(metav1.ConditionFalse, "AuthenticationFailed", "401 Unauthorized"),
+					policy(metav1.ConditionFalse, "ReconciliationFailed", "no tags"),
+				},
+				metav1.ConditionFalse, "AuthenticationFailed", "ImageRepository: 401 Unauthorized"),
+			Entry("a failed child over a handler failure",
+				[]metav1.Condition{
+					repo(metav1.ConditionFalse, "AuthenticationFailed", "401 Unauthorized"),
+					ready(metav1.ConditionFalse, appsv1.WaitingForDependencyReason, "dependency default/redis is not ready"),
+				},
+				metav1.ConditionFalse, "AuthenticationFailed", "ImageRepository: 401 Unauthorized"),
+			Entry("a handler failure over a progressing child",
+				[]metav1.Condition{
+					policy(metav1.ConditionUnknown, meta.ProgressingReason, "reconciliation in progress"),
+					ready(metav1.ConditionFalse, appsv1.MissingTargetNamespaceReason, "target namespace podinfo does not exist"),
+				},
+				metav1.ConditionFalse, appsv1.MissingTargetNamespaceReason, "target namespace podinfo does not exist"),
+		)
+
+		It("should not add a Ready condition while the children are ready", func() {
+			app := newTestApp()
+			app.Status.Conditions = []metav1.Condition{repo(metav1.ConditionTrue, meta.SucceededReason, "successful scan")}
+			aggregateReady(app)
+			Expect(conditions.Has(app, meta.ReadyCondition)).To(BeFalse())
+		})
+
+		It("should aggregate the persisted Ready condition", func() {
+			ctx := context.Background()
+			app := newTestApp()
+			repo := &imagev1.ImageRepository{ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace}}
+			conditions.MarkUnknown(repo, meta.ReadyCondition, meta.ProgressingReason, "reconciliation in progress")
+			hr := &helmv2.HelmRelease{ObjectMeta: metav1.ObjectMeta{Name: app.Name, Namespace: app.Namespace}}
+			conditions.MarkTrue(hr, meta.ReadyCondition, meta.SucceededReason, "Helm install succeeded")
+			r := newTestReconciler(app, repo, hr)
+			_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+			Expect(err).NotTo(HaveOccurred())
+
+			updated := &appsv1.FluxApp{}
+			Expect(r.Get(ctx, client.ObjectKeyFromObject(app), updated)).To(Succeed())
+			Expect(conditions.IsUnknown(updated, meta.ReadyCondition)).To(BeTrue())
+			Expect(conditions.GetReason(updated, meta.ReadyCondition)).To(Equal(meta.ProgressingReason))
+		})
+	})
+
+	Context("reconcile timing", func() {
+		ctx := context.Background()
+
+		It("should record the reconcile duration & queue wait", func() {
+			app := newTestApp()
+			app.Generation = 1
+			changed := metav1.NewTime(time.Now().Add(-time.Minute))
+			app.ManagedFields = []metav1.ManagedFieldsEntry{
+				{Manager: "kubectl", Operation: metav1.ManagedFieldsOperationUpdate, Time: &changed},
+			}
+			r := newTestReconciler(app)
+			_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+			Expect(err).NotTo(HaveOccurred())
+			updated := &appsv1.FluxApp{}
+			Expect(r.Get(ctx, client.ObjectKeyFromObject(app), updated)).To(Succeed())
+			Expect(updated.Status.ObservedGeneration).To(Equal(updated.Generation))
+			Expect(updated.Status.LastReconcileDuration).NotTo(BeNil())
+			Expect(updated.Status.LastReconcileDuration.Duration).To(BeNumerically(">", 0))
+			Expect(updated.Status.LastQueueWaitDuration).NotTo(BeNil())
+			Expect(updated.Status.LastQueueWaitDuration.Duration).To(BeNumerically(">=", time.Minute))
+		})
+
+		It("should only record the first reconcile of each generation", func() {
+			app := newTestApp()
+			app.Generation = 2
+			start := time.Now()
+			setReconcileTiming(app, start, time.Second)
+			recorded := app.Status.LastReconcileDuration
+			setReconcileTiming(app, start.Add(-time.Hour), time.Hour)
+			Expect(app.Status.LastReconcileDuration).To(Equal(recorded))
+			Expect(app.Status.LastQueueWaitDuration.Duration).To(Equal(time.Second))
+
+			app.Generation = 3
+			setReconcileTiming(app, start.Add(-time.Hour), time.Hour)
+			Expect(app.Status.ObservedGeneration).To(Equal(int64(3)))
+			Expect(app.Status.LastReconcileDuration.Duration).To(BeNumerically(">=", time.Hour))
+			Expect(app.Status.LastQueueWaitDuration.Duration).To(Equal(time.Hour))
+		})
+
+		It("should ignore status updates when finding the spec change", func() {
+			app := newTestApp()
+			app.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+			spec := metav1.NewTime(time.Now().Add(-time.Minute))
+			status := metav1.NewTime(time.Now())
+			app.ManagedFields = []metav1.ManagedFieldsEntry{
+				{Manager: "kubectl", Time: &spec},
+				{Manager: "manager", Subresource: "status", Time: &status},
+			}
+			Expect(specChangeTime(app)).To(Equal(spec.Time))
+			app.ManagedFields = nil
+			Expect(specChangeTime(app)).To(Equal(app.CreationTimestamp.Time))
+		})
+	})
+
+	Context("summarizeReady", func() {
+		ready := func(status metav1.ConditionStatus, reason, message string) metav1.Condition {
+			return metav1.Condition{Type: meta.ReadyCondition, Status: status, Reason: reason, Message: message}
+		}
+
+		DescribeTable("should compose the Ready message from the status",
+			func(condition metav1.Condition, chart appsv1.ChartStatus, expected string) {
+				app := newTestApp()
+				app.Status.Chart = chart
+				app.Status.Conditions = []metav1.Condition{condition}
+				summarizeReady(app)
+				Expect(conditions.GetMessage(app, meta.ReadyCondition)).To(Equal(expected))
+				// Only the message is changed
+				Expect(conditions.Get(app, meta.ReadyCondition).Reason).To(Equal(condition.Reason))
+			},
+			Entry("deployed",
+				ready(metav1.ConditionTrue, helmv2.UpgradeSucceededReason, "Helm upgrade succeeded for release default/podinfo.v2 with chart podinfo@6.5.3"),
+				appsv1.ChartStatus{Name: "podinfo", Version: "6.5.3", AppliedVersion: "6.5.3"},
+				"podinfo 6.5.3 deployed"),
+			Entry("deployed before Helm reports the applied version",
+				ready(metav1.ConditionTrue, helmv2.InstallSucceededReason, "Helm install succeeded"),
+				appsv1.ChartStatus{Name: "podinfo", Version: "6.5.3"},
+				"podinfo 6.5.3 deployed"),
+			Entry("deployed from Git",
+				ready(metav1.ConditionTrue, helmv2.InstallSucceededReason, "Helm install succeeded"),
+				appsv1.ChartStatus{Name: "charts/podinfo", Version: gitChartVersion, AppliedVersion: "6.5.3"},
+				"podinfo 6.5.3 deployed"),
+			Entry("waiting for the chart scan",
+				ready(metav1.ConditionFalse, meta.ProgressingReason, "ImagePolicy: ImagePolicy is not ready"),
+				appsv1.ChartStatus{Name: "podinfo"},
+				"waiting for chart scan"),
+			Entry("deploying a new version",
+				ready(metav1.ConditionUnknown, meta.ProgressingReason, "Fulfilling prerequisites"),
+				appsv1.ChartStatus{Name: "podinfo", Version: "6.6.0", AppliedVersion: "6.5.3"},
+				"deploying podinfo 6.6.0"),
+			Entry("failed",
+				ready(metav1.ConditionFalse, appsv1.NoMatchingVersionReason, `no chart versions match "~> 99"`),
+				appsv1.ChartStatus{Name: "podinfo"},
+				`no chart versions match "~> 99"`),
+		)
+
+		It("should not add a Ready condition", func() {
+			app := newTestApp()
+			summarizeReady(app)
+			Expect(conditions.Has(app, meta.ReadyCondition)).To(BeFalse())
+		})
+	})
+
+	Context("setChartUpdate", func() {
+		DescribeTable("should compare the applied & available versions",
+			func(applied, available, update string) {
+				app := newTestApp()
+				app.Status.Chart.AppliedVersion = applied
+				app.Status.Chart.AvailableVersion = available
+				setChartUpdate(app)
+				Expect(app.Status.Chart.Update).To(Equal(update))
+			},
+			Entry("up to date", "6.5.3", "6.5.3", "✔"),
+			Entry("update available", "6.5.3", "6.6.0", "new: 6.6.0"),
+			Entry("applied ahead of available", "6.6.0", "6.5.3", "✔"),
+			Entry("not deployed yet", "", "6.5.3", ""),
+			Entry("not scanned yet", "6.5.3", "", ""),
+			Entry("non-SemVer versions", "latest", "stable", "new: stable"),
+		)
+
+		It("should show an update waiting while version updates are paused", func() {
+			ctx := context.Background()
+			app := newTestApp()
+			app.Spec.PauseVersionUpdates = true
+			policy := &imagev1.ImagePolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace},
+				Status:     imagev1.ImagePolicyStatus{LatestImage: "ghcr.io/stefanprodan/charts/podinfo:6.6.0"},
+			}
+			hr := &helmv2.HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{Name: app.Name, Namespace: app.Namespace},
+				Status: helmv2.HelmReleaseStatus{History: helmv2.Snapshots{
+					{Version: 1, ChartVersion: "6.5.3", Status: releaseStatusDeployed},
+				}},
+			}
+			r := newTestReconciler(app, policy, hr)
+			_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+			Expect(err).NotTo(HaveOccurred())
+			updated := &appsv1.FluxApp{}
+			Expect(r.Get(ctx, client.ObjectKeyFromObject(app), updated)).To(Succeed())
+			Expect(updated.Status.Chart.AppliedVersion).To(Equal("6.5.3"))
+			Expect(updated.Status.Chart.Update).To(Equal("new: 6.6.0"))
+		})
+	})
+})
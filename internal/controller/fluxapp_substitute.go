@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// substitutionToken matches a ${name} token in a string value
+var substitutionToken = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// substituteValues returns the app values with the substitution tokens replaced
+// The values are returned unchanged unless the app opts in with substituteValues
+// Tokens without a substitution are an error rather than being deployed as is
+func substituteValues(app *appsv1.FluxApp, substitutions map[string]string) (*apiextensionsv1.JSON, error) {
+	if !app.Spec.SubstituteValues || app.Spec.Values == nil || len(app.Spec.Values.Raw) == 0 {
+		return app.Spec.Values, nil
+	}
+	var values interface{}
+	if err := json.Unmarshal(app.Spec.Values.Raw, &values); err != nil {
+		return nil, fmt.Errorf("%w values: %w", errInvalid, err)
+	}
+	missing := map[string]bool{}
+	values = substitute(values, substitutions, missing)
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%w values: no substitution for %s", errInvalid, strings.Join(names, ", "))
+	}
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	return &apiextensionsv1.JSON{Raw: raw}, nil
+}
+
+// substitute replaces the tokens in the string values beneath v, recording any tokens without a substitution
+func substitute(v interface{}, substitutions map[string]string, missing map[string]bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			v[k] = substitute(val, substitutions, missing)
+		}
+	case []interface{}:
+		for i, val := range v {
+			v[i] = substitute(val, substitutions, missing)
+		}
+	case string:
+		return substitutionToken.ReplaceAllStringFunc(v, func(token string) string {
+			name := substitutionToken.FindStringSubmatch(token)[1]
+			value, ok := substitutions[name]
+			if !ok {
+				missing[name] = true
+				return token
+			}
+			return value
+		})
+	}
+	return v
+}
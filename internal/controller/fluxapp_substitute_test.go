@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("Values substitution", func() {
+	substitutions := map[string]string{"clusterName": "prod-eu", "region": "eu-west-1"}
+
+	newSubstituteApp := func(raw string) *appsv1.FluxApp {
+		app := newTestApp()
+		app.Spec.SubstituteValues = true
+		app.Spec.Values = &apiextensionsv1.JSON{Raw: []byte(raw)}
+		return app
+	}
+
+	It("should replace multiple tokens in nested values & lists", func() {
+		app := newSubstituteApp(`{"cluster":"${clusterName}","ingress":{"hosts":["app.${region}.${clusterName}.example.com"]},"replicaCount":2}`)
+		values, err := substituteValues(app, substitutions)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values.Raw).To(MatchJSON(`{"cluster":"prod-eu","ingress":{"hosts":["app.eu-west-1.prod-eu.example.com"]},"replicaCount":2}`))
+		// The app spec isn't changed
+		Expect(string(app.Spec.Values.Raw)).To(ContainSubstring("${clusterName}"))
+	})
+
+	It("should reject tokens without a substitution", func() {
+		app := newSubstituteApp(`{"cluster":"${clusterName}","zone":"${zone}","env":"${environment}"}`)
+		_, err := substituteValues(app, substitutions)
+		Expect(err).To(MatchError(errInvalid))
+		Expect(err).To(MatchError(ContainSubstring("no substitution for environment, zone")))
+	})
+
+	It("should leave the values unchanged unless the app opts in", func() {
+		app := newSubstituteApp(`{"cluster":"${clusterName}","zone":"${zone}"}`)
+		app.Spec.SubstituteValues = false
+		values, err := substituteValues(app, substitutions)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values).To(Equal(app.Spec.Values))
+	})
+
+	It("should substitute the HelmRelease values", func() {
+		ctx := context.Background()
+		app := newSubstituteApp(`{"cluster":"${clusterName}"}`)
+		r := newTestReconciler()
+		r.Substitutions = substitutions
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Values.Raw).To(MatchJSON(`{"cluster":"prod-eu"}`))
+	})
+})
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fluxcd/pkg/runtime/conditions"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// suspendReasonAnnotation records why version updates are paused e.g. a change freeze or an incident ticket
+const suspendReasonAnnotation = "apps.kloudy.uk/suspend-reason"
+
+// suspendedReason is the reason of the event emitted when version updates are paused
+const suspendedReason = "Suspended"
+
+// suspendReason returns the reason from the suspend reason annotation on the app
+func suspendReason(app *appsv1.FluxApp) string {
+	return strings.TrimSpace(app.GetAnnotations()[suspendReasonAnnotation])
+}
+
+// suspendReasonSuffix returns what's added to the VersionUpdatesPaused message for the suspend reason
+func suspendReasonSuffix(reason string) string {
+	return fmt.Sprintf(" (reason: %s)", reason)
+}
+
+// markVersionUpdatesPaused marks version updates as paused
+// with the suspend reason added to the message so it's shown alongside the paused version
+func markVersionUpdatesPaused(app *appsv1.FluxApp, reason string, messageFormat string, messageArgs ...interface{}) {
+	message := fmt.Sprintf(messageFormat, messageArgs...)
+	if suspend := suspendReason(app); suspend != "" {
+		message += suspendReasonSuffix(suspend)
+	}
+	conditions.MarkTrue(app, appsv1.VersionUpdatesPausedCondition, reason, "%s", message)
+}
+
+// suspendReasonApplied returns true if the VersionUpdatesPaused message has the current suspend reason
+// The annotation doesn't change the generation so it's checked on its own
+func suspendReasonApplied(app *appsv1.FluxApp) bool {
+	paused := conditions.Get(app, appsv1.VersionUpdatesPausedCondition)
+	if paused == nil || paused.Status != metav1.ConditionTrue {
+		return true
+	}
+	if suspend := suspendReason(app); suspend != "" {
+		return strings.HasSuffix(paused.Message, suspendReasonSuffix(suspend))
+	}
+	return !strings.Contains(paused.Message, " (reason: ")
+}
+
+// recordSuspension emits an event when version updates become paused or the pause message changes
+// e.g. the suspend reason was updated
+func (r *FluxAppReconciler) recordSuspension(before, app *appsv1.FluxApp) {
+	if r.Recorder == nil {
+		return
+	}
+	paused := conditions.Get(app, appsv1.VersionUpdatesPausedCondition)
+	if paused == nil || paused.Status != metav1.ConditionTrue {
+		return
+	}
+	if previous := conditions.Get(before, appsv1.VersionUpdatesPausedCondition); previous != nil &&
+		previous.Status == metav1.ConditionTrue && previous.Message == paused.Message {
+		return
+	}
+	r.Recorder.Event(app, corev1.EventTypeNormal, suspendedReason, paused.Message)
+}
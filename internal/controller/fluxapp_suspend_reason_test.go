@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+)
+
+var _ = Describe("Suspend reason", func() {
+	ctx := context.Background()
+
+	newPausedApp := func(reason string) *appsv1.FluxApp {
+		app := newTestApp()
+		app.Spec.PauseVersionUpdates = true
+		if reason != "" {
+			app.Annotations = map[string]string{suspendReasonAnnotation: reason}
+		}
+		return app
+	}
+
+	newPolicy := func(app *appsv1.FluxApp, tag string) *imagev1.ImagePolicy {
+		return &imagev1.ImagePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace},
+			Status:     imagev1.ImagePolicyStatus{LatestImage: "ghcr.io/stefanprodan/charts/podinfo:" + tag},
+		}
+	}
+
+	It("should add the reason to the VersionUpdatesPaused message", func() {
+		app := newPausedApp("change freeze CHG-1234")
+		r := newTestReconciler(newPolicy(app, "6.6.0"))
+		Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+		Expect(app.Status.Chart.Version).To(Equal("6.5.3"))
+		Expect(conditions.IsTrue(app, appsv1.VersionUpdatesPausedCondition)).To(BeTrue())
+		Expect(conditions.GetMessage(app, appsv1.VersionUpdatesPausedCondition)).To(Equal(
+			"version updates are paused at 6.5.3, the latest matching version is 6.6.0 (reason: change freeze CHG-1234)"))
+	})
+
+	It("should add the reason while image automation is suspended", func() {
+		app := newTestApp()
+		app.Spec.SuspendImageAutomation = true
+		app.Annotations = map[string]string{suspendReasonAnnotation: "registry migration"}
+		r := newTestReconciler(newPolicy(app, "6.6.0"))
+		Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+		Expect(conditions.GetMessage(app, appsv1.VersionUpdatesPausedCondition)).To(HaveSuffix("(reason: registry migration)"))
+	})
+
+	It("should leave the message unchanged without a reason", func() {
+		app := newPausedApp("")
+		r := newTestReconciler(newPolicy(app, "6.6.0"))
+		Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+		Expect(conditions.GetMessage(app, appsv1.VersionUpdatesPausedCondition)).NotTo(ContainSubstring("reason"))
+		Expect(suspendReasonApplied(app)).To(BeTrue())
+	})
+
+	It("should detect a changed reason", func() {
+		app := newPausedApp("change freeze")
+		r := newTestReconciler(newPolicy(app, "6.6.0"))
+		Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+		Expect(suspendReasonApplied(app)).To(BeTrue())
+		app.Annotations[suspendReasonAnnotation] = "incident INC-42"
+		Expect(suspendReasonApplied(app)).To(BeFalse())
+		delete(app.Annotations, suspendReasonAnnotation)
+		Expect(suspendReasonApplied(app)).To(BeFalse())
+	})
+
+	It("should emit an event with the reason when version updates are paused", func() {
+		app := newPausedApp("change freeze CHG-1234")
+		r := newTestReconciler(newPolicy(app, "6.6.0"))
+		recorder := record.NewFakeRecorder(10)
+		r.Recorder = recorder
+		before := app.DeepCopy()
+		Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+		r.recordSuspension(before, app)
+		Expect(recorder.Events).To(Receive(Equal(
+			"Normal Suspended version updates are paused at 6.5.3, the latest matching version is 6.6.0 (reason: change freeze CHG-1234)")))
+
+		// Nothing is emitted again until the message changes
+		r.recordSuspension(app.DeepCopy(), app)
+		Expect(recorder.Events).NotTo(Receive())
+	})
+})
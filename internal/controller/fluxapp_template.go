@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// applyTemplate merges the FluxAppTemplate referenced by the app into the app spec
+// The merged spec is only used in memory for the current reconcile, it's never written back
+func applyTemplate(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) error {
+	if app.Spec.TemplateRef == nil {
+		return nil
+	}
+	tmpl := &appsv1.FluxAppTemplate{}
+	key := types.NamespacedName{Name: app.Spec.TemplateRef.Name, Namespace: app.Namespace}
+	if err := r.Get(ctx, key, tmpl); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		conditions.MarkFalse(app, meta.ReadyCondition, appsv1.TemplateNotFoundReason, "FluxAppTemplate %s not found", key.Name)
+		return errRequeue
+	}
+	mergeTemplate(&app.Spec, tmpl.Spec)
+	return nil
+}
+
+// mergeTemplate sets any fields not set in the app spec from the template spec
+func mergeTemplate(spec *appsv1.FluxAppSpec, tmpl appsv1.FluxAppTemplateSpec) {
+	if spec.Interval == nil && tmpl.Interval != nil {
+		interval := *tmpl.Interval
+		spec.Interval = &interval
+	}
+	if spec.Chart.ScanInterval == nil && tmpl.ScanInterval != nil {
+		scanInterval := *tmpl.ScanInterval
+		spec.Chart.ScanInterval = &scanInterval
+	}
+	if spec.DriftDetection == "" {
+		spec.DriftDetection = tmpl.DriftDetection
+	}
+	if spec.Chart.Provider == "" {
+		spec.Chart.Provider = tmpl.Provider
+	}
+}
+
+// appsForTemplate maps a FluxAppTemplate to reconcile requests for the FluxApps referencing it
+func (r *FluxAppReconciler) appsForTemplate(ctx context.Context, obj client.Object) []reconcile.Request {
+	apps := &appsv1.FluxAppList{}
+	if err := r.listApps(ctx, apps, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, app := range apps.Items {
+		if app.Spec.TemplateRef != nil && app.Spec.TemplateRef.Name == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&app)})
+		}
+	}
+	return requests
+}
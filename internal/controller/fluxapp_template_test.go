@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("FluxAppTemplate", func() {
+	ctx := context.Background()
+
+	var tmpl *appsv1.FluxAppTemplate
+
+	BeforeEach(func() {
+		tmpl = &appsv1.FluxAppTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "common", Namespace: "default"},
+			Spec: appsv1.FluxAppTemplateSpec{
+				Interval:       &metav1.Duration{Duration: 10 * time.Minute},
+				ScanInterval:   &metav1.Duration{Duration: time.Hour},
+				DriftDetection: helmv2.DriftDetectionWarn,
+				Provider:       "aws",
+			},
+		}
+	})
+
+	It("should inherit fields not set on the app", func() {
+		app := newTestApp()
+		mergeTemplate(&app.Spec, tmpl.Spec)
+		Expect(app.Spec.Interval).To(Equal(&metav1.Duration{Duration: 10 * time.Minute}))
+		Expect(app.Spec.Chart.ScanInterval).To(Equal(&metav1.Duration{Duration: time.Hour}))
+		Expect(app.Spec.DriftDetection).To(Equal(helmv2.DriftDetectionWarn))
+		Expect(app.Spec.Chart.Provider).To(Equal("aws"))
+	})
+
+	It("should keep fields set on the app", func() {
+		app := newTestApp()
+		app.Spec.Interval = &metav1.Duration{Duration: 30 * time.Second}
+		app.Spec.Chart.ScanInterval = &metav1.Duration{Duration: 5 * time.Minute}
+		app.Spec.DriftDetection = helmv2.DriftDetectionDisabled
+		app.Spec.Chart.Provider = "generic"
+		mergeTemplate(&app.Spec, tmpl.Spec)
+		Expect(app.Spec.Interval).To(Equal(&metav1.Duration{Duration: 30 * time.Second}))
+		Expect(app.Spec.Chart.ScanInterval).To(Equal(&metav1.Duration{Duration: 5 * time.Minute}))
+		Expect(app.Spec.DriftDetection).To(Equal(helmv2.DriftDetectionDisabled))
+		Expect(app.Spec.Chart.Provider).To(Equal("generic"))
+	})
+
+	It("should apply the inherited fields to the children", func() {
+		app := newTestApp()
+		app.Spec.TemplateRef = &meta.LocalObjectReference{Name: tmpl.Name}
+		r := newTestReconciler(tmpl)
+		Expect(applyTemplate(ctx, r, app)).To(Succeed())
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Interval.Duration).To(Equal(10 * time.Minute))
+		Expect(hr.Spec.DriftDetection.Mode).To(Equal(helmv2.DriftDetectionWarn))
+	})
+
+	It("should requeue when the template doesn't exist", func() {
+		app := newTestApp()
+		app.Spec.TemplateRef = &meta.LocalObjectReference{Name: "missing"}
+		r := newTestReconciler()
+		Expect(applyTemplate(ctx, r, app)).To(MatchError(errRequeue))
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.TemplateNotFoundReason))
+	})
+
+	It("should enqueue the apps referencing the template", func() {
+		app := newTestApp()
+		app.Spec.TemplateRef = &meta.LocalObjectReference{Name: tmpl.Name}
+		other := newTestApp()
+		other.Name = "other"
+		r := newTestReconciler(app, other)
+		requests := r.appsForTemplate(ctx, tmpl)
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].Name).To(Equal(app.Name))
+	})
+})
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/yaml"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// defaultValuesKey is the key used by Flux when a ValuesReference omits the ValuesKey
+const defaultValuesKey = "values.yaml"
+
+// inlineValues returns the inline values of the HelmRelease
+// The app values are substituted, the values template is merged over them & the values patches applied,
+// then the values annotations are merged over the result
+func inlineValues(app *appsv1.FluxApp, vars map[string]string) (*apiextensionsv1.JSON, error) {
+	if err := validateValues(app.Spec.Values); err != nil {
+		return nil, err
+	}
+	values, err := substituteValues(app, vars)
+	if err != nil {
+		return nil, err
+	}
+	if values, err = renderValuesTemplate(app, vars, values); err != nil {
+		return nil, err
+	}
+	if values, err = patchValues(app, values); err != nil {
+		return nil, err
+	}
+	return annotationValues(app, values)
+}
+
+// validateValues checks the inline values are an object
+// The API server accepts any JSON for the values but the helm-controller fails on anything else
+// Values set as a YAML string are a common mistake so the error says so, with the YAML parse error if there is one
+func validateValues(values *apiextensionsv1.JSON) error {
+	if values == nil {
+		return nil
+	}
+	err := json.Unmarshal(values.Raw, &map[string]interface{}{})
+	if err == nil {
+		return nil
+	}
+	var s string
+	if json.Unmarshal(values.Raw, &s) != nil {
+		return fmt.Errorf("%w: must be an object: %w", errInvalidValues, err)
+	}
+	if err := yaml.Unmarshal([]byte(s), &map[string]interface{}{}); err != nil {
+		return fmt.Errorf("%w: must be an object, not a string, and the string isn't a valid YAML object: %w", errInvalidValues, err)
+	}
+	return fmt.Errorf("%w: must be an object, not a string, remove the | or quotes so the YAML is part of the FluxApp", errInvalidValues)
+}
+
+// valuesFrom validates the app ValuesFrom entries and returns a copy with defaults set
+func valuesFrom(app *appsv1.FluxApp) ([]helmv2.ValuesReference, error) {
+	refs, err := validateValuesFrom("valuesFrom", app.Spec.ValuesFrom)
+	if err != nil {
+		return nil, err
+	}
+	return ignoreMissingValues(app, refs), nil
+}
+
+// ignoreMissingValues marks the references as optional if the app ignores missing values
+// so the HelmRelease doesn't fail while a referenced ConfigMap or Secret doesn't exist yet
+func ignoreMissingValues(app *appsv1.FluxApp, refs []helmv2.ValuesReference) []helmv2.ValuesReference {
+	if !app.Spec.IgnoreMissingValuesFiles {
+		return refs
+	}
+	for i := range refs {
+		refs[i].Optional = true
+	}
+	return refs
+}
+
+// validateValuesFrom validates the ValuesFrom entries of the named field and returns a copy with defaults set
+func validateValuesFrom(field string, valuesFrom []helmv2.ValuesReference) ([]helmv2.ValuesReference, error) {
+	if len(valuesFrom) == 0 {
+		return nil, nil
+	}
+	refs := make([]helmv2.ValuesReference, 0, len(valuesFrom))
+	for i, ref := range valuesFrom {
+		switch {
+		case ref.TargetPath == "" && ref.ValuesKey == "":
+			// The whole values document is merged at the root
+			ref.ValuesKey = defaultValuesKey
+		case ref.TargetPath != "" && ref.ValuesKey == "":
+			// A TargetPath expects a single flat value so the full values document can't be used
+			return nil, fmt.Errorf("%w %s[%d]: valuesKey is required when targetPath is set", errInvalid, field, i)
+		case ref.TargetPath != "" && ref.ValuesKey == defaultValuesKey:
+			return nil, fmt.Errorf("%w %s[%d]: targetPath %q can't be used with the full values document %q", errInvalid, field, i, ref.TargetPath, defaultValuesKey)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
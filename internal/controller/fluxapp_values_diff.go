@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+// valuesChangedReason is the reason of the event emitted when the HelmRelease values change
+const valuesChangedReason = "ValuesChanged"
+
+// redacted replaces values which may have come from a Secret
+const redacted = "<redacted>"
+
+// maxDiffValueLength is the length values are truncated to in a values diff
+const maxDiffValueLength = 32
+
+// secretValueKeys returns the top level values keys which may be set from a Secret
+// If a Secret is merged at the root any key may be set from it so all keys are redacted
+func secretValueKeys(refs []helmv2.ValuesReference) (keys map[string]bool, all bool) {
+	keys = map[string]bool{}
+	for _, ref := range refs {
+		if ref.Kind != "Secret" {
+			continue
+		}
+		if ref.TargetPath == "" {
+			return nil, true
+		}
+		// The root of a path such as auth.password or hosts[0]
+		if path := strings.FieldsFunc(ref.TargetPath, func(r rune) bool { return r == '.' || r == '[' }); len(path) > 0 {
+			keys[path[0]] = true
+		}
+	}
+	return keys, false
+}
+
+// valuesDiff returns a concise summary of the top level keys added, removed & changed between the values
+// Values of the secret keys are redacted. It returns an empty string if the values are the same
+func valuesDiff(before, after *apiextensionsv1.JSON, refs []helmv2.ValuesReference) (string, error) {
+	oldValues, err := topLevelValues(before)
+	if err != nil {
+		return "", err
+	}
+	newValues, err := topLevelValues(after)
+	if err != nil {
+		return "", err
+	}
+	secretKeys, allSecret := secretValueKeys(refs)
+	format := func(key string, v interface{}) string {
+		if allSecret || secretKeys[key] {
+			return redacted
+		}
+		b, _ := json.Marshal(v)
+		s := string(b)
+		if len(s) > maxDiffValueLength {
+			s = s[:maxDiffValueLength] + "..."
+		}
+		return s
+	}
+	var added, removed, changed []string
+	for _, k := range sortedKeys(newValues) {
+		old, ok := oldValues[k]
+		switch {
+		case !ok:
+			added = append(added, fmt.Sprintf("%s=%s", k, format(k, newValues[k])))
+		case !reflect.DeepEqual(old, newValues[k]):
+			changed = append(changed, fmt.Sprintf("%s: %s -> %s", k, format(k, old), format(k, newValues[k])))
+		}
+	}
+	for _, k := range sortedKeys(oldValues) {
+		if _, ok := newValues[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	var parts []string
+	if len(added) > 0 {
+		parts = append(parts, "added "+strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		parts = append(parts, "removed "+strings.Join(removed, ", "))
+	}
+	if len(changed) > 0 {
+		parts = append(parts, "changed "+strings.Join(changed, ", "))
+	}
+	return strings.Join(parts, "; "), nil
+}
+
+// topLevelValues decodes the values into a map of the top level keys
+func topLevelValues(values *apiextensionsv1.JSON) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	if values == nil || len(values.Raw) == 0 {
+		return m, nil
+	}
+	if err := json.Unmarshal(values.Raw, &m); err != nil {
+		return nil, fmt.Errorf("unable to decode values: %w", err)
+	}
+	return m, nil
+}
+
+// sortedKeys returns the keys of the map in order so the diff is stable
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
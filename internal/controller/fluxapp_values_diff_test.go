@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/client-go/tools/record"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+var _ = Describe("Values diff", func() {
+	values := func(raw string) *apiextensionsv1.JSON {
+		return &apiextensionsv1.JSON{Raw: []byte(raw)}
+	}
+
+	It("should report added, removed & changed top level keys", func() {
+		diff, err := valuesDiff(
+			values(`{"replicaCount":1,"image":{"tag":"6.5.3"},"debug":true}`),
+			values(`{"replicaCount":2,"image":{"tag":"6.5.3"},"ingress":{"enabled":true}}`),
+			nil,
+		)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff).To(Equal(`added ingress={"enabled":true}; removed debug; changed replicaCount: 1 -> 2`))
+	})
+
+	It("should report nothing when the values are the same", func() {
+		diff, err := valuesDiff(values(`{"a":1,"b":[1,2]}`), values(`{"b":[1,2],"a":1}`), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff).To(BeEmpty())
+	})
+
+	It("should handle values being set & unset", func() {
+		diff, err := valuesDiff(nil, values(`{"a":1}`), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff).To(Equal("added a=1"))
+		diff, err = valuesDiff(values(`{"a":1}`), nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff).To(Equal("removed a"))
+	})
+
+	It("should truncate long values", func() {
+		diff, err := valuesDiff(nil, values(`{"a":"0123456789012345678901234567890123456789"}`), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff).To(Equal(`added a="0123456789012345678901234567890...`))
+	})
+
+	It("should redact keys set from a Secret", func() {
+		refs := []helmv2.ValuesReference{
+			{Kind: "ConfigMap", Name: "podinfo-values", ValuesKey: "values.yaml"},
+			{Kind: "Secret", Name: "podinfo-secrets", ValuesKey: "password", TargetPath: "auth.password"},
+			{Kind: "Secret", Name: "podinfo-hosts", ValuesKey: "host", TargetPath: "hosts[0]"},
+		}
+		diff, err := valuesDiff(
+			values(`{"auth":{"password":"old"},"replicaCount":1}`),
+			values(`{"auth":{"password":"new"},"hosts":["a"],"replicaCount":2}`),
+			refs,
+		)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff).To(Equal("added hosts=<redacted>; changed auth: <redacted> -> <redacted>, replicaCount: 1 -> 2"))
+		Expect(diff).NotTo(ContainSubstring("old"))
+		Expect(diff).NotTo(ContainSubstring("new"))
+	})
+
+	It("should redact all values when a Secret is merged at the root", func() {
+		refs := []helmv2.ValuesReference{{Kind: "Secret", Name: "podinfo-secrets", ValuesKey: "values.yaml"}}
+		diff, err := valuesDiff(values(`{"replicaCount":1}`), values(`{"replicaCount":2}`), refs)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff).To(Equal("changed replicaCount: <redacted> -> <redacted>"))
+	})
+
+	Context("handleHelmRelease", func() {
+		ctx := context.Background()
+
+		It("should emit an event when the values change", func() {
+			app := newTestApp()
+			app.Spec.Values = values(`{"replicaCount":1}`)
+			r := newTestReconciler()
+			recorder := record.NewFakeRecorder(10)
+			r.Recorder = recorder
+			// No event when the HelmRelease is created
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			Expect(recorder.Events).To(BeEmpty())
+			// No event when the values haven't changed
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			Expect(recorder.Events).To(BeEmpty())
+
+			app.Spec.Values = values(`{"replicaCount":2}`)
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			Expect(recorder.Events).To(Receive(Equal("Normal ValuesChanged HelmRelease values changed: changed replicaCount: 1 -> 2")))
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hr.Spec.Values).To(Equal(app.Spec.Values))
+		})
+	})
+})
@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// patchValues returns the values with the app values patches applied in order
+// A patch which fails to apply e.g. removing a missing key is an error rather than deploying the values without it
+func patchValues(app *appsv1.FluxApp, values *apiextensionsv1.JSON) (*apiextensionsv1.JSON, error) {
+	if len(app.Spec.ValuesPatches) == 0 {
+		return values, nil
+	}
+	ops, err := json.Marshal(app.Spec.ValuesPatches)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := jsonpatch.DecodePatch(ops)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidValuesPatches, err)
+	}
+	doc := []byte("{}")
+	if values != nil && len(values.Raw) > 0 {
+		doc = values.Raw
+	}
+	patched, err := patch.Apply(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidValuesPatches, err)
+	}
+	// The values must stay an object for Helm to merge them
+	if err := json.Unmarshal(patched, &map[string]interface{}{}); err != nil {
+		return nil, fmt.Errorf("%w: patched values aren't an object", errInvalidValuesPatches)
+	}
+	return &apiextensionsv1.JSON{Raw: patched}, nil
+}
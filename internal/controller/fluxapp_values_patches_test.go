@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("Values patches", func() {
+	values := &apiextensionsv1.JSON{Raw: []byte(`{"replicaCount":1,"ingress":{"hosts":["podinfo.example.com"]},"tolerations":[{"key":"spot"},{"key":"gpu"}]}`)}
+
+	newPatchesApp := func(ops ...appsv1.JSONPatchOp) *appsv1.FluxApp {
+		app := newTestApp()
+		app.Spec.ValuesPatches = ops
+		return app
+	}
+
+	value := func(raw string) *apiextensionsv1.JSON {
+		return &apiextensionsv1.JSON{Raw: []byte(raw)}
+	}
+
+	DescribeTable("should apply the patches to the values",
+		func(op appsv1.JSONPatchOp, expected string) {
+			patched, err := patchValues(newPatchesApp(op), values)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patched.Raw).To(MatchJSON(expected))
+		},
+		Entry("add", appsv1.JSONPatchOp{Op: "add", Path: "/ingress/hosts/-", Value: value(`"podinfo.example.org"`)},
+			`{"replicaCount":1,"ingress":{"hosts":["podinfo.example.com","podinfo.example.org"]},"tolerations":[{"key":"spot"},{"key":"gpu"}]}`),
+		Entry("remove", appsv1.JSONPatchOp{Op: "remove", Path: "/tolerations/0"},
+			`{"replicaCount":1,"ingress":{"hosts":["podinfo.example.com"]},"tolerations":[{"key":"gpu"}]}`),
+		Entry("replace", appsv1.JSONPatchOp{Op: "replace", Path: "/replicaCount", Value: value(`3`)},
+			`{"replicaCount":3,"ingress":{"hosts":["podinfo.example.com"]},"tolerations":[{"key":"spot"},{"key":"gpu"}]}`),
+	)
+
+	It("should apply the patches in order", func() {
+		app := newPatchesApp(
+			appsv1.JSONPatchOp{Op: "add", Path: "/resources", Value: value(`{}`)},
+			appsv1.JSONPatchOp{Op: "add", Path: "/resources/limits", Value: value(`{"memory":"256Mi"}`)},
+		)
+		patched, err := patchValues(app, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patched.Raw).To(MatchJSON(`{"resources":{"limits":{"memory":"256Mi"}}}`))
+	})
+
+	It("should leave the values unchanged without patches", func() {
+		patched, err := patchValues(newTestApp(), values)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patched).To(BeIdenticalTo(values))
+	})
+
+	DescribeTable("should reject patches which can't be applied",
+		func(op appsv1.JSONPatchOp) {
+			_, err := patchValues(newPatchesApp(op), values)
+			Expect(err).To(MatchError(errInvalidValuesPatches))
+			Expect(err).To(MatchError(errInvalid))
+		},
+		Entry("missing key", appsv1.JSONPatchOp{Op: "remove", Path: "/affinity"}),
+		Entry("index out of range", appsv1.JSONPatchOp{Op: "replace", Path: "/tolerations/2", Value: value(`{"key":"arm"}`)}),
+		Entry("failed test", appsv1.JSONPatchOp{Op: "test", Path: "/replicaCount", Value: value(`2`)}),
+		Entry("values replaced with a list", appsv1.JSONPatchOp{Op: "replace", Path: "", Value: value(`[]`)}),
+	)
+
+	It("should patch the values template & not the values annotations", func() {
+		app := newPatchesApp(appsv1.JSONPatchOp{Op: "remove", Path: "/cluster"})
+		app.Spec.Values = value(`{"replicaCount":1}`)
+		app.Spec.ValuesTemplate = `cluster: {{ .Vars.clusterName }}`
+		app.Annotations = map[string]string{valuesAnnotationPrefix + "debug": "true"}
+		patched, err := inlineValues(app, map[string]string{"clusterName": "prod-eu"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patched.Raw).To(MatchJSON(`{"replicaCount":1,"debug":true}`))
+	})
+
+	It("should patch the HelmRelease values", func() {
+		ctx := context.Background()
+		app := newPatchesApp(appsv1.JSONPatchOp{Op: "remove", Path: "/tolerations/1"})
+		app.Spec.Values = values
+		r := newTestReconciler()
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Values.Raw).To(MatchJSON(`{"replicaCount":1,"ingress":{"hosts":["podinfo.example.com"]},"tolerations":[{"key":"spot"}]}`))
+	})
+
+	It("should report a patch error in the Ready condition", func() {
+		ctx := context.Background()
+		app := newPatchesApp(appsv1.JSONPatchOp{Op: "remove", Path: "/affinity"})
+		r := newTestReconciler()
+		err := handleHelmRelease(ctx, r, app)
+		Expect(err).To(MatchError(errInvalid))
+		Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.InvalidValuesPatchesReason))
+		Expect(conditions.GetMessage(app, meta.ReadyCondition)).To(ContainSubstring("/affinity"))
+	})
+})
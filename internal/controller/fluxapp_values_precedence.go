@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/yaml"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+// inlineValuesFirst returns true if the inline values are merged before the valuesFrom
+func inlineValuesFirst(app *appsv1.FluxApp) bool {
+	return app.Spec.ValuesPrecedence == appsv1.ValuesPrecedenceInlineFirst
+}
+
+// inlineValuesData returns the inline values as the YAML stored in the inline values ConfigMap
+func inlineValuesData(values *apiextensionsv1.JSON) (string, error) {
+	raw := []byte("{}")
+	if values != nil && len(values.Raw) > 0 {
+		raw = values.Raw
+	}
+	data, err := yaml.JSONToYAML(raw)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// handleInlineValues returns the inline values & references for the HelmRelease in the order of the values precedence
+// The helm-controller always merges the HelmRelease values over the valuesFrom, so to merge the inline values first
+// they're copied into a ConfigMap referenced before the app valuesFrom. Otherwise the ConfigMap is deleted
+func handleInlineValues(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp, values *apiextensionsv1.JSON) (*apiextensionsv1.JSON, []helmv2.ValuesReference, error) {
+	// Get the inline values ConfigMap managed resource
+	mr, err := r.ResourceManager.Get(ctx, app, InlineValuesKind)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !inlineValuesFirst(app) {
+		return values, nil, r.ResourceManager.Delete(ctx, mr)
+	}
+	data, err := inlineValuesData(values)
+	if err != nil {
+		return nil, nil, err
+	}
+	cm := mr.Object.(*corev1.ConfigMap)
+	cm.Data = map[string]string{defaultValuesKey: data}
+	if err := r.ResourceManager.Update(ctx, mr); err != nil {
+		return nil, nil, err
+	}
+	return nil, []helmv2.ValuesReference{{
+		Kind:      "ConfigMap",
+		Name:      cm.Name,
+		ValuesKey: defaultValuesKey,
+	}}, nil
+}
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+)
+
+var _ = Describe("Values precedence", func() {
+	ctx := context.Background()
+
+	// newPrecedenceApp returns an app with inline values & valuesFrom setting the same key
+	newPrecedenceApp := func(precedence string) (*appsv1.FluxApp, *corev1.ConfigMap) {
+		app := newTestApp()
+		app.Spec.ValuesPrecedence = precedence
+		app.Spec.Values = &apiextensionsv1.JSON{Raw: []byte(`{"replicaCount":3,"image":{"tag":"6.5.3"}}`)}
+		app.Spec.ValuesFrom = []helmv2.ValuesReference{{Kind: "ConfigMap", Name: "podinfo-values"}}
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo-values", Namespace: app.Namespace},
+			Data:       map[string]string{defaultValuesKey: "replicaCount: 1\n"},
+		}
+		return app, cm
+	}
+
+	// helmValues merges the HelmRelease values in the same order as the helm-controller
+	// The valuesFrom are merged in order, then the inline values
+	helmValues := func(r *FluxAppReconciler, hr *helmv2.HelmRelease) map[string]interface{} {
+		values := map[string]interface{}{}
+		for _, ref := range hr.Spec.ValuesFrom {
+			cm := &corev1.ConfigMap{}
+			Expect(r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: hr.Namespace}, cm)).To(Succeed())
+			from := map[string]interface{}{}
+			Expect(yaml.Unmarshal([]byte(cm.Data[ref.ValuesKey]), &from)).To(Succeed())
+			values = mergeValues(values, from)
+		}
+		if hr.Spec.Values != nil {
+			inline := map[string]interface{}{}
+			Expect(json.Unmarshal(hr.Spec.Values.Raw, &inline)).To(Succeed())
+			values = mergeValues(values, inline)
+		}
+		return values
+	}
+
+	getInlineValues := func(r *FluxAppReconciler, app *appsv1.FluxApp) (*corev1.ConfigMap, error) {
+		cm := &corev1.ConfigMap{}
+		err := r.Get(ctx, types.NamespacedName{Name: r.ResourceManager.InlineValuesName(app), Namespace: app.Namespace}, cm)
+		return cm, err
+	}
+
+	DescribeTable("should resolve a conflicting key by the values precedence",
+		func(precedence string, replicaCount float64) {
+			app, cm := newPrecedenceApp(precedence)
+			r := newTestReconciler(cm)
+			Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+			hr, err := getHelmRelease(ctx, r, app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(helmValues(r, hr)).To(Equal(map[string]interface{}{
+				"replicaCount": replicaCount,
+				"image":        map[string]interface{}{"tag": "6.5.3"},
+			}))
+		},
+		Entry("inline values win by default", "", float64(3)),
+		Entry("inline values win with fromFirst", appsv1.ValuesPrecedenceFromFirst, float64(3)),
+		Entry("valuesFrom win with inlineFirst", appsv1.ValuesPrecedenceInlineFirst, float64(1)),
+	)
+
+	It("should reference the inline values before the app valuesFrom", func() {
+		app, cm := newPrecedenceApp(appsv1.ValuesPrecedenceInlineFirst)
+		r := newTestReconciler(cm)
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Values).To(BeNil())
+		Expect(hr.Spec.ValuesFrom).To(Equal([]helmv2.ValuesReference{
+			{Kind: "ConfigMap", Name: "podinfo-inline-values", ValuesKey: "values.yaml"},
+			{Kind: "ConfigMap", Name: "podinfo-values", ValuesKey: "values.yaml"},
+		}))
+		inline, err := getInlineValues(r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(inline.Data).To(Equal(map[string]string{defaultValuesKey: "image:\n  tag: 6.5.3\nreplicaCount: 3\n"}))
+		Expect(metav1.IsControlledBy(inline, app)).To(BeTrue())
+	})
+
+	It("should delete the inline values ConfigMap once the inline values are merged last", func() {
+		app, cm := newPrecedenceApp(appsv1.ValuesPrecedenceInlineFirst)
+		r := newTestReconciler(cm)
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		app.Spec.ValuesPrecedence = appsv1.ValuesPrecedenceFromFirst
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Values.Raw).To(MatchJSON(`{"replicaCount":3,"image":{"tag":"6.5.3"}}`))
+		Expect(hr.Spec.ValuesFrom).To(Equal([]helmv2.ValuesReference{
+			{Kind: "ConfigMap", Name: "podinfo-values", ValuesKey: "values.yaml"},
+		}))
+		_, err = getInlineValues(r, app)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+	"github.com/kloudyuk/fluxer/internal/valuestemplate"
+)
+
+// renderValuesTemplate returns the values with the values rendered from the app values template merged over them
+// The template vars are the controller substitutions so the same cluster name or region can be used in both
+// A template which fails to render is an error rather than deploying the values without it
+func renderValuesTemplate(app *appsv1.FluxApp, vars map[string]string, values *apiextensionsv1.JSON) (*apiextensionsv1.JSON, error) {
+	if app.Spec.ValuesTemplate == "" {
+		return values, nil
+	}
+	rendered, err := valuestemplate.Render(app.Spec.ValuesTemplate, valuestemplate.Data{
+		Name:        app.Name,
+		Namespace:   app.Namespace,
+		Labels:      app.Labels,
+		Annotations: app.Annotations,
+		Vars:        vars,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidValuesTemplate, err)
+	}
+	merged := map[string]interface{}{}
+	if values != nil && len(values.Raw) > 0 {
+		if err := json.Unmarshal(values.Raw, &merged); err != nil {
+			return nil, fmt.Errorf("%w values: %w", errInvalid, err)
+		}
+	}
+	raw, err := json.Marshal(mergeValues(merged, rendered))
+	if err != nil {
+		return nil, err
+	}
+	return &apiextensionsv1.JSON{Raw: raw}, nil
+}
+
+// mergeValues deep merges the overrides into the base values the same way Helm merges values files
+// Nested maps are merged, any other value in the overrides replaces the base value
+func mergeValues(base, overrides map[string]interface{}) map[string]interface{} {
+	for k, v := range overrides {
+		if override, ok := v.(map[string]interface{}); ok {
+			if b, ok := base[k].(map[string]interface{}); ok {
+				base[k] = mergeValues(b, override)
+				continue
+			}
+		}
+		base[k] = v
+	}
+	return base
+}
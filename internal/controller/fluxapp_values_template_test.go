@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("Values template", func() {
+	vars := map[string]string{"clusterName": "prod-eu", "region": "eu-west-1"}
+
+	newTemplateApp := func(tmpl string) *appsv1.FluxApp {
+		app := newTestApp()
+		app.Labels = map[string]string{"tier": "frontend"}
+		app.Spec.ValuesTemplate = tmpl
+		return app
+	}
+
+	It("should render the app metadata & vars merged over the values", func() {
+		app := newTemplateApp(`
+ingress:
+  host: {{ .Name }}.{{ .Vars.region }}.example.com
+{{- if eq .Labels.tier "frontend" }}
+replicaCount: 3
+{{- end }}
+`)
+		values := &apiextensionsv1.JSON{Raw: []byte(`{"replicaCount":1,"ingress":{"enabled":true},"image":{"tag":"6.5.3"}}`)}
+		rendered, err := renderValuesTemplate(app, vars, values)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rendered.Raw).To(MatchJSON(`{"replicaCount":3,"ingress":{"enabled":true,"host":"podinfo.eu-west-1.example.com"},"image":{"tag":"6.5.3"}}`))
+	})
+
+	It("should support the sprig functions", func() {
+		app := newTemplateApp(`
+cluster: {{ .Vars.clusterName | upper | quote }}
+env: {{ index .Labels "env" | default "dev" }}
+debug: {{ ternary "true" "false" (hasPrefix "prod" .Vars.clusterName) }}
+zones: {{ list "a" "b" | toJson }}
+`)
+		rendered, err := renderValuesTemplate(app, vars, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rendered.Raw).To(MatchJSON(`{"cluster":"PROD-EU","env":"dev","debug":true,"zones":["a","b"]}`))
+	})
+
+	It("should not allow reading the controller environment", func() {
+		app := newTemplateApp(`home: {{ env "HOME" }}`)
+		_, err := renderValuesTemplate(app, vars, nil)
+		Expect(err).To(MatchError(errInvalid))
+		Expect(err).To(MatchError(ContainSubstring(`function "env" not defined`)))
+	})
+
+	DescribeTable("should reject templates which can't be rendered",
+		func(tmpl string, message string) {
+			_, err := renderValuesTemplate(newTemplateApp(tmpl), vars, nil)
+			Expect(err).To(MatchError(errInvalid))
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("syntax error", `cluster: {{ .Vars.clusterName `, "unclosed action"),
+		Entry("missing var", `zone: {{ .Vars.zone }}`, `map has no entry for key "zone"`),
+		Entry("not a YAML object", `- {{ .Name }}`, "aren't a YAML object"),
+		Entry("too large", `{{ range until 200000 }}padding-{{ . }}{{ end }}`, "rendered values exceed"),
+		Entry("large repeat", `{{ $x := repeat 100000000 "ab" }}a: 1`, "template functions allocate more than"),
+		Entry("large until", `{{ $y := until 10000000 }}a: 1`, "template functions allocate more than"),
+		Entry("large untilStep", `{{ $y := untilStep 0 9223372036854775807 1 }}a: 1`, "template functions allocate more than"),
+		Entry("large seq", `{{ $y := seq 100000000 }}a: 1`, "template functions allocate more than"),
+		Entry("large printf padding", `{{ $y := printf "%0999999999d" 1 }}a: 1`, "template functions allocate more than"),
+		Entry("large indent", `{{ $y := indent 999999999 "a" }}a: 1`, "template functions allocate more than"),
+		Entry("doubling string", `{{ $s := "ab" }}{{ range until 100 }}{{ $s = cat $s $s }}{{ end }}a: 1`,
+			"template functions allocate more than"),
+	)
+
+	It("should leave the values unchanged without a template", func() {
+		values := &apiextensionsv1.JSON{Raw: []byte(`{"replicaCount":1}`)}
+		rendered, err := renderValuesTemplate(newTestApp(), vars, values)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rendered).To(BeIdenticalTo(values))
+	})
+
+	It("should render the HelmRelease values", func() {
+		ctx := context.Background()
+		app := newTemplateApp(`cluster: {{ .Vars.clusterName }}`)
+		r := newTestReconciler()
+		r.Substitutions = vars
+		Expect(handleHelmRelease(ctx, r, app)).To(Succeed())
+		hr, err := getHelmRelease(ctx, r, app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hr.Spec.Values.Raw).To(MatchJSON(`{"cluster":"prod-eu"}`))
+	})
+
+	It("should report a template error in the Ready condition", func() {
+		ctx := context.Background()
+		app := newTemplateApp(`cluster: {{ .Vars.clusterName }}`)
+		r := newTestReconciler()
+		err := handleHelmRelease(ctx, r, app)
+		Expect(err).To(MatchError(errInvalid))
+		Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+		Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.InvalidValuesTemplateReason))
+		Expect(conditions.GetMessage(app, meta.ReadyCondition)).To(ContainSubstring("clusterName"))
+	})
+})
@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"context"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/fluxcd/pkg/runtime/conditions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("Values", func() {
+	Context("valuesFrom", func() {
+		appWithValuesFrom := func(refs ...helmv2.ValuesReference) *appsv1.FluxApp {
+			return &appsv1.FluxApp{Spec: appsv1.FluxAppSpec{ValuesFrom: refs}}
+		}
+
+		It("should return nil when no references are set", func() {
+			refs, err := valuesFrom(appWithValuesFrom())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(refs).To(BeNil())
+		})
+
+		DescribeTable("valid combinations",
+			func(ref helmv2.ValuesReference, expected helmv2.ValuesReference) {
+				refs, err := valuesFrom(appWithValuesFrom(ref))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(refs).To(ConsistOf(expected))
+			},
+			Entry("defaults the values key",
+				helmv2.ValuesReference{Kind: "ConfigMap", Name: "values"},
+				helmv2.ValuesReference{Kind: "ConfigMap", Name: "values", ValuesKey: "values.yaml"},
+			),
+			Entry("keeps a custom values key",
+				helmv2.ValuesReference{Kind: "ConfigMap", Name: "values", ValuesKey: "prod.yaml"},
+				helmv2.ValuesReference{Kind: "ConfigMap", Name: "values", ValuesKey: "prod.yaml"},
+			),
+			Entry("defaults to a required reference",
+				helmv2.ValuesReference{Kind: "Secret", Name: "creds", ValuesKey: "values.yaml"},
+				helmv2.ValuesReference{Kind: "Secret", Name: "creds", ValuesKey: "values.yaml", Optional: false},
+			),
+			Entry("keeps an optional reference",
+				helmv2.ValuesReference{Kind: "ConfigMap", Name: "overrides", Optional: true},
+				helmv2.ValuesReference{Kind: "ConfigMap", Name: "overrides", ValuesKey: "values.yaml", Optional: true},
+			),
+			Entry("keeps an optional single value with a target path",
+				helmv2.ValuesReference{Kind: "Secret", Name: "creds", ValuesKey: "token", TargetPath: "auth.token", Optional: true},
+				helmv2.ValuesReference{Kind: "Secret", Name: "creds", ValuesKey: "token", TargetPath: "auth.token", Optional: true},
+			),
+			Entry("keeps a single value with a target path",
+				helmv2.ValuesReference{Kind: "Secret", Name: "creds", ValuesKey: "password", TargetPath: "auth.password"},
+				helmv2.ValuesReference{Kind: "Secret", Name: "creds", ValuesKey: "password", TargetPath: "auth.password"},
+			),
+		)
+
+		DescribeTable("invalid combinations",
+			func(ref helmv2.ValuesReference) {
+				_, err := valuesFrom(appWithValuesFrom(ref))
+				Expect(err).To(MatchError(errInvalid))
+			},
+			Entry("target path without a values key",
+				helmv2.ValuesReference{Kind: "ConfigMap", Name: "values", TargetPath: "image.tag"},
+			),
+			Entry("target path with the full values document",
+				helmv2.ValuesReference{Kind: "ConfigMap", Name: "values", ValuesKey: "values.yaml", TargetPath: "image.tag"},
+			),
+		)
+
+		It("should validate optional references the same as required references", func() {
+			_, err := valuesFrom(appWithValuesFrom(helmv2.ValuesReference{Kind: "ConfigMap", Name: "values", TargetPath: "image.tag", Optional: true}))
+			Expect(err).To(MatchError(errInvalid))
+		})
+
+		It("should not modify the app spec", func() {
+			app := appWithValuesFrom(helmv2.ValuesReference{Kind: "ConfigMap", Name: "values"})
+			_, err := valuesFrom(app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(app.Spec.ValuesFrom[0].ValuesKey).To(BeEmpty())
+		})
+	})
+
+	Context("ignoreMissingValues", func() {
+		It("should mark references as optional when ignoring missing values", func() {
+			app := &appsv1.FluxApp{Spec: appsv1.FluxAppSpec{IgnoreMissingValuesFiles: true}}
+			refs := ignoreMissingValues(app, []helmv2.ValuesReference{{Kind: "ConfigMap", Name: "values"}})
+			Expect(refs).To(ConsistOf(helmv2.ValuesReference{Kind: "ConfigMap", Name: "values", Optional: true}))
+		})
+
+		It("should keep optional references when not ignoring missing values", func() {
+			app := &appsv1.FluxApp{}
+			refs := ignoreMissingValues(app, []helmv2.ValuesReference{
+				{Kind: "ConfigMap", Name: "values"},
+				{Kind: "Secret", Name: "secrets", Optional: true},
+			})
+			Expect(refs[0].Optional).To(BeFalse())
+			Expect(refs[1].Optional).To(BeTrue())
+		})
+	})
+
+	Context("inline values", func() {
+		DescribeTable("should accept well-formed values",
+			func(raw string) {
+				Expect(validateValues(&apiextensionsv1.JSON{Raw: []byte(raw)})).To(Succeed())
+			},
+			Entry("an object", `{"replicaCount":1,"ingress":{"enabled":true}}`),
+			Entry("an empty object", `{}`),
+			Entry("null", `null`),
+		)
+
+		DescribeTable("should reject malformed values",
+			func(raw string, message string) {
+				err := validateValues(&apiextensionsv1.JSON{Raw: []byte(raw)})
+				Expect(err).To(MatchError(errInvalidValues))
+				Expect(err).To(MatchError(ContainSubstring(message)))
+			},
+			Entry("a list", `["replicaCount"]`, "must be an object"),
+			Entry("a number", `3`, "must be an object"),
+			Entry("a YAML string", `"replicaCount: 1\ningress:\n  enabled: true\n"`, "remove the | or quotes"),
+			Entry("a malformed YAML string", `"replicaCount: 1\n  ingress: [\n"`, "isn't a valid YAML object"),
+		)
+
+		It("should set InvalidValues on the Ready condition before creating the HelmRelease", func() {
+			ctx := context.Background()
+			app := newTestApp()
+			app.Spec.Values = &apiextensionsv1.JSON{Raw: []byte(`"replicaCount: [1"`)}
+			r := newTestReconciler()
+			err := handleHelmRelease(ctx, r, app)
+			Expect(err).To(MatchError(errInvalidValues))
+			Expect(conditions.IsFalse(app, meta.ReadyCondition)).To(BeTrue())
+			Expect(conditions.GetReason(app, meta.ReadyCondition)).To(Equal(appsv1.InvalidValuesReason))
+			Expect(conditions.GetMessage(app, meta.ReadyCondition)).To(ContainSubstring("isn't a valid YAML object"))
+			_, err = getHelmRelease(ctx, r, app)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
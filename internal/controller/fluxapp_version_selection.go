@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	mmsemver "github.com/Masterminds/semver/v3"
+	"github.com/blang/semver/v4"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+// lowestVersion returns the lowest chart version matching the version constraint
+// The ImagePolicy only selects the highest matching version so the chart tags are listed from the registry
+func lowestVersion(ctx context.Context, r *FluxAppReconciler, app *appsv1.FluxApp) (string, error) {
+	if r.ChartTags == nil {
+		return "", errors.New("unable to select the lowest chart version, the chart tags can't be listed")
+	}
+	tags, err := r.ChartTags.ChartTags(ctx, app.Status.Chart.Repository, app.Status.Chart.Name)
+	if err != nil {
+		return "", err
+	}
+	// The excluded tags are never selected, the same as the tags the ImageRepository doesn't store
+	if tags, err = excludeTags(tags, exclusionList(app)); err != nil {
+		return "", err
+	}
+	return selectVersion(tags, app.Spec.Chart.Version, app.Spec.Chart.TagPrefix, appsv1.VersionSelectionLowest)
+}
+
+// defaultExclusion excludes the signature tags, the same as the image-reflector-controller default exclusion list
+const defaultExclusion = `^.*\.sig$`
+
+// exclusionList returns the ImageRepository exclusion list for the app
+// Setting a list replaces the image-reflector-controller default so the signature tags are kept excluded
+// If nil, the default applies
+func exclusionList(app *appsv1.FluxApp) []string {
+	if len(app.Spec.Chart.ExclusionList) == 0 {
+		return nil
+	}
+	return append([]string{defaultExclusion}, app.Spec.Chart.ExclusionList...)
+}
+
+// excludeTags returns the tags which don't match any of the exclusion regular expressions
+func excludeTags(tags []string, exclusions []string) ([]string, error) {
+	if len(exclusions) == 0 {
+		return tags, nil
+	}
+	res := make([]*regexp.Regexp, 0, len(exclusions))
+	for _, e := range exclusions {
+		re, err := regexp.Compile(e)
+		if err != nil {
+			return nil, fmt.Errorf("%w chart exclusion %q: %w", errInvalid, e, err)
+		}
+		res = append(res, re)
+	}
+	included := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if !matchesAny(res, t) {
+			included = append(included, t)
+		}
+	}
+	return included, nil
+}
+
+// matchesAny returns true if any of the regular expressions match the string
+func matchesAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectVersion returns the highest or lowest version of the chart tags matching the version constraint
+// The tag prefix is removed and the constraint applied the same way as the image-reflector-controller
+func selectVersion(tags []string, version, prefix, selection string) (string, error) {
+	constraint, err := mmsemver.NewConstraint(version)
+	if err != nil {
+		return "", fmt.Errorf("%w chart version constraint %q: %w", errInvalid, version, err)
+	}
+	var selected *mmsemver.Version
+	var tag string
+	for _, t := range tags {
+		if !strings.HasPrefix(t, prefix) {
+			continue
+		}
+		t = strings.TrimPrefix(t, prefix)
+		// Only SemVer tags are charts, the same as the versions selected by the ImagePolicy
+		if _, err := semver.Parse(t); err != nil {
+			continue
+		}
+		v, err := mmsemver.NewVersion(t)
+		if err != nil || !constraint.Check(v) {
+			continue
+		}
+		if selected == nil ||
+			(selection == appsv1.VersionSelectionLowest && v.LessThan(selected)) ||
+			(selection != appsv1.VersionSelectionLowest && v.GreaterThan(selected)) {
+			selected, tag = v, t
+		}
+	}
+	if selected == nil {
+		return "", fmt.Errorf("%w %q", errNoMatchingTags, version)
+	}
+	return tag, nil
+}
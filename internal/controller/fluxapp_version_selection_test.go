@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+)
+
+// fakeChartTags returns the same tags for every chart, recording the last chart listed
+type fakeChartTags struct {
+	tags       []string
+	err        error
+	repository string
+	name       string
+}
+
+func (f *fakeChartTags) ChartTags(_ context.Context, repository, name string) ([]string, error) {
+	f.repository, f.name = repository, name
+	return f.tags, f.err
+}
+
+var _ = Describe("Version selection", func() {
+	ctx := context.Background()
+	tags := []string{"6.4.0", "6.5.0", "6.5.3", "6.6.0", "7.0.0-rc.1", "7.0.0", "latest", "sha256-0123"}
+
+	// newSelectionReconciler returns a reconciler with the ImagePolicy selecting the highest matching version
+	newSelectionReconciler := func(app *appsv1.FluxApp, latest string, tags []string) *FluxAppReconciler {
+		policy := &imagev1.ImagePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo-chart", Namespace: app.Namespace},
+			Status:     imagev1.ImagePolicyStatus{LatestImage: "ghcr.io/stefanprodan/charts/podinfo:" + latest},
+		}
+		r := newTestReconciler(policy)
+		r.ChartTags = &fakeChartTags{tags: tags}
+		return r
+	}
+
+	DescribeTable("should select the chart version",
+		func(selection, constraint, latest, expected string) {
+			app := newTestApp()
+			app.Spec.Chart.Version = constraint
+			app.Spec.Chart.VersionSelection = selection
+			r := newSelectionReconciler(app, latest, tags)
+			Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+			Expect(app.Status.Chart.Version).To(Equal(expected))
+		},
+		Entry("highest by default", "", "6.x", "6.6.0", "6.6.0"),
+		Entry("highest", appsv1.VersionSelectionHighest, "6.x", "6.6.0", "6.6.0"),
+		Entry("lowest", appsv1.VersionSelectionLowest, "6.x", "6.6.0", "6.4.0"),
+		Entry("lowest in a range", appsv1.VersionSelectionLowest, ">=6.5.1 <7.0.0", "6.6.0", "6.5.3"),
+		Entry("lowest of any version", appsv1.VersionSelectionLowest, "*", "7.0.0", "6.4.0"),
+		Entry("lowest exact version", appsv1.VersionSelectionLowest, "6.5.0", "6.5.0", "6.5.0"),
+	)
+
+	It("should select the lowest prefixed tag", func() {
+		app := newTestApp()
+		app.Spec.Chart.Version = "6.x"
+		app.Spec.Chart.VersionSelection = appsv1.VersionSelectionLowest
+		app.Spec.Chart.TagPrefix = "chart-"
+		r := newSelectionReconciler(app, "chart-6.6.0", []string{"6.0.0", "chart-6.5.3", "chart-6.6.0", "other-6.1.0"})
+		Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+		Expect(app.Status.Chart.Version).To(Equal("6.5.3"))
+	})
+
+	It("should not select an excluded tag", func() {
+		app := newTestApp()
+		app.Spec.Chart.Version = "6.x"
+		app.Spec.Chart.VersionSelection = appsv1.VersionSelectionLowest
+		app.Spec.Chart.ExclusionList = []string{`^6\.4\.`}
+		r := newSelectionReconciler(app, "6.6.0", tags)
+		Expect(handleImagePolicy(ctx, r, app)).To(Succeed())
+		Expect(app.Status.Chart.Version).To(Equal("6.5.0"))
+	})
+
+	It("should reject an exclusion which isn't a regular expression", func() {
+		app := newTestApp()
+		app.Spec.Chart.VersionSelection = appsv1.VersionSelectionLowest
+		app.Spec.Chart.ExclusionList = []string{"6.4.(0"}
+		r := newSelectionReconciler(app, "6.6.0", tags)
+		Expect(handleImagePolicy(ctx, r, app)).To(MatchError(errInvalid))
+	})
+
+	It("should return the error if the tags can't be listed", func() {
+		app := newTestApp()
+		app.Spec.Chart.VersionSelection = appsv1.VersionSelectionLowest
+		r := newSelectionReconciler(app, "6.6.0", nil)
+		r.ChartTags = &fakeChartTags{err: errors.New("registry unavailable")}
+		Expect(handleImagePolicy(ctx, r, app)).To(MatchError("registry unavailable"))
+		Expect(app.Status.Chart.Version).To(Equal("6.5.3"))
+	})
+
+	It("should not select the lowest version without a tag lister", func() {
+		app := newTestApp()
+		app.Spec.Chart.VersionSelection = appsv1.VersionSelectionLowest
+		r := newSelectionReconciler(app, "6.6.0", nil)
+		r.ChartTags = nil
+		Expect(handleImagePolicy(ctx, r, app)).To(HaveOccurred())
+		Expect(app.Status.Chart.Version).To(Equal("6.5.3"))
+	})
+
+	It("should list the tags of every page", func() {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			Expect(req.URL.Path).To(Equal("/v2/charts/podinfo/tags/list"))
+			if req.URL.Query().Get("last") == "" {
+				w.Header().Set("Link", `</v2/charts/podinfo/tags/list?n=2&last=6.5.0>; rel="next"`)
+				_, _ = w.Write([]byte(`{"name":"charts/podinfo","tags":["6.4.0","6.5.0"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"name":"charts/podinfo","tags":["6.5.3"]}`))
+		}))
+		DeferCleanup(server.Close)
+		repository := "oci://" + strings.TrimPrefix(server.URL, "https://") + "/charts"
+		listed, err := NewRegistryClient(server.Client()).ChartTags(ctx, repository, "podinfo")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(listed).To(Equal([]string{"6.4.0", "6.5.0", "6.5.3"}))
+	})
+})
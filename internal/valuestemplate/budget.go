@@ -0,0 +1,319 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package valuestemplate
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// MaxAllocated limits the memory the functions called by a template allocate while it's rendered
+// It's larger than MaxSize as a template can build values it only renders in part
+const MaxAllocated = 16 * MaxSize
+
+// maxDepth is how deeply nested a value can be before it's treated as too large
+const maxDepth = 100
+
+// wordSize is the approximate memory held by a value besides its contents
+const wordSize = 8
+
+// tooLarge is the estimate of a function allocating more than MaxAllocated
+// The estimates saturate at it so adding a few of them can't overflow
+const tooLarge = MaxAllocated + 1
+
+// errAllocated is returned when the functions called by a template allocate more than MaxAllocated
+var errAllocated = fmt.Errorf("template functions allocate more than %d bytes", MaxAllocated)
+
+// estimates return how much memory a function allocates from its arguments, before it's called,
+// for the functions whose result can be much larger than their arguments
+// The other functions are estimated from the size of their arguments
+var estimates = map[string]func(args []reflect.Value) int{
+	"repeat": func(args []reflect.Value) int {
+		return product(int(args[0].Int()), args[1].Len())
+	},
+	"indent":  estimateIndent,
+	"nindent": estimateIndent,
+	"replace": func(args []reflect.Value) int {
+		old, replacement, src := args[0].String(), args[1].String(), args[2].String()
+		if len(replacement) <= len(old) {
+			return len(src)
+		}
+		return len(src) + product(strings.Count(src, old), len(replacement)-len(old))
+	},
+	"regexReplaceAll":            estimateRegexReplace,
+	"mustRegexReplaceAll":        estimateRegexReplace,
+	"regexReplaceAllLiteral":     estimateRegexReplace,
+	"mustRegexReplaceAllLiteral": estimateRegexReplace,
+	"join": func(args []reflect.Value) int {
+		return product(args[0].Len(), length(args[1])) + sizeOf(args[1], tooLarge)
+	},
+	"until": func(args []reflect.Value) int {
+		return steps(0, int(args[0].Int()), 1)
+	},
+	"untilStep": func(args []reflect.Value) int {
+		return steps(int(args[0].Int()), int(args[1].Int()), int(args[2].Int()))
+	},
+	"seq": func(args []reflect.Value) int {
+		params := args[0]
+		switch params.Len() {
+		case 1:
+			return steps(1, int(params.Index(0).Int()), 1)
+		case 2:
+			return steps(int(params.Index(0).Int()), int(params.Index(1).Int()), 1)
+		case 3:
+			return steps(int(params.Index(0).Int()), int(params.Index(2).Int()), int(params.Index(1).Int()))
+		}
+		return 0
+	},
+	"printf": func(args []reflect.Value) int {
+		size := sizeOf(args[1], tooLarge)
+		// Padding & precision apply to each element of a list so are counted for each word of the arguments
+		return args[0].Len() + size + product(padding(args[0].String(), args[1]), 1+size/wordSize)
+	},
+	"html":     estimateEscape,
+	"js":       estimateEscape,
+	"urlquery": estimateEscape,
+}
+
+// builtins are the text/template functions which can allocate, wrapped like the sprig functions
+var builtins = template.FuncMap{
+	"print":    fmt.Sprint,
+	"printf":   fmt.Sprintf,
+	"println":  fmt.Sprintln,
+	"html":     template.HTMLEscaper,
+	"js":       template.JSEscaper,
+	"urlquery": template.URLQueryEscaper,
+}
+
+// budget tracks the memory allocated by the functions called while a template is rendered
+type budget struct {
+	remaining int
+}
+
+// funcs returns the template functions, each checking its allocations against the budget
+// The estimate of a function is checked before it's called, then the size of its result is taken from the budget
+func (b *budget) funcs() template.FuncMap {
+	wrapped := make(template.FuncMap, len(funcs))
+	for name, fn := range funcs {
+		estimate := estimates[name]
+		if estimate == nil {
+			estimate = sizeOfArgs
+		}
+		wrapped[name] = b.wrap(fn, estimate)
+	}
+	return wrapped
+}
+
+// wrap returns the function returning an error when it would allocate more than the remaining budget
+func (b *budget) wrap(fn interface{}, estimate func(args []reflect.Value) int) interface{} {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
+	in := make([]reflect.Type, t.NumIn())
+	for i := range in {
+		in[i] = t.In(i)
+	}
+	resultType := t.Out(0)
+	wrappedType := reflect.FuncOf(in, []reflect.Type{resultType, errorType}, t.IsVariadic())
+	fail := func(err error) []reflect.Value {
+		return []reflect.Value{reflect.Zero(resultType), reflect.ValueOf(&err).Elem()}
+	}
+	return reflect.MakeFunc(wrappedType, func(args []reflect.Value) []reflect.Value {
+		if estimate(args) > b.remaining {
+			return fail(errAllocated)
+		}
+		var out []reflect.Value
+		if t.IsVariadic() {
+			out = v.CallSlice(args)
+		} else {
+			out = v.Call(args)
+		}
+		if len(out) == 2 && !out[1].IsNil() {
+			return out
+		}
+		size := sizeOf(out[0], b.remaining)
+		if size > b.remaining {
+			return fail(errAllocated)
+		}
+		b.remaining -= size
+		return []reflect.Value{out[0], reflect.Zero(errorType)}
+	}).Interface()
+}
+
+// sizeOf returns the approximate memory held by the value, stopping once it's more than the limit
+func sizeOf(v reflect.Value, limit int) int {
+	s := &sizer{limit: limit}
+	s.add(v, 0)
+	return s.size
+}
+
+// sizer adds up the memory held by a value and the values it contains
+type sizer struct {
+	size  int
+	limit int
+}
+
+func (s *sizer) add(v reflect.Value, depth int) {
+	if s.size > s.limit {
+		return
+	}
+	if depth > maxDepth {
+		s.size = s.limit + 1
+		return
+	}
+	s.size += wordSize
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if !v.IsNil() {
+			s.add(v.Elem(), depth+1)
+		}
+	case reflect.String:
+		s.size += v.Len()
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			s.size += v.Len()
+			return
+		}
+		for i := 0; i < v.Len() && s.size <= s.limit; i++ {
+			s.add(v.Index(i), depth+1)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() && s.size <= s.limit {
+			s.add(iter.Key(), depth+1)
+			s.add(iter.Value(), depth+1)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField() && s.size <= s.limit; i++ {
+			s.add(v.Field(i), depth+1)
+		}
+	}
+}
+
+// sizeOfArgs returns the approximate memory held by the arguments of a function
+func sizeOfArgs(args []reflect.Value) int {
+	size := 0
+	for _, arg := range args {
+		size += sizeOf(arg, tooLarge-size)
+		if size > MaxAllocated {
+			return tooLarge
+		}
+	}
+	return size
+}
+
+// length returns the number of elements of a list, or 1 for any other value
+func length(v reflect.Value) int {
+	for v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return v.Len()
+	}
+	return 1
+}
+
+// product multiplies the sizes, saturating at tooLarge
+// A negative size is an error the function reports itself so it's counted as nothing
+func product(a, b int) int {
+	if a <= 0 || b <= 0 {
+		return 0
+	}
+	if a > tooLarge/b {
+		return tooLarge
+	}
+	return a * b
+}
+
+// steps returns the memory held by the list of ints from start towards stop
+func steps(start, stop, step int) int {
+	if step == 0 {
+		return 0
+	}
+	if step < 0 {
+		start, stop, step = stop, start, -step
+	}
+	if stop <= start {
+		return 0
+	}
+	// The difference of two ints can overflow an int but not a uint
+	count := (uint64(stop) - uint64(start)) / uint64(step)
+	if count >= tooLarge/wordSize {
+		return tooLarge
+	}
+	return product(int(count)+1, wordSize)
+}
+
+// estimateIndent estimates indent & nindent, which pad every line of the string
+func estimateIndent(args []reflect.Value) int {
+	v := args[1].String()
+	return len(v) + product(int(args[0].Int()), strings.Count(v, "\n")+2)
+}
+
+// estimateRegexReplace estimates the regexReplaceAll functions, which can replace between every character
+func estimateRegexReplace(args []reflect.Value) int {
+	src, replacement := args[1].Len(), args[2].Len()
+	return src + product(src+1, replacement)
+}
+
+// estimateEscape estimates html, js & urlquery, which escape each character into up to 6 characters
+func estimateEscape(args []reflect.Value) int {
+	return product(sizeOfArgs(args), 6)
+}
+
+// verb matches the width & precision of a printf verb, either a number or * taken from the arguments
+var verb = regexp.MustCompile(`%[-+# 0]*(?:\[\d+\])?(\*|\d*)(?:\.(?:\[\d+\])?(\*|\d*))?`)
+
+// padding returns the total width & precision of the printf verbs in the format
+// A * is counted as the largest int in the arguments
+func padding(format string, args reflect.Value) int {
+	largest := 0
+	for i := 0; i < args.Len(); i++ {
+		arg := args.Index(i)
+		if arg.Kind() == reflect.Interface && !arg.IsNil() {
+			arg = arg.Elem()
+		}
+		if arg.CanInt() && arg.Int() > int64(largest) {
+			largest = int(min(arg.Int(), tooLarge))
+		}
+	}
+	total := 0
+	for _, match := range verb.FindAllStringSubmatch(format, -1) {
+		for _, n := range match[1:] {
+			switch n {
+			case "":
+			case "*":
+				total += largest
+			default:
+				width, err := strconv.Atoi(n)
+				if err != nil {
+					return tooLarge
+				}
+				total += min(width, tooLarge)
+			}
+			if total > MaxAllocated {
+				return tooLarge
+			}
+		}
+	}
+	return total
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package valuestemplate renders the FluxApp values template with text/template & sprig
+// It's shared by the controller, which renders the values, and the webhook, which validates the template
+package valuestemplate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"text/template"
+
+	sprig "github.com/go-task/slim-sprig/v3"
+	"sigs.k8s.io/yaml"
+)
+
+// MaxSize limits the size of the rendered values
+const MaxSize = 1 << 20
+
+// errTooLarge is returned when the rendered values exceed MaxSize
+var errTooLarge = fmt.Errorf("rendered values exceed %d bytes", MaxSize)
+
+// funcs are the sprig functions available to templates, and the builtins which allocate
+// Only repeatable functions are included so the values don't change on every reconcile
+// and templates can't read the controller environment
+// Each render calls them through a budget so a template can't exhaust the controller memory
+var funcs = func() template.FuncMap {
+	m := sprig.HermeticTxtFuncMap()
+	for name, fn := range builtins {
+		m[name] = fn
+	}
+	return m
+}()
+
+// Data is what the template is rendered with
+type Data struct {
+	// Name of the FluxApp
+	Name string
+	// Namespace of the FluxApp
+	Namespace string
+	// Labels of the FluxApp
+	Labels map[string]string
+	// Annotations of the FluxApp
+	Annotations map[string]string
+	// Vars set on the controller e.g. the cluster name or region
+	Vars map[string]string
+}
+
+// Parse parses the template
+// Referencing a missing map key e.g. an unset var is an error when the template is rendered
+func Parse(text string) (*template.Template, error) {
+	return template.New("valuesTemplate").Option("missingkey=error").Funcs(funcs).Parse(text)
+}
+
+// Render renders the template with the data and returns the values from the rendered YAML
+func Render(text string, data Data) (map[string]interface{}, error) {
+	tmpl, err := Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	b := &budget{remaining: MaxAllocated}
+	w := &limitedWriter{max: MaxSize}
+	if err := tmpl.Funcs(b.funcs()).Execute(w, data); err != nil {
+		for _, limit := range []error{errTooLarge, errAllocated} {
+			if errors.Is(err, limit) {
+				return nil, limit
+			}
+		}
+		return nil, err
+	}
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(w.buf.Bytes(), &values); err != nil {
+		return nil, fmt.Errorf("rendered values aren't a YAML object: %w", err)
+	}
+	return values, nil
+}
+
+// limitedWriter buffers up to max bytes, failing any write beyond that
+type limitedWriter struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.max {
+		return 0, errTooLarge
+	}
+	return w.buf.Write(p)
+}
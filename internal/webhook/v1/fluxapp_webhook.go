@@ -0,0 +1,491 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/pkg/apis/kustomize"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+	"github.com/kloudyuk/fluxer/internal/childpatch"
+	"github.com/kloudyuk/fluxer/internal/valuestemplate"
+)
+
+// minInterval is the shortest interval of the Flux children
+// The CRD pattern allows e.g. 0s or 10ms which the Flux controllers can't reconcile at
+const minInterval = time.Second
+
+// log is for logging in this package.
+var fluxapplog = logf.Log.WithName("fluxapp-resource")
+
+// SetupFluxAppWebhookWithManager registers the webhook for FluxApp in the manager.
+func SetupFluxAppWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&appsv1.FluxApp{}).
+		WithValidator(&FluxAppCustomValidator{Client: mgr.GetClient()}).
+		Complete()
+}
+
+// NOTE: The 'path' attribute must follow a specific pattern and should not be modified directly here.
+// Modifying the path for an invalid path can cause API server errors; failing to locate the webhook.
+// +kubebuilder:webhook:path=/validate-apps-kloudy-uk-v1-fluxapp,mutating=false,failurePolicy=fail,sideEffects=None,groups=apps.kloudy.uk,resources=fluxapps,verbs=create;update,versions=v1,name=vfluxapp-v1.kb.io,admissionReviewVersions=v1
+
+// FluxAppCustomValidator struct is responsible for validating the FluxApp resource
+// when it is created or updated.
+//
+// NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
+// as this struct is used only for temporary operations and does not need to be deeply copied.
+// +kubebuilder:object:generate=false
+type FluxAppCustomValidator struct {
+	// Client lists the existing FluxApps to check for release name collisions
+	Client client.Reader
+}
+
+var _ webhook.CustomValidator = &FluxAppCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type FluxApp.
+func (v *FluxAppCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	fluxapp, ok := obj.(*appsv1.FluxApp)
+	if !ok {
+		return nil, fmt.Errorf("expected a FluxApp object but got %T", obj)
+	}
+	fluxapplog.Info("Validation for FluxApp upon creation", "name", fluxapp.GetName())
+	return nil, v.validateFluxApp(ctx, fluxapp)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type FluxApp.
+func (v *FluxAppCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	fluxapp, ok := newObj.(*appsv1.FluxApp)
+	if !ok {
+		return nil, fmt.Errorf("expected a FluxApp object for the newObj but got %T", newObj)
+	}
+	oldApp, ok := oldObj.(*appsv1.FluxApp)
+	if !ok {
+		return nil, fmt.Errorf("expected a FluxApp object for the oldObj but got %T", oldObj)
+	}
+	// A deleted app, or an update which doesn't change the spec e.g. removing the finalizer, isn't validated again
+	// so an app which has become invalid, e.g. when the app it collides with was created, can still be deleted
+	if fluxapp.DeletionTimestamp != nil || equality.Semantic.DeepEqual(oldApp.Spec, fluxapp.Spec) {
+		return nil, nil
+	}
+	fluxapplog.Info("Validation for FluxApp upon update", "name", fluxapp.GetName())
+	// The release of the old name would be left behind
+	if oldApp.Spec.ReleaseName != fluxapp.Spec.ReleaseName {
+		return nil, apierrors.NewInvalid(appsv1.GroupVersion.WithKind("FluxApp").GroupKind(), fluxapp.Name, field.ErrorList{
+			field.Invalid(field.NewPath("spec", "releaseName"), fluxapp.Spec.ReleaseName, "releaseName is immutable"),
+		})
+	}
+	return nil, v.validateFluxApp(ctx, fluxapp)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type FluxApp.
+// Deletes aren't validated so this isn't called
+func (v *FluxAppCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validateFluxApp returns an Invalid error listing all the problems with the app
+func (v *FluxAppCustomValidator) validateFluxApp(ctx context.Context, app *appsv1.FluxApp) error {
+	allErrs, err := validateValuesOverlap(app)
+	if err != nil {
+		return err
+	}
+	releaseErrs, err := v.validateReleaseName(ctx, app)
+	if err != nil {
+		return err
+	}
+	allErrs = append(allErrs, releaseErrs...)
+	allErrs = append(allErrs, validateValuesTemplate(app)...)
+	allErrs = append(allErrs, validateValuesPatches(app)...)
+	allErrs = append(allErrs, validateVersionSource(app)...)
+	allErrs = append(allErrs, validateIntervals(app)...)
+	allErrs = append(allErrs, validateExclusionList(app)...)
+	allErrs = append(allErrs, validateValuesFiles(app)...)
+	allErrs = append(allErrs, validateChartAuth(app)...)
+	allErrs = append(allErrs, validatePinDigest(app)...)
+	allErrs = append(allErrs, validateDriftExclusions(app)...)
+	allErrs = append(allErrs, validateChildPatches(app)...)
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(appsv1.GroupVersion.WithKind("FluxApp").GroupKind(), app.Name, allErrs)
+}
+
+// helmRelease identifies the Helm release deployed by an app
+type helmRelease struct {
+	server    string
+	namespace string
+	name      string
+}
+
+// releaseOf returns the Helm release deployed by the app, defaulted the same way as the controller
+func releaseOf(app *appsv1.FluxApp) helmRelease {
+	release := helmRelease{namespace: app.Spec.TargetNamespace, name: app.Spec.ReleaseName}
+	if release.namespace == "" {
+		release.namespace = app.Namespace
+	}
+	if release.name == "" {
+		release.name = app.Name
+	}
+	if app.Spec.RemoteCluster != nil {
+		release.server = app.Spec.RemoteCluster.Server
+	}
+	return release
+}
+
+// validateReleaseName rejects an app deploying the same Helm release as another app
+// as the HelmReleases would fight over the release
+func (v *FluxAppCustomValidator) validateReleaseName(ctx context.Context, app *appsv1.FluxApp) (field.ErrorList, error) {
+	apps := &appsv1.FluxAppList{}
+	if err := v.Client.List(ctx, apps); err != nil {
+		return nil, apierrors.NewInternalError(fmt.Errorf("unable to list FluxApps: %w", err))
+	}
+	release := releaseOf(app)
+	var allErrs field.ErrorList
+	for i := range apps.Items {
+		other := &apps.Items[i]
+		if other.Namespace == app.Namespace && other.Name == app.Name {
+			continue
+		}
+		if releaseOf(other) == release {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "releaseName"), release.name,
+				fmt.Sprintf("release is already deployed to namespace %s by FluxApp %s/%s", release.namespace, other.Namespace, other.Name)))
+		}
+	}
+	return allErrs, nil
+}
+
+// validateValuesOverlap rejects valuesFrom references with a TargetPath which overlaps a key set in the inline values
+// The inline values take precedence so the referenced value would be silently ignored or partly overwritten
+// The overlap is allowed when the inline values are merged first as the referenced value overrides them
+func validateValuesOverlap(app *appsv1.FluxApp) (field.ErrorList, error) {
+	if app.Spec.Values == nil || len(app.Spec.Values.Raw) == 0 || app.Spec.ValuesPrecedence == appsv1.ValuesPrecedenceInlineFirst {
+		return nil, nil
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(app.Spec.Values.Raw, &values); err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("unable to decode values: %s", err))
+	}
+	var allErrs field.ErrorList
+	check := func(fldPath *field.Path, refs []helmv2.ValuesReference) {
+		for i, ref := range refs {
+			if ref.TargetPath == "" {
+				continue
+			}
+			if overlaps(values, targetPathKeys(ref.TargetPath)) {
+				allErrs = append(allErrs, field.Invalid(fldPath.Index(i).Child("targetPath"), ref.TargetPath,
+					"overlaps a key set in spec.values"))
+			}
+		}
+	}
+	check(field.NewPath("spec", "valuesFrom"), app.Spec.ValuesFrom)
+	if app.Spec.Canary != nil {
+		check(field.NewPath("spec", "canary", "valuesFrom"), app.Spec.Canary.ValuesFrom)
+	}
+	return allErrs, nil
+}
+
+// validateValuesTemplate rejects a values template which can't be parsed
+// The vars are set on the controller so the template can only be rendered by the controller
+func validateValuesTemplate(app *appsv1.FluxApp) field.ErrorList {
+	if app.Spec.ValuesTemplate == "" {
+		return nil
+	}
+	if _, err := valuestemplate.Parse(app.Spec.ValuesTemplate); err != nil {
+		return field.ErrorList{field.Invalid(field.NewPath("spec", "valuesTemplate"), app.Spec.ValuesTemplate, err.Error())}
+	}
+	return nil
+}
+
+// validateValuesPatches rejects values patch operations which are missing a field the operation needs
+// or have a path which isn't a JSON pointer
+// Whether the paths exist depends on the merged values so can only be checked by the controller
+func validateValuesPatches(app *appsv1.FluxApp) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, op := range app.Spec.ValuesPatches {
+		fldPath := field.NewPath("spec", "valuesPatches").Index(i)
+		if !isJSONPointer(op.Path) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("path"), op.Path, "must be a JSON pointer e.g. /ingress/hosts/0"))
+		}
+		switch op.Op {
+		case "move", "copy":
+			if op.From == "" {
+				allErrs = append(allErrs, field.Required(fldPath.Child("from"), fmt.Sprintf("required for the %s operation", op.Op)))
+			} else if !isJSONPointer(op.From) {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("from"), op.From, "must be a JSON pointer e.g. /ingress/hosts/0"))
+			}
+		case "add", "replace", "test":
+			if op.Value == nil {
+				allErrs = append(allErrs, field.Required(fldPath.Child("value"), fmt.Sprintf("required for the %s operation", op.Op)))
+			}
+		}
+	}
+	return allErrs
+}
+
+// validateVersionSource rejects an app setting the chart version with more than one mechanism
+// A registry chart version is selected from the tags by chart.version, a Git chart version is read
+// from Chart.yaml at chart.git.ref, so the fields of the other source would be silently ignored
+func validateVersionSource(app *appsv1.FluxApp) field.ErrorList {
+	chartPath := field.NewPath("spec", "chart")
+	var allErrs field.ErrorList
+	if app.Spec.SourceKind != sourcev1.GitRepositoryKind {
+		if app.Spec.Chart.Git != nil {
+			allErrs = append(allErrs, field.Forbidden(chartPath.Child("git"),
+				fmt.Sprintf("can only be set when spec.sourceKind is %s", sourcev1.GitRepositoryKind)))
+		}
+		return allErrs
+	}
+	gitVersion := fmt.Sprintf("can't be set when spec.sourceKind is %s, the chart version is read from Chart.yaml at spec.chart.git.ref",
+		sourcev1.GitRepositoryKind)
+	// The CRD defaults are the same as not setting the fields
+	if v := app.Spec.Chart.Version; v != "" && v != "*" {
+		allErrs = append(allErrs, field.Forbidden(chartPath.Child("version"), gitVersion))
+	}
+	if app.Spec.Chart.VersionSelection == appsv1.VersionSelectionLowest {
+		allErrs = append(allErrs, field.Forbidden(chartPath.Child("versionSelection"), gitVersion))
+	}
+	if app.Spec.Chart.TagPrefix != "" {
+		allErrs = append(allErrs, field.Forbidden(chartPath.Child("tagPrefix"), gitVersion))
+	}
+	if app.Spec.Chart.Name != "" {
+		allErrs = append(allErrs, field.Forbidden(chartPath.Child("name"),
+			fmt.Sprintf("can't be set when spec.sourceKind is %s, the chart is at spec.chart.git.path", sourcev1.GitRepositoryKind)))
+	}
+	if app.Spec.HelmRepositoryRef != nil {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "helmRepositoryRef"),
+			fmt.Sprintf("can't be set when spec.sourceKind is %s", sourcev1.GitRepositoryKind)))
+	}
+	// source-controller checks out the first of commit, name, semver & tag which is set, ignoring the others
+	// The branch can be set with a commit to fetch the commit from the branch
+	if git := app.Spec.Chart.Git; git != nil && git.Ref != nil {
+		ref := git.Ref
+		var set []string
+		for _, r := range []struct{ name, value string }{
+			{"commit", ref.Commit}, {"name", ref.Name}, {"semver", ref.SemVer}, {"tag", ref.Tag},
+		} {
+			if r.value != "" {
+				set = append(set, r.name)
+			}
+		}
+		if len(set) > 1 {
+			allErrs = append(allErrs, field.Invalid(chartPath.Child("git", "ref"), strings.Join(set, ", "),
+				"only one of commit, name, semver & tag can be set"))
+		}
+	}
+	return allErrs
+}
+
+// validateChartAuth rejects chart credentials set both inline & by a Git Secret reference
+// The GitRepository can only reference one Secret so one of them would be silently ignored
+func validateChartAuth(app *appsv1.FluxApp) field.ErrorList {
+	if git := app.Spec.Chart.Git; app.Spec.Chart.Auth != nil && git != nil && git.SecretRef != nil {
+		return field.ErrorList{field.Forbidden(field.NewPath("spec", "chart", "auth"),
+			"can't be set with spec.chart.git.secretRef")}
+	}
+	return nil
+}
+
+// validatePinDigest rejects chart.pinDigest where the pinned chart wouldn't be deployed
+// A Git chart has no digest, the OCIRepository pulls from chart.repository rather than a referenced HelmRepository,
+// and it only pulls the digest of the stable version so a canary can't be deployed
+func validatePinDigest(app *appsv1.FluxApp) field.ErrorList {
+	if !app.Spec.Chart.PinDigest {
+		return nil
+	}
+	fldPath := field.NewPath("spec", "chart", "pinDigest")
+	var allErrs field.ErrorList
+	if app.Spec.SourceKind == sourcev1.GitRepositoryKind {
+		allErrs = append(allErrs, field.Forbidden(fldPath,
+			fmt.Sprintf("can't be set when spec.sourceKind is %s", sourcev1.GitRepositoryKind)))
+	}
+	if app.Spec.HelmRepositoryRef != nil {
+		allErrs = append(allErrs, field.Forbidden(fldPath, "can't be set with spec.helmRepositoryRef"))
+	}
+	if app.Spec.Canary != nil {
+		allErrs = append(allErrs, field.Forbidden(fldPath, "can't be set with spec.canary"))
+	}
+	return allErrs
+}
+
+// validateDriftExclusions rejects drift detection exclusions which helm-controller can't apply
+// An empty selector would exclude every resource, which is what driftDetection: disabled is for
+func validateDriftExclusions(app *appsv1.FluxApp) field.ErrorList {
+	fldPath := field.NewPath("spec", "driftDetectionResourceExclusions")
+	var allErrs field.ErrorList
+	for i, s := range app.Spec.DriftDetectionResourceExclusions {
+		if s == (kustomize.Selector{}) {
+			allErrs = append(allErrs, field.Required(fldPath.Index(i),
+				"must select resources, set spec.driftDetection to disabled to exclude every resource"))
+			continue
+		}
+		if _, err := labels.Parse(s.LabelSelector); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i).Child("labelSelector"), s.LabelSelector, err.Error()))
+		}
+		if _, err := labels.Parse(s.AnnotationSelector); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i).Child("annotationSelector"), s.AnnotationSelector, err.Error()))
+		}
+	}
+	return allErrs
+}
+
+// validateChildPatches rejects child patches for children which can't be patched or which don't apply
+// The controller would otherwise fail every reconcile of the app
+func validateChildPatches(app *appsv1.FluxApp) field.ErrorList {
+	fldPath := field.NewPath("spec", "childPatches")
+	kinds := make([]string, 0, len(app.Spec.ChildPatches))
+	for kind := range app.Spec.ChildPatches {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	var allErrs field.ErrorList
+	for _, kind := range kinds {
+		if !childpatch.Supported(kind) {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Key(kind), kind, childpatch.Kinds()))
+			continue
+		}
+		patch := app.Spec.ChildPatches[kind]
+		if err := childpatch.Validate(kind, patch.Raw); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(kind), string(patch.Raw), err.Error()))
+		}
+	}
+	return allErrs
+}
+
+// validateIntervals rejects intervals shorter than the Flux children support
+// The children are created regardless so the error would otherwise only show on the child
+func validateIntervals(app *appsv1.FluxApp) field.ErrorList {
+	var allErrs field.ErrorList
+	check := func(fldPath *field.Path, d *metav1.Duration) {
+		if d != nil && d.Duration < minInterval {
+			allErrs = append(allErrs, field.Invalid(fldPath, d.Duration.String(),
+				fmt.Sprintf("must be at least %s, the Flux controllers don't support shorter intervals", minInterval)))
+		}
+	}
+	check(field.NewPath("spec", "interval"), app.Spec.Interval)
+	check(field.NewPath("spec", "chart", "scanInterval"), app.Spec.Chart.ScanInterval)
+	return allErrs
+}
+
+// validateExclusionList rejects chart exclusions which aren't valid regular expressions
+// The image-reflector-controller would otherwise fail every scan of the chart tags
+func validateExclusionList(app *appsv1.FluxApp) field.ErrorList {
+	var allErrs field.ErrorList
+	fldPath := field.NewPath("spec", "chart", "exclusionList")
+	for i, e := range app.Spec.Chart.ExclusionList {
+		if _, err := regexp.Compile(e); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), e, err.Error()))
+		}
+	}
+	return allErrs
+}
+
+// validateValuesFiles rejects chart values files which aren't relative paths within the source
+// source-controller would otherwise fail to build the chart
+func validateValuesFiles(app *appsv1.FluxApp) field.ErrorList {
+	var allErrs field.ErrorList
+	fldPath := field.NewPath("spec", "chart", "valuesFiles")
+	for i, f := range app.Spec.Chart.ValuesFiles {
+		if f == "" || path.IsAbs(f) || !fs.ValidPath(path.Clean(f)) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), f, "must be a relative path within the chart source"))
+		}
+	}
+	return allErrs
+}
+
+// isJSONPointer returns whether the path is an RFC 6901 JSON pointer
+// The whole document is referenced by an empty path, otherwise each token is prefixed with / and ~ is escaped as ~0 or ~1
+func isJSONPointer(path string) bool {
+	if path == "" {
+		return true
+	}
+	if !strings.HasPrefix(path, "/") {
+		return false
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] == '~' && (i+1 == len(path) || (path[i+1] != '0' && path[i+1] != '1')) {
+			return false
+		}
+	}
+	return true
+}
+
+// targetPathKeys splits a Helm --set style path such as a.b[0].c into its keys & indexes
+// A dot can be escaped with a backslash to be used in a key
+func targetPathKeys(path string) []string {
+	var keys []string
+	var key strings.Builder
+	flush := func() {
+		if key.Len() > 0 {
+			keys = append(keys, key.String())
+			key.Reset()
+		}
+	}
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; c {
+		case '\\':
+			if i+1 < len(path) {
+				i++
+				key.WriteByte(path[i])
+			}
+		case '.', '[', ']':
+			flush()
+		default:
+			key.WriteByte(c)
+		}
+	}
+	flush()
+	return keys
+}
+
+// overlaps returns true if the values set the path, a parent of the path to something other than a map,
+// or any key beneath the path
+func overlaps(values map[string]interface{}, keys []string) bool {
+	var v interface{} = values
+	for _, key := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			// A scalar or list is set where the path expects a map
+			return true
+		}
+		if v, ok = m[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,549 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"time"
+
+	helmv2 "github.com/fluxcd/helm-controller/api/v2"
+	"github.com/fluxcd/pkg/apis/kustomize"
+	"github.com/fluxcd/pkg/apis/meta"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appsv1 "github.com/kloudyuk/fluxer/api/v1"
+)
+
+var _ = Describe("FluxApp Webhook", func() {
+	var (
+		ctx       = context.Background()
+		validator FluxAppCustomValidator
+	)
+
+	// newValidator returns a validator listing the existing apps from a fake client
+	newValidator := func(existing ...client.Object) FluxAppCustomValidator {
+		scheme := runtime.NewScheme()
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		return FluxAppCustomValidator{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing...).Build()}
+	}
+
+	BeforeEach(func() {
+		validator = newValidator()
+	})
+
+	// newApp returns an app with the inline values & a single valuesFrom target path
+	newApp := func(values, targetPath string) *appsv1.FluxApp {
+		return &appsv1.FluxApp{
+			ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "default"},
+			Spec: appsv1.FluxAppSpec{
+				Chart:  appsv1.Chart{Repository: "oci://ghcr.io/stefanprodan/charts/podinfo"},
+				Values: &apiextensionsv1.JSON{Raw: []byte(values)},
+				ValuesFrom: []helmv2.ValuesReference{
+					{Kind: "Secret", Name: "podinfo-secrets", ValuesKey: "password", TargetPath: targetPath},
+				},
+			},
+		}
+	}
+
+	// changedFrom returns the app before its spec was changed to the app
+	changedFrom := func(app *appsv1.FluxApp) *appsv1.FluxApp {
+		oldApp := app.DeepCopy()
+		oldApp.Spec.Description = "before the update"
+		return oldApp
+	}
+
+	Context("When updating a FluxApp", func() {
+		It("should not validate an update which doesn't change the spec", func() {
+			app := newApp(`{"auth":{"password":"changeme"}}`, "auth.password")
+			updated := app.DeepCopy()
+			updated.Finalizers = []string{"apps.kloudy.uk/finalizer"}
+			_, err := validator.ValidateUpdate(ctx, app, updated)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should not validate a deleted app", func() {
+			app := newApp(`{"auth":{"password":"changeme"}}`, "auth.password")
+			updated := app.DeepCopy()
+			updated.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			updated.Spec.Description = "changed while deleting"
+			_, err := validator.ValidateUpdate(ctx, app, updated)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("When creating or updating FluxApp under Validating Webhook", func() {
+		DescribeTable("should reject a targetPath overlapping the inline values",
+			func(values, targetPath string) {
+				app := newApp(values, targetPath)
+				_, err := validator.ValidateCreate(ctx, app)
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring("spec.valuesFrom[0].targetPath"))
+				_, err = validator.ValidateUpdate(ctx, changedFrom(app), app)
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			},
+			Entry("same key", `{"auth":{"password":"changeme"}}`, "auth.password"),
+			Entry("parent set to a scalar", `{"auth":"none"}`, "auth.password"),
+			Entry("keys beneath the path", `{"auth":{"password":{"value":"changeme"}}}`, "auth"),
+			Entry("list index", `{"hosts":["a.example.com"]}`, "hosts[0]"),
+			Entry("escaped dot", `{"annotations":{"example.com/secret":"x"}}`, `annotations.example\.com/secret`),
+		)
+
+		DescribeTable("should allow a targetPath not overlapping the inline values",
+			func(values, targetPath string) {
+				_, err := validator.ValidateCreate(ctx, newApp(values, targetPath))
+				Expect(err).NotTo(HaveOccurred())
+			},
+			Entry("different key", `{"replicaCount":2}`, "auth.password"),
+			Entry("sibling key", `{"auth":{"username":"admin"}}`, "auth.password"),
+			Entry("empty values", `{}`, "auth.password"),
+			Entry("escaped dot", `{"annotations":{"example":{"com/secret":"x"}}}`, `annotations.example\.com/secret`),
+		)
+
+		It("should allow an overlapping targetPath when the inline values are merged first", func() {
+			app := newApp(`{"auth":{"password":"changeme"}}`, "auth.password")
+			app.Spec.ValuesPrecedence = appsv1.ValuesPrecedenceInlineFirst
+			_, err := validator.ValidateCreate(ctx, app)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should allow valuesFrom without a targetPath", func() {
+			_, err := validator.ValidateCreate(ctx, newApp(`{"auth":{"password":"changeme"}}`, ""))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should allow valuesFrom without inline values", func() {
+			app := newApp("", "auth.password")
+			app.Spec.Values = nil
+			_, err := validator.ValidateCreate(ctx, app)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should check the canary valuesFrom", func() {
+			app := newApp(`{"auth":{"password":"changeme"}}`, "")
+			app.Spec.Canary = &appsv1.Canary{
+				Version:    "6.5.3",
+				ValuesFrom: []helmv2.ValuesReference{{Kind: "ConfigMap", Name: "canary", ValuesKey: "password", TargetPath: "auth.password"}},
+			}
+			_, err := validator.ValidateCreate(ctx, app)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.canary.valuesFrom[0].targetPath"))
+		})
+	})
+
+	Context("When validating the release name", func() {
+		// newReleaseApp returns an app deploying the release to the target namespace
+		newReleaseApp := func(namespace, name, targetNamespace, releaseName string) *appsv1.FluxApp {
+			return &appsv1.FluxApp{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Spec: appsv1.FluxAppSpec{
+					Chart:           appsv1.Chart{Repository: "oci://ghcr.io/stefanprodan/charts/podinfo"},
+					TargetNamespace: targetNamespace,
+					ReleaseName:     releaseName,
+				},
+			}
+		}
+
+		DescribeTable("should reject an app deploying the same release as another app",
+			func(existing, app *appsv1.FluxApp) {
+				validator = newValidator(existing)
+				_, err := validator.ValidateCreate(ctx, app)
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring("spec.releaseName"))
+				Expect(err.Error()).To(ContainSubstring(existing.Namespace + "/" + existing.Name))
+				_, err = validator.ValidateUpdate(ctx, changedFrom(app), app)
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			},
+			Entry("same release name & target namespace",
+				newReleaseApp("team-a", "podinfo", "apps", "podinfo"),
+				newReleaseApp("team-b", "frontend", "apps", "podinfo")),
+			Entry("release name defaulted from the app name",
+				newReleaseApp("team-a", "podinfo", "apps", ""),
+				newReleaseApp("team-b", "frontend", "apps", "podinfo")),
+			Entry("target namespace defaulted from the app namespace",
+				newReleaseApp("default", "podinfo", "", ""),
+				newReleaseApp("team-b", "frontend", "default", "podinfo")),
+		)
+
+		DescribeTable("should allow an app deploying a different release",
+			func(existing, app *appsv1.FluxApp) {
+				validator = newValidator(existing)
+				_, err := validator.ValidateCreate(ctx, app)
+				Expect(err).NotTo(HaveOccurred())
+			},
+			Entry("different release name",
+				newReleaseApp("team-a", "podinfo", "apps", "podinfo"),
+				newReleaseApp("team-b", "frontend", "apps", "frontend")),
+			Entry("different target namespace",
+				newReleaseApp("team-a", "podinfo", "apps", "podinfo"),
+				newReleaseApp("team-b", "frontend", "web", "podinfo")),
+			Entry("the same app",
+				newReleaseApp("team-a", "podinfo", "apps", "podinfo"),
+				newReleaseApp("team-a", "podinfo", "apps", "podinfo")),
+		)
+
+		DescribeTable("should reject changing the release name",
+			func(oldReleaseName, releaseName string) {
+				oldApp := newReleaseApp("team-a", "podinfo", "apps", oldReleaseName)
+				app := newReleaseApp("team-a", "podinfo", "apps", releaseName)
+				_, err := validator.ValidateUpdate(ctx, oldApp, app)
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring("spec.releaseName"))
+				Expect(err.Error()).To(ContainSubstring("releaseName is immutable"))
+			},
+			Entry("renamed", "podinfo", "frontend"),
+			Entry("set", "", "frontend"),
+			Entry("set to the defaulted name", "", "podinfo"),
+			Entry("unset", "frontend", ""),
+		)
+
+		It("should allow the same release on a different cluster", func() {
+			existing := newReleaseApp("team-a", "podinfo", "apps", "podinfo")
+			app := newReleaseApp("team-b", "frontend", "apps", "podinfo")
+			app.Spec.RemoteCluster = &appsv1.RemoteCluster{
+				Server:    "https://spoke.example.com:6443",
+				TokenFile: "/var/run/secrets/tokens/spoke",
+			}
+			validator = newValidator(existing)
+			_, err := validator.ValidateCreate(ctx, app)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("When validating the values template", func() {
+		newTemplateApp := func(tmpl string) *appsv1.FluxApp {
+			app := newApp(`{}`, "")
+			app.Spec.ValuesTemplate = tmpl
+			return app
+		}
+
+		It("should allow a valid template", func() {
+			_, err := validator.ValidateCreate(ctx, newTemplateApp(`cluster: {{ .Vars.clusterName | default "dev" | quote }}`))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		DescribeTable("should reject a template which can't be parsed",
+			func(tmpl string) {
+				_, err := validator.ValidateCreate(ctx, newTemplateApp(tmpl))
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring("spec.valuesTemplate"))
+			},
+			Entry("unclosed action", `cluster: {{ .Vars.clusterName `),
+			Entry("unknown function", `cluster: {{ env "CLUSTER" }}`),
+			Entry("unmatched end", `cluster: prod{{ end }}`),
+		)
+	})
+
+	Context("When validating the version source", func() {
+		newGitApp := func(change func(app *appsv1.FluxApp)) *appsv1.FluxApp {
+			app := newApp(`{}`, "")
+			app.Spec.SourceKind = sourcev1.GitRepositoryKind
+			app.Spec.Chart.Repository = "https://github.com/stefanprodan/podinfo"
+			app.Spec.Chart.Version = "*"
+			app.Spec.Chart.VersionSelection = appsv1.VersionSelectionHighest
+			app.Spec.Chart.Git = &appsv1.GitChart{Path: "charts/podinfo", Ref: &sourcev1.GitRepositoryRef{Branch: "master"}}
+			change(app)
+			return app
+		}
+
+		DescribeTable("should allow a single version source",
+			func(app *appsv1.FluxApp) {
+				_, err := validator.ValidateCreate(ctx, app)
+				Expect(err).NotTo(HaveOccurred())
+			},
+			Entry("registry version constraint", func() *appsv1.FluxApp {
+				app := newApp(`{}`, "")
+				app.Spec.Chart.Version = "6.x"
+				app.Spec.Chart.VersionSelection = appsv1.VersionSelectionLowest
+				app.Spec.Chart.TagPrefix = "chart-"
+				return app
+			}()),
+			Entry("git branch", newGitApp(func(app *appsv1.FluxApp) {})),
+			Entry("git tag", newGitApp(func(app *appsv1.FluxApp) {
+				app.Spec.Chart.Git.Ref = &sourcev1.GitRepositoryRef{Tag: "6.5.3"}
+			})),
+			Entry("git commit on a branch", newGitApp(func(app *appsv1.FluxApp) {
+				app.Spec.Chart.Git.Ref = &sourcev1.GitRepositoryRef{Branch: "master", Commit: "a1b2c3d"}
+			})),
+			Entry("git without the CRD defaults", newGitApp(func(app *appsv1.FluxApp) {
+				app.Spec.Chart.Version = ""
+				app.Spec.Chart.VersionSelection = ""
+			})),
+		)
+
+		DescribeTable("should reject more than one version source",
+			func(app *appsv1.FluxApp, field string) {
+				_, err := validator.ValidateCreate(ctx, app)
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring(field))
+			},
+			Entry("git with a version constraint", newGitApp(func(app *appsv1.FluxApp) {
+				app.Spec.Chart.Version = "6.x"
+			}), "spec.chart.version"),
+			Entry("git with the lowest version selection", newGitApp(func(app *appsv1.FluxApp) {
+				app.Spec.Chart.VersionSelection = appsv1.VersionSelectionLowest
+			}), "spec.chart.versionSelection"),
+			Entry("git with a tag prefix", newGitApp(func(app *appsv1.FluxApp) {
+				app.Spec.Chart.TagPrefix = "chart-"
+			}), "spec.chart.tagPrefix"),
+			Entry("git with a chart name", newGitApp(func(app *appsv1.FluxApp) {
+				app.Spec.Chart.Name = "podinfo"
+			}), "spec.chart.name"),
+			Entry("git with a HelmRepository reference", newGitApp(func(app *appsv1.FluxApp) {
+				app.Spec.HelmRepositoryRef = &meta.NamespacedObjectReference{Name: "charts", Namespace: "flux-system"}
+			}), "spec.helmRepositoryRef"),
+			Entry("git tag & semver", newGitApp(func(app *appsv1.FluxApp) {
+				app.Spec.Chart.Git.Ref = &sourcev1.GitRepositoryRef{Tag: "6.5.3", SemVer: "6.x"}
+			}), "spec.chart.git.ref"),
+			Entry("git commit & tag", newGitApp(func(app *appsv1.FluxApp) {
+				app.Spec.Chart.Git.Ref = &sourcev1.GitRepositoryRef{Commit: "a1b2c3d", Tag: "6.5.3"}
+			}), "spec.chart.git.ref"),
+			Entry("registry with a git chart", func() *appsv1.FluxApp {
+				app := newApp(`{}`, "")
+				app.Spec.Chart.Git = &appsv1.GitChart{Path: "charts/podinfo"}
+				return app
+			}(), "spec.chart.git"),
+		)
+	})
+
+	Context("When validating the chart auth", func() {
+		It("should allow inline credentials", func() {
+			app := newApp(`{}`, "")
+			app.Spec.Chart.Auth = &appsv1.ChartAuth{Username: "dev", Password: "secret"}
+			_, err := validator.ValidateCreate(ctx, app)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should reject inline credentials with a Git Secret reference", func() {
+			app := newApp(`{}`, "")
+			app.Spec.SourceKind = sourcev1.GitRepositoryKind
+			app.Spec.Chart.Repository = "https://github.com/stefanprodan/podinfo"
+			app.Spec.Chart.Git = &appsv1.GitChart{Path: "charts/podinfo", SecretRef: &meta.LocalObjectReference{Name: "git-auth"}}
+			app.Spec.Chart.Auth = &appsv1.ChartAuth{Username: "dev", Password: "secret"}
+			_, err := validator.ValidateCreate(ctx, app)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.chart.auth"))
+		})
+	})
+
+	Context("When validating the digest pinning", func() {
+		It("should allow pinning a registry chart", func() {
+			app := newApp(`{}`, "")
+			app.Spec.Chart.PinDigest = true
+			_, err := validator.ValidateCreate(ctx, app)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		DescribeTable("should reject pinning a chart which wouldn't be deployed by its digest",
+			func(mutate func(app *appsv1.FluxApp)) {
+				app := newApp(`{}`, "")
+				app.Spec.Chart.PinDigest = true
+				mutate(app)
+				_, err := validator.ValidateCreate(ctx, app)
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring("spec.chart.pinDigest"))
+			},
+			Entry("git source", func(app *appsv1.FluxApp) {
+				app.Spec.SourceKind = sourcev1.GitRepositoryKind
+				app.Spec.Chart.Repository = "https://github.com/stefanprodan/podinfo"
+				app.Spec.Chart.Version = ""
+				app.Spec.Chart.Git = &appsv1.GitChart{Path: "charts/podinfo"}
+			}),
+			Entry("referenced HelmRepository", func(app *appsv1.FluxApp) {
+				app.Spec.HelmRepositoryRef = &meta.NamespacedObjectReference{Name: "charts", Namespace: "flux-system"}
+			}),
+			Entry("canary", func(app *appsv1.FluxApp) {
+				app.Spec.Canary = &appsv1.Canary{Version: "6.6.0", Weight: 10}
+			}),
+		)
+	})
+
+	Context("When validating the drift detection exclusions", func() {
+		It("should allow selecting resources", func() {
+			app := newApp(`{}`, "")
+			app.Spec.DriftDetectionResourceExclusions = []kustomize.Selector{
+				{Kind: "ConfigMap", Name: "podinfo-redis"},
+				{LabelSelector: "app.kubernetes.io/component in (cache, queue)"},
+			}
+			_, err := validator.ValidateCreate(ctx, app)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should reject an empty selector", func() {
+			app := newApp(`{}`, "")
+			app.Spec.DriftDetectionResourceExclusions = []kustomize.Selector{{Kind: "ConfigMap"}, {}}
+			_, err := validator.ValidateCreate(ctx, app)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.driftDetectionResourceExclusions[1]"))
+		})
+
+		It("should reject an invalid label selector", func() {
+			app := newApp(`{}`, "")
+			app.Spec.DriftDetectionResourceExclusions = []kustomize.Selector{{LabelSelector: "app in (cache"}}
+			_, err := validator.ValidateCreate(ctx, app)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.driftDetectionResourceExclusions[0].labelSelector"))
+		})
+	})
+
+	Context("When validating the child patches", func() {
+		It("should allow a patch of a child spec", func() {
+			app := newApp(`{}`, "")
+			app.Spec.ChildPatches = map[string]apiextensionsv1.JSON{
+				sourcev1.HelmRepositoryKind: {Raw: []byte(`{"spec":{"timeout":"2m"}}`)},
+				helmv2.HelmReleaseKind:      {Raw: []byte(`{"spec":{"install":{"disableHooks":true}}}`)},
+			}
+			_, err := validator.ValidateCreate(ctx, app)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		DescribeTable("should reject a patch which can't be applied",
+			func(kind, patch, message string) {
+				app := newApp(`{}`, "")
+				app.Spec.ChildPatches = map[string]apiextensionsv1.JSON{kind: {Raw: []byte(patch)}}
+				_, err := validator.ValidateCreate(ctx, app)
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring("spec.childPatches[" + kind + "]"))
+				Expect(err.Error()).To(ContainSubstring(message))
+			},
+			Entry("unsupported kind", "ConfigMap", `{"data":{}}`, "supported values"),
+			Entry("unknown field", sourcev1.HelmRepositoryKind, `{"spec":{"intervall":"5m"}}`, "intervall"),
+			Entry("metadata", helmv2.HelmReleaseKind, `{"metadata":{"labels":{"team":"a"}}}`, "can only change the spec"),
+			Entry("target namespace", helmv2.HelmReleaseKind, `{"spec":{"targetNamespace":"kube-system"}}`,
+				"can't set spec.targetNamespace"),
+			Entry("chart source", helmv2.HelmReleaseKind, `{"spec":{"chart":{"spec":{"sourceRef":{"name":"other"}}}}}`,
+				"can't set spec.chart.spec.sourceRef"),
+			Entry("service account in another case", helmv2.HelmReleaseKind, `{"spec":{"ServiceAccountName":"cluster-admin"}}`,
+				"can't set spec.serviceAccountName"),
+			Entry("kubeConfig in another case", helmv2.HelmReleaseKind, `{"spec":{"KubeConfig":{"secretRef":{"name":"x"}}}}`,
+				"can't set spec.kubeConfig"),
+		)
+	})
+
+	Context("When validating the exclusion list", func() {
+		It("should allow regular expressions", func() {
+			app := newApp(`{}`, "")
+			app.Spec.Chart.ExclusionList = []string{`^6\.4\.`, "-rc"}
+			_, err := validator.ValidateCreate(ctx, app)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should reject an invalid regular expression", func() {
+			app := newApp(`{}`, "")
+			app.Spec.Chart.ExclusionList = []string{"-rc", "6.4.(0"}
+			_, err := validator.ValidateCreate(ctx, app)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.chart.exclusionList[1]"))
+		})
+	})
+
+	Context("When validating the values files", func() {
+		It("should allow relative paths", func() {
+			app := newApp(`{}`, "")
+			app.Spec.Chart.ValuesFiles = []string{"values.yaml", "./ci/values-production.yaml"}
+			_, err := validator.ValidateCreate(ctx, app)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		DescribeTable("should reject paths outside the source",
+			func(file string) {
+				app := newApp(`{}`, "")
+				app.Spec.Chart.ValuesFiles = []string{"values.yaml", file}
+				_, err := validator.ValidateCreate(ctx, app)
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring("spec.chart.valuesFiles[1]"))
+			},
+			Entry("absolute", "/etc/values.yaml"),
+			Entry("parent directory", "../values.yaml"),
+			Entry("escaping through a subdirectory", "ci/../../values.yaml"),
+			Entry("empty", ""),
+		)
+	})
+
+	Context("When validating the intervals", func() {
+		newIntervalApp := func(interval, scanInterval *metav1.Duration) *appsv1.FluxApp {
+			app := newApp(`{}`, "")
+			app.Spec.Interval = interval
+			app.Spec.Chart.ScanInterval = scanInterval
+			return app
+		}
+
+		DescribeTable("should allow intervals Flux supports",
+			func(interval, scanInterval *metav1.Duration) {
+				_, err := validator.ValidateCreate(ctx, newIntervalApp(interval, scanInterval))
+				Expect(err).NotTo(HaveOccurred())
+			},
+			Entry("defaults", nil, nil),
+			Entry("minimum", &metav1.Duration{Duration: time.Second}, &metav1.Duration{Duration: time.Second}),
+			Entry("typical", &metav1.Duration{Duration: 5 * time.Minute}, &metav1.Duration{Duration: time.Hour}),
+		)
+
+		DescribeTable("should reject intervals shorter than Flux supports",
+			func(interval, scanInterval *metav1.Duration, field string) {
+				_, err := validator.ValidateCreate(ctx, newIntervalApp(interval, scanInterval))
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring(field))
+				Expect(err.Error()).To(ContainSubstring("must be at least 1s"))
+			},
+			Entry("zero interval", &metav1.Duration{}, nil, "spec.interval"),
+			Entry("sub-second interval", &metav1.Duration{Duration: 500 * time.Millisecond}, nil, "spec.interval"),
+			Entry("zero scan interval", nil, &metav1.Duration{}, "spec.chart.scanInterval"),
+			Entry("sub-second scan interval", nil, &metav1.Duration{Duration: 10 * time.Millisecond}, "spec.chart.scanInterval"),
+		)
+	})
+
+	Context("When validating the values patches", func() {
+		value := &apiextensionsv1.JSON{Raw: []byte(`"podinfo.example.com"`)}
+
+		newPatchesApp := func(ops ...appsv1.JSONPatchOp) *appsv1.FluxApp {
+			app := newApp(`{}`, "")
+			app.Spec.ValuesPatches = ops
+			return app
+		}
+
+		It("should allow valid patches", func() {
+			_, err := validator.ValidateCreate(ctx, newPatchesApp(
+				appsv1.JSONPatchOp{Op: "add", Path: "/ingress/hosts/-", Value: value},
+				appsv1.JSONPatchOp{Op: "remove", Path: "/tolerations/0"},
+				appsv1.JSONPatchOp{Op: "replace", Path: "/podAnnotations/example.com~1team", Value: value},
+				appsv1.JSONPatchOp{Op: "copy", From: "/ingress/hosts/0", Path: "/ingress/tls/0/hosts/0"},
+			))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		DescribeTable("should reject invalid patches",
+			func(op appsv1.JSONPatchOp, field string) {
+				_, err := validator.ValidateCreate(ctx, newPatchesApp(op))
+				Expect(apierrors.IsInvalid(err)).To(BeTrue())
+				Expect(err.Error()).To(ContainSubstring(field))
+			},
+			Entry("path not a JSON pointer", appsv1.JSONPatchOp{Op: "remove", Path: "ingress.hosts"}, "spec.valuesPatches[0].path"),
+			Entry("invalid escape", appsv1.JSONPatchOp{Op: "remove", Path: "/podAnnotations/a~b"}, "spec.valuesPatches[0].path"),
+			Entry("add without a value", appsv1.JSONPatchOp{Op: "add", Path: "/replicaCount"}, "spec.valuesPatches[0].value"),
+			Entry("replace without a value", appsv1.JSONPatchOp{Op: "replace", Path: "/replicaCount"}, "spec.valuesPatches[0].value"),
+			Entry("move without from", appsv1.JSONPatchOp{Op: "move", Path: "/replicaCount"}, "spec.valuesPatches[0].from"),
+			Entry("from not a JSON pointer", appsv1.JSONPatchOp{Op: "copy", From: "replicas", Path: "/replicaCount"}, "spec.valuesPatches[0].from"),
+		)
+	})
+})